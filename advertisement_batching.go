@@ -2,11 +2,21 @@ package herald
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"github.com/ipni/go-libipni/announce"
 	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 // According to the IPNI specification, the maximum is:
@@ -20,6 +30,9 @@ const DefaultMaxMHsPerAdvertisement = 200_000
 
 const DefaultMaxDelay = 30 * time.Second
 
+// DefaultMaxBatchBytes is the default memory budget of a batching lane.
+const DefaultMaxBatchBytes = 64 << 20
+
 type BatchConfig struct {
 	// CountThreshold is the threshold to separate two publishing strategies:
 	// - above the threshold: publish as a single advertisement, with a ContextID for easy retraction
@@ -32,6 +45,62 @@ type BatchConfig struct {
 	// MaxDelay is the maximum delay after which a batch triggers
 	MaxDelay time.Duration
 
+	// MaxBatchBytes is the memory budget of each batching lane, in bytes of multihashes (including the slice overhead).
+	// Unlike MaxMHsPerAdvertisement which is checked between catalogs, it's enforced while iterating a catalog: when
+	// reached, the batch is flushed before the iteration continues. If that flush fails, the catalog is rejected.
+	// This bounds the memory used by catalogs with an unknown count and no ContextID, which are always batched.
+	// Defaults to DefaultMaxBatchBytes.
+	MaxBatchBytes int
+
+	// RetryPolicy is the policy applied when publishing a batch or announcing a new head fails.
+	// The zero value means no retry. If the retries are exhausted, the batch is kept and retried after MaxDelay.
+	RetryPolicy RetryPolicy
+
+	// Queue, if set, persists the batched multihashes until they are published, so that they survive a restart
+	// of the process. PublishCatalog and RetractCatalog only return once the catalog is persisted.
+	// The datastore should be dedicated to the batcher, for example with a namespace.Wrap.
+	Queue datastore.Datastore
+
+	// OnPanic, if set, is called each time a batching goroutine crashes, with the recovered value and the number of
+	// consecutive crashes. The goroutine is restarted with an increasing delay after each crash, with its batch. The
+	// crashes are also counted in the metrics, and reported by CatalogBatcher.Health.
+	OnPanic func(lane string, recovered any, crashes int)
+
+	// AnnounceInterval, if set, is the minimum interval between two announcements of a new head. The heads advancing
+	// within the interval are coalesced into a single announcement of the latest head, sent in the background: the
+	// publishing methods don't wait for it, and its failures are only logged.
+	AnnounceInterval time.Duration
+
+	// OnFlush, if set, is called after each flush of a batching lane, with the number of multihashes, and the new
+	// head or the error.
+	OnFlush func(lane string, count int, head cid.Cid, err error)
+
+	// RetractionVerifier, if set, verifies in the background that the retractions with ContextID (above the
+	// threshold) have propagated to the indexer. The outcome is reported to RetractionVerifier.OnReport.
+	RetractionVerifier *RetractionVerifier
+
+	// OrderedLanes runs the publish and retract lanes in a single goroutine, so that the publication and the
+	// retraction of a multihash land on the chain in the order they were submitted: a catalog holding a multihash
+	// pending in the other lane flushes that lane first. Without it, the lanes flush independently, and a small
+	// retraction can land before the publication it retracts. It costs a set of the multihashes pending in each lane.
+	OrderedLanes bool
+
+	// QueueCapacity is the number of batched catalogs that can wait for their lane while it's busy, typically
+	// flushing a batch to a slow backend. When the queue is full, OverflowPolicy applies. Zero means no queue: a
+	// catalog is only accepted when the lane is ready to take it.
+	// The callers still wait for the lane to consume their catalog. A caller whose context expires while its catalog
+	// is queued gets the context error, and the lane skips the catalog: the caller can submit it again without
+	// duplicate.
+	QueueCapacity int
+
+	// OverflowPolicy is what happens to a catalog submitted while the queue of its lane is full. Defaults to
+	// OverflowBlock.
+	OverflowPolicy OverflowPolicy
+
+	// OverflowTimeout is how long OverflowBlock waits for room in the queue before failing with
+	// ErrBatcherSaturated. Zero means waiting as long as the context allows.
+	OverflowTimeout time.Duration
+
 	// allow overrides for testing
 	publishWithContextID func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)
 	retractWithContextID func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)
@@ -39,17 +108,126 @@ type BatchConfig struct {
 	retractRawMHs        func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)
 }
 
+// OverflowPolicy is the behavior of the CatalogBatcher when the queue of a batching lane is full, see
+// BatchConfig.QueueCapacity.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, up to BatchConfig.OverflowTimeout.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject fails right away with ErrBatcherSaturated, for the caller to shed the load or retry later.
+	OverflowReject
+	// OverflowSpill reads the catalog and persists it in BatchConfig.Queue, which is required, and returns. The lane
+	// adds the spilled catalogs to its batch once it's available again.
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowReject:
+		return "reject"
+	case OverflowSpill:
+		return "spill"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
 // CatalogBatcher is a batcher to publish/retract Catalog. Strategy is as follows:
 // - above the threshold: publish as a single advertisement, with a ContextID for easy retraction
 // - below the threshold: batch together publishes and retract, with no ContextID
 type CatalogBatcher struct {
 	batchConfig BatchConfig
+	cfgMu       sync.RWMutex // protects chainConfig, which can be updated while running
 	chainConfig ChainConfig
 	backend     ChainWriter
 	announcer   announce.Sender
+	debouncer   *announceDebouncer // nil without AnnounceInterval
+
+	publish *laneInput
+	retract *laneInput
+
+	// closing is closed by Close to stop the lanes, stopped once they all returned
+	closing   chan struct{}
+	closeOnce sync.Once
+	stopped   chan struct{}
+
+	// verifyCtx is cancelled by Close to stop the retraction verifications, tracked by verifying. verifyMu orders
+	// the start of a verification with the cancellation.
+	verifyMu     sync.Mutex
+	verifyCtx    context.Context
+	verifyCancel context.CancelFunc
+	verifying    sync.WaitGroup
+
+	crashMu sync.Mutex
+	crashes map[string]laneCrash // the last crash of each lane
+}
+
+// laneCrash is the last crash of a batching lane.
+type laneCrash struct {
+	at        time.Time
+	count     int // consecutive crashes
+	recovered any
+}
+
+// laneInput is the input of a batching lane, which outlives the lane when it's restarted after a crash: the queue of
+// the submitted catalogs, and the durable queue holding the batch and the spilled catalogs.
+type laneInput struct {
+	name  string
+	ch    chan batchRequest
+	queue *batchQueue
+	// spilled is signaled when a catalog is spilled to queue
+	spilled chan struct{}
+	// kept is the batch of a crashed lane without queue, and keptResults the results waiting for it, taken over by
+	// the next run of the lane
+	kept        []multihash.Multihash
+	keptResults []chan PublishResult
+}
+
+// depth returns the number of catalogs waiting for the lane.
+func (in *laneInput) depth() int {
+	return len(in.ch) + in.queue.spilledCount()
+}
+
+// batchRequest is a catalog submitted to a batching lane. ack receives the outcome once the catalog is consumed.
+// result, if not nil, receives the outcome once the batch holding the catalog is published.
+type batchRequest struct {
+	ctx     context.Context
+	catalog Catalog
+	ack     chan error
+	result  chan PublishResult
+	// state is claimed either by the lane taking the catalog, or by the caller giving up on it
+	state *atomic.Int32
+}
+
+const (
+	requestPending int32 = iota
+	requestTaken
+	requestCanceled
+)
+
+// take claims the request for the lane. It returns false if the caller gave up on it, or its context expired.
+func (r batchRequest) take() bool {
+	if r.ctx.Err() != nil {
+		r.state.CompareAndSwap(requestPending, requestCanceled)
+	}
+	return r.state.CompareAndSwap(requestPending, requestTaken)
+}
+
+// cancel gives up on the request. It returns false if the lane already took it, in which case ack still receives
+// the outcome.
+func (r batchRequest) cancel() bool {
+	return r.state.CompareAndSwap(requestPending, requestCanceled)
+}
 
-	publish chan Catalog
-	retract chan Catalog
+// PublishResult is the outcome of a catalog published or retracted through the CatalogBatcher.
+type PublishResult struct {
+	// Head is the advertisement holding the catalog, or the last of them if split, or the last published if there
+	// was nothing to publish
+	Head cid.Cid
+	Err  error
 }
 
 func StartCatalogBatcher(batchConfig BatchConfig, chainCfg ChainConfig, backend ChainWriter, announcer announce.Sender) *CatalogBatcher {
@@ -58,134 +236,1002 @@ func StartCatalogBatcher(batchConfig BatchConfig, chainCfg ChainConfig, backend
 		chainConfig: chainCfg,
 		backend:     backend,
 		announcer:   announcer,
-		publish:     make(chan Catalog),
-		retract:     make(chan Catalog),
+		closing:     make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	b.verifyCtx, b.verifyCancel = context.WithCancel(context.Background())
+	b.publish = b.newLaneInput("publish")
+	b.retract = b.newLaneInput("retract")
+	if batchConfig.AnnounceInterval > 0 && announcer != nil {
+		b.debouncer = newAnnounceDebouncer(batchConfig.AnnounceInterval, b.sendAnnouncement, b.logger())
+	}
+	if batchConfig.OverflowPolicy == OverflowSpill && batchConfig.Queue == nil {
+		b.logger().Warn("OverflowSpill requires a Queue, falling back to OverflowBlock")
+		b.batchConfig.OverflowPolicy = OverflowBlock
 	}
 
-	publish := batchConfig.publishRawMHs
-	if publish == nil {
-		publish = PublishRawMHs
+	var lanes sync.WaitGroup
+	run := func(lane string, fn func()) {
+		lanes.Add(1)
+		go func() {
+			defer lanes.Done()
+			b.supervise(lane, fn)
+		}()
 	}
+	if batchConfig.OrderedLanes {
+		run("ordered", b.runOrderedBatcher)
+	} else {
+		run("publish", func() { b.runBatcher(b.publish, b.publishRawMHs()) })
+		run("retract", func() { b.runBatcher(b.retract, b.retractRawMHs()) })
+	}
+	go func() {
+		lanes.Wait()
+		close(b.stopped)
+	}()
+
+	return b
+}
 
-	retract := batchConfig.retractRawMHs
-	if retract == nil {
-		retract = RetractRawMHs
+// Close stops the batching lanes, and sends the delayed announcement if any. The catalogs still queued and the
+// results waiting for the batch fail with ErrBatcherClosed. The batch is not published, but stays in the durable
+// Queue if any, to be resumed by the next batcher. Close waits for the batch being published, if any, and for the
+// retraction verifications in progress, which are cancelled.
+func (b *CatalogBatcher) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() { close(b.closing) })
+	b.verifyMu.Lock()
+	b.verifyCancel()
+	b.verifyMu.Unlock()
+	verified := make(chan struct{})
+	go func() {
+		b.verifying.Wait()
+		close(verified)
+	}()
+	for _, done := range []chan struct{}{b.stopped, verified} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	// the results kept by a lane closed while restarting after a crash
+	for _, in := range []*laneInput{b.publish, b.retract} {
+		for _, result := range in.keptResults {
+			resolve(result, PublishResult{Err: ErrBatcherClosed})
+		}
+		in.keptResults = nil
+	}
+	return b.FlushAnnouncements(ctx)
+}
 
-	go b.runBatcher(b.publish, publish)
-	go b.runBatcher(b.retract, retract)
+func (b *CatalogBatcher) newLaneInput(name string) *laneInput {
+	return &laneInput{
+		name:    name,
+		ch:      make(chan batchRequest, max(b.batchConfig.QueueCapacity, 0)),
+		queue:   newBatchQueue(b.batchConfig.Queue, name, b.logger()),
+		spilled: make(chan struct{}, 1),
+	}
+}
 
-	return b
+func (c BatchConfig) maxBatchBytes() int {
+	if c.MaxBatchBytes <= 0 {
+		return DefaultMaxBatchBytes
+	}
+	return c.MaxBatchBytes
+}
+
+// config returns the current ChainConfig.
+func (b *CatalogBatcher) config() ChainConfig {
+	b.cfgMu.RLock()
+	defer b.cfgMu.RUnlock()
+	return b.chainConfig
 }
 
+// logger returns the logger of the batcher, with the provider field.
+func (b *CatalogBatcher) logger() *zap.SugaredLogger {
+	return b.config().withLogFields(nil).logger()
+}
+
+// lockConfig returns the current ChainConfig, which can't change until release is called. It must be held while
+// generating advertisements, so that none is generated with an outdated config after an update.
+func (b *CatalogBatcher) lockConfig() (cfg ChainConfig, release func()) {
+	b.cfgMu.RLock()
+	return b.chainConfig, b.cfgMu.RUnlock
+}
+
+func (b *CatalogBatcher) publishRawMHs() func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+	if b.batchConfig.publishRawMHs != nil {
+		return b.batchConfig.publishRawMHs
+	}
+	return PublishRawMHs
+}
+
+func (b *CatalogBatcher) retractRawMHs() func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+	if b.batchConfig.retractRawMHs != nil {
+		return b.batchConfig.retractRawMHs
+	}
+	return RetractRawMHs
+}
+
+// PublishCatalog publishes the catalog. A batched catalog is only persisted in the batch when it returns, see
+// PublishCatalogResult to wait for its publication.
 func (b *CatalogBatcher) PublishCatalog(ctx context.Context, catalog Catalog) error {
-	if catalog.Count() > b.batchConfig.CountThreshold {
+	return b.publishCatalog(ctx, catalog, nil, false)
+}
+
+// PublishCatalogResult publishes the catalog like PublishCatalog, and returns a channel receiving the outcome once
+// the advertisement holding the catalog is published and announced. A batch failing to publish is retried, so that
+// the result can take several MaxDelay to come, or never if the process stops. Acknowledging the catalog upstream on
+// a successful result gives an at-least-once delivery.
+func (b *CatalogBatcher) PublishCatalogResult(ctx context.Context, catalog Catalog) (<-chan PublishResult, error) {
+	result := make(chan PublishResult, 1)
+	if err := b.publishCatalog(ctx, catalog, result, false); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PublishCatalogWithID publishes the catalog like PublishCatalogResult, except that a catalog with a ContextID is
+// published on its own whatever its size, so that its ContextID can be retracted later, see RetractCatalogWithID.
+func (b *CatalogBatcher) PublishCatalogWithID(ctx context.Context, catalog Catalog) (<-chan PublishResult, error) {
+	result := make(chan PublishResult, 1)
+	if err := b.publishCatalog(ctx, catalog, result, true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// publishCatalog publishes the catalog, batched unless it's large or has overrides. withID publishes a catalog with a
+// ContextID on its own whatever its size, so that its ContextID is kept.
+func (b *CatalogBatcher) publishCatalog(ctx context.Context, catalog Catalog, result chan PublishResult, withID bool) (err error) {
+	ctx, span := b.config().tracer().Start(ctx, "CatalogBatcher.PublishCatalog", trace.WithAttributes(catalogAttributes(catalog)...))
+	defer func() { endSpan(span, err) }()
+
+	if catalog.Count() == 0 {
+		return ErrEmptyCatalog
+	}
+	large := b.isLarge(catalog) || (withID && len(catalog.ID()) > 0)
+	span.SetAttributes(attribute.Bool("herald.batched", !large && !hasOverrides(catalog)))
+	if large || hasOverrides(catalog) {
 		publish := b.batchConfig.publishWithContextID
 		if publish == nil {
 			publish = PublishWithContextID
 		}
+		if !large && len(catalog.ID()) == 0 {
+			// small catalog with overrides, which can't be merged with the others
+			publish = b.publishRawMHs()
+		}
 
 		// for large catalogs, we don't do batching
-		newHead, err := publish(ctx, b.chainConfig, b.backend, catalog)
+		cfg, release := b.lockConfig()
+		newHead, err := publish(ctx, cfg, b.backend, catalog)
+		release()
 		if err != nil {
 			return err
 		}
-		return announce.Send(ctx, newHead, b.chainConfig.PublisherHttpAddrs, b.announcer)
-	}
-
-	select {
-	case b.publish <- catalog:
+		if err := b.announce(ctx, newHead); err != nil {
+			return err
+		}
+		resolve(result, PublishResult{Head: newHead})
 		return nil
-	case <-ctx.Done():
-		return ctx.Err()
 	}
+
+	return b.submit(ctx, b.publish, catalog, result)
 }
 
+// RetractCatalog retracts the catalog. A batched catalog is only persisted in the batch when it returns, see
+// RetractCatalogResult to wait for its publication.
 func (b *CatalogBatcher) RetractCatalog(ctx context.Context, catalog Catalog) error {
-	if catalog.Count() > b.batchConfig.CountThreshold {
+	return b.retractCatalog(ctx, catalog, nil, false)
+}
+
+// RetractCatalogResult retracts the catalog like RetractCatalog, and returns a channel receiving the outcome once the
+// advertisement retracting the catalog is published and announced, as PublishCatalogResult.
+func (b *CatalogBatcher) RetractCatalogResult(ctx context.Context, catalog Catalog) (<-chan PublishResult, error) {
+	result := make(chan PublishResult, 1)
+	if err := b.retractCatalog(ctx, catalog, result, false); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// RetractCatalogWithID retracts the catalog like RetractCatalogResult, except that a catalog with a ContextID is
+// retracted on its own whatever its size, retracting the ContextID published by PublishCatalogWithID.
+func (b *CatalogBatcher) RetractCatalogWithID(ctx context.Context, catalog Catalog) (<-chan PublishResult, error) {
+	result := make(chan PublishResult, 1)
+	if err := b.retractCatalog(ctx, catalog, result, true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// retractCatalog retracts the catalog, batched unless it's large or has overrides. withID retracts a catalog with a
+// ContextID on its own whatever its size, so that its ContextID is retracted.
+func (b *CatalogBatcher) retractCatalog(ctx context.Context, catalog Catalog, result chan PublishResult, withID bool) (err error) {
+	ctx, span := b.config().tracer().Start(ctx, "CatalogBatcher.RetractCatalog", trace.WithAttributes(catalogAttributes(catalog)...))
+	defer func() { endSpan(span, err) }()
+
+	large := b.isLarge(catalog) || (withID && len(catalog.ID()) > 0)
+	span.SetAttributes(attribute.Bool("herald.batched", !large && !hasOverrides(catalog)))
+	if large || hasOverrides(catalog) {
 		retract := b.batchConfig.retractWithContextID
 		if retract == nil {
 			retract = RetractWithContextID
 		}
+		if !large && len(catalog.ID()) == 0 {
+			// small catalog with overrides, which can't be merged with the others
+			retract = b.retractRawMHs()
+		}
+
+		var sample []multihash.Multihash
+		if b.batchConfig.RetractionVerifier != nil {
+			var err error
+			sample, err = b.batchConfig.RetractionVerifier.Sample(ctx, catalog)
+			if err != nil {
+				return err
+			}
+		}
 
 		// for large catalogs, we don't do batching
-		newHead, err := retract(ctx, b.chainConfig, b.backend, catalog)
+		cfg, release := b.lockConfig()
+		newHead, err := retract(ctx, cfg, b.backend, catalog)
+		release()
+		if err != nil {
+			return err
+		}
+		err = b.announce(ctx, newHead)
 		if err != nil {
 			return err
 		}
-		return announce.Send(ctx, newHead, b.chainConfig.PublisherHttpAddrs, b.announcer)
+
+		if b.batchConfig.RetractionVerifier != nil {
+			contextID, err := b.config().contextID(catalog.ID())
+			if err != nil {
+				return err
+			}
+			b.startRetractionVerification(contextID, sample)
+		}
+		resolve(result, PublishResult{Head: newHead})
+		return nil
 	}
 
+	return b.submit(ctx, b.retract, catalog, result)
+}
+
+// isLarge returns true if the catalog is published on its own with its ContextID instead of batched: with a ContextID,
+// and above CountThreshold or with an unknown count. A catalog without ContextID is always batched, whatever its size,
+// as it can't be published on its own.
+func (b *CatalogBatcher) isLarge(catalog Catalog) bool {
+	if len(catalog.ID()) == 0 {
+		return false
+	}
+	count := catalog.Count()
+	return count > b.batchConfig.CountThreshold || count < 0
+}
+
+// resolve delivers the outcome of a catalog, if requested. result must be buffered.
+func resolve(result chan PublishResult, r PublishResult) {
+	if result != nil {
+		result <- r
+	}
+}
+
+// submit hands over the catalog to a batching lane, and waits for it to be consumed, unless it's spilled.
+// If ctx expires first, the catalog is withdrawn from the lane, unless the lane is already consuming it.
+func (b *CatalogBatcher) submit(ctx context.Context, in *laneInput, catalog Catalog, result chan PublishResult) error {
+	req := batchRequest{ctx: ctx, catalog: catalog, ack: make(chan error, 1), result: result, state: new(atomic.Int32)}
+	spilled, err := b.enqueue(ctx, in, req)
+	if err != nil || spilled {
+		return err
+	}
 	select {
-	case b.retract <- catalog:
-		return nil
+	case err := <-req.ack:
+		return err
 	case <-ctx.Done():
-		return ctx.Err()
+		if req.cancel() {
+			return ctx.Err()
+		}
+		// the catalog is being consumed, its outcome is the outcome of the call
+		return <-req.ack
+	case <-b.stopped:
+		if req.cancel() {
+			return ErrBatcherClosed
+		}
+		return <-req.ack
 	}
 }
 
-func (b *CatalogBatcher) runBatcher(ch chan Catalog, fn func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)) {
-	var counter uint64
-	var timer <-chan time.Time
+// enqueue adds the request to the queue of the lane, applying the OverflowPolicy if it's full. It returns true if
+// the catalog was spilled to the durable queue instead.
+func (b *CatalogBatcher) enqueue(ctx context.Context, in *laneInput, req batchRequest) (spilled bool, err error) {
+	select {
+	case <-b.closing:
+		return false, ErrBatcherClosed
+	default:
+	}
+	metrics := b.config().Metrics
+	select {
+	case in.ch <- req:
+		metrics.recordBatcherQueueDepth(in.name, in.depth())
+		return false, nil
+	default:
+	}
 
-	// pre-alloc to CountThreshold as a first reasonable approximation
-	batch := make([]multihash.Multihash, 0, b.batchConfig.CountThreshold)
+	switch b.batchConfig.OverflowPolicy {
+	case OverflowReject:
+		metrics.recordBatcherOverflow(in.name, "rejected")
+		return false, fmt.Errorf("%w: the %s queue is full", ErrBatcherSaturated, in.name)
+	case OverflowSpill:
+		if err := b.spill(ctx, in, req); err != nil {
+			return false, err
+		}
+		metrics.recordBatcherOverflow(in.name, "spilled")
+		metrics.recordBatcherQueueDepth(in.name, in.depth())
+		return true, nil
+	}
 
-	send := func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-		defer cancel()
+	var timeout <-chan time.Time
+	if b.batchConfig.OverflowTimeout > 0 {
+		timer := time.NewTimer(b.batchConfig.OverflowTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case in.ch <- req:
+		metrics.recordBatcherQueueDepth(in.name, in.depth())
+		return false, nil
+	case <-timeout:
+		metrics.recordBatcherOverflow(in.name, "timeout")
+		return false, fmt.Errorf("%w: the %s queue is still full after %s", ErrBatcherSaturated, in.name, b.batchConfig.OverflowTimeout)
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-b.closing:
+		return false, ErrBatcherClosed
+	}
+}
 
-		defer func() {
-			// reset the input
-			batch = make([]multihash.Multihash, 0, b.batchConfig.CountThreshold)
-		}()
+// spill reads the catalog of req and persists it in the durable queue of the lane, without waiting for the lane.
+// As in the lane, a catalog larger than the memory budget is persisted in several records.
+func (b *CatalogBatcher) spill(ctx context.Context, in *laneInput, req batchRequest) error {
+	batch := &mhBatch{}
+	added, err := consumeCatalog(b.logger(), req.catalog, batch, b.batchConfig.maxBatchBytes(), func(added []multihash.Multihash) error {
+		if err := in.queue.spill(ctx, added, nil); err != nil {
+			return err
+		}
+		batch.reset()
+		return nil
+	})
+	if err == nil && len(added) > 0 {
+		err = in.queue.spill(ctx, added, req.result)
+	} else if err == nil {
+		resolve(req.result, PublishResult{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to spill the catalog: %w", err)
+	}
+	select {
+	case in.spilled <- struct{}{}:
+	default:
+	}
+	return nil
+}
 
-		// kill the timer and drain the channel
-		timer = nil
+// QueueDepth returns the number of batched catalogs waiting for their lane, in the queues or spilled. It can be
+// used by the callers to shed the load before the batcher is saturated.
+func (b *CatalogBatcher) QueueDepth() int {
+	return b.publish.depth() + b.retract.depth()
+}
 
-		// TODO: implement retry, otherwise we'd drop entirely the advertisements!
-		newHead, err := fn(ctx, b.chainConfig, b.backend, CatalogFromMultihashes(batch...))
-		if err != nil {
-			logger.Errorw("failed to publish or retract batch", "err", err)
+// ValidateContextID checks that id can be published as a ContextID, once namespaced with ChainConfig.ContextIDNamespace.
+func (b *CatalogBatcher) ValidateContextID(id CatalogID) error {
+	_, err := b.config().contextID(id)
+	return err
+}
+
+// RetractContextID retracts the ContextID, without the catalog, and announces the new head. The retraction isn't
+// checked by the RetractionVerifier, which samples the multihashes of the catalog.
+func (b *CatalogBatcher) RetractContextID(ctx context.Context, id CatalogID) error {
+	return b.RetractContextIDs(ctx, []CatalogID{id})
+}
+
+// RetractContextIDs retracts all the given ContextIDs at once, with a single head update and a single announcement.
+func (b *CatalogBatcher) RetractContextIDs(ctx context.Context, ids []CatalogID) error {
+	cfg, release := b.lockConfig()
+	newHead, err := RetractContextIDs(ctx, cfg, b.backend, ids)
+	release()
+	if err != nil {
+		return err
+	}
+	return b.announce(ctx, newHead)
+}
+
+// UpdateMetadata updates the metadata of an already published ContextID, and announces the new head.
+func (b *CatalogBatcher) UpdateMetadata(ctx context.Context, contextID CatalogID, metadata []byte) error {
+	cfg, release := b.lockConfig()
+	newHead, err := UpdateMetadataWithContextID(ctx, cfg, b.backend, contextID, metadata)
+	release()
+	if err != nil {
+		return err
+	}
+	return b.announce(ctx, newHead)
+}
+
+// RetractNamespace retracts all the live ContextIDs of the namespace, see RetractNamespace, and announces the new
+// head. The backend must be a ChainReader.
+func (b *CatalogBatcher) RetractNamespace(ctx context.Context, namespace []byte) error {
+	reader, ok := b.backend.(ChainReader)
+	if !ok {
+		return fmt.Errorf("backend %T can't be read to find the ContextIDs of the namespace", b.backend)
+	}
+	cfg, release := b.lockConfig()
+	newHead, err := RetractNamespace(ctx, cfg, b.backend, reader, namespace)
+	release()
+	if err != nil || !newHead.Defined() {
+		return err
+	}
+	return b.announce(ctx, newHead)
+}
+
+// UpdateProviderAddrs publishes the new addresses of the provider, announces the new head, and uses the new addresses
+// for all the subsequent advertisements.
+func (b *CatalogBatcher) UpdateProviderAddrs(ctx context.Context, addrs []string) error {
+	// the lock waits for the advertisements being generated, and makes sure that none is generated with the old
+	// addresses after the update
+	b.cfgMu.Lock()
+	cfg := b.chainConfig
+	newHead, err := UpdateProviderAddrs(ctx, cfg, b.backend, addrs)
+	if err == nil {
+		b.chainConfig.ProviderAddrs = addrs
+	}
+	b.cfgMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return b.announce(ctx, newHead)
+}
+
+// announce sends an announcement of the new head, retrying according to the batcher RetryPolicy.
+// With an AnnounceInterval, the announcement is only scheduled.
+func (b *CatalogBatcher) announce(ctx context.Context, newHead cid.Cid) error {
+	if b.debouncer != nil {
+		b.debouncer.announce(newHead)
+		return nil
+	}
+	return b.sendAnnouncement(ctx, newHead)
+}
+
+func (b *CatalogBatcher) sendAnnouncement(ctx context.Context, newHead cid.Cid) error {
+	return announceHead(ctx, b.config(), b.announcer, b.batchConfig.RetryPolicy, newHead)
+}
+
+// FlushAnnouncements sends the announcement delayed by AnnounceInterval right away, if any.
+func (b *CatalogBatcher) FlushAnnouncements(ctx context.Context) error {
+	if b.debouncer == nil {
+		return nil
+	}
+	return b.debouncer.flush(ctx)
+}
+
+// batchLane is a batching lane, publishing or retracting the small catalogs in batches.
+type batchLane struct {
+	b     *CatalogBatcher
+	name  string
+	input *laneInput
+	fn    func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)
+	queue *batchQueue
+
+	counter uint64
+	batch   *mhBatch
+	timer   <-chan time.Time
+	// waiting are the results to deliver once the batch is published, and lastHead the head of the last batch
+	waiting  []chan PublishResult
+	lastHead cid.Cid
+	// pending is the set of the multihashes of the batch, only maintained with OrderedLanes
+	pending map[string]struct{}
+}
+
+func (b *CatalogBatcher) newLane(input *laneInput, fn func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)) *batchLane {
+	l := &batchLane{
+		b:     b,
+		name:  input.name,
+		input: input,
+		fn:    fn,
+		queue: input.queue,
+		// pre-alloc to CountThreshold as a first reasonable approximation
+		batch: &mhBatch{mhs: make([]multihash.Multihash, 0, b.batchConfig.CountThreshold)},
+	}
+	if b.batchConfig.OrderedLanes {
+		l.pending = make(map[string]struct{})
+	}
+	return l
+}
+
+func (b *CatalogBatcher) runBatcher(input *laneInput, fn func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error)) {
+	l := b.newLane(input, fn)
+	defer l.abort()
+	l.resume()
+	for {
+		select {
+		case <-l.timer:
+			_ = l.send()
+		case req := <-l.input.ch:
+			l.handle(req, nil)
+		case <-l.input.spilled:
+			l.adopt(nil)
+		case <-b.closing:
+			l.close()
 			return
 		}
+	}
+}
 
-		err = announce.Send(ctx, newHead, b.chainConfig.PublisherHttpAddrs, b.announcer)
-		if err != nil {
-			logger.Errorw("failed to publish new head", "err", err, "head", newHead.String())
+// runOrderedBatcher runs the publish and retract lanes together, so that the operations on a multihash land on the
+// chain in the order they were submitted.
+func (b *CatalogBatcher) runOrderedBatcher() {
+	publish := b.newLane(b.publish, b.publishRawMHs())
+	retract := b.newLane(b.retract, b.retractRawMHs())
+	defer publish.abort()
+	defer retract.abort()
+	publish.resume()
+	retract.resume()
+	for {
+		select {
+		case <-publish.timer:
+			_ = publish.send()
+		case <-retract.timer:
+			_ = retract.send()
+		case req := <-publish.input.ch:
+			publish.handle(req, retract)
+		case req := <-retract.input.ch:
+			retract.handle(req, publish)
+		case <-publish.input.spilled:
+			publish.adopt(retract)
+		case <-retract.input.spilled:
+			retract.adopt(publish)
+		case <-b.closing:
+			publish.close()
+			retract.close()
 			return
 		}
 	}
+}
 
+// abort hands over the batch and the results waiting for it to the next run of the lane, after a crash. With a
+// queue, the batch is loaded again from it instead.
+func (l *batchLane) abort() {
+	if l.queue == nil && len(l.batch.mhs) > 0 {
+		l.input.kept = append(l.input.kept[:0], l.batch.mhs...)
+	}
+	l.input.keptResults = append(l.input.keptResults, l.waiting...)
+	l.waiting = nil
+}
+
+// close fails the catalogs still queued and the results waiting for the batch, as the batcher is closed.
+func (l *batchLane) close() {
 	for {
 		select {
-		case <-timer:
-			send()
+		case req := <-l.input.ch:
+			l.b.config().Metrics.recordBatcherQueueDepth(l.name, l.input.depth())
+			req.take()
+			req.ack <- ErrBatcherClosed
+			resolve(req.result, PublishResult{Err: ErrBatcherClosed})
+		default:
+			l.fail(ErrBatcherClosed)
+			return
+		}
+	}
+}
 
-		case catalog := <-ch:
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			iter, err := catalog.Iterator(ctx)
-			cancel()
-			if err != nil {
-				logger.Errorw("failed to get catalog iterator", "err", err)
-				continue
-			}
+// fail fails the results waiting for the batch.
+func (l *batchLane) fail(err error) {
+	for _, result := range l.waiting {
+		resolve(result, PublishResult{Err: err})
+	}
+	l.waiting = nil
+}
 
-			// Note: we always consume the whole catalog, even if that means overshooting the batch limit
-			for !iter.Done() {
-				batch = append(batch, iter.Next())
-				counter++
-			}
+// resume loads the batch left over by a previous run, with the catalogs spilled and not adopted yet.
+func (l *batchLane) resume() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	pending, results, err := l.queue.load(ctx)
+	cancel()
+	if err != nil {
+		l.b.logger().Errorw("failed to load the batch queue", "lane", l.name, "err", err)
+	}
+	pending = append(l.input.kept, pending...)
+	l.waiting = append(append(l.waiting, l.input.keptResults...), results...)
+	l.input.kept, l.input.keptResults = nil, nil
+	if len(pending) > 0 {
+		l.b.logger().Infow("resuming persisted batch", "lane", l.name, "count", len(pending))
+		for _, mh := range pending {
+			l.batch.append(mh)
+		}
+		l.track(pending)
+		l.timer = time.After(0)
+	}
+}
+
+// track adds the multihashes to the pending set, if maintained.
+func (l *batchLane) track(mhs []multihash.Multihash) {
+	if l.pending == nil {
+		return
+	}
+	for _, mh := range mhs {
+		l.pending[string(mh)] = struct{}{}
+	}
+}
+
+// overlaps returns true if one of the multihashes is in the batch. It's always false without OrderedLanes.
+func (l *batchLane) overlaps(mhs []multihash.Multihash) bool {
+	if len(l.pending) == 0 {
+		return false
+	}
+	for _, mh := range mhs {
+		if _, ok := l.pending[string(mh)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// order flushes the other lane if it holds some of the multihashes, so that its older operations on them land first.
+func (l *batchLane) order(other *batchLane, mhs []multihash.Multihash) error {
+	if other == nil || !other.overlaps(mhs) {
+		return nil
+	}
+	if err := other.send(); err != nil {
+		return fmt.Errorf("failed to flush the %s lane first: %w", other.name, err)
+	}
+	return nil
+}
+
+// send publishes the batch, and announces the new head.
+func (l *batchLane) send() error {
+	b := l.b
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	ctx, span := b.config().tracer().Start(ctx, "CatalogBatcher.flush", trace.WithAttributes(
+		attribute.String("herald.lane", l.name), attribute.Int("herald.multihashes", len(l.batch.mhs))))
+	defer span.End()
+
+	// kill the timer and drain the channel
+	l.timer = nil
+
+	var newHead cid.Cid
+	start := time.Now()
+	err := b.batchConfig.RetryPolicy.withLogger(b.logger()).Do(ctx, func(ctx context.Context) error {
+		cfg, release := b.lockConfig()
+		defer release()
+		var err error
+		newHead, err = l.fn(ctx, cfg, b.backend, CatalogFromMultihashes(l.batch.mhs...))
+		return err
+	})
+	b.config().Metrics.recordBatchFlush(l.name, len(l.batch.mhs), time.Since(start), err)
+	if b.batchConfig.OnFlush != nil {
+		b.batchConfig.OnFlush(l.name, len(l.batch.mhs), newHead, err)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		// keep the batch, and try again later
+		b.logger().Errorw("failed to publish or retract batch, retrying later", "lane", l.name, "count", len(l.batch.mhs), "err", err, "delay", b.batchConfig.MaxDelay)
+		l.timer = time.After(b.batchConfig.MaxDelay)
+		return err
+	}
+
+	// reset the input
+	l.batch.reset()
+	if l.pending != nil {
+		clear(l.pending)
+	}
+	if err := l.queue.clear(ctx); err != nil {
+		// the multihashes will be published again on restart, which is harmless
+		b.logger().Errorw("failed to clear the batch queue", "lane", l.name, "err", err)
+	}
+
+	err = b.announce(ctx, newHead)
+	if err != nil {
+		b.logger().Errorw("failed to publish new head", "err", err, "head", newHead.String())
+	}
+	// the batch is published, a failed announcement is caught up by the next one
+	l.lastHead = newHead
+	for _, result := range l.waiting {
+		resolve(result, PublishResult{Head: newHead})
+	}
+	l.waiting = nil
+	return nil
+}
 
-			if len(batch) >= b.batchConfig.MaxMHsPerAdvertisement {
-				send()
-				continue
+// adopt adds the catalogs spilled by the callers to the batch. With OrderedLanes, other is the other lane, flushed
+// first when it holds some of their multihashes.
+func (l *batchLane) adopt(other *batchLane) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	mhs, results, err := l.queue.adopt(ctx)
+	cancel()
+	if err != nil {
+		// the records left in the queue are adopted on the next signal
+		l.b.logger().Errorw("failed to adopt the spilled catalogs", "lane", l.name, "err", err)
+		time.AfterFunc(l.b.batchConfig.MaxDelay, func() {
+			select {
+			case l.input.spilled <- struct{}{}:
+			default:
 			}
+		})
+	}
+	l.b.config().Metrics.recordBatcherQueueDepth(l.name, l.input.depth())
+	if len(mhs) == 0 {
+		for _, result := range results {
+			resolve(result, PublishResult{Head: l.lastHead})
+		}
+		return
+	}
+	if err := l.order(other, mhs); err != nil {
+		// already persisted in this lane: the catalogs are published anyway, possibly out of order
+		l.b.logger().Errorw("failed to order the spilled catalogs", "lane", l.name, "err", err)
+	}
+	for _, mh := range mhs {
+		l.batch.append(mh)
+	}
+	l.counter += uint64(len(mhs))
+	l.track(mhs)
+	l.waiting = append(l.waiting, results...)
+
+	if len(l.batch.mhs) >= l.b.batchConfig.MaxMHsPerAdvertisement {
+		_ = l.send()
+		return
+	}
+	if l.timer == nil {
+		l.timer = time.After(l.b.batchConfig.MaxDelay)
+	}
+}
+
+// persist saves the multihashes added to the batch in the queue.
+func (l *batchLane) persist(mhs []multihash.Multihash) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := l.queue.push(ctx, mhs)
+	if err != nil {
+		l.b.logger().Errorw("failed to persist catalog in the batch queue", "lane", l.name, "err", err)
+	}
+	return err
+}
+
+// handle adds the catalog of req to the batch. With OrderedLanes, other is the other lane, flushed first when it
+// holds some of the multihashes of the catalog.
+func (l *batchLane) handle(req batchRequest, other *batchLane) {
+	l.b.config().Metrics.recordBatcherQueueDepth(l.name, l.input.depth())
+	if !req.take() {
+		// the caller gave up while the catalog was queued
+		err := req.ctx.Err()
+		req.ack <- err
+		resolve(req.result, PublishResult{Err: err})
+		return
+	}
+	var spillErr error
+	added, err := consumeCatalog(l.b.logger(), req.catalog, l.batch, l.b.batchConfig.maxBatchBytes(), func(added []multihash.Multihash) error {
+		// the memory budget is reached in the middle of the catalog: flush what we have before continuing
+		l.counter += uint64(len(added))
+		spillErr = l.order(other, added)
+		if spillErr == nil {
+			spillErr = l.persist(added)
+		}
+		if spillErr == nil {
+			l.track(added)
+			spillErr = l.send()
+		}
+		return spillErr
+	})
+	if spillErr != nil {
+		// what was consumed so far stays in the batch, the rest is up to the caller to submit again
+		req.ack <- fmt.Errorf("batch memory budget reached and flush failed: %w", spillErr)
+		return
+	}
+	if err != nil {
+		// consumeCatalog dropped what it read since the last flush: the catalog is up to the caller to submit again
+		l.b.logger().Errorw("failed to read catalog", "contextID", logContextID(req.catalog.ID()), "err", err)
+		req.ack <- fmt.Errorf("failed to read catalog: %w", err)
+		resolve(req.result, PublishResult{Err: err})
+		return
+	}
+	if err := l.order(other, added); err != nil {
+		// the other lane must land first, the catalog is up to the caller to submit again
+		l.batch.truncate(len(l.batch.mhs) - len(added))
+		req.ack <- err
+		return
+	}
+	l.counter += uint64(len(added))
+	err = l.persist(added)
+	if err == nil {
+		l.track(added)
+	}
+	req.ack <- err
+	switch {
+	case err != nil || req.result == nil:
+	case len(l.batch.mhs) == 0:
+		// already published by the flushes while reading the catalog
+		resolve(req.result, PublishResult{Head: l.lastHead})
+	default:
+		l.waiting = append(l.waiting, req.result)
+	}
+
+	if len(l.batch.mhs) >= l.b.batchConfig.MaxMHsPerAdvertisement {
+		_ = l.send()
+		return
+	}
+
+	// start the timer if needed
+	if l.timer == nil && len(l.batch.mhs) > 0 {
+		l.timer = time.After(l.b.batchConfig.MaxDelay)
+	}
+}
+
+// mhBatch is the multihashes accumulated by a batching lane, with their memory footprint.
+type mhBatch struct {
+	mhs   []multihash.Multihash
+	bytes int
+}
+
+// mhOverhead is the memory used by a multihash on top of its content: its slice header.
+const mhOverhead = 24
+
+func (b *mhBatch) append(mh multihash.Multihash) {
+	b.mhs = append(b.mhs, mh)
+	b.bytes += len(mh) + mhOverhead
+}
+
+// truncate drops the multihashes after the first n.
+func (b *mhBatch) truncate(n int) {
+	for _, mh := range b.mhs[n:] {
+		b.bytes -= len(mh) + mhOverhead
+	}
+	clear(b.mhs[n:])
+	b.mhs = b.mhs[:n]
+}
+
+// reset empties the batch, keeping the allocated memory for the next one.
+func (b *mhBatch) reset() {
+	b.truncate(0)
+}
+
+// consumeCatalog appends all the multihashes of the catalog to batch, and returns the ones added.
+// Each time the batch reaches budget bytes, spill is called with the multihashes added so far, to persist and flush
+// them before the iteration continues: this is the back-pressure on the iterator. If spill fails, the iteration stops
+// and the error is returned.
+// A panic during the iteration is recovered and returned as an error, in which case the multihashes added since the
+// last spill are dropped from the batch.
+func consumeCatalog(logger *zap.SugaredLogger, catalog Catalog, batch *mhBatch, budget int, spill func(added []multihash.Multihash) error) (added []multihash.Multihash, err error) {
+	before := len(batch.mhs)
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorw("panic while iterating catalog", "panic", r, "stack", string(debug.Stack()))
+			batch.truncate(before)
+			added, err = nil, fmt.Errorf("panic while iterating catalog: %v", r)
+		}
+	}()
+
+	// the context lives as long as the iteration, as lazy iterators read with it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter, err := catalog.Iterator(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-			// start the timer if needed
-			if timer == nil {
-				timer = time.After(b.batchConfig.MaxDelay)
+	// Note: we consume the whole catalog even if that means overshooting MaxMHsPerAdvertisement, but not the budget
+	for !iter.Done() {
+		batch.append(iter.Next())
+		if batch.bytes >= budget {
+			if err := spill(batch.mhs[before:]); err != nil {
+				return nil, err
 			}
+			before = len(batch.mhs)
 		}
 	}
+	if err := iteratorErr(iter); err != nil {
+		batch.truncate(before)
+		return nil, err
+	}
+	return batch.mhs[before:], nil
+}
+
+const (
+	supervisorMinBackoff = time.Second
+	supervisorMaxBackoff = time.Minute
+)
+
+// startRetractionVerification runs the retraction verification in the background, until it completes or the batcher
+// is closed.
+func (b *CatalogBatcher) startRetractionVerification(contextID []byte, sample []multihash.Multihash) {
+	b.verifyMu.Lock()
+	defer b.verifyMu.Unlock()
+	if b.verifyCtx.Err() != nil {
+		return
+	}
+	b.verifying.Add(1)
+	go func() {
+		defer b.verifying.Done()
+		b.verifyRetraction(b.verifyCtx, contextID, sample)
+	}()
+}
+
+// verifyRetraction runs the retraction verification and reports the outcome.
+func (b *CatalogBatcher) verifyRetraction(ctx context.Context, contextID []byte, sample []multihash.Multihash) {
+	verifier := b.batchConfig.RetractionVerifier
+	logger := verifier.Logger
+	if logger == nil {
+		logger = b.logger()
+	}
+	report := verifier.verify(ctx, contextID, sample, logger)
+	if verifier.OnReport != nil {
+		verifier.OnReport(report)
+	}
+}
+
+// supervise runs fn, and restarts it with an increasing delay if it panics.
+// It returns when fn returns normally.
+func (b *CatalogBatcher) supervise(lane string, fn func()) {
+	var crashes int
+	backoff := supervisorMinBackoff
+
+	for {
+		start := time.Now()
+		recovered, panicked := runRecovered(b.logger(), fn)
+		if !panicked {
+			return
+		}
+
+		// a goroutine that ran fine for a while before crashing is not considered as crashing repeatedly
+		if time.Since(start) > supervisorMaxBackoff {
+			crashes = 0
+			backoff = supervisorMinBackoff
+		}
+		crashes++
+
+		b.logger().Errorw("batcher crashed, restarting", "lane", lane, "panic", recovered, "crashes", crashes, "delay", backoff)
+		b.config().Metrics.recordBatcherCrash(lane)
+		b.crashMu.Lock()
+		if b.crashes == nil {
+			b.crashes = make(map[string]laneCrash)
+		}
+		b.crashes[lane] = laneCrash{at: time.Now(), count: crashes, recovered: recovered}
+		b.crashMu.Unlock()
+		if b.batchConfig.OnPanic != nil {
+			b.batchConfig.OnPanic(lane, recovered, crashes)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-b.closing:
+			return
+		}
+		backoff *= 2
+		if backoff > supervisorMaxBackoff {
+			backoff = supervisorMaxBackoff
+		}
+	}
+}
+
+// Health returns an error if a batching lane crashed recently, within the longest restart delay, to report it to a
+// health check. The lanes are restarted automatically, see BatchConfig.OnPanic.
+func (b *CatalogBatcher) Health() error {
+	b.crashMu.Lock()
+	defer b.crashMu.Unlock()
+	var errs []error
+	for lane, crash := range b.crashes {
+		if time.Since(crash.at) < supervisorMaxBackoff {
+			errs = append(errs, fmt.Errorf("the %s batching lane crashed %d times, last at %s: %v",
+				lane, crash.count, crash.at.Format(time.RFC3339), crash.recovered))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runRecovered(logger *zap.SugaredLogger, fn func()) (recovered any, panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorw("recovered panic", "panic", r, "stack", string(debug.Stack()))
+			recovered, panicked = r, true
+		}
+	}()
+	fn()
+	return nil, false
 }
@@ -0,0 +1,216 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// batchQueue persists the multihashes of a batching lane until they are published, so that they survive a restart.
+// A nil *batchQueue is valid and persists nothing.
+//
+// Each consumed catalog is stored as a single record, keyed by a sequence number to preserve the order. The catalogs
+// spilled by the callers while the lane is saturated are stored the same way, until the lane adopts them.
+type batchQueue struct {
+	ds   datastore.Datastore
+	lane datastore.Key
+	log  *zap.SugaredLogger
+
+	// ready is closed once the records of a previous run are loaded, before which nothing can be spilled
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu      sync.Mutex // protects seq and spilled, shared with the callers spilling catalogs
+	seq     uint64
+	spilled []spilledRecord
+	// pending are the records of the batch, only used by the lane
+	pending []datastore.Key
+}
+
+// spilledRecord is a catalog spilled to the queue, with the result to deliver once published, if requested.
+type spilledRecord struct {
+	key    datastore.Key
+	result chan PublishResult
+}
+
+func newBatchQueue(ds datastore.Datastore, lane string, log *zap.SugaredLogger) *batchQueue {
+	if ds == nil {
+		return nil
+	}
+	return &batchQueue{ds: ds, lane: datastore.NewKey(lane), log: log, ready: make(chan struct{})}
+}
+
+// load returns the multihashes persisted by a previous run of the lane, in order, including the spilled ones not
+// adopted yet, whose results are returned.
+func (q *batchQueue) load(ctx context.Context) ([]multihash.Multihash, []chan PublishResult, error) {
+	if q == nil {
+		return nil, nil, nil
+	}
+	defer q.readyOnce.Do(func() { close(q.ready) })
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var results []chan PublishResult
+	for _, record := range q.spilled {
+		if record.result != nil {
+			results = append(results, record.result)
+		}
+	}
+	q.spilled = nil
+	q.pending = q.pending[:0]
+
+	res, err := q.ds.Query(ctx, query.Query{
+		Prefix: q.lane.String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, results, err
+	}
+	defer res.Close()
+
+	var mhs []multihash.Multihash
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, results, r.Error
+		}
+		key := datastore.RawKey(r.Key)
+		seq, err := strconv.ParseUint(key.BaseNamespace(), 10, 64)
+		if err != nil {
+			q.log.Warnw("skipping unknown key in the batch queue", "key", r.Key)
+			continue
+		}
+		mhs, err = decodeMultihashes(r.Value, mhs)
+		if err != nil {
+			return nil, results, fmt.Errorf("corrupted batch queue record %s: %w", r.Key, err)
+		}
+		q.pending = append(q.pending, key)
+		q.seq = max(q.seq, seq+1)
+	}
+	return mhs, results, nil
+}
+
+// push persists the multihashes of a consumed catalog.
+func (q *batchQueue) push(ctx context.Context, mhs []multihash.Multihash) error {
+	if q == nil || len(mhs) == 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key, err := q.put(ctx, mhs)
+	if err != nil {
+		return err
+	}
+	q.pending = append(q.pending, key)
+	return nil
+}
+
+// spill persists the multihashes of a catalog submitted while the lane is saturated, for the lane to adopt them
+// later. Unlike the other methods, it's called by the callers' goroutines.
+func (q *batchQueue) spill(ctx context.Context, mhs []multihash.Multihash, result chan PublishResult) error {
+	select {
+	case <-q.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key, err := q.put(ctx, mhs)
+	if err != nil {
+		return err
+	}
+	q.spilled = append(q.spilled, spilledRecord{key: key, result: result})
+	return nil
+}
+
+// put stores a record with the next sequence number. q.mu must be held.
+func (q *batchQueue) put(ctx context.Context, mhs []multihash.Multihash) (datastore.Key, error) {
+	var buf bytes.Buffer
+	for _, mh := range mhs {
+		buf.Write(mh)
+	}
+	// zero-padded, so that the lexicographic order is the insertion order
+	key := q.lane.ChildString(fmt.Sprintf("%020d", q.seq))
+	if err := q.ds.Put(ctx, key, buf.Bytes()); err != nil {
+		return datastore.Key{}, err
+	}
+	q.seq++
+	return key, nil
+}
+
+// adopt returns the multihashes spilled since the last adoption, in order, with their results. They become part of
+// the batch, and are cleared with it once published.
+func (q *batchQueue) adopt(ctx context.Context) ([]multihash.Multihash, []chan PublishResult, error) {
+	if q == nil {
+		return nil, nil, nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var mhs []multihash.Multihash
+	var results []chan PublishResult
+	for i, record := range q.spilled {
+		data, err := q.ds.Get(ctx, record.key)
+		if err != nil {
+			// the records adopted so far are returned, the others are left for a later adoption
+			q.spilled = q.spilled[i:]
+			return mhs, results, fmt.Errorf("failed to read the spilled record %s: %w", record.key, err)
+		}
+		decoded, err := decodeMultihashes(data, nil)
+		if err != nil {
+			q.log.Errorw("dropping corrupted spilled record", "key", record.key, "err", err)
+		}
+		mhs = append(mhs, decoded...)
+		q.pending = append(q.pending, record.key)
+		if record.result != nil {
+			results = append(results, record.result)
+		}
+	}
+	q.spilled = nil
+	return mhs, results, nil
+}
+
+// spilledCount returns the number of spilled catalogs not adopted yet.
+func (q *batchQueue) spilledCount() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.spilled)
+}
+
+// clear removes the persisted multihashes, once published.
+func (q *batchQueue) clear(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	var errs []error
+	for _, key := range q.pending {
+		errs = append(errs, q.ds.Delete(ctx, key))
+	}
+	q.pending = q.pending[:0]
+	return errors.Join(errs...)
+}
+
+// decodeMultihashes appends to mhs the multihashes concatenated in data.
+func decodeMultihashes(data []byte, mhs []multihash.Multihash) ([]multihash.Multihash, error) {
+	r := multihash.NewReader(bytes.NewReader(data))
+	for {
+		mh, err := r.ReadMultihash()
+		if errors.Is(err, io.EOF) {
+			return mhs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		mhs = append(mhs, mh)
+	}
+}
@@ -2,32 +2,24 @@ package herald
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	gosync "sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	"github.com/ipld/go-ipld-prime/datamodel"
-	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-datastore/sync"
 	"github.com/ipni/go-libipni/announce"
 	"github.com/ipni/go-libipni/announce/message"
 	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
-var _ ChainWriter = nilBackend{}
-
-type nilBackend struct{}
-
-func (n nilBackend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
-	return nil
-}
-
-func (n nilBackend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, node datamodel.Node) (datamodel.Link, error) {
-	return nil, nil
-}
-
 var _ announce.Sender = nilAnnouncer{}
 
 type nilAnnouncer struct{}
@@ -79,8 +71,11 @@ func TestBatching(t *testing.T) {
 		}
 		return CatalogFromMultihashes(mhs...)
 	}
+	makeLargeCatalog := func(size int) Catalog {
+		return testCatalog{MhCatalog: makeCatalog(size).(MhCatalog), id: []byte(strconv.Itoa(counter))}
+	}
 
-	batcher := StartCatalogBatcher(cfg, ChainConfig{}, nilBackend{}, nilAnnouncer{})
+	batcher := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
 
 	// Publish: batch small catalogs
 	for i := 0; i < 1000; i++ {
@@ -90,9 +85,9 @@ func TestBatching(t *testing.T) {
 	eventuallyEqual(t, &publishRawMHs, 5000)
 
 	// Publish: pass through large catalogs
-	err := batcher.PublishCatalog(ctx, makeCatalog(1000))
+	err := batcher.PublishCatalog(ctx, makeLargeCatalog(1000))
 	require.NoError(t, err)
-	err = batcher.PublishCatalog(ctx, makeCatalog(1000))
+	err = batcher.PublishCatalog(ctx, makeLargeCatalog(1000))
 	require.NoError(t, err)
 	eventuallyEqual(t, &publishWithContextID, 2000)
 
@@ -104,16 +99,28 @@ func TestBatching(t *testing.T) {
 	eventuallyEqual(t, &retractRawMHs, 5000)
 
 	// Retract: pass through large catalogs
-	err = batcher.RetractCatalog(ctx, makeCatalog(1000))
+	err = batcher.RetractCatalog(ctx, makeLargeCatalog(1000))
 	require.NoError(t, err)
-	err = batcher.RetractCatalog(ctx, makeCatalog(1000))
+	err = batcher.RetractCatalog(ctx, makeLargeCatalog(1000))
 	require.NoError(t, err)
 	eventuallyEqual(t, &retractWithContextID, 2000)
 
-	require.Equal(t, int64(5000), publishRawMHs)
-	require.Equal(t, int64(5000), retractRawMHs)
-	require.Equal(t, int64(2000), publishWithContextID)
-	require.Equal(t, int64(2000), retractWithContextID)
+	// Unknown count: pass through the catalogs with a ContextID, which a batch would drop
+	filtered := FilterCatalog(testCatalog{MhCatalog: testMultihashes(5), id: []byte("filtered")}, func(multihash.Multihash) bool { return true })
+	require.Negative(t, filtered.Count())
+	require.NoError(t, batcher.PublishCatalog(ctx, filtered))
+	eventuallyEqual(t, &publishWithContextID, 2000+int64(filtered.Count()))
+	require.NoError(t, batcher.RetractCatalog(ctx, filtered))
+	eventuallyEqual(t, &retractWithContextID, 2000+int64(filtered.Count()))
+
+	// Large without ContextID: batched, as it can't be published on its own
+	require.NoError(t, batcher.PublishCatalog(ctx, makeCatalog(1000)))
+	eventuallyEqual(t, &publishRawMHs, 6000)
+	require.NoError(t, batcher.RetractCatalog(ctx, makeCatalog(1000)))
+	eventuallyEqual(t, &retractRawMHs, 6000)
+
+	require.Equal(t, int64(2000)+int64(filtered.Count()), publishWithContextID)
+	require.Equal(t, int64(2000)+int64(filtered.Count()), retractWithContextID)
 }
 
 func eventuallyEqual(t *testing.T, i *int64, expected int64) {
@@ -121,3 +128,459 @@ func eventuallyEqual(t *testing.T, i *int64, expected int64) {
 		return atomic.LoadInt64(i) == expected
 	}, 5*time.Second, 100*time.Millisecond)
 }
+
+type panickingCatalog struct{}
+
+func (p panickingCatalog) ID() []byte { return nil }
+
+func (p panickingCatalog) Count() int { return 1 }
+
+func (p panickingCatalog) Iterator(_ context.Context) (MhIterator, error) {
+	panic("boom")
+}
+
+func TestBatchingPanicRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	var published, panics int64
+	var crashed int32
+
+	cfg := BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 2,
+		MaxDelay:               time.Second,
+		OnPanic: func(lane string, recovered any, crashes int) {
+			atomic.AddInt64(&panics, 1)
+		},
+		publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			if atomic.CompareAndSwapInt32(&crashed, 0, 1) {
+				panic("publish crashed")
+			}
+			atomic.AddInt64(&published, int64(catalog.Count()))
+			return cid.Undef, nil
+		},
+	}
+
+	batcher := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+
+	mh := func(s string) multihash.Multihash {
+		h, _ := multihash.Sum([]byte(s), multihash.SHA2_256, -1)
+		return h
+	}
+
+	// a panicking catalog is rejected, without crashing the batcher
+	require.Error(t, batcher.PublishCatalog(ctx, panickingCatalog{}))
+
+	// the first flush crashes the batcher, which gets restarted with its batch
+	result, err := batcher.PublishCatalogResult(ctx, CatalogFromMultihashes(mh("a"), mh("b")))
+	require.NoError(t, err)
+	eventuallyEqual(t, &panics, 1)
+	require.Error(t, batcher.Health())
+
+	require.NoError(t, batcher.PublishCatalog(ctx, CatalogFromMultihashes(mh("c"))))
+	eventuallyEqual(t, &published, 3)
+	select {
+	case r := <-result:
+		require.NoError(t, r.Err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+	}
+}
+
+func TestBatchingFailureKeepsBatch(t *testing.T) {
+	ctx := context.Background()
+
+	var attempts, published int64
+
+	cfg := BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 100,
+		MaxDelay:               50 * time.Millisecond,
+		RetryPolicy:            RetryPolicy{MaxAttempts: 2},
+		publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			if atomic.AddInt64(&attempts, 1) <= 3 {
+				return cid.Undef, errors.New("backend unavailable")
+			}
+			atomic.AddInt64(&published, int64(catalog.Count()))
+			return cid.Undef, nil
+		},
+	}
+
+	batcher := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+	require.NoError(t, batcher.PublishCatalog(ctx, CatalogFromMultihashes(testMultihashes(5)...)))
+
+	// the retries of the first send are exhausted, but the batch is sent again later
+	eventuallyEqual(t, &published, 5)
+	require.Equal(t, int64(4), atomic.LoadInt64(&attempts))
+}
+
+func TestBatchingQueue(t *testing.T) {
+	ctx := context.Background()
+	queue := sync.MutexWrap(datastore.NewMapDatastore())
+	mhs := testMultihashes(6)
+
+	var failed, published int64
+	cfg := BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 4,
+		MaxDelay:               time.Hour,
+		Queue:                  queue,
+		publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			atomic.AddInt64(&failed, 1)
+			return cid.Undef, errors.New("backend unavailable")
+		},
+	}
+
+	// a batcher unable to publish, as if the process was stopped before recovering
+	failing := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+	require.NoError(t, failing.PublishCatalog(ctx, CatalogFromMultihashes(mhs[:3]...)))
+	require.NoError(t, failing.PublishCatalog(ctx, CatalogFromMultihashes(mhs[3:]...)))
+	eventuallyEqual(t, &failed, 1)
+
+	// a new batcher resumes the persisted batch
+	var got []multihash.Multihash
+	cfg.publishRawMHs = func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+		got = append(got, catalog.(MhCatalog)...)
+		atomic.AddInt64(&published, int64(catalog.Count()))
+		return cid.Undef, nil
+	}
+	_ = StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+	eventuallyEqual(t, &published, 6)
+	require.Equal(t, mhs, got)
+
+	// the queue is emptied once published
+	require.Eventually(t, func() bool {
+		res, err := queue.Query(ctx, query.Query{KeysOnly: true})
+		require.NoError(t, err)
+		entries, err := res.Rest()
+		require.NoError(t, err)
+		return len(entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// unknownCountCatalog is a catalog not knowing its count, which is always batched.
+type unknownCountCatalog struct{ MhCatalog }
+
+func (u unknownCountCatalog) Count() int { return -1 }
+
+func TestBatchingMemoryBudget(t *testing.T) {
+	ctx := context.Background()
+	mhs := testMultihashes(100)
+	mhSize := len(mhs[0]) + mhOverhead
+
+	var published, largest int64
+	var fail atomic.Bool
+	cfg := BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 1000,
+		MaxDelay:               time.Hour,
+		MaxBatchBytes:          20 * mhSize,
+		publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			if fail.Load() {
+				return cid.Undef, errors.New("backend unavailable")
+			}
+			atomic.AddInt64(&published, int64(catalog.Count()))
+			if n := int64(catalog.Count()); n > atomic.LoadInt64(&largest) {
+				atomic.StoreInt64(&largest, n)
+			}
+			return cid.Undef, nil
+		},
+	}
+	batcher := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+
+	// the catalog is flushed while iterated, never exceeding the budget
+	require.NoError(t, batcher.PublishCatalog(ctx, unknownCountCatalog{mhs}))
+	eventuallyEqual(t, &published, 100)
+	require.Equal(t, int64(20), atomic.LoadInt64(&largest))
+
+	// a failed flush rejects the catalog
+	fail.Store(true)
+	require.Error(t, batcher.PublishCatalog(ctx, unknownCountCatalog{mhs}))
+}
+
+// recordingSender is an announce.Sender recording the announced heads.
+type recordingSender struct {
+	mu   gosync.Mutex
+	sent []cid.Cid
+	last message.Message
+}
+
+func (r *recordingSender) Close() error { return nil }
+
+func (r *recordingSender) Send(_ context.Context, msg message.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, msg.Cid)
+	r.last = msg
+	return nil
+}
+
+func (r *recordingSender) heads() []cid.Cid {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]cid.Cid(nil), r.sent...)
+}
+
+func TestBatchingAnnounceInterval(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+	sender := &recordingSender{}
+	b := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         0,
+		MaxMHsPerAdvertisement: 1000,
+		MaxDelay:               time.Hour,
+		AnnounceInterval:       300 * time.Millisecond,
+	}, cfg, backend, sender)
+
+	for i := 0; i < 5; i++ {
+		catalog := testCatalog{MhCatalog: testMultihashes(i + 1), id: []byte(strconv.Itoa(i))}
+		require.NoError(t, b.PublishCatalog(ctx, catalog))
+	}
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+
+	// the first head is announced right away, the others are coalesced into the latest
+	require.Eventually(t, func() bool {
+		heads := sender.heads()
+		return len(heads) > 0 && heads[len(heads)-1] == head
+	}, 2*time.Second, 10*time.Millisecond)
+	require.LessOrEqual(t, len(sender.heads()), 2)
+
+	// a pending announcement is sent on flush, without waiting for the interval
+	require.NoError(t, b.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(10), id: []byte("last")}))
+	require.NoError(t, b.FlushAnnouncements(ctx))
+	head, err = backend.GetHead(ctx)
+	require.NoError(t, err)
+	heads := sender.heads()
+	require.Equal(t, head, heads[len(heads)-1])
+	require.NoError(t, b.FlushAnnouncements(ctx))
+	require.Len(t, sender.heads(), len(heads))
+}
+
+func TestBatchingPublishResult(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	var calls, flushes atomic.Int32
+	b := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 1000,
+		MaxDelay:               100 * time.Millisecond,
+		OnFlush: func(lane string, count int, head cid.Cid, err error) {
+			require.Equal(t, "publish", lane)
+			flushes.Add(1)
+		},
+		publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			if calls.Add(1) == 1 {
+				return cid.Undef, errors.New("backend down")
+			}
+			return PublishRawMHs(ctx, cfg, backend, catalog)
+		},
+	}, cfg, backend, nilAnnouncer{})
+
+	first, err := b.PublishCatalogResult(ctx, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	second, err := b.PublishCatalogResult(ctx, CatalogFromMultihashes(testMultihashes(3)...))
+	require.NoError(t, err)
+	select {
+	case <-first:
+		t.Fatal("result before the batch is published")
+	default:
+	}
+
+	// the first flush fails, the results come with the retry
+	var results []PublishResult
+	for _, ch := range []<-chan PublishResult{first, second} {
+		select {
+		case r := <-ch:
+			results = append(results, r)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no result")
+		}
+	}
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []PublishResult{{Head: head}, {Head: head}}, results)
+	require.EqualValues(t, 2, calls.Load())
+	require.EqualValues(t, 2, flushes.Load())
+
+	// not batched: the result is immediate
+	large, err := b.PublishCatalogResult(ctx, testCatalog{MhCatalog: testMultihashes(20), id: []byte("large")})
+	require.NoError(t, err)
+	r := <-large
+	require.NoError(t, r.Err)
+	head, err = backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, r.Head)
+}
+
+func TestBatchingClose(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ordered=%v", ordered), func(t *testing.T) {
+			ctx := context.Background()
+			var published int64
+			b := StartCatalogBatcher(BatchConfig{
+				CountThreshold:         10,
+				MaxMHsPerAdvertisement: 1000,
+				MaxDelay:               time.Hour,
+				OrderedLanes:           ordered,
+				publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+					atomic.AddInt64(&published, int64(catalog.Count()))
+					return cid.Undef, nil
+				},
+			}, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+
+			result, err := b.PublishCatalogResult(ctx, CatalogFromMultihashes(testMultihashes(2)...))
+			require.NoError(t, err)
+			require.NoError(t, b.Close(ctx))
+
+			// the lanes are stopped: the waiting result fails, and the batch is not published
+			select {
+			case r := <-result:
+				require.ErrorIs(t, r.Err, ErrBatcherClosed)
+			default:
+				t.Fatal("no result")
+			}
+			require.Zero(t, atomic.LoadInt64(&published))
+			require.ErrorIs(t, b.PublishCatalog(ctx, CatalogFromMultihashes(testMultihashes(2)...)), ErrBatcherClosed)
+			require.ErrorIs(t, b.RetractCatalog(ctx, CatalogFromMultihashes(testMultihashes(2)...)), ErrBatcherClosed)
+			require.NoError(t, b.Close(ctx))
+		})
+	}
+}
+
+func TestBatchingOrderedLanes(t *testing.T) {
+	ctx := context.Background()
+	mhs := testMultihashes(6)
+
+	var mu gosync.Mutex
+	var flushes []string
+	record := func(lane string) func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+		return func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			flushes = append(flushes, lane+":"+strconv.Itoa(catalog.Count()))
+			return cid.Undef, nil
+		}
+	}
+	cfg := BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 100,
+		MaxDelay:               time.Hour,
+		OrderedLanes:           true,
+		publishRawMHs:          record("publish"),
+		retractRawMHs:          record("retract"),
+	}
+	batcher := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+	flushed := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), flushes...)
+	}
+
+	// disjoint catalogs don't flush the other lane
+	require.NoError(t, batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[:3]...)))
+	require.NoError(t, batcher.RetractCatalog(ctx, CatalogFromMultihashes(mhs[5])))
+	require.Empty(t, flushed())
+
+	// retracting a multihash pending publication publishes it first
+	require.NoError(t, batcher.RetractCatalog(ctx, CatalogFromMultihashes(mhs[2:4]...)))
+	require.Equal(t, []string{"publish:3"}, flushed())
+
+	// and the other way around
+	require.NoError(t, batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[3])))
+	require.Equal(t, []string{"publish:3", "retract:3"}, flushed())
+}
+
+func TestBatchingOverflow(t *testing.T) {
+	ctx := context.Background()
+	mhs := testMultihashes(3)
+
+	// start returns a batcher whose publish lane is stuck flushing the first catalog, until release is closed
+	start := func(t *testing.T, cfg BatchConfig) (batcher *CatalogBatcher, release chan struct{}, published *atomic.Int64) {
+		entered := make(chan struct{}, 10)
+		release = make(chan struct{})
+		published = &atomic.Int64{}
+		cfg.CountThreshold = 10
+		cfg.MaxMHsPerAdvertisement = 1
+		cfg.MaxDelay = time.Hour
+		cfg.publishRawMHs = func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			entered <- struct{}{}
+			<-release
+			published.Add(int64(catalog.Count()))
+			return cid.Undef, nil
+		}
+		batcher = StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+		require.NoError(t, batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[0])))
+		<-entered
+		return batcher, release, published
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		batcher, release, published := start(t, BatchConfig{QueueCapacity: 1, OverflowPolicy: OverflowReject})
+		queued := make(chan error, 1)
+		go func() { queued <- batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[1])) }()
+		require.Eventually(t, func() bool { return batcher.QueueDepth() == 1 }, 5*time.Second, time.Millisecond)
+
+		err := batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[2]))
+		require.ErrorIs(t, err, ErrBatcherSaturated)
+		require.True(t, IsRetryable(err))
+
+		close(release)
+		require.NoError(t, <-queued)
+		require.Eventually(t, func() bool { return published.Load() == 2 }, 5*time.Second, time.Millisecond)
+		require.Zero(t, batcher.QueueDepth())
+	})
+
+	t.Run("canceled while queued", func(t *testing.T) {
+		batcher, release, published := start(t, BatchConfig{QueueCapacity: 1})
+		expiring, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		err := batcher.PublishCatalog(expiring, CatalogFromMultihashes(mhs[1]))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// skipped by the lane: submitting it again doesn't duplicate it
+		close(release)
+		require.NoError(t, batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[1])))
+		require.Eventually(t, func() bool { return published.Load() == 2 }, 5*time.Second, time.Millisecond)
+		require.Never(t, func() bool { return published.Load() > 2 }, 100*time.Millisecond, 10*time.Millisecond)
+		require.Zero(t, batcher.QueueDepth())
+	})
+
+	t.Run("block with timeout", func(t *testing.T) {
+		batcher, release, _ := start(t, BatchConfig{OverflowTimeout: 20 * time.Millisecond})
+		defer close(release)
+		begin := time.Now()
+		err := batcher.PublishCatalog(ctx, CatalogFromMultihashes(mhs[1]))
+		require.ErrorIs(t, err, ErrBatcherSaturated)
+		require.GreaterOrEqual(t, time.Since(begin), 20*time.Millisecond)
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		queue := sync.MutexWrap(datastore.NewMapDatastore())
+		batcher, release, published := start(t, BatchConfig{Queue: queue, OverflowPolicy: OverflowSpill})
+
+		// returns right away, persisted in the queue
+		result, err := batcher.PublishCatalogResult(ctx, CatalogFromMultihashes(mhs[1:]...))
+		require.NoError(t, err)
+		require.Equal(t, 1, batcher.QueueDepth())
+		res, err := queue.Query(ctx, query.Query{KeysOnly: true})
+		require.NoError(t, err)
+		entries, err := res.Rest()
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+
+		// adopted by the lane once available
+		close(release)
+		select {
+		case r := <-result:
+			require.NoError(t, r.Err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no result")
+		}
+		require.EqualValues(t, 3, published.Load())
+		require.Zero(t, batcher.QueueDepth())
+	})
+}
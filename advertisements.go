@@ -2,7 +2,10 @@ package herald
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"math"
+	"sync"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -11,117 +14,642 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
 	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 // DefaultAdEntriesChunkSize is the default value for the maximum number of multihashes in a chunk
 // This value was taken from index-provider
 const DefaultAdEntriesChunkSize = 16384
 
+// MaxEntriesChunksPerAdvertisement is the maximum length of the list of entry chunks of an advertisement, as per the
+// IPNI specification. Above that, the multihashes are split into multiple advertisements.
+const MaxEntriesChunksPerAdvertisement = 400
+
+// MaxEntriesChunkBytes is the maximum encoded size of an entry chunk, as per the 4MB per block of the IPNI
+// specification.
+const MaxEntriesChunkBytes = 4 << 20
+
+// DefaultEntriesChunkBytes is the default value for the maximum encoded size of an entry chunk.
+const DefaultEntriesChunkBytes = 3 << 20
+
+// entriesChunkOverhead is an upper bound of the encoded size of an entry chunk without its multihashes: the field
+// names and the link to the next chunk.
+const entriesChunkOverhead = 128
+
+// entriesChunkPool recycles the slices of multihashes used to build the entry chunks.
+var entriesChunkPool sync.Pool
+
 type ChainConfig struct {
-	// AdEntriesChunkSize is the maximum number of multihashes in a chunk
+	// AdEntriesChunkSize is the maximum number of multihashes in a chunk. Zero means DefaultAdEntriesChunkSize.
 	AdEntriesChunkSize int
 
+	// EntriesChunkBytes is the maximum encoded size of a chunk: a chunk is cut early when reaching it, whatever
+	// AdEntriesChunkSize is, which matters with large multihashes. Zero means DefaultEntriesChunkBytes, and it is capped
+	// at MaxEntriesChunkBytes.
+	EntriesChunkBytes int
+
+	// EntriesFormat is the data structure holding the multihashes of the advertisements: a chain of entry chunks by
+	// default, or a HAMT.
+	EntriesFormat EntriesFormat
+
+	// MaxMHsPerAdvertisement is the maximum number of multihashes in a single advertisement with a ContextID. A larger
+	// catalog is split into multiple advertisements sharing the ContextID. Zero means that only the limit of
+	// MaxEntriesChunksPerAdvertisement applies.
+	MaxMHsPerAdvertisement int
+
 	// PublisherKey is the keypair corresponding to PublisherId
 	PublisherKey crypto.PrivKey
 
+	// Signer optionally signs the advertisements in place of PublisherKey, for example to keep the key in a KMS or
+	// an HSM. It must match PublisherID.
+	Signer Signer
+
 	// PublisherID is the peer.ID matching PublisherKey
 	PublisherID peer.ID
 
 	// PublisherHttpAddrs is the HTTP addresses from which the IPNI chain is available
 	PublisherHttpAddrs []multiaddr.Multiaddr
 
+	// PublisherLibp2pAddrs is the libp2p addresses of the publisher host, from which the indexers can also sync the
+	// chain. They are announced after PublisherHttpAddrs.
+	PublisherLibp2pAddrs []multiaddr.Multiaddr
+
 	// ProviderAddrs is the list of multiaddrs from which the content will be retrievable
 	ProviderAddrs []string
 
+	// ProviderID is the optional provider of the content, if different from the publisher.
+	// If ProviderKey is not set, the advertisements are signed by the publisher on behalf of the provider.
+	ProviderID peer.ID
+
+	// ProviderKey is the optional keypair of ProviderID, to sign the advertisements as the provider.
+	ProviderKey crypto.PrivKey
+
+	// Codec is the IPLD codec of the blocks of the chain: multicodec.DagJson, the IPNI default used when unset, or
+	// multicodec.DagCbor, more compact. The indexers sync either over HTTP, and a chain can mix both, but the
+	// Libp2pPublisher re-encodes the blocks it serves as DAG-JSON, so a DAG-CBOR chain can only be published over HTTP.
+	Codec multicodec.Code
+
 	// Metadata contains a protocol identifier and, optionally, protocol-specific "following metadata".
 	// See https://github.com/ipni/specs/blob/main/IPNI.md#metadata
 	// It can be constructed, for example, with metadata.Default.New(metadata.Bitswap{})
 	Metadata []byte
+
+	// ContextIDNamespace is an optional tenant namespace, automatically prefixed onto the ContextIDs.
+	// See NamespacedContextID.
+	ContextIDNamespace []byte
+
+	// Metrics, if set, records the multihashes and advertisements published and retracted.
+	Metrics *Metrics
+
+	// DuplicateContextIDs is what PublishWithContextID does when the ContextID is already live with the same metadata.
+	// It only applies with a backend maintaining a ContextIDIndex.
+	DuplicateContextIDs DuplicateContextIDPolicy
+
+	// DeduplicateAds makes PublishWithContextID return the advertisements already on the chain, instead of publishing
+	// new ones, when they are identical: same ContextID, entries, metadata, provider and addresses. This is typically
+	// the replay of a message by an upstream system. It only applies with a backend maintaining a ContextIDIndex.
+	DeduplicateAds bool
+
+	// Progress, if set, is called with the progress of the publications and retractions of the multihashes of the
+	// catalogs, after every entry chunk stored. It must not block, and it may be called concurrently by concurrent
+	// operations.
+	Progress func(ProgressEvent)
+
+	// progress is the tracker of the operation in progress, set by the publish functions
+	progress *progressTracker
+
+	// Tracer, if set, records the spans of the publishing and retracting pipelines, from the catalog iteration to the
+	// announcement. It is typically created with tracerProvider.Tracer(TracerName).
+	Tracer trace.Tracer
+
+	// Logger, if set, replaces the default logger, for example to add per-tenant fields. The lines of the pipelines
+	// carry the provider, the ContextID and the advertisement CIDs.
+	Logger *zap.SugaredLogger
+
+	// logFields are the fields of the operation in progress, set by the publish functions
+	logFields []any
 }
 
 // PublishWithContextID generate the IPNI advertisement and chunks for the publishing of the given catalog.
 // A ContextID is used as an identifier for easy retraction.
+// A catalog too large for a single advertisement is split, see PublishSplitWithContextID.
 func PublishWithContextID(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+	ads, err := PublishSplitWithContextID(ctx, cfg, backend, catalog)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(ads) == 0 {
+		// duplicate, skipped
+		return cid.Undef, nil
+	}
+	return ads[len(ads)-1], nil
+}
+
+// PublishSplitWithContextID is PublishWithContextID, returning the CIDs of all the advertisements generated, oldest
+// first; the last one is the new head. A catalog exceeding cfg.MaxMHsPerAdvertisement or
+// MaxEntriesChunksPerAdvertisement chunks is split into multiple advertisements sharing the same ContextID, so that
+// a single retraction of the ContextID retracts them all. The advertisements are chained under a single head update.
+// If the ContextID is already live, the outcome depends on cfg.DuplicateContextIDs; when skipped, no CID is returned.
+// With cfg.DeduplicateAds, the CIDs of identical advertisements already on the chain are returned instead.
+// An empty catalog returns ErrEmptyCatalog.
+func PublishSplitWithContextID(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (ads []cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "PublishWithContextID", trace.WithAttributes(catalogAttributes(catalog)...))
+	defer func() {
+		span.SetAttributes(attribute.Int("herald.advertisements", len(ads)))
+		endSpan(span, err)
+	}()
+	backend = cfg.traced(backend)
 	if len(catalog.ID()) == 0 {
-		return cid.Undef, fmt.Errorf("no valid ContextID to publish")
+		return nil, fmt.Errorf("no valid ContextID to publish")
 	}
+	cfg, err = cfg.forCatalog(catalog)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(false); err != nil {
+		return nil, err
+	}
+	id, err := cfg.contextID(catalog.ID())
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.withLogFields(id)
+	cfg.progress = cfg.startProgress("publish", id, catalog)
+	defer func() { cfg.progress.finish(err) }()
 
-	// generate the chain of chunks holding the multihashes
-	entries, err := generateEntries(ctx, cfg, backend, catalog)
+	if cfg.DuplicateContextIDs != DuplicateContextIDAllow {
+		duplicate, err := cfg.isDuplicate(ctx, backend, id)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case duplicate && cfg.DuplicateContextIDs == DuplicateContextIDError:
+			return nil, fmt.Errorf("publishing ContextID %x: %w", id, ErrContextIDLive)
+		case duplicate:
+			cfg.logger().Infow("skipping the publication of a live ContextID")
+			return nil, nil
+		}
+	}
+
+	// generate the chains of chunks holding the multihashes, one per advertisement
+	it, err := catalog.Iterator(ctx)
 	if err != nil {
-		return cid.Undef, err
+		return nil, err
 	}
-	// generate the root advertisement with all the Metadata
-	return generateAdvertisement(ctx, cfg, backend, catalog.ID(), entries, false)
+	iter := &entriesIterator{iter: it}
+	var parts []ipld.Link
+	var count int
+	for {
+		entries, n, err := generateEntriesPart(ctx, cfg, backend, iter)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, entries)
+		count += n
+		if iter.Done() {
+			break
+		}
+	}
+	if count == 0 {
+		return nil, ErrEmptyCatalog
+	}
+	if cfg.DeduplicateAds {
+		existing, err := cfg.findIdenticalAds(ctx, backend, id, parts)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			cfg.logger().Infow("skipping the publication of identical advertisements", "ads", existing)
+			return existing, nil
+		}
+	}
+	if len(parts) > 1 {
+		cfg.logger().Infow("Splitting catalog into multiple advertisements", "advertisements", len(parts), "totalMhCount", count)
+	}
+
+	// generate the root advertisements with all the Metadata
+	err = backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
+		ads = ads[:0]
+		for _, entries := range parts {
+			var err error
+			head, err = storeAdvertisement(ctx, cfg, backend, head, id, entries, false)
+			if err != nil {
+				return cid.Undef, err
+			}
+			ads = append(ads, head)
+		}
+		return head, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.Metrics.recordMultihashes("publish", count)
+	return ads, nil
 }
 
 // RetractWithContextID generate the IPNI advertisement to retract the given catalog, using a ContextID.
-func RetractWithContextID(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
-	return generateAdvertisement(ctx, cfg, backend, catalog.ID(), schema.NoEntries, true)
+func RetractWithContextID(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "RetractWithContextID", trace.WithAttributes(catalogAttributes(catalog)...))
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	cfg, err = cfg.forCatalog(catalog)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := cfg.validate(true); err != nil {
+		return cid.Undef, err
+	}
+	id, err := cfg.contextID(catalog.ID())
+	if err != nil {
+		return cid.Undef, err
+	}
+	cfg = cfg.withLogFields(id)
+	cfg.warnNotLive(ctx, backend, "retract", id)
+	newHead, err = generateAdvertisement(ctx, cfg, backend, id, schema.NoEntries, true)
+	if err == nil {
+		// the entries are not read, only the count is known, if any
+		cfg.Metrics.recordMultihashes("retract", catalog.Count())
+	}
+	return newHead, err
+}
+
+// UpdateMetadataWithContextID generate the IPNI advertisement updating the metadata of an already published ContextID,
+// without sending its entries again. This allows to cheaply change the retrieval information, like the transport or
+// its endpoints.
+func UpdateMetadataWithContextID(ctx context.Context, cfg ChainConfig, backend ChainWriter, contextID CatalogID, metadata []byte) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "UpdateMetadataWithContextID")
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	if len(contextID) == 0 {
+		return cid.Undef, fmt.Errorf("no valid ContextID to update")
+	}
+	if len(metadata) == 0 {
+		return cid.Undef, fmt.Errorf("no metadata to update")
+	}
+	id, err := cfg.contextID(contextID)
+	if err != nil {
+		return cid.Undef, err
+	}
+	cfg = cfg.withLogFields(id)
+	cfg.warnNotLive(ctx, backend, "update", id)
+	cfg.Metadata = metadata
+	if err := cfg.Validate(); err != nil {
+		return cid.Undef, err
+	}
+	return generateAdvertisement(ctx, cfg, backend, id, schema.NoEntries, false)
+}
+
+// UpdateProviderAddrs generate the IPNI advertisement publishing new addresses for the provider, without ContextID nor
+// entries. The indexers update the provider addresses with each advertisement, so the subsequent ones must be
+// generated with the new addresses as well, in cfg.ProviderAddrs.
+func UpdateProviderAddrs(ctx context.Context, cfg ChainConfig, backend ChainWriter, addrs []string) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "UpdateProviderAddrs")
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	if len(addrs) == 0 {
+		return cid.Undef, fmt.Errorf("no provider address to publish")
+	}
+	cfg.ProviderAddrs = addrs
+	cfg.Metadata = nil
+	if err := cfg.validate(true); err != nil {
+		return cid.Undef, err
+	}
+	if err := ValidateProviderAddrs(addrs); err != nil {
+		return cid.Undef, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	cfg = cfg.withLogFields(nil)
+	return generateAdvertisement(ctx, cfg, backend, nil, schema.NoEntries, false)
+}
+
+// RetractContextID generate the IPNI advertisement to retract the ContextID id. Unlike RetractWithContextID, it
+// doesn't need the catalog: the ContextID is all an indexer needs to retract its multihashes.
+func RetractContextID(ctx context.Context, cfg ChainConfig, backend ChainWriter, id CatalogID) (cid.Cid, error) {
+	return RetractContextIDs(ctx, cfg, backend, []CatalogID{id})
+}
+
+// RetractContextIDs generate the IPNI advertisements to retract all the given ContextIDs.
+// The advertisements are chained under a single head update, which makes it suitable for retracting a large number
+// of ContextIDs at once. It returns the new head of the chain.
+func RetractContextIDs(ctx context.Context, cfg ChainConfig, backend ChainWriter, ids []CatalogID) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "RetractContextIDs", trace.WithAttributes(attribute.Int("herald.contextids", len(ids))))
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	if len(ids) == 0 {
+		return cid.Undef, fmt.Errorf("no ContextID to retract")
+	}
+	if err := cfg.validate(true); err != nil {
+		return cid.Undef, err
+	}
+	contextIDs := make([]CatalogID, 0, len(ids))
+	for _, id := range ids {
+		if len(id) == 0 {
+			return cid.Undef, fmt.Errorf("no valid ContextID to retract")
+		}
+		contextID, err := cfg.contextID(id)
+		if err != nil {
+			return cid.Undef, err
+		}
+		contextIDs = append(contextIDs, contextID)
+	}
+	cfg = cfg.withLogFields(nil)
+	cfg.warnNotLive(ctx, backend, "retract", contextIDs...)
+	return retractContextIDs(ctx, cfg, backend, contextIDs)
+}
+
+// retractContextIDs is RetractContextIDs, for ContextIDs already within their namespace.
+func retractContextIDs(ctx context.Context, cfg ChainConfig, backend ChainWriter, ids []CatalogID) (cid.Cid, error) {
+	var newHead cid.Cid
+	err := backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
+		for _, id := range ids {
+			var err error
+			head, err = storeAdvertisement(ctx, cfg.withLogFields(id), backend, head, id, schema.NoEntries, true)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		newHead = head
+		return newHead, nil
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	cfg.logger().Infow("Retracted ContextIDs", "count", len(ids), "head", newHead)
+	return newHead, nil
 }
 
 // PublishRawMHs generate the IPNI advertisement and chunks for the publishing of the given catalog, without ContextID.
-func PublishRawMHs(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+func PublishRawMHs(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "PublishRawMHs", trace.WithAttributes(catalogAttributes(catalog)...))
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	cfg, err = cfg.forCatalog(catalog)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := cfg.validate(false); err != nil {
+		return cid.Undef, err
+	}
+	cfg = cfg.withLogFields(nil)
+	cfg.progress = cfg.startProgress("publish", nil, catalog)
+	defer func() { cfg.progress.finish(err) }()
 	// generate the chain of chunks holding the multihashes
-	entries, err := generateEntries(ctx, cfg, backend, catalog)
+	entries, count, err := generateEntries(ctx, cfg, backend, catalog)
 	if err != nil {
 		return cid.Undef, err
 	}
+	if count == 0 {
+		return cid.Undef, ErrEmptyCatalog
+	}
 	// generate the root advertisement with all the Metadata
-	return generateAdvertisement(ctx, cfg, backend, nil, entries, false)
+	newHead, err = generateAdvertisement(ctx, cfg, backend, nil, entries, false)
+	if err == nil {
+		cfg.Metrics.recordMultihashes("publish", count)
+	}
+	return newHead, err
 }
 
 // RetractRawMHs generate the IPNI advertisement and chunks for the retraction of the given catalog, without ContextID.
-func RetractRawMHs(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+func RetractRawMHs(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "RetractRawMHs", trace.WithAttributes(catalogAttributes(catalog)...))
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	cfg, err = cfg.forCatalog(catalog)
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := cfg.validate(true); err != nil {
+		return cid.Undef, err
+	}
+	cfg = cfg.withLogFields(nil)
+	cfg.progress = cfg.startProgress("retract", nil, catalog)
+	defer func() { cfg.progress.finish(err) }()
 	// generate the chain of chunks holding the multihashes
-	entries, err := generateEntries(ctx, cfg, backend, catalog)
+	entries, count, err := generateEntries(ctx, cfg, backend, catalog)
 	if err != nil {
 		return cid.Undef, err
 	}
+	if count == 0 {
+		return cid.Undef, ErrEmptyCatalog
+	}
 	// generate the root retract advertisement with all the Metadata
-	return generateAdvertisement(ctx, cfg, backend, nil, entries, true)
+	newHead, err = generateAdvertisement(ctx, cfg, backend, nil, entries, true)
+	if err == nil {
+		cfg.Metrics.recordMultihashes("retract", count)
+	}
+	return newHead, err
+}
+
+// generateEntries produce all the blocks necessary to store the multihashes entry of the given catalog, in
+// cfg.EntriesFormat, and returns the number of multihashes stored.
+func generateEntries(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (ipld.Link, int, error) {
+	iter, err := catalog.Iterator(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cfg.EntriesFormat == EntriesHAMT {
+		entries := &entriesIterator{iter: iter}
+		lnk, count, err := generateEntriesHAMT(ctx, cfg, backend, entries, math.MaxInt)
+		if err == nil && !entries.Done() {
+			// without ContextID, the catalog can't be split into several advertisements
+			return nil, 0, fmt.Errorf("the catalog exceeds the %d multihashes of a HAMT", hamtMaxMHs)
+		}
+		return lnk, count, err
+	}
+	cfg.MaxMHsPerAdvertisement = math.MaxInt
+	return generateEntriesChunks(ctx, cfg, backend, &entriesIterator{iter: iter}, math.MaxInt)
+}
+
+// generateEntriesPart generates the entries of a single advertisement, consuming the iterator up to the limits
+// of an advertisement. The rest is left in the iterator, for the next advertisement.
+func generateEntriesPart(ctx context.Context, cfg ChainConfig, backend ChainWriter, iter *entriesIterator) (ipld.Link, int, error) {
+	if cfg.MaxMHsPerAdvertisement <= 0 {
+		cfg.MaxMHsPerAdvertisement = math.MaxInt
+	}
+	if cfg.EntriesFormat == EntriesHAMT {
+		// a HAMT has no limit on the number of chunks
+		return generateEntriesHAMT(ctx, cfg, backend, iter, cfg.MaxMHsPerAdvertisement)
+	}
+	return generateEntriesChunks(ctx, cfg, backend, iter, MaxEntriesChunksPerAdvertisement)
 }
 
-// generateEntries produce all the linked chunks necessary to store the multihashes entry of the given catalog
-func generateEntries(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (ipld.Link, error) {
-	mhs := make([]multihash.Multihash, 0, cfg.AdEntriesChunkSize)
+// entriesPipelineDepth is the number of chunks cut from the iterator ahead of the chunk being stored.
+const entriesPipelineDepth = 2
 
-	var err error
+// generateEntriesChunks generates a chain of chunks, up to cfg.MaxMHsPerAdvertisement multihashes and maxChunks chunks.
+func generateEntriesChunks(ctx context.Context, cfg ChainConfig, backend ChainWriter, iter *entriesIterator, maxChunks int) (_ ipld.Link, _ int, err error) {
 	var next ipld.Link
 	var mhCount, chunkCount int
 
-	iter, err := catalog.Iterator(ctx)
+	ctx, span := cfg.tracer().Start(ctx, "generateEntries")
+	defer func() {
+		span.SetAttributes(attribute.Int("herald.multihashes", mhCount), attribute.Int("herald.chunks", chunkCount))
+		endSpan(span, err)
+	}()
+
+	// The chunks are cut from the iterator in a goroutine, while the previous chunk is encoded and stored. Each chunk
+	// links to the CID of the previous one, so the encoding itself stays sequential, but reading the catalog overlaps
+	// with the backend writes. Only entriesPipelineDepth chunks are held ahead of the one being stored, which still
+	// applies the back-pressure of the backend.
+	cutCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := make(chan []multihash.Multihash, entriesPipelineDepth)
+	var cutErr error
+	go func() {
+		defer close(chunks)
+		cutErr = cutEntriesChunks(cutCtx, cfg, iter, maxChunks, chunks)
+	}()
+
+	for mhs := range chunks {
+		if err == nil {
+			next, err = generateEntriesChunk(ctx, cfg.linkPrototype(), backend, next, mhs)
+			if err != nil {
+				cancel()
+			} else {
+				chunkCount++
+				mhCount += len(mhs)
+				cfg.progress.stored(len(mhs), 1, encodedChunkSize(mhs))
+			}
+		}
+		putEntriesChunk(mhs)
+	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	if cutErr != nil {
+		return nil, 0, cutErr
+	}
+	cfg.logger().Infow("Generated linked chunks of multihashes", "link", next, "totalMhCount", mhCount, "chunkCount", chunkCount)
+	return next, mhCount, nil
+}
+
+// cutEntriesChunks consumes the iterator into chunks sent to out, up to maxChunks chunks or MaxMHsPerAdvertisement
+// multihashes. The chunks are taken from the pool.
+func cutEntriesChunks(ctx context.Context, cfg ChainConfig, iter *entriesIterator, maxChunks int, out chan<- []multihash.Multihash) error {
+	maxCount, maxBytes := cfg.entriesChunkLimits()
+	mhs := getEntriesChunk(maxCount)
+	var mhCount, chunkCount, chunkBytes int
+
+	send := func() error {
+		select {
+		case out <- mhs:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		chunkCount++
+		mhs, chunkBytes = getEntriesChunk(maxCount), 0
+		return nil
 	}
-	for !iter.Done() {
+	defer func() { putEntriesChunk(mhs) }()
+
+	for !iter.Done() && mhCount < cfg.MaxMHsPerAdvertisement && chunkCount < maxChunks {
+		mh := iter.Peek()
+		size := encodedEntrySize(mh)
+		if size > maxBytes {
+			return fmt.Errorf("%w: multihash of %d bytes", ErrEntryChunkTooLarge, len(mh))
+		}
+		if len(mhs) > 0 && chunkBytes+size > maxBytes {
+			if err := send(); err != nil {
+				return err
+			}
+			continue
+		}
 		mhs = append(mhs, iter.Next())
 		mhCount++
-		if len(mhs) >= cfg.AdEntriesChunkSize {
-			next, err = generateEntriesChunk(ctx, backend, next, mhs)
-			if err != nil {
-				return nil, err
+		chunkBytes += size
+		if len(mhs) >= maxCount {
+			if err := send(); err != nil {
+				return err
 			}
-			chunkCount++
-			mhs = mhs[:0]
+		}
+	}
+	if iter.Done() {
+		// a truncated catalog must not be published
+		if err := iteratorErr(iter.iter); err != nil {
+			return err
 		}
 	}
 	if len(mhs) != 0 {
-		var err error
-		next, err = generateEntriesChunk(ctx, backend, next, mhs)
-		if err != nil {
-			return nil, err
+		if err := send(); err != nil {
+			return err
 		}
-		chunkCount++
 	}
-	logger.Infow("Generated linked chunks of multihashes", "link", next, "totalMhCount", mhCount, "chunkCount", chunkCount)
-	return next, nil
+	return nil
+}
+
+// entriesChunkLimits returns the maximum number of multihashes of a chunk, and the maximum encoded size of its
+// multihashes, applying the defaults.
+func (cfg ChainConfig) entriesChunkLimits() (count int, bytes int) {
+	count, bytes = cfg.AdEntriesChunkSize, cfg.EntriesChunkBytes
+	if count <= 0 {
+		count = DefaultAdEntriesChunkSize
+	}
+	if bytes <= 0 {
+		bytes = DefaultEntriesChunkBytes
+	}
+	return count, min(bytes, MaxEntriesChunkBytes) - entriesChunkOverhead
+}
+
+// encodedChunkSize returns an upper bound of the encoded size of an entry chunk holding mhs.
+func encodedChunkSize(mhs []multihash.Multihash) int64 {
+	size := entriesChunkOverhead
+	for _, mh := range mhs {
+		size += encodedEntrySize(mh)
+	}
+	return int64(size)
+}
+
+// encodedEntrySize returns the size of a multihash in an encoded entry chunk, as DAG-JSON bytes: {"/":{"bytes":"..."}},
+// It is an upper bound of its DAG-CBOR size.
+func encodedEntrySize(mh multihash.Multihash) int {
+	return base64.RawStdEncoding.EncodedLen(len(mh)) + len(`{"/":{"bytes":""}},`)
+}
+
+// entriesIterator is a MhIterator allowing to look at the next multihash without consuming it.
+type entriesIterator struct {
+	iter   MhIterator
+	next   multihash.Multihash // read from iter, but not consumed yet
+	peeked bool
+}
+
+func (e *entriesIterator) Done() bool {
+	return !e.peeked && e.iter.Done()
+}
+
+func (e *entriesIterator) Peek() multihash.Multihash {
+	if !e.peeked {
+		e.next, e.peeked = e.iter.Next(), true
+	}
+	return e.next
+}
+
+func (e *entriesIterator) Next() multihash.Multihash {
+	mh := e.Peek()
+	e.next, e.peeked = nil, false
+	return mh
+}
+
+// getEntriesChunk returns an empty slice of multihashes with the given capacity, recycled if possible.
+func getEntriesChunk(size int) []multihash.Multihash {
+	if mhs, ok := entriesChunkPool.Get().(*[]multihash.Multihash); ok && cap(*mhs) == size {
+		return (*mhs)[:0]
+	}
+	return make([]multihash.Multihash, 0, size)
+}
+
+// putEntriesChunk recycles a slice of multihashes, without retaining the multihashes themselves.
+func putEntriesChunk(mhs []multihash.Multihash) {
+	mhs = mhs[:cap(mhs)]
+	clear(mhs)
+	entriesChunkPool.Put(&mhs)
 }
 
 // generateEntriesChunk produce a single multihashes entry chunk containing mhs.
 // If next is not nil, the produced chunk will be chained with next.
-func generateEntriesChunk(ctx context.Context, backend ChainWriter, next ipld.Link, mhs []multihash.Multihash) (ipld.Link, error) {
+func generateEntriesChunk(ctx context.Context, lp ipld.LinkPrototype, backend ChainWriter, next ipld.Link, mhs []multihash.Multihash) (ipld.Link, error) {
 	chunk, err := schema.EntryChunk{
 		Entries: mhs,
 		Next:    next,
@@ -129,7 +657,11 @@ func generateEntriesChunk(ctx context.Context, backend ChainWriter, next ipld.Li
 	if err != nil {
 		return nil, err
 	}
-	return backend.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, chunk)
+	lnk, err := backend.Store(ipld.LinkContext{Ctx: ctx}, lp, chunk)
+	if err != nil {
+		return nil, backendUnavailable(err)
+	}
+	return lnk, nil
 }
 
 // generateAdvertisement produce an advertisement for the given chunk entries.
@@ -137,41 +669,63 @@ func generateAdvertisement(ctx context.Context, cfg ChainConfig, backend ChainWr
 	var newHead cid.Cid
 
 	err := backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
-		var previousID ipld.Link
-		if !cid.Undef.Equals(head) {
-			previousID = cidlink.Link{Cid: head}
-		}
+		var err error
+		newHead, err = storeAdvertisement(ctx, cfg, backend, head, id, entries, isRm)
+		return newHead, err
+	})
+	return newHead, err
+}
 
-		if entries == nil {
-			entries = schema.NoEntries
-		}
+// storeAdvertisement sign and store an advertisement chained after head, and returns its CID.
+// It doesn't update the head of the chain.
+func storeAdvertisement(ctx context.Context, cfg ChainConfig, backend ChainWriter, head cid.Cid, id CatalogID, entries ipld.Link, isRm bool) (cid.Cid, error) {
+	var previousID ipld.Link
+	if !cid.Undef.Equals(head) {
+		previousID = cidlink.Link{Cid: head}
+	}
 
-		ad := schema.Advertisement{
-			PreviousID: previousID,
-			Provider:   cfg.PublisherID.String(),
-			Addresses:  cfg.ProviderAddrs,
-			Entries:    entries,
-			ContextID:  id,
-			Metadata:   cfg.Metadata,
-			IsRm:       isRm,
-		}
-		if err := ad.Sign(cfg.PublisherKey); err != nil {
-			logger.Errorw("failed to sign advertisement", "err", err)
-			return cid.Undef, err
-		}
-		adNode, err := ad.ToNode()
-		if err != nil {
-			logger.Errorw("failed to generate IPLD node from advertisement", "err", err)
-			return cid.Undef, err
-		}
-		adLink, err := backend.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, adNode)
-		if err != nil {
-			logger.Errorw("failed to store advertisement", "err", err)
-			return cid.Undef, err
+	if entries == nil {
+		entries = schema.NoEntries
+	}
+
+	provider, signer := cfg.provider(), cfg.PublisherKey
+	if cfg.Signer != nil {
+		signer = signerPrivKey(cfg.Signer)
+	}
+	if cfg.ProviderKey != nil {
+		signer = cfg.ProviderKey
+		// signed by the provider itself, which the indexers check against the Provider field
+		if err := checkIdentity(provider, signer); err != nil {
+			return cid.Undef, fmt.Errorf("%w: %w", ErrSignFailed, err)
 		}
+	}
 
-		newHead = adLink.(cidlink.Link).Cid
-		return newHead, nil
-	})
-	return newHead, err
+	ad := schema.Advertisement{
+		PreviousID: previousID,
+		Provider:   provider.String(),
+		Addresses:  cfg.ProviderAddrs,
+		Entries:    entries,
+		ContextID:  id,
+		Metadata:   cfg.Metadata,
+		IsRm:       isRm,
+	}
+	if err := ad.Sign(signer); err != nil {
+		cfg.logger().Errorw("failed to sign advertisement", "err", err)
+		return cid.Undef, fmt.Errorf("%w: %w", ErrSignFailed, err)
+	}
+	adNode, err := ad.ToNode()
+	if err != nil {
+		cfg.logger().Errorw("failed to generate IPLD node from advertisement", "err", err)
+		return cid.Undef, err
+	}
+	adLink, err := backend.Store(ipld.LinkContext{Ctx: ctx}, cfg.linkPrototype(), adNode)
+	if err != nil {
+		cfg.logger().Errorw("failed to store advertisement", "err", err)
+		return cid.Undef, backendUnavailable(err)
+	}
+	cfg.Metrics.recordAdvertisement(isRm)
+
+	adCid := adLink.(cidlink.Link).Cid
+	cfg.logger().Debugw("Stored advertisement", "ad", adCid, "previous", head, "isRm", isRm)
+	return adCid, nil
 }
@@ -0,0 +1,304 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetractContextIDs(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	ids := []CatalogID{[]byte("a"), []byte("b"), []byte("c")}
+	head, err := RetractContextIDs(ctx, cfg, backend, ids)
+	require.NoError(t, err)
+
+	stored, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, stored)
+
+	// walk back the chain: the last ContextID is at the head
+	next := head
+	for i := len(ids) - 1; i >= 0; i-- {
+		ad, err := LoadAdvertisement(ctx, backend, next)
+		require.NoError(t, err)
+		require.True(t, ad.IsRm)
+		require.Equal(t, []byte(ids[i]), ad.ContextID)
+		next = ad.PreviousCid()
+	}
+	require.Equal(t, cid.Undef, next)
+
+	_, err = RetractContextIDs(ctx, cfg, backend, []CatalogID{nil})
+	require.Error(t, err)
+
+	// a published catalog is retracted with its ContextID only
+	_, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("d")})
+	require.NoError(t, err)
+	head, err = RetractContextID(ctx, cfg, backend, CatalogID("d"))
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	require.True(t, ad.IsRm)
+	require.Equal(t, []byte("d"), ad.ContextID)
+}
+
+func TestGenerateEntriesChunkBytes(t *testing.T) {
+	ctx := context.Background()
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	chunkSizes := func(lnk ipld.Link) (sizes []int, mhs int) {
+		err := WalkEntryChunks(ctx, backend, lnk, func(chunkCid cid.Cid, chunk schema.EntryChunk) error {
+			data, err := backend.GetContent(ctx, chunkCid)
+			require.NoError(t, err)
+			sizes = append(sizes, len(data))
+			mhs += len(chunk.Entries)
+			return nil
+		})
+		require.NoError(t, err)
+		return sizes, mhs
+	}
+
+	// identity multihashes large enough to fill a chunk before AdEntriesChunkSize, which defaults when zero
+	var mhs []multihash.Multihash
+	for i := 0; i < 10; i++ {
+		mh, err := multihash.Sum(bytes.Repeat([]byte{byte(i)}, MaxEntriesChunkBytes/4), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		mhs = append(mhs, mh)
+	}
+	lnk, count, err := generateEntries(ctx, ChainConfig{}, backend, CatalogFromMultihashes(mhs...))
+	require.NoError(t, err)
+	require.Equal(t, 10, count)
+	sizes, _ := chunkSizes(lnk)
+	// base64 in DAG-JSON: only 2 multihashes fit in the default budget
+	require.Len(t, sizes, 5)
+	for _, size := range sizes {
+		require.LessOrEqual(t, size, DefaultEntriesChunkBytes)
+	}
+
+	// multihashes of varying length, with a small budget
+	mhs = mhs[:0]
+	for i := 0; i < 200; i++ {
+		mh, err := multihash.Sum(bytes.Repeat([]byte{byte(i)}, 1+i*7%300), multihash.IDENTITY, -1)
+		require.NoError(t, err)
+		mhs = append(mhs, mh)
+	}
+	cfg := ChainConfig{AdEntriesChunkSize: 100, EntriesChunkBytes: 4096}
+	lnk, count, err = generateEntries(ctx, cfg, backend, CatalogFromMultihashes(mhs...))
+	require.NoError(t, err)
+	require.Equal(t, 200, count)
+	sizes, stored := chunkSizes(lnk)
+	require.Equal(t, 200, stored)
+	require.Greater(t, len(sizes), 2)
+	for _, size := range sizes {
+		require.LessOrEqual(t, size, 4096)
+	}
+
+	// a single multihash larger than the budget
+	huge, err := multihash.Sum(make([]byte, 4096), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	_, _, err = generateEntries(ctx, cfg, backend, CatalogFromMultihashes(huge))
+	require.ErrorIs(t, err, ErrEntryChunkTooLarge)
+}
+
+func TestPublishSplitWithContextID(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	countEntries := func(ad schema.Advertisement) (mhs, chunks int) {
+		err := WalkEntryChunks(ctx, backend, ad.Entries, func(_ cid.Cid, chunk schema.EntryChunk) error {
+			mhs += len(chunk.Entries)
+			chunks++
+			return nil
+		})
+		require.NoError(t, err)
+		return mhs, chunks
+	}
+
+	// split by number of multihashes
+	cfg.AdEntriesChunkSize = 2
+	cfg.MaxMHsPerAdvertisement = 5
+	ads, err := PublishSplitWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(12), id: []byte("foo")})
+	require.NoError(t, err)
+	require.Len(t, ads, 3)
+
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ads[2], head)
+
+	prev := cid.Undef
+	for i, expected := range []int{5, 5, 2} {
+		ad, err := LoadAdvertisement(ctx, backend, ads[i])
+		require.NoError(t, err)
+		require.Equal(t, []byte("foo"), ad.ContextID)
+		require.Equal(t, prev, ad.PreviousCid())
+		mhs, _ := countEntries(ad)
+		require.Equal(t, expected, mhs)
+		prev = ads[i]
+	}
+
+	// split by number of chunks
+	cfg.AdEntriesChunkSize = 1
+	cfg.MaxMHsPerAdvertisement = 0
+	ads, err = PublishSplitWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(MaxEntriesChunksPerAdvertisement + 1), id: []byte("bar")})
+	require.NoError(t, err)
+	require.Len(t, ads, 2)
+	ad, err := LoadAdvertisement(ctx, backend, ads[0])
+	require.NoError(t, err)
+	_, chunks := countEntries(ad)
+	require.Equal(t, MaxEntriesChunksPerAdvertisement, chunks)
+
+	// a small catalog is a single advertisement
+	head, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(1), id: []byte("baz")})
+	require.NoError(t, err)
+	ad, err = LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	require.Equal(t, ads[1], ad.PreviousCid())
+}
+
+func TestUpdateMetadataWithContextID(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()), WithDsContextIDIndex())
+
+	_, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte("foo")})
+	require.NoError(t, err)
+
+	head, err := UpdateMetadataWithContextID(ctx, cfg, backend, []byte("foo"), []byte("new metadata"))
+	require.NoError(t, err)
+
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	require.False(t, ad.IsRm)
+	require.Equal(t, []byte("foo"), ad.ContextID)
+	require.Equal(t, []byte("new metadata"), ad.Metadata)
+	require.Equal(t, schema.NoEntries, ad.Entries)
+
+	info, err := backend.ContextIDState(ctx, []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, ContextIDLive, info.State)
+	require.Equal(t, []byte("new metadata"), info.Metadata)
+
+	_, err = UpdateMetadataWithContextID(ctx, cfg, backend, []byte("foo"), nil)
+	require.Error(t, err)
+	_, err = UpdateMetadataWithContextID(ctx, cfg, backend, nil, []byte("new metadata"))
+	require.Error(t, err)
+}
+
+func TestUpdateProviderAddrs(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	newAddrs := []string{"/dns4/new.example.com/tcp/443/https"}
+
+	var published []Catalog
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 100,
+		MaxDelay:               time.Hour,
+		publishWithContextID: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			require.Equal(t, newAddrs, cfg.ProviderAddrs)
+			published = append(published, catalog)
+			return PublishWithContextID(ctx, cfg, backend, catalog)
+		},
+	}, cfg, backend, nopSender{})
+
+	require.NoError(t, batcher.UpdateProviderAddrs(ctx, newAddrs))
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	require.Equal(t, newAddrs, ad.Addresses)
+	require.Empty(t, ad.ContextID)
+	require.Equal(t, schema.NoEntries, ad.Entries)
+
+	// the subsequent advertisements use the new addresses
+	require.NoError(t, batcher.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(20), id: []byte("foo")}))
+	require.Len(t, published, 1)
+
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+
+	require.Error(t, batcher.UpdateProviderAddrs(ctx, nil))
+	require.Error(t, batcher.UpdateProviderAddrs(ctx, []string{"not a multiaddr"}))
+}
+
+// overlapBackend blocks the store of the first entry chunk until the catalog has been read further, which only
+// happens if the catalog is read while the chunks are stored.
+type overlapBackend struct {
+	*MemoryBackend
+	read    *atomic.Int64
+	wait    int64
+	stores  int
+	failing bool
+}
+
+func (b *overlapBackend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error) {
+	b.stores++
+	if b.failing {
+		return nil, errors.New("disk on fire")
+	}
+	if b.stores == 1 {
+		deadline := time.Now().Add(5 * time.Second)
+		for b.read.Load() < b.wait {
+			if time.Now().After(deadline) {
+				return nil, errors.New("catalog not read while storing")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return b.MemoryBackend.Store(lnkCtx, lp, n)
+}
+
+func TestGenerateEntriesPipeline(t *testing.T) {
+	ctx := context.Background()
+	mhs := testMultihashes(9)
+	cfg := ChainConfig{AdEntriesChunkSize: 2}
+
+	catalog := func(read *atomic.Int64) Catalog {
+		return CatalogFromIterator(nil, len(mhs), func(context.Context) (multihash.Multihash, error) {
+			i := read.Add(1) - 1
+			if int(i) >= len(mhs) {
+				return nil, io.EOF
+			}
+			return mhs[i], nil
+		})
+	}
+
+	var read atomic.Int64
+	// the second chunk is complete once the first multihash of the third one is peeked
+	backend := &overlapBackend{MemoryBackend: NewMemoryBackend(), read: &read, wait: 5}
+	lnk, count, err := generateEntries(ctx, cfg, backend, catalog(&read))
+	require.NoError(t, err)
+	require.Equal(t, len(mhs), count)
+	require.Equal(t, 5, backend.stores)
+
+	// same chain as the one generated from a plain catalog
+	expected, _, err := generateEntries(ctx, cfg, NewMemoryBackend(), CatalogFromMultihashes(mhs...))
+	require.NoError(t, err)
+	require.Equal(t, expected, lnk)
+
+	// a store failure stops the reading of the catalog
+	read.Store(0)
+	backend = &overlapBackend{MemoryBackend: NewMemoryBackend(), read: &read, failing: true}
+	_, _, err = generateEntries(ctx, cfg, backend, catalog(&read))
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.Equal(t, 1, backend.stores)
+}
@@ -0,0 +1,54 @@
+package herald
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/message"
+)
+
+// RetryingSender wraps an announce.Sender so that failed announcements are retried according to policy.
+func RetryingSender(sender announce.Sender, policy RetryPolicy) announce.Sender {
+	return &retryingSender{sender: sender, policy: policy}
+}
+
+var _ announce.Sender = &retryingSender{}
+
+type retryingSender struct {
+	sender announce.Sender
+	policy RetryPolicy
+}
+
+func (r *retryingSender) Send(ctx context.Context, msg message.Message) error {
+	return r.policy.Do(ctx, func(ctx context.Context) error {
+		return r.sender.Send(ctx, msg)
+	})
+}
+
+func (r *retryingSender) Close() error {
+	return r.sender.Close()
+}
+
+// lastSentSender wraps an announce.Sender to record the time of the last successful announcement.
+type lastSentSender struct {
+	announce.Sender
+	last atomic.Int64 // unix nanoseconds, 0 if none
+}
+
+func (l *lastSentSender) Send(ctx context.Context, msg message.Message) error {
+	if err := l.Sender.Send(ctx, msg); err != nil {
+		return err
+	}
+	l.last.Store(time.Now().UnixNano())
+	return nil
+}
+
+// lastSent returns the time of the last successful announcement, or the zero time if none.
+func (l *lastSentSender) lastSent() time.Time {
+	if ns := l.last.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,79 @@
+package herald
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+// announceDebouncer sends at most one announcement per interval. The heads advancing in between are coalesced: only
+// the latest is announced, which is enough for the indexers to fetch the previous advertisements along the chain.
+type announceDebouncer struct {
+	interval time.Duration
+	send     func(ctx context.Context, head cid.Cid) error
+	logger   *zap.SugaredLogger
+
+	mu      sync.Mutex
+	pending cid.Cid
+	last    time.Time
+	timer   *time.Timer
+
+	sendMu sync.Mutex // serializes the sends, which can outlast the interval; taken before mu
+}
+
+func newAnnounceDebouncer(interval time.Duration, send func(ctx context.Context, head cid.Cid) error, logger *zap.SugaredLogger) *announceDebouncer {
+	return &announceDebouncer{interval: interval, send: send, logger: logger}
+}
+
+// announce schedules the announcement of head, replacing any announcement not sent yet.
+func (d *announceDebouncer) announce(head cid.Cid) {
+	if !head.Defined() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending = head
+	if d.timer == nil {
+		delay := max(0, time.Until(d.last.Add(d.interval)))
+		d.timer = time.AfterFunc(delay, d.fire)
+	}
+}
+
+func (d *announceDebouncer) fire() {
+	d.sendMu.Lock()
+	defer d.sendMu.Unlock()
+	d.mu.Lock()
+	head := d.pending
+	d.pending, d.timer, d.last = cid.Undef, nil, time.Now()
+	d.mu.Unlock()
+	if !head.Defined() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := d.send(ctx, head); err != nil {
+		// the next announcement catches up
+		d.logger.Errorw("failed to announce new head", "err", err, "head", head.String())
+	}
+}
+
+// flush sends the pending announcement right away, if any, or waits for the one being sent.
+func (d *announceDebouncer) flush(ctx context.Context) error {
+	d.sendMu.Lock()
+	defer d.sendMu.Unlock()
+	d.mu.Lock()
+	head := d.pending
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.pending, d.timer, d.last = cid.Undef, nil, time.Now()
+	d.mu.Unlock()
+	if !head.Defined() {
+		return nil
+	}
+	return d.send(ctx, head)
+}
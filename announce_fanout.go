@@ -0,0 +1,365 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/httpsender"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// DefaultDoHEndpoint is the DNS-over-HTTPS resolver used to discover the indexers from DNS TXT records.
+const DefaultDoHEndpoint = "https://cloudflare-dns.com/dns-query"
+
+var _ announce.Sender = &FanoutSender{}
+
+// FanoutSender is an announce.Sender sending the announcements to multiple indexers concurrently, tolerating the
+// failure of some of them. With the health checks, the indexers failing are skipped until they are healthy again.
+type FanoutSender struct {
+	endpoints  []*announceEndpoint
+	minSuccess int
+	// healthChecks is set when the failing indexers are checked, and skipped until then
+	healthChecks bool
+	client       *http.Client
+	logger       *zap.SugaredLogger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// announceEndpoint is an indexer announce URL.
+type announceEndpoint struct {
+	url       string
+	sender    *httpsender.Sender
+	unhealthy atomic.Bool
+}
+
+// AnnouncersOption is an optional configuration for NewAnnouncers.
+type AnnouncersOption func(*announcersConfig)
+
+type announcersConfig struct {
+	urls           []string
+	dnsNames       []string
+	dohEndpoint    string
+	lists          []string
+	client         *http.Client
+	minSuccess     int
+	healthInterval time.Duration
+	logger         *zap.SugaredLogger
+}
+
+// WithAnnounceURLs adds indexer announce URLs. A URL without path gets the default /announce.
+func WithAnnounceURLs(urls ...string) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.urls = append(c.urls, urls...)
+	}
+}
+
+// WithAnnounceDNS discovers indexer announce URLs from the TXT records of the DNS name, one URL per record, resolved
+// with DNS-over-HTTPS.
+func WithAnnounceDNS(name string) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.dnsNames = append(c.dnsNames, name)
+	}
+}
+
+// WithAnnounceDoHEndpoint sets the DNS-over-HTTPS resolver, speaking the JSON API (application/dns-json).
+// Defaults to DefaultDoHEndpoint.
+func WithAnnounceDoHEndpoint(endpoint string) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.dohEndpoint = endpoint
+	}
+}
+
+// WithAnnounceIndexerList discovers indexer announce URLs from a list published over HTTP, such as the list of the
+// members of an indexer federation. The list is a JSON array of URLs.
+func WithAnnounceIndexerList(listURL string) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.lists = append(c.lists, listURL)
+	}
+}
+
+// WithAnnounceHTTPClient sets the HTTP client used for the discovery, the announcements and the health checks.
+func WithAnnounceHTTPClient(client *http.Client) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.client = client
+	}
+}
+
+// WithAnnounceMinSuccess sets the number of indexers that must receive an announcement for it to succeed, capped
+// to the number of indexers. Defaults to 1.
+func WithAnnounceMinSuccess(n int) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.minSuccess = n
+	}
+}
+
+// WithAnnounceHealthCheck sets the interval at which the indexers failing are checked, to be announced to again once
+// reachable. Zero disables the checks: the failing indexers are then tried again on every announcement.
+// Defaults to one minute.
+func WithAnnounceHealthCheck(interval time.Duration) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.healthInterval = interval
+	}
+}
+
+// WithAnnounceLogger sets the logger of the FanoutSender, instead of the default one.
+func WithAnnounceLogger(l *zap.SugaredLogger) AnnouncersOption {
+	return func(c *announcersConfig) {
+		c.logger = l
+	}
+}
+
+// NewAnnouncers creates a FanoutSender announcing on behalf of the publisher peerID to the indexers given with
+// WithAnnounceURLs, or discovered with WithAnnounceDNS and WithAnnounceIndexerList. The discovery happens once, here.
+func NewAnnouncers(ctx context.Context, peerID peer.ID, opts ...AnnouncersOption) (*FanoutSender, error) {
+	cfg := announcersConfig{
+		dohEndpoint:    DefaultDoHEndpoint,
+		client:         &http.Client{Timeout: time.Minute},
+		minSuccess:     1,
+		healthInterval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	urls := append([]string(nil), cfg.urls...)
+	for _, name := range cfg.dnsNames {
+		found, err := resolveTXT(ctx, cfg.client, cfg.dohEndpoint, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover indexers from %s: %w", name, err)
+		}
+		urls = append(urls, found...)
+	}
+	for _, list := range cfg.lists {
+		found, err := fetchIndexerList(ctx, cfg.client, list)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover indexers from %s: %w", list, err)
+		}
+		urls = append(urls, found...)
+	}
+
+	f := &FanoutSender{minSuccess: cfg.minSuccess, healthChecks: cfg.healthInterval > 0, client: cfg.client, logger: orDefaultLogger(cfg.logger)}
+	seen := make(map[string]bool)
+	for _, s := range urls {
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			f.logger.Warnw("skipping invalid indexer announce URL", "url", s)
+			continue
+		}
+		if u.Path == "" {
+			u.Path = httpsender.DefaultAnnouncePath
+		}
+		if seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		sender, err := httpsender.New([]*url.URL{u}, peerID, httpsender.WithClient(cfg.client))
+		if err != nil {
+			return nil, err
+		}
+		f.endpoints = append(f.endpoints, &announceEndpoint{url: u.String(), sender: sender})
+	}
+	if len(f.endpoints) == 0 {
+		return nil, errors.New("no indexer announce URL")
+	}
+	f.logger.Infow("announcing to indexers", "urls", f.URLs())
+
+	if f.healthChecks {
+		f.stop, f.done = make(chan struct{}), make(chan struct{})
+		go f.runHealthChecks(cfg.healthInterval)
+	}
+	return f, nil
+}
+
+// URLs returns the announce URLs of the indexers.
+func (f *FanoutSender) URLs() []string {
+	urls := make([]string, len(f.endpoints))
+	for i, e := range f.endpoints {
+		urls[i] = e.url
+	}
+	return urls
+}
+
+// Send sends the announcement to the healthy indexers concurrently, or to all of them if too few are healthy or without
+// health checks. It fails if fewer than the minimum number of indexers received it.
+func (f *FanoutSender) Send(ctx context.Context, msg message.Message) error {
+	need := min(f.minSuccess, len(f.endpoints))
+	var targets []*announceEndpoint
+	for _, e := range f.endpoints {
+		if !e.unhealthy.Load() {
+			targets = append(targets, e)
+		}
+	}
+	if len(targets) < need || len(targets) == 0 {
+		targets = f.endpoints
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, e := range targets {
+		wg.Add(1)
+		go func(i int, e *announceEndpoint) {
+			defer wg.Done()
+			if err := e.sender.Send(ctx, msg); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", e.url, err)
+				// only the health checks make it healthy again
+				e.unhealthy.Store(f.healthChecks)
+				return
+			}
+			e.unhealthy.Store(false)
+		}(i, e)
+	}
+	wg.Wait()
+	return checkMinSuccess(f.logger, errs, need, len(f.endpoints), "indexers")
+}
+
+// checkMinSuccess returns nil if at least need of the sends succeeded, need being capped to the number of sends,
+// logging the failures if any. errs are the outcomes of the sends, out of total receivers named by what.
+func checkMinSuccess(logger *zap.SugaredLogger, errs []error, need, total int, what string) error {
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	succeeded := len(errs) - len(failed)
+	if succeeded >= min(need, len(errs)) && succeeded > 0 {
+		if len(failed) > 0 {
+			logger.Warnw("failed to announce to some "+what, "succeeded", succeeded, "err", errors.Join(failed...))
+		}
+		return nil
+	}
+	return fmt.Errorf("announced to %d %s out of %d: %w", succeeded, what, total, errors.Join(failed...))
+}
+
+// runHealthChecks checks the unhealthy indexers periodically, until Close.
+func (f *FanoutSender) runHealthChecks(interval time.Duration) {
+	defer close(f.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			for _, e := range f.endpoints {
+				if e.unhealthy.Load() && f.reachable(e) {
+					f.logger.Infow("indexer is reachable again", "url", e.url)
+					e.unhealthy.Store(false)
+				}
+			}
+		}
+	}
+}
+
+// reachable returns true if the indexer answers on its /health endpoint without a server error.
+func (f *FanoutSender) reachable(e *announceEndpoint) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	u, err := url.Parse(e.url)
+	if err != nil {
+		return false
+	}
+	u.Path, u.RawQuery = "/health", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+// Close stops the health checks and closes the idle connections.
+func (f *FanoutSender) Close() error {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.done
+	}
+	var errs []error
+	for _, e := range f.endpoints {
+		errs = append(errs, e.sender.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// resolveTXT returns the TXT records of name, resolved with the DNS-over-HTTPS JSON API of endpoint.
+func resolveTXT(ctx context.Context, client *http.Client, endpoint string, name string) ([]string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("name", name)
+	q.Set("type", "TXT")
+	u.RawQuery = q.Encode()
+
+	var resp struct {
+		Status int
+		Answer []struct {
+			Type int    `json:"type"`
+			Data string `json:"data"`
+		}
+	}
+	if err := getJSON(ctx, client, u.String(), "application/dns-json", &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != 0 {
+		return nil, fmt.Errorf("DNS error code %d", resp.Status)
+	}
+	const typeTXT = 16
+	var records []string
+	for _, answer := range resp.Answer {
+		if answer.Type != typeTXT {
+			continue
+		}
+		// a TXT record is a sequence of quoted strings, to concatenate
+		var record strings.Builder
+		for _, part := range strings.Split(answer.Data, `" "`) {
+			record.WriteString(strings.Trim(part, `"`))
+		}
+		records = append(records, record.String())
+	}
+	return records, nil
+}
+
+// fetchIndexerList returns the URLs of a JSON array published at listURL.
+func fetchIndexerList(ctx context.Context, client *http.Client, listURL string) ([]string, error) {
+	var urls []string
+	if err := getJSON(ctx, client, listURL, "application/json", &urls); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, accept string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
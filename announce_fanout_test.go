@@ -0,0 +1,109 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIndexer is an indexer counting the announcements, failing them while down.
+type fakeIndexer struct {
+	*httptest.Server
+	announces atomic.Int32
+	down      atomic.Bool
+}
+
+func newFakeIndexer(t *testing.T) *fakeIndexer {
+	f := &fakeIndexer{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.down.Load() {
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/announce" {
+			f.announces.Add(1)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestFanoutSender(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	a, b, c := newFakeIndexer(t), newFakeIndexer(t), newFakeIndexer(t)
+
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "indexers.example.com", r.URL.Query().Get("name"))
+		require.Equal(t, "TXT", r.URL.Query().Get("type"))
+		_ = json.NewEncoder(w).Encode(map[string]any{"Status": 0, "Answer": []map[string]any{
+			{"type": 16, "data": `"` + b.URL[:10] + `" "` + b.URL[10:] + `"`},
+		}})
+	}))
+	defer doh.Close()
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]string{c.URL, a.URL + "/announce", "not a url"})
+	}))
+	defer list.Close()
+
+	sender, err := NewAnnouncers(ctx, cfg.PublisherID,
+		WithAnnounceURLs(a.URL),
+		WithAnnounceDNS("indexers.example.com"), WithAnnounceDoHEndpoint(doh.URL),
+		WithAnnounceIndexerList(list.URL),
+		WithAnnounceMinSuccess(2),
+		WithAnnounceHealthCheck(50*time.Millisecond))
+	require.NoError(t, err)
+	defer sender.Close()
+	require.Equal(t, []string{a.URL + "/announce", b.URL + "/announce", c.URL + "/announce"}, sender.URLs())
+
+	msg := message.Message{Cid: cid.NewCidV1(cid.Raw, testMultihashes(1)[0])}
+	require.NoError(t, sender.Send(ctx, msg))
+	for _, indexer := range []*fakeIndexer{a, b, c} {
+		require.EqualValues(t, 1, indexer.announces.Load())
+	}
+
+	// partial failure: c is skipped until healthy again
+	c.down.Store(true)
+	require.NoError(t, sender.Send(ctx, msg))
+	require.NoError(t, sender.Send(ctx, msg))
+	require.EqualValues(t, 3, a.announces.Load())
+	require.EqualValues(t, 1, c.announces.Load())
+
+	// too many failures
+	b.down.Store(true)
+	require.Error(t, sender.Send(ctx, msg))
+
+	b.down.Store(false)
+	c.down.Store(false)
+	require.Eventually(t, func() bool {
+		return sender.Send(ctx, msg) == nil && c.announces.Load() > 1
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestFanoutSenderNoHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	a, b := newFakeIndexer(t), newFakeIndexer(t)
+
+	sender, err := NewAnnouncers(ctx, cfg.PublisherID, WithAnnounceURLs(a.URL, b.URL), WithAnnounceHealthCheck(0))
+	require.NoError(t, err)
+	defer sender.Close()
+
+	// without health checks, a failing indexer is tried again on every announcement
+	msg := message.Message{Cid: cid.NewCidV1(cid.Raw, testMultihashes(1)[0])}
+	b.down.Store(true)
+	require.NoError(t, sender.Send(ctx, msg))
+	b.down.Store(false)
+	require.NoError(t, sender.Send(ctx, msg))
+	require.EqualValues(t, 2, a.announces.Load())
+	require.EqualValues(t, 1, b.announces.Load())
+}
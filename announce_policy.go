@@ -0,0 +1,197 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/httpsender"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/ipni/go-libipni/announce/p2psender"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// AnnounceTarget is a destination of the announcements, with an optional fallback.
+type AnnounceTarget struct {
+	// Name identifies the target in the logs and the metrics. Targets sharing a name are sent to once per
+	// announcement, for example a gossipsub fallback shared by several indexers.
+	Name string
+
+	// Sender sends the announcements to the target. Any announce.Sender can be a target, for example a webhook.
+	Sender announce.Sender
+
+	// Fallback, if set, is tried when Sender fails, for example gossipsub for an indexer announced to over HTTP.
+	Fallback *AnnounceTarget
+}
+
+// HttpAnnounceTarget returns a target announcing directly to the indexer announce URL, on behalf of the publisher.
+// A URL without path gets the default /announce.
+func HttpAnnounceTarget(rawURL string, publisher peer.ID) (AnnounceTarget, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return AnnounceTarget{}, fmt.Errorf("invalid indexer announce URL %q", rawURL)
+	}
+	if u.Path == "" {
+		u.Path = httpsender.DefaultAnnouncePath
+	}
+	sender, err := httpsender.New([]*url.URL{u}, publisher)
+	if err != nil {
+		return AnnounceTarget{}, err
+	}
+	return AnnounceTarget{Name: u.String(), Sender: sender}, nil
+}
+
+// GossipsubAnnounceTarget returns a target announcing on the IPNI gossipsub topic, through the libp2p host h.
+func GossipsubAnnounceTarget(h host.Host, topic string) (AnnounceTarget, error) {
+	sender, err := p2psender.New(h, topic)
+	if err != nil {
+		return AnnounceTarget{}, err
+	}
+	return AnnounceTarget{Name: "gossipsub:" + topic, Sender: sender}, nil
+}
+
+// AnnouncePolicyOption is an optional configuration for NewAnnouncePolicy.
+type AnnouncePolicyOption func(*AnnouncePolicy)
+
+// WithAnnouncePolicyMinSuccess sets the number of targets, fallbacks included, that must receive an announcement for
+// it to succeed, capped to the number of targets. Defaults to 1.
+func WithAnnouncePolicyMinSuccess(n int) AnnouncePolicyOption {
+	return func(p *AnnouncePolicy) {
+		p.minSuccess = n
+	}
+}
+
+// WithAnnouncePolicyMetrics records the outcome of the announcements of each target into m.
+func WithAnnouncePolicyMetrics(m *Metrics) AnnouncePolicyOption {
+	return func(p *AnnouncePolicy) {
+		p.metrics = m
+	}
+}
+
+// WithAnnouncePolicyLogger sets the logger of the AnnouncePolicy, instead of the default one.
+func WithAnnouncePolicyLogger(l *zap.SugaredLogger) AnnouncePolicyOption {
+	return func(p *AnnouncePolicy) {
+		p.logger = l
+	}
+}
+
+var _ announce.Sender = &AnnouncePolicy{}
+
+// AnnouncePolicy is an announce.Sender announcing to its targets concurrently, each falling back to the next sender
+// of its chain on failure. This implements the IPNI announcement over HTTP, falling back to gossipsub, with a
+// different policy per indexer if needed.
+type AnnouncePolicy struct {
+	targets    []AnnounceTarget
+	minSuccess int
+	metrics    *Metrics
+	logger     *zap.SugaredLogger
+}
+
+// NewAnnouncePolicy creates an AnnouncePolicy announcing to the targets. The fallback chains must not loop.
+func NewAnnouncePolicy(targets []AnnounceTarget, opts ...AnnouncePolicyOption) (*AnnouncePolicy, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("no announce target")
+	}
+	for _, target := range targets {
+		visited := make(map[*AnnounceTarget]bool)
+		for t := &target; t != nil; t = t.Fallback {
+			if visited[t] {
+				return nil, fmt.Errorf("the fallbacks of announce target %q loop back to %q", target.Name, t.Name)
+			}
+			visited[t] = true
+			if t.Sender == nil {
+				return nil, fmt.Errorf("announce target %q has no sender", t.Name)
+			}
+		}
+	}
+	p := &AnnouncePolicy{targets: targets, minSuccess: 1}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.logger = orDefaultLogger(p.logger)
+	return p, nil
+}
+
+// Send announces to all the targets concurrently. It fails if fewer than the minimum number of targets received the
+// announcement, either directly or through a fallback.
+func (p *AnnouncePolicy) Send(ctx context.Context, msg message.Message) error {
+	sends := &announceSends{policy: p, msg: msg, results: make(map[string]*announceSend)}
+	errs := make([]error, len(p.targets))
+	var wg sync.WaitGroup
+	for i, target := range p.targets {
+		wg.Add(1)
+		go func(i int, target AnnounceTarget) {
+			defer wg.Done()
+			errs[i] = sends.sendChain(ctx, &target)
+		}(i, target)
+	}
+	wg.Wait()
+	return checkMinSuccess(p.logger, errs, p.minSuccess, len(p.targets), "targets")
+}
+
+// Close closes the senders of all the targets and their fallbacks, once each.
+func (p *AnnouncePolicy) Close() error {
+	closed := make(map[announce.Sender]bool)
+	var errs []error
+	for _, target := range p.targets {
+		for t := &target; t != nil; t = t.Fallback {
+			if closed[t.Sender] {
+				continue
+			}
+			closed[t.Sender] = true
+			errs = append(errs, t.Sender.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// announceSends is a single announcement to the targets, sending once per target name.
+type announceSends struct {
+	policy *AnnouncePolicy
+	msg    message.Message
+
+	mu      sync.Mutex
+	results map[string]*announceSend
+}
+
+type announceSend struct {
+	once sync.Once
+	err  error
+}
+
+// sendChain sends to the target, then to its fallbacks until one succeeds.
+func (s *announceSends) sendChain(ctx context.Context, target *AnnounceTarget) error {
+	var errs []error
+	for t := target; t != nil; t = t.Fallback {
+		err := s.send(ctx, t)
+		if err == nil {
+			if t != target {
+				s.policy.logger.Infow("announced through a fallback", "target", target.Name, "fallback", t.Name)
+			}
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// send sends to a single target, or returns the outcome of the previous send to a target of the same name.
+func (s *announceSends) send(ctx context.Context, t *AnnounceTarget) error {
+	s.mu.Lock()
+	res, ok := s.results[t.Name]
+	if !ok {
+		res = &announceSend{}
+		s.results[t.Name] = res
+	}
+	s.mu.Unlock()
+	res.once.Do(func() {
+		res.err = t.Sender.Send(ctx, s.msg)
+		s.policy.metrics.recordAnnounce(t.Name, res.err)
+	})
+	return res.err
+}
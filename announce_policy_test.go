@@ -0,0 +1,96 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSender is an announce.Sender counting the announcements, failing them while down.
+type countingSender struct {
+	sends  atomic.Int32
+	closed atomic.Int32
+	down   atomic.Bool
+}
+
+func (c *countingSender) Send(context.Context, message.Message) error {
+	if c.down.Load() {
+		return errors.New("down")
+	}
+	c.sends.Add(1)
+	return nil
+}
+
+func (c *countingSender) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+func TestAnnouncePolicy(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	metrics, err := NewMetrics(prometheus.NewRegistry())
+	require.NoError(t, err)
+	a, b := newFakeIndexer(t), newFakeIndexer(t)
+
+	gossip := &countingSender{}
+	fallback := AnnounceTarget{Name: "gossipsub", Sender: gossip}
+	targetA, err := HttpAnnounceTarget(a.URL, cfg.PublisherID)
+	require.NoError(t, err)
+	targetA.Fallback = &fallback
+	targetB, err := HttpAnnounceTarget(b.URL, cfg.PublisherID)
+	require.NoError(t, err)
+	targetB.Fallback = &fallback
+	webhook := &countingSender{}
+
+	policy, err := NewAnnouncePolicy([]AnnounceTarget{targetA, targetB, {Name: "webhook", Sender: webhook}},
+		WithAnnouncePolicyMinSuccess(3), WithAnnouncePolicyMetrics(metrics))
+	require.NoError(t, err)
+
+	// the fallback is only used on failure
+	msg := message.Message{Cid: cid.NewCidV1(cid.Raw, testMultihashes(1)[0])}
+	require.NoError(t, policy.Send(ctx, msg))
+	require.Equal(t, int32(1), a.announces.Load())
+	require.Equal(t, int32(1), b.announces.Load())
+	require.Equal(t, int32(1), webhook.sends.Load())
+	require.Equal(t, int32(0), gossip.sends.Load())
+
+	// both indexers down: the shared fallback is announced to once
+	a.down.Store(true)
+	b.down.Store(true)
+	require.NoError(t, policy.Send(ctx, msg))
+	require.Equal(t, int32(1), gossip.sends.Load())
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.announces.WithLabelValues(targetA.Name, "error")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.announces.WithLabelValues("gossipsub", "success")))
+
+	// too few targets reached
+	gossip.down.Store(true)
+	require.Error(t, policy.Send(ctx, msg))
+
+	require.NoError(t, policy.Close())
+	require.Equal(t, int32(1), gossip.closed.Load())
+	require.Equal(t, int32(1), webhook.closed.Load())
+
+	_, err = NewAnnouncePolicy([]AnnounceTarget{{Name: "nil"}})
+	require.Error(t, err)
+
+	// looping fallbacks are rejected
+	first := AnnounceTarget{Name: "first", Sender: &countingSender{}}
+	second := AnnounceTarget{Name: "second", Sender: &countingSender{}, Fallback: &first}
+	first.Fallback = &second
+	_, err = NewAnnouncePolicy([]AnnounceTarget{first})
+	require.ErrorContains(t, err, "loop")
+	self := AnnounceTarget{Name: "self", Sender: &countingSender{}}
+	self.Fallback = &self
+	_, err = NewAnnouncePolicy([]AnnounceTarget{self})
+	require.ErrorContains(t, err, "loop")
+	_, err = HttpAnnounceTarget("not a url", cfg.PublisherID)
+	require.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// SNSPublishAPI is the subset of the SNS client used by SNSSender.
+type SNSPublishAPI interface {
+	Publish(ctx context.Context, params *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+var _ announce.Sender = &SNSSender{}
+
+// SNSSender is an announce.Sender publishing the head announcements to an AWS SNS topic, so that indexers subscribed
+// through SNS or SQS can receive them without exposing an HTTP announce endpoint.
+//
+// The message body is the JSON encoding of the announce message, as sent to the HTTP announce endpoints. As for
+// HTTP announcements, the publisher ID is carried with /p2p/ in the addresses.
+type SNSSender struct {
+	client   SNSPublishAPI
+	topicArn string
+	peerID   peer.ID
+
+	// messageGroupID is required for FIFO topics
+	messageGroupID string
+
+	logger *zap.SugaredLogger
+}
+
+// SNSSenderOption is an optional configuration for the SNSSender.
+type SNSSenderOption func(*SNSSender)
+
+// WithSNSMessageGroupID sets the message group ID, required to publish on a FIFO topic.
+func WithSNSMessageGroupID(id string) SNSSenderOption {
+	return func(s *SNSSender) {
+		s.messageGroupID = id
+	}
+}
+
+// WithSNSLogger sets the logger of the SNSSender, instead of the default one.
+func WithSNSLogger(l *zap.SugaredLogger) SNSSenderOption {
+	return func(s *SNSSender) {
+		s.logger = l
+	}
+}
+
+// NewSNSSender creates an announce.Sender publishing to the SNS topic topicArn, on behalf of the publisher peerID.
+func NewSNSSender(client SNSPublishAPI, topicArn string, peerID peer.ID, opts ...SNSSenderOption) *SNSSender {
+	s := &SNSSender{client: client, topicArn: topicArn, peerID: peerID}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.logger = orDefaultLogger(s.logger)
+	return s
+}
+
+// Send publishes the announce message to the SNS topic.
+func (s *SNSSender) Send(ctx context.Context, msg message.Message) error {
+	if len(msg.Addrs) != 0 {
+		addrs, err := msg.GetAddrs()
+		if err != nil {
+			return fmt.Errorf("cannot get addrs from message: %w", err)
+		}
+		p2pAddrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: s.peerID, Addrs: addrs})
+		if err != nil {
+			return fmt.Errorf("cannot add p2p id to message addrs: %w", err)
+		}
+		msg.SetAddrs(p2pAddrs)
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("cannot json encode announce message: %w", err)
+	}
+
+	input := &sns.PublishInput{
+		TopicArn: aws.String(s.topicArn),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"Content-Type": {DataType: aws.String("String"), StringValue: aws.String("application/json")},
+		},
+	}
+	if s.messageGroupID != "" {
+		input.MessageGroupId = aws.String(s.messageGroupID)
+		// announcing twice the same head is pointless
+		input.MessageDeduplicationId = aws.String(msg.Cid.String())
+	}
+
+	_, err = s.client.Publish(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to publish announce message to SNS: %w", err)
+	}
+	s.logger.Debugw("announced head on SNS", "topic", s.topicArn, "head", msg.Cid)
+	return nil
+}
+
+func (s *SNSSender) Close() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSNS struct {
+	published []*sns.PublishInput
+}
+
+func (f *fakeSNS) Publish(_ context.Context, params *sns.PublishInput, _ ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	f.published = append(f.published, params)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSNSSender(t *testing.T) {
+	cfg := testChainConfig(t)
+	client := &fakeSNS{}
+	sender := NewSNSSender(client, "arn:aws:sns:us-east-1:123456789012:announce", cfg.PublisherID)
+
+	head := cid.NewCidV1(cid.DagCBOR, testMultihashes(1)[0])
+	addr := multiaddr.StringCast("/dns4/example.com/tcp/443/https")
+	err := announce.Send(context.Background(), head, []multiaddr.Multiaddr{addr}, sender)
+	require.NoError(t, err)
+
+	require.Len(t, client.published, 1)
+	var msg message.Message
+	require.NoError(t, json.Unmarshal([]byte(aws.ToString(client.published[0].Message)), &msg))
+	require.Equal(t, head, msg.Cid)
+	addrs, err := msg.GetAddrs()
+	require.NoError(t, err)
+	require.Equal(t, "/dns4/example.com/tcp/443/https/p2p/"+cfg.PublisherID.String(), addrs[0].String())
+}
@@ -1,8 +1,10 @@
 package herald
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime/datamodel"
@@ -11,14 +13,14 @@ import (
 
 // ChainWriter is a write access to an IPNI chain backend
 type ChainWriter interface {
-	// UpdateHead perform an atomic update of the IPNI chain head
+	// UpdateHead perform an atomic update of the IPNI chain head.
+	// fn may be run more than once, if the backend retries on a concurrent update.
 	UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error
 
 	// Store record a new IPLD node into the backend
 	Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error)
 
 	// TODO:
-	//  - Update address
 	//  - AddProvider
 	//  - RemoveProvider
 	//  - UpdateProvider
@@ -36,4 +38,71 @@ type ChainReader interface {
 	// GetContent returns the raw content of an IPLD block of the IPNI chain.
 	// Returns ErrContentNotFound if not found.
 	GetContent(ctx context.Context, cid cid.Cid) ([]byte, error)
+
+	// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+	// If the receiver falls behind, the oldest heads are dropped so that the latest is always delivered.
+	SubscribeHead(ctx context.Context) (<-chan cid.Cid, error)
+}
+
+// ContentStreamer is an optional streaming read access to the blocks of a ChainReader, to serve large blocks without
+// holding them in memory. See GetContentStream.
+type ContentStreamer interface {
+	// GetContentStream returns the raw content of an IPLD block of the IPNI chain as a stream to close, and its size,
+	// or -1 if unknown. Returns ErrContentNotFound if not found.
+	GetContentStream(ctx context.Context, cid cid.Cid) (io.ReadCloser, int64, error)
+}
+
+// GetContentStream returns the content of a block as a stream, with ContentStreamer if the backend implements it, or
+// from GetContent otherwise.
+func GetContentStream(ctx context.Context, backend ChainReader, c cid.Cid) (io.ReadCloser, int64, error) {
+	if streamer, ok := backend.(ContentStreamer); ok {
+		return streamer.GetContentStream(ctx, c)
+	}
+	data, err := backend.GetContent(ctx, c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return newBytesStream(data)
 }
+
+// bytesStream is a block in memory as a stream, which can seek.
+type bytesStream struct {
+	*bytes.Reader
+}
+
+func newBytesStream(data []byte) (io.ReadCloser, int64, error) {
+	return bytesStream{Reader: bytes.NewReader(data)}, int64(len(data)), nil
+}
+
+func (bytesStream) Close() error {
+	return nil
+}
+
+// BlockManager is an optional low-level access to the blocks stored by a backend, as needed by maintenance
+// operations like orphan detection or garbage collection.
+type BlockManager interface {
+	// ForEachBlock calls fn for the CID of every block stored in the backend, in no particular order.
+	// Iteration stops at the first error returned by fn.
+	ForEachBlock(ctx context.Context, fn func(c cid.Cid) error) error
+
+	// DeleteBlock removes a block from the backend. Deleting a missing block is not an error.
+	DeleteBlock(ctx context.Context, c cid.Cid) error
+}
+
+// ContextIDIndex is an optional index of the ContextIDs of the chain, maintained by a backend on each head update.
+// The ContextIDs are the ones stored in the advertisements, meaning including their namespace, if any.
+type ContextIDIndex interface {
+	// ContextIDState returns the state of a ContextID in the chain.
+	// Returns ErrNoContextIDIndex if the backend doesn't maintain the index.
+	ContextIDState(ctx context.Context, id CatalogID) (ContextIDInfo, error)
+
+	// HasContextID returns true if the ContextID is live, meaning published and not retracted.
+	// Returns ErrNoContextIDIndex if the backend doesn't maintain the index.
+	HasContextID(ctx context.Context, id CatalogID) (bool, error)
+
+	// ListContextIDs calls fn for every ContextID of the chain, live or retracted, in no particular order.
+	// Iteration stops at the first error returned by fn.
+	ListContextIDs(ctx context.Context, fn func(id CatalogID, info ContextIDInfo) error) error
+}
+
+var ErrNoContextIDIndex = errors.New("the backend doesn't maintain a ContextID index")
@@ -0,0 +1,150 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+)
+
+var _ ChainWriter = &DryRunBackend{}
+var _ ChainReader = &DryRunBackend{}
+var _ ContentStreamer = &DryRunBackend{}
+
+// DryRunStats summarizes what a dry run would have published.
+type DryRunStats struct {
+	// Head is the would-be head of the chain.
+	Head cid.Cid
+	// Ads is the number of advertisements generated.
+	Ads int
+	// Blocks is the number of blocks generated, advertisements and entry chunks.
+	Blocks int
+	// Bytes is the total size of the generated blocks.
+	Bytes int64
+}
+
+// DryRunBackend is a ChainWriter keeping the generated blocks and head in a MemoryBackend, to validate catalogs and
+// estimate the storage of their publication without touching the real backend. The advertisements are generated and
+// signed as they would be, on top of the head of the base chain, if any.
+//
+//	dry := NewDryRunBackend(backend)
+//	_, err := PublishWithContextID(ctx, cfg, dry, catalog)
+//	stats := dry.Stats()
+type DryRunBackend struct {
+	mem  *MemoryBackend
+	base ChainReader
+
+	mu  sync.Mutex
+	ads int
+}
+
+// NewDryRunBackend creates a DryRunBackend on top of the chain of base, which is only read. base can be nil, to start
+// from an empty chain.
+func NewDryRunBackend(base ChainReader) *DryRunBackend {
+	return &DryRunBackend{mem: NewMemoryBackend(), base: base}
+}
+
+// Store records a new IPLD node in memory.
+func (d *DryRunBackend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error) {
+	return d.mem.Store(lnkCtx, lp, n)
+}
+
+// UpdateHead updates the in-memory head, starting from the head of the base chain.
+func (d *DryRunBackend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
+	return d.mem.UpdateHead(ctx, func(memHead cid.Cid) (cid.Cid, error) {
+		prevHead := memHead
+		if !prevHead.Defined() && d.base != nil {
+			var err error
+			prevHead, err = d.base.GetHead(ctx)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		newHead, err := fn(prevHead)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if newHead == prevHead {
+			return memHead, nil
+		}
+
+		// count the new advertisements
+		var ads int
+		for next := newHead; next.Defined() && next != prevHead; {
+			ad, err := LoadAdvertisement(ctx, d, next)
+			if err != nil {
+				return cid.Undef, err
+			}
+			ads++
+			next = ad.PreviousCid()
+		}
+		d.mu.Lock()
+		d.ads += ads
+		d.mu.Unlock()
+		return newHead, nil
+	})
+}
+
+// GetHead returns the in-memory head, or the head of the base chain if nothing was published yet.
+func (d *DryRunBackend) GetHead(ctx context.Context) (cid.Cid, error) {
+	head, err := d.mem.GetHead(ctx)
+	if err != nil || head.Defined() || d.base == nil {
+		return head, err
+	}
+	return d.base.GetHead(ctx)
+}
+
+// GetContent returns a generated block, or a block of the base chain.
+func (d *DryRunBackend) GetContent(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, err := d.mem.GetContent(ctx, c)
+	if errors.Is(err, ErrContentNotFound) && d.base != nil {
+		return d.base.GetContent(ctx, c)
+	}
+	return data, err
+}
+
+// GetContentStream returns a generated block, or streams a block of the base chain.
+func (d *DryRunBackend) GetContentStream(ctx context.Context, c cid.Cid) (io.ReadCloser, int64, error) {
+	stream, size, err := d.mem.GetContentStream(ctx, c)
+	if errors.Is(err, ErrContentNotFound) && d.base != nil {
+		return GetContentStream(ctx, d.base, c)
+	}
+	return stream, size, err
+}
+
+// SubscribeHead returns a channel emitting every new in-memory head, until ctx is done.
+func (d *DryRunBackend) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	return d.mem.SubscribeHead(ctx)
+}
+
+// Stats returns the summary of what was generated so far.
+func (d *DryRunBackend) Stats() DryRunStats {
+	ctx := context.Background()
+	var stats DryRunStats
+	stats.Head, _ = d.mem.GetHead(ctx)
+	d.mu.Lock()
+	stats.Ads = d.ads
+	d.mu.Unlock()
+	// the in-memory blocks can't fail to be read
+	_ = d.mem.ForEachBlock(ctx, func(c cid.Cid) error {
+		data, err := d.mem.GetContent(ctx, c)
+		if err == nil {
+			stats.Blocks++
+			stats.Bytes += int64(len(data))
+		}
+		return nil
+	})
+	return stats
+}
+
+// Reset discards the generated blocks and head, to start a new dry run.
+func (d *DryRunBackend) Reset() {
+	d.mem.reset()
+	d.mu.Lock()
+	d.ads = 0
+	d.mu.Unlock()
+}
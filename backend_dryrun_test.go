@@ -0,0 +1,57 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunBackend(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	backend := NewDatastoreBackend(ds)
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	size := func() int {
+		res, err := ds.Query(ctx, query.Query{KeysOnly: true})
+		require.NoError(t, err)
+		entries, err := res.Rest()
+		require.NoError(t, err)
+		return len(entries)
+	}
+	before := size()
+
+	dry := NewDryRunBackend(backend)
+	head, err := PublishWithContextID(ctx, cfg, dry, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.NoError(t, err)
+
+	// 3 entry chunks of 2 multihashes, and the advertisement on top of the real head
+	stats := dry.Stats()
+	require.Equal(t, DryRunStats{Head: head, Ads: 1, Blocks: 4, Bytes: stats.Bytes}, stats)
+	require.Positive(t, stats.Bytes)
+	ad, err := LoadAdvertisement(ctx, dry, head)
+	require.NoError(t, err)
+	require.Equal(t, first, ad.PreviousCid())
+
+	// the real backend is untouched
+	realHead, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first, realHead)
+	require.Equal(t, before, size())
+
+	// the same publication for real gives the same advertisement
+	realHead, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.NoError(t, err)
+	require.Equal(t, head, realHead)
+
+	dry.Reset()
+	require.Equal(t, DryRunStats{}, dry.Stats())
+	dryHead, err := dry.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, realHead, dryHead)
+}
@@ -10,34 +10,113 @@ import (
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/linking"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"go.uber.org/zap"
 )
 
 var _ ChainWriter = &DsBackend{}
 var _ ChainReader = &DsBackend{}
+var _ ContentStreamer = &DsBackend{}
+var _ BlockManager = &DsBackend{}
 
 // DsBackend is an IPNI publishing backend that stores the chain in a datastore.Datastore.
 type DsBackend struct {
 	locker sync.RWMutex // atomicity over the chain head
 	head   cid.Cid      // cache the head CID
+	notif  headNotifier
 
-	ds datastore.Datastore
-	ls ipld.LinkSystem
+	ds        datastore.Datastore
+	namespace datastore.Key // if not the root, prefix of all the keys
+	ls        ipld.LinkSystem
+
+	// batching of the block writes, if the datastore supports it
+	batching  datastore.Batching
+	batchSize int
+	batchMu   sync.Mutex
+	batch     datastore.Batch
+	pending   map[datastore.Key][]byte // content of the batch, to serve reads before the flush
+
+	// metrics records the head updates, if not nil
+	metrics *Metrics
+	logger  *zap.SugaredLogger
+
+	// contextIDIndex enables the ContextID index, see WithDsContextIDIndex
+	contextIDIndex bool
+}
+
+// DefaultDsBatchSize is the default number of block writes grouped in a single datastore batch.
+const DefaultDsBatchSize = 256
+
+// DsBackendOption is an optional configuration for the DsBackend.
+type DsBackendOption func(*DsBackend)
+
+// WithDsBatchSize sets the number of block writes grouped in a single batch, if the datastore implements
+// datastore.Batching. Pending writes are also flushed before any update of the head.
+// A value <= 1 disables the batching.
+func WithDsBatchSize(n int) DsBackendOption {
+	return func(p *DsBackend) {
+		p.batchSize = n
+	}
+}
+
+// WithDsNamespace stores all the keys of the backend under the given prefix, for example "/ipni/chain", so that the
+// datastore can be shared with other components or hold multiple chains.
+func WithDsNamespace(prefix string) DsBackendOption {
+	return func(p *DsBackend) {
+		p.namespace = datastore.NewKey(prefix)
+	}
+}
+
+// WithDsMetrics records the head updates and their failures.
+func WithDsMetrics(metrics *Metrics) DsBackendOption {
+	return func(p *DsBackend) {
+		p.metrics = metrics
+	}
+}
+
+// WithDsLogger sets the logger of the backend, instead of the default one.
+func WithDsLogger(l *zap.SugaredLogger) DsBackendOption {
+	return func(p *DsBackend) {
+		p.logger = l
+	}
 }
 
-func NewDsPublisher(ds datastore.Datastore) *DsBackend {
-	p := &DsBackend{ds: ds, head: cid.Undef}
+// NewDatastoreBackend creates a DsBackend storing the chain in ds.
+// Block writes are grouped in batches if ds implements datastore.Batching, see WithDsBatchSize.
+func NewDatastoreBackend(ds datastore.Datastore, opts ...DsBackendOption) *DsBackend {
+	p := &DsBackend{ds: ds, head: cid.Undef, batchSize: DefaultDsBatchSize, namespace: datastore.NewKey("/")}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.logger = orDefaultLogger(p.logger)
+	if !p.namespace.Equal(datastore.NewKey("/")) {
+		// the wrapped datastore still implements datastore.Batching
+		p.ds = namespace.Wrap(ds, p.namespace)
+		ds = p.ds
+	}
+	if batching, ok := ds.(datastore.Batching); ok && p.batchSize > 1 {
+		p.batching = batching
+	}
 	p.ls = cidlink.DefaultLinkSystem()
 	p.ls.StorageReadOpener = p.storageReadOpener
 	p.ls.StorageWriteOpener = p.storageWriteOpener
 	return p
 }
 
+// NewDsPublisher creates a DsBackend storing the chain in ds.
+//
+// Deprecated: use NewDatastoreBackend.
+func NewDsPublisher(ds datastore.Datastore, opts ...DsBackendOption) *DsBackend {
+	return NewDatastoreBackend(ds, opts...)
+}
+
 func (p *DsBackend) storageReadOpener(ctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
-	val, err := p.ds.Get(ctx.Ctx, dsKey(lnk))
+	val, err := p.get(ctx.Ctx, dsKey(lnk))
 	if err != nil {
 		return nil, err
 	}
@@ -49,10 +128,74 @@ func (p *DsBackend) storageWriteOpener(linkCtx linking.LinkContext) (io.Writer,
 	buf.Reset()
 	return buf, func(lnk ipld.Link) error {
 		defer bytesBuffersPool.Put(buf)
-		return p.ds.Put(linkCtx.Ctx, dsKey(lnk), buf.Bytes())
+		// the buffer gets reused, so the datastore can't be allowed to retain it
+		return p.put(linkCtx.Ctx, dsKey(lnk), bytes.Clone(buf.Bytes()))
 	}, nil
 }
 
+// put writes a block, in the current batch if batching is enabled.
+func (p *DsBackend) put(ctx context.Context, key datastore.Key, value []byte) error {
+	if p.batching == nil {
+		return p.ds.Put(ctx, key, value)
+	}
+
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+
+	if p.batch == nil {
+		var err error
+		p.batch, err = p.batching.Batch(ctx)
+		if err != nil {
+			return err
+		}
+		p.pending = make(map[datastore.Key][]byte, p.batchSize)
+	}
+	if err := p.batch.Put(ctx, key, value); err != nil {
+		return err
+	}
+	p.pending[key] = value
+	if len(p.pending) >= p.batchSize {
+		return p.flush(ctx)
+	}
+	return nil
+}
+
+// Flush commits the pending block writes to the datastore.
+func (p *DsBackend) Flush(ctx context.Context) error {
+	if p.batching == nil {
+		return nil
+	}
+	p.batchMu.Lock()
+	defer p.batchMu.Unlock()
+	return p.flush(ctx)
+}
+
+func (p *DsBackend) flush(ctx context.Context) error {
+	if p.batch == nil {
+		return nil
+	}
+	if err := p.batch.Commit(ctx); err != nil {
+		p.logger.Errorw("failed to commit datastore batch", "size", len(p.pending), "err", err)
+		return err
+	}
+	p.batch = nil
+	p.pending = nil
+	return nil
+}
+
+// get reads a block, including the pending writes.
+func (p *DsBackend) get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	if p.batching != nil {
+		p.batchMu.Lock()
+		value, ok := p.pending[key]
+		p.batchMu.Unlock()
+		if ok {
+			return value, nil
+		}
+	}
+	return p.ds.Get(ctx, key)
+}
+
 func dsKey(l ipld.Link) datastore.Key {
 	return datastore.NewKey(l.(cidlink.Link).Cid.String())
 }
@@ -64,6 +207,7 @@ func (p *DsBackend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (c
 
 	prevHead, err := p.getHead(ctx)
 	if err != nil {
+		p.metrics.recordHeadUpdate(err)
 		return err
 	}
 
@@ -72,7 +216,22 @@ func (p *DsBackend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (c
 		return err
 	}
 
-	return p.setHead(ctx, newHead)
+	// make sure that all the blocks are durably stored before exposing them through the head
+	err = p.Flush(ctx)
+	if err != nil {
+		err = backendUnavailable(err)
+	}
+	if err == nil {
+		err = p.setHead(ctx, newHead)
+	}
+	p.metrics.recordHeadUpdate(err)
+	if err == nil && p.contextIDIndex {
+		if err := p.syncContextIDs(ctx, newHead); err != nil {
+			// the index is caught up on the next update or read
+			p.logger.Errorw("failed to update the ContextID index", "head", newHead, "err", err)
+		}
+	}
+	return err
 }
 
 var headKey = datastore.NewKey("head")
@@ -86,11 +245,11 @@ func (p *DsBackend) getHead(ctx context.Context) (cid.Cid, error) {
 	case errors.Is(err, datastore.ErrNotFound):
 		return cid.Undef, nil
 	case err != nil:
-		return cid.Undef, err
+		return cid.Undef, backendUnavailable(err)
 	default:
 		_, head, err := cid.CidFromBytes(value)
 		if err != nil {
-			logger.Errorw("failed to decode stored head as CID", "err", err)
+			p.logger.Errorw("failed to decode stored head as CID", "err", err)
 			return cid.Undef, err
 		}
 		p.head = head
@@ -105,10 +264,11 @@ func (p *DsBackend) setHead(ctx context.Context, newHead cid.Cid) error {
 	}
 
 	if err := p.ds.Put(ctx, headKey, newHead.Bytes()); err != nil {
-		logger.Errorw("failed to set new head", "newHead", newHead, "err", err)
-		return err
+		p.logger.Errorw("failed to set new head", "newHead", newHead, "err", err)
+		return backendUnavailable(err)
 	}
 	p.head = newHead
+	p.notif.notify(newHead)
 	return nil
 }
 
@@ -129,7 +289,7 @@ func (p *DsBackend) GetHead(ctx context.Context) (cid.Cid, error) {
 // Returns ErrContentNotFound if not found.
 func (p *DsBackend) GetContent(ctx context.Context, cid cid.Cid) ([]byte, error) {
 	key := dsKey(cidlink.Link{Cid: cid})
-	switch value, err := p.ds.Get(ctx, key); {
+	switch value, err := p.get(ctx, key); {
 	case errors.Is(err, datastore.ErrNotFound):
 		return nil, ErrContentNotFound
 	case err != nil:
@@ -139,6 +299,63 @@ func (p *DsBackend) GetContent(ctx context.Context, cid cid.Cid) ([]byte, error)
 	}
 }
 
+// GetContentStream returns the raw content of an IPLD block of the IPNI chain as a stream. The datastore has no
+// streaming read, so the block is in memory.
+// Returns ErrContentNotFound if not found.
+func (p *DsBackend) GetContentStream(ctx context.Context, cid cid.Cid) (io.ReadCloser, int64, error) {
+	data, err := p.GetContent(ctx, cid)
+	if err != nil {
+		return nil, 0, err
+	}
+	return newBytesStream(data)
+}
+
+// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+// If the receiver falls behind, the oldest heads are dropped so that the latest is always delivered.
+func (p *DsBackend) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	return p.notif.subscribe(ctx), nil
+}
+
+// ForEachBlock calls fn for the CID of every block stored in the backend, in no particular order.
+// Iteration stops at the first error returned by fn.
+func (p *DsBackend) ForEachBlock(ctx context.Context, fn func(c cid.Cid) error) error {
+	if err := p.Flush(ctx); err != nil {
+		return err
+	}
+	results, err := p.ds.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for res := range results.Next() {
+		if res.Error != nil {
+			return res.Error
+		}
+		key := datastore.RawKey(res.Key)
+		if key.Equal(headKey) || key.Equal(contextIDsHeadKey) || contextIDsKey.IsAncestorOf(key) {
+			continue
+		}
+		c, err := cid.Decode(key.BaseNamespace())
+		if err != nil {
+			p.logger.Debugw("skipping non-block datastore key", "key", res.Key)
+			continue
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlock removes a block from the backend. Deleting a missing block is not an error.
+func (p *DsBackend) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	if err := p.Flush(ctx); err != nil {
+		return err
+	}
+	return p.ds.Delete(ctx, dsKey(cidlink.Link{Cid: c}))
+}
+
 var bytesBuffersPool = sync.Pool{
 	New: func() any { return new(bytes.Buffer) },
 }
@@ -0,0 +1,210 @@
+package herald
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+var _ ContextIDIndex = &DsBackend{}
+
+var (
+	// contextIDsKey is the parent key of the ContextID index entries
+	contextIDsKey = datastore.NewKey("contextid")
+	// contextIDsHeadKey holds the head of the chain that the ContextID index reflects
+	contextIDsHeadKey = datastore.NewKey("contextid-head")
+)
+
+// WithDsContextIDIndex maintains an index of the ContextIDs of the chain, updated on each head update, and exposed
+// through ContextIDIndex. Enabling it on an existing chain builds the index on first use, by walking the whole chain.
+func WithDsContextIDIndex() DsBackendOption {
+	return func(p *DsBackend) {
+		p.contextIDIndex = true
+	}
+}
+
+// ContextIDState returns the state of a ContextID in the chain.
+// Returns ErrNoContextIDIndex if the backend doesn't maintain the index.
+func (p *DsBackend) ContextIDState(ctx context.Context, id CatalogID) (ContextIDInfo, error) {
+	if !p.contextIDIndex {
+		return ContextIDInfo{}, ErrNoContextIDIndex
+	}
+	if err := p.syncContextIDsLocked(ctx); err != nil {
+		return ContextIDInfo{}, err
+	}
+	value, err := p.ds.Get(ctx, contextIDKey(id))
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+		return ContextIDInfo{State: ContextIDUnknown}, nil
+	case err != nil:
+		return ContextIDInfo{}, err
+	}
+	return decodeContextIDInfo(value)
+}
+
+// HasContextID returns true if the ContextID is live, meaning published and not retracted.
+// Returns ErrNoContextIDIndex if the backend doesn't maintain the index.
+func (p *DsBackend) HasContextID(ctx context.Context, id CatalogID) (bool, error) {
+	info, err := p.ContextIDState(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return info.State == ContextIDLive, nil
+}
+
+// ListContextIDs calls fn for every ContextID of the chain, live or retracted, in no particular order.
+// Iteration stops at the first error returned by fn.
+func (p *DsBackend) ListContextIDs(ctx context.Context, fn func(id CatalogID, info ContextIDInfo) error) error {
+	if !p.contextIDIndex {
+		return ErrNoContextIDIndex
+	}
+	if err := p.syncContextIDsLocked(ctx); err != nil {
+		return err
+	}
+	results, err := p.ds.Query(ctx, query.Query{Prefix: contextIDsKey.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for res := range results.Next() {
+		if res.Error != nil {
+			return res.Error
+		}
+		id, err := hex.DecodeString(datastore.RawKey(res.Key).BaseNamespace())
+		if err != nil {
+			return fmt.Errorf("corrupted ContextID index key %s: %w", res.Key, err)
+		}
+		info, err := decodeContextIDInfo(res.Value)
+		if err != nil {
+			return err
+		}
+		if err := fn(id, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncContextIDsLocked is syncContextIDs, for callers not holding the head lock.
+func (p *DsBackend) syncContextIDsLocked(ctx context.Context) error {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	head, err := p.getHead(ctx)
+	if err != nil {
+		return err
+	}
+	return p.syncContextIDs(ctx, head)
+}
+
+// syncContextIDs brings the ContextID index up to date with head, by indexing the advertisements added since the
+// indexed head. If the indexed head is not part of the chain anymore, for example after a garbage collection, the
+// index is rebuilt from scratch. The caller must hold the head lock.
+func (p *DsBackend) syncContextIDs(ctx context.Context, head cid.Cid) error {
+	var indexed cid.Cid
+	switch value, err := p.ds.Get(ctx, contextIDsHeadKey); {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		if _, indexed, err = cid.CidFromBytes(value); err != nil {
+			return fmt.Errorf("corrupted ContextID index head: %w", err)
+		}
+	}
+	if indexed.Equals(head) {
+		return nil
+	}
+
+	// find out if the indexed head is still part of the chain
+	rebuild := true
+	for c := head; c.Defined() && indexed.Defined(); {
+		if c.Equals(indexed) {
+			rebuild = false
+			break
+		}
+		ad, err := LoadAdvertisement(ctx, p, c)
+		if err != nil {
+			return err
+		}
+		c = ad.PreviousCid()
+	}
+	if rebuild {
+		if err := p.clearContextIDs(ctx); err != nil {
+			return err
+		}
+	}
+
+	// walking from the head, the newest advertisement of each ContextID wins
+	seen := make(map[string]struct{})
+	for c := head; c.Defined() && !c.Equals(indexed); {
+		ad, err := LoadAdvertisement(ctx, p, c)
+		if err != nil {
+			return err
+		}
+		if _, ok := seen[string(ad.ContextID)]; len(ad.ContextID) > 0 && !ok {
+			seen[string(ad.ContextID)] = struct{}{}
+			info := ContextIDInfo{State: ContextIDLive, Ad: c, Metadata: ad.Metadata}
+			if ad.IsRm {
+				info = ContextIDInfo{State: ContextIDRetracted, Ad: c}
+			}
+			if err := p.ds.Put(ctx, contextIDKey(ad.ContextID), encodeContextIDInfo(info)); err != nil {
+				return err
+			}
+		}
+		c = ad.PreviousCid()
+	}
+
+	if !head.Defined() {
+		return p.ds.Delete(ctx, contextIDsHeadKey)
+	}
+	return p.ds.Put(ctx, contextIDsHeadKey, head.Bytes())
+}
+
+// clearContextIDs removes all the entries of the ContextID index.
+func (p *DsBackend) clearContextIDs(ctx context.Context) error {
+	results, err := p.ds.Query(ctx, query.Query{Prefix: contextIDsKey.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := p.ds.Delete(ctx, datastore.RawKey(entry.Key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contextIDKey(id CatalogID) datastore.Key {
+	return contextIDsKey.ChildString(hex.EncodeToString(id))
+}
+
+// encodeContextIDInfo encodes the info as the state, followed by the advertisement CID and the metadata.
+func encodeContextIDInfo(info ContextIDInfo) []byte {
+	value := []byte{byte(info.State)}
+	value = append(value, info.Ad.Bytes()...)
+	return append(value, info.Metadata...)
+}
+
+func decodeContextIDInfo(value []byte) (ContextIDInfo, error) {
+	if len(value) == 0 {
+		return ContextIDInfo{}, fmt.Errorf("corrupted ContextID index entry")
+	}
+	n, ad, err := cid.CidFromBytes(value[1:])
+	if err != nil {
+		return ContextIDInfo{}, fmt.Errorf("corrupted ContextID index entry: %w", err)
+	}
+	info := ContextIDInfo{State: ContextIDState(value[0]), Ad: ad}
+	if metadata := value[1+n:]; len(metadata) > 0 {
+		info.Metadata = metadata
+	}
+	return info, nil
+}
@@ -0,0 +1,130 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDsBackendContextIDIndex(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+
+	// a chain started without the index
+	_, err := PublishWithContextID(ctx, cfg, NewDatastoreBackend(ds), testCatalog{MhCatalog: testMultihashes(2), id: []byte("foo")})
+	require.NoError(t, err)
+
+	backend := NewDatastoreBackend(ds, WithDsContextIDIndex())
+	live, err := backend.HasContextID(ctx, []byte("foo"))
+	require.NoError(t, err)
+	require.True(t, live)
+
+	_, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte("bar")})
+	require.NoError(t, err)
+	retracted, err := RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+
+	states := make(map[string]ContextIDState)
+	err = backend.ListContextIDs(ctx, func(id CatalogID, info ContextIDInfo) error {
+		states[string(id)] = info.State
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]ContextIDState{"foo": ContextIDRetracted, "bar": ContextIDLive}, states)
+
+	info, err := backend.ContextIDState(ctx, []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, retracted, info.Ad)
+	info, err = backend.ContextIDState(ctx, []byte("baz"))
+	require.NoError(t, err)
+	require.Equal(t, ContextIDUnknown, info.State)
+
+	// the index entries are not blocks
+	err = backend.ForEachBlock(ctx, func(c cid.Cid) error {
+		_, err := backend.GetContent(ctx, c)
+		return err
+	})
+	require.NoError(t, err)
+
+	_, err = NewDatastoreBackend(ds).HasContextID(ctx, []byte("foo"))
+	require.ErrorIs(t, err, ErrNoContextIDIndex)
+}
+
+func TestDuplicateContextIDs(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()), WithDsContextIDIndex())
+	catalog := testCatalog{MhCatalog: testMultihashes(2), id: []byte("foo")}
+
+	head, err := PublishWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+
+	cfg.DuplicateContextIDs = DuplicateContextIDSkip
+	skipped, err := PublishWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, skipped)
+
+	cfg.DuplicateContextIDs = DuplicateContextIDError
+	_, err = PublishWithContextID(ctx, cfg, backend, catalog)
+	require.ErrorIs(t, err, ErrContextIDLive)
+
+	stored, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, stored)
+
+	// a metadata update is not a duplicate
+	cfg.Metadata = []byte("other")
+	_, err = PublishWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+}
+
+func TestDeduplicateAds(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.DeduplicateAds = true
+	cfg.AdEntriesChunkSize = 2
+	cfg.MaxMHsPerAdvertisement = 5
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()), WithDsContextIDIndex())
+	catalog := testCatalog{MhCatalog: testMultihashes(12), id: []byte("foo")}
+
+	ads, err := PublishSplitWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+	require.Len(t, ads, 3)
+
+	// a replay returns the same advertisements, without growing the chain
+	replayed, err := PublishSplitWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+	require.Equal(t, ads, replayed)
+	head, err := PublishWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+	require.Equal(t, ads[2], head)
+	stored, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, ads[2], stored)
+
+	// different entries are published
+	changed, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")})
+	require.NoError(t, err)
+	require.NotEqual(t, head, changed)
+
+	// as well as different metadata
+	cfg.Metadata = []byte("other")
+	updated, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")})
+	require.NoError(t, err)
+	require.NotEqual(t, changed, updated)
+
+	// and a retracted ContextID
+	_, err = RetractWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+	republished, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")})
+	require.NoError(t, err)
+	stored, err = backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, republished, stored)
+	require.NotEqual(t, updated, republished)
+}
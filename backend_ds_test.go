@@ -0,0 +1,74 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDsBackendBatching(t *testing.T) {
+	ctx := context.Background()
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	backend := NewDatastoreBackend(ds, WithDsBatchSize(3))
+
+	var links []ipld.Link
+	for _, s := range []string{"a", "b"} {
+		lnk, err := backend.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, basicnode.NewString(s))
+		require.NoError(t, err)
+		links = append(links, lnk)
+	}
+
+	// pending writes are not in the datastore yet, but readable through the backend
+	for _, lnk := range links {
+		has, err := ds.Has(ctx, dsKey(lnk))
+		require.NoError(t, err)
+		require.False(t, has)
+		_, err = backend.GetContent(ctx, lnk.(cidlink.Link).Cid)
+		require.NoError(t, err)
+	}
+
+	// reaching the batch size flushes
+	lnk, err := backend.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, basicnode.NewString("c"))
+	require.NoError(t, err)
+	links = append(links, lnk)
+	for _, lnk := range links {
+		has, err := ds.Has(ctx, dsKey(lnk))
+		require.NoError(t, err)
+		require.True(t, has)
+	}
+}
+
+func TestDsBackendNamespace(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+
+	// two chains sharing the same datastore
+	a := NewDatastoreBackend(ds, WithDsNamespace("/chain/a"))
+	b := NewDatastoreBackend(ds, WithDsNamespace("/chain/b"))
+	headA, err := PublishRawMHs(ctx, cfg, a, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+
+	has, err := ds.Has(ctx, datastore.NewKey("/chain/a").Child(dsKey(cidlink.Link{Cid: headA})))
+	require.NoError(t, err)
+	require.True(t, has)
+
+	head, err := b.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, head)
+	_, err = b.GetContent(ctx, headA)
+	require.ErrorIs(t, err, ErrContentNotFound)
+
+	// a new backend on the same namespace resumes the chain
+	head, err = NewDatastoreBackend(ds, WithDsNamespace("/chain/a")).GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, headA, head)
+}
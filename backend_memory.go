@@ -0,0 +1,161 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+)
+
+var _ ChainWriter = &MemoryBackend{}
+var _ ChainReader = &MemoryBackend{}
+var _ ContentStreamer = &MemoryBackend{}
+var _ BlockManager = &MemoryBackend{}
+
+// MemoryBackend is a backend keeping the chain in memory, for tests and ephemeral use. It can be served by the
+// publishers like any other backend, but everything is lost on exit.
+type MemoryBackend struct {
+	ls ipld.LinkSystem
+
+	// updateMu serializes the head updates
+	updateMu sync.Mutex
+
+	mu     sync.RWMutex
+	head   cid.Cid
+	blocks map[cid.Cid][]byte
+	notif  headNotifier
+}
+
+// NewMemoryBackend creates an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	m := &MemoryBackend{blocks: make(map[cid.Cid][]byte)}
+	m.ls = cidlink.DefaultLinkSystem()
+	m.ls.StorageReadOpener = m.storageReadOpener
+	m.ls.StorageWriteOpener = m.storageWriteOpener
+	return m
+}
+
+func (m *MemoryBackend) storageReadOpener(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+	data, err := m.GetContent(lnkCtx.Ctx, linkCid(lnk))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+func (m *MemoryBackend) storageWriteOpener(_ linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+	buf := new(bytes.Buffer)
+	return buf, func(lnk ipld.Link) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.blocks[linkCid(lnk)] = buf.Bytes()
+		return nil
+	}, nil
+}
+
+// Store record a new IPLD node into the backend
+func (m *MemoryBackend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error) {
+	return m.ls.Store(lnkCtx, lp, n)
+}
+
+// UpdateHead perform an atomic update of the IPNI chain head
+func (m *MemoryBackend) UpdateHead(_ context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+	m.mu.RLock()
+	prevHead := m.head
+	m.mu.RUnlock()
+
+	newHead, err := fn(prevHead)
+	if err != nil {
+		return err
+	}
+	if newHead == prevHead {
+		return nil
+	}
+	m.mu.Lock()
+	m.head = newHead
+	m.mu.Unlock()
+	m.notif.notify(newHead)
+	return nil
+}
+
+// GetHead return the cid of the IPNI chain head
+// Returns cid.Undef if the chain hasn't started yet.
+func (m *MemoryBackend) GetHead(_ context.Context) (cid.Cid, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.head, nil
+}
+
+// GetContent returns the raw content of an IPLD block of the IPNI chain.
+// Returns ErrContentNotFound if not found.
+func (m *MemoryBackend) GetContent(_ context.Context, c cid.Cid) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.blocks[c]
+	if !ok {
+		return nil, ErrContentNotFound
+	}
+	return data, nil
+}
+
+// GetContentStream returns the raw content of an IPLD block of the IPNI chain as a stream.
+// Returns ErrContentNotFound if not found.
+func (m *MemoryBackend) GetContentStream(ctx context.Context, c cid.Cid) (io.ReadCloser, int64, error) {
+	data, err := m.GetContent(ctx, c)
+	if err != nil {
+		return nil, 0, err
+	}
+	return newBytesStream(data)
+}
+
+// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+// If the receiver falls behind, the oldest heads are dropped so that the latest is always delivered.
+func (m *MemoryBackend) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	return m.notif.subscribe(ctx), nil
+}
+
+// ForEachBlock calls fn for the CID of every block stored in the backend, in no particular order.
+// Iteration stops at the first error returned by fn.
+func (m *MemoryBackend) ForEachBlock(ctx context.Context, fn func(c cid.Cid) error) error {
+	m.mu.RLock()
+	cids := make([]cid.Cid, 0, len(m.blocks))
+	for c := range m.blocks {
+		cids = append(cids, c)
+	}
+	m.mu.RUnlock()
+	for _, c := range cids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteBlock removes a block from the backend. Deleting a missing block is not an error.
+func (m *MemoryBackend) DeleteBlock(_ context.Context, c cid.Cid) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blocks, c)
+	return nil
+}
+
+// reset discards all the blocks and the head, keeping the head subscribers.
+func (m *MemoryBackend) reset() {
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.head = cid.Undef
+	m.blocks = make(map[cid.Cid][]byte)
+}
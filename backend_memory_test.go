@@ -0,0 +1,69 @@
+package herald
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, head.Defined())
+
+	heads, err := backend.SubscribeHead(ctx)
+	require.NoError(t, err)
+
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	require.Equal(t, first, <-heads)
+	second, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(8)[5:], id: []byte("foo")})
+	require.NoError(t, err)
+	require.Equal(t, second, <-heads)
+
+	ad, err := LoadAdvertisement(ctx, backend, second)
+	require.NoError(t, err)
+	require.Equal(t, first, ad.PreviousCid())
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK())
+
+	// served by the HTTP publisher
+	pub, err := NewHttpPublisher(backend, "", "topic", cfg.PublisherKey)
+	require.NoError(t, err)
+	server := httptest.NewServer(pub.Handler())
+	defer server.Close()
+	remote, err := NewHttpChainReader(server.URL+"/ipni/v1/ad", cfg.PublisherID).GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, second, remote)
+
+	// 3 + 2 entry chunks and 2 advertisements
+	var blocks []cid.Cid
+	require.NoError(t, backend.ForEachBlock(ctx, func(c cid.Cid) error {
+		blocks = append(blocks, c)
+		return nil
+	}))
+	require.Len(t, blocks, 7)
+	require.NoError(t, backend.DeleteBlock(ctx, second))
+	_, err = backend.GetContent(ctx, second)
+	require.ErrorIs(t, err, ErrContentNotFound)
+}
+
+func TestHeraldMemoryBackend(t *testing.T) {
+	h, err := New(
+		WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+		WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")),
+		WithMemoryBackend(),
+	)
+	require.NoError(t, err)
+	require.IsType(t, &MemoryBackend{}, h.Backend())
+}
@@ -0,0 +1,346 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+)
+
+var _ ChainWriter = &ObjectStoreBackend{}
+var _ ChainReader = &ObjectStoreBackend{}
+var _ ContentStreamer = &ObjectStoreBackend{}
+var _ BlockManager = &ObjectStoreBackend{}
+
+// ObjectStoreBackend stores the chain pre-rendered in an ObjectStore, such as a Google Cloud Storage bucket or an
+// Azure Blob container: the blocks at prefix+CID and the signed head at prefix+"head", which is the layout of the
+// HTTP publishers. The store, or a CDN in front of it, can then serve the chain directly to the indexers.
+//
+// The head is replaced with a conditional write on the version it was read at, which fails with ErrHeadConflict if
+// another writer sharing the store changed it. For S3, use the S3Backend.
+type ObjectStoreBackend struct {
+	locker      sync.RWMutex // atomicity over the chain head
+	head        cid.Cid      // cache the head CID
+	headVersion string       // version of the head object, empty if there is none
+	notif       headNotifier
+
+	store ObjectStore
+	ls    ipld.LinkSystem
+
+	// topic is the IPNI topic name on which the advertisement is published
+	topic string
+	// providerKey is the keypair of the IPNI publisher
+	providerKey crypto.PrivKey
+
+	// prefix is the prefix of the keys of the blocks, and headKey the key of the signed head
+	prefix  string
+	headKey string
+	// blockCacheControl and headCacheControl are the Cache-Control headers set on the objects
+	blockCacheControl string
+	headCacheControl  string
+
+	// retry is the policy applied to failed requests to the store
+	retry RetryPolicy
+	// metrics records the head updates, if not nil
+	metrics *Metrics
+	logger  *zap.SugaredLogger
+}
+
+// ObjectStoreBackendOption is an optional configuration for the ObjectStoreBackend.
+type ObjectStoreBackendOption func(*ObjectStoreBackend)
+
+// WithObjectStorePrefix sets the prefix of the keys of the objects. The blocks are stored at prefix+CID, and the head
+// at prefix+"head". Defaults to DefaultS3Prefix.
+func WithObjectStorePrefix(prefix string) ObjectStoreBackendOption {
+	return func(b *ObjectStoreBackend) {
+		b.prefix = prefix
+	}
+}
+
+// WithObjectStoreCacheControl sets the Cache-Control of the blocks and of the head, served by the store and the CDNs.
+// Defaults to DefaultS3BlockCacheControl and DefaultS3HeadCacheControl.
+func WithObjectStoreCacheControl(block, head string) ObjectStoreBackendOption {
+	return func(b *ObjectStoreBackend) {
+		b.blockCacheControl = block
+		b.headCacheControl = head
+	}
+}
+
+// WithObjectStoreRetryPolicy sets the RetryPolicy applied to the failed requests to the store.
+func WithObjectStoreRetryPolicy(policy RetryPolicy) ObjectStoreBackendOption {
+	return func(b *ObjectStoreBackend) {
+		b.retry = policy
+	}
+}
+
+// WithObjectStoreMetrics records the head updates and their failures.
+func WithObjectStoreMetrics(metrics *Metrics) ObjectStoreBackendOption {
+	return func(b *ObjectStoreBackend) {
+		b.metrics = metrics
+	}
+}
+
+// WithObjectStoreLogger sets the logger of the backend, instead of the default one.
+func WithObjectStoreLogger(l *zap.SugaredLogger) ObjectStoreBackendOption {
+	return func(b *ObjectStoreBackend) {
+		b.logger = l
+	}
+}
+
+// NewObjectStoreBackend creates an ObjectStoreBackend storing the chain in store, the head being signed with
+// providerKey.
+func NewObjectStoreBackend(store ObjectStore, topic string, providerKey crypto.PrivKey, opts ...ObjectStoreBackendOption) *ObjectStoreBackend {
+	b := &ObjectStoreBackend{
+		store:             store,
+		topic:             topic,
+		providerKey:       providerKey,
+		prefix:            DefaultS3Prefix,
+		blockCacheControl: DefaultS3BlockCacheControl,
+		headCacheControl:  DefaultS3HeadCacheControl,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.logger = orDefaultLogger(b.logger)
+	b.retry = b.retry.withLogger(b.logger)
+	b.headKey = b.prefix + "head"
+	b.ls = cidlink.DefaultLinkSystem()
+	b.ls.StorageWriteOpener = b.storageWriteOpener
+	return b
+}
+
+func (b *ObjectStoreBackend) storageWriteOpener(linkCtx linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+	buf := bytesBuffersPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf, func(lnk ipld.Link) error {
+		defer bytesBuffersPool.Put(buf)
+
+		c := lnk.(cidlink.Link).Cid
+		contentType, err := blockContentType(c)
+		if err != nil {
+			return err
+		}
+		meta := ObjectMeta{ContentType: contentType, CacheControl: b.blockCacheControl}
+		err = b.retry.Do(linkCtx.Ctx, func(ctx context.Context) error {
+			return b.store.Put(ctx, b.prefix+c.String(), buf.Bytes(), meta)
+		})
+		if err != nil {
+			return backendUnavailable(err)
+		}
+		return nil
+	}, nil
+}
+
+// Store record a new IPLD node into the backend
+func (b *ObjectStoreBackend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error) {
+	return b.ls.Store(lnkCtx, lp, n)
+}
+
+// UpdateHead perform an atomic update of the IPNI chain head.
+// Returns ErrHeadConflict if another writer changed the stored head, which is then read again on the next update.
+func (b *ObjectStoreBackend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	prevHead, err := b.getHead(ctx)
+	if err != nil {
+		b.metrics.recordHeadUpdate(err)
+		return err
+	}
+	newHead, err := fn(prevHead)
+	if err != nil {
+		return err
+	}
+	err = b.setHead(ctx, newHead)
+	b.metrics.recordHeadUpdate(err)
+	return err
+}
+
+func (b *ObjectStoreBackend) getHead(ctx context.Context) (cid.Cid, error) {
+	if b.head.Defined() {
+		return b.head, nil
+	}
+	var encoded []byte
+	var meta ObjectMeta
+	var notFound bool
+	err := b.retry.Do(ctx, func(ctx context.Context) error {
+		var body io.ReadCloser
+		var err error
+		body, meta, err = b.store.Get(ctx, b.headKey)
+		if errors.Is(err, ErrObjectNotFound) {
+			// not an error, no need to retry
+			notFound = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		encoded, err = io.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return cid.Undef, backendUnavailable(err)
+	}
+	if notFound {
+		b.headVersion = ""
+		return cid.Undef, nil
+	}
+	if meta.Version == "" {
+		return cid.Undef, fmt.Errorf("object store %T returned no version for the head", b.store)
+	}
+
+	stored, err := decodeSignedHead(bytes.NewReader(encoded))
+	if err != nil {
+		b.logger.Errorw("failed to decode stored head as SignedHead", "err", err)
+		return cid.Undef, err
+	}
+	b.head, b.headVersion = stored, meta.Version
+	return b.head, nil
+}
+
+func (b *ObjectStoreBackend) setHead(ctx context.Context, newHead cid.Cid) error {
+	encoded, err := encodeSignedHead(newHead, b.topic, b.providerKey)
+	if err != nil {
+		return err
+	}
+
+	meta := ObjectMeta{ContentType: "application/json", CacheControl: b.headCacheControl}
+	var version string
+	var conflict bool
+	err = b.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		version, err = b.store.PutIfVersion(ctx, b.headKey, encoded, meta, b.headVersion)
+		if errors.Is(err, ErrObjectModified) {
+			// not an error to retry as is, the head must be read again
+			conflict = true
+			return nil
+		}
+		return err
+	})
+	if conflict {
+		b.head, b.headVersion = cid.Undef, ""
+		b.logger.Warnw("the head was changed by another writer", "newHead", newHead)
+		return fmt.Errorf("%w: conditional write of %s rejected", ErrHeadConflict, newHead)
+	}
+	if err != nil {
+		b.logger.Errorw("failed to set new head", "newHead", newHead, "err", err)
+		return backendUnavailable(err)
+	}
+	b.head, b.headVersion = newHead, version
+	b.notif.notify(newHead)
+	return nil
+}
+
+// GetHead return the cid of the IPNI chain head, as last written or read by this backend.
+// Returns cid.Undef if the chain hasn't started yet.
+func (b *ObjectStoreBackend) GetHead(ctx context.Context) (cid.Cid, error) {
+	b.locker.RLock()
+	h := b.head
+	b.locker.RUnlock()
+	if h.Defined() {
+		return h, nil
+	}
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.getHead(ctx)
+}
+
+// GetContent returns the raw content of an IPLD block of the IPNI chain.
+// Returns ErrContentNotFound if not found.
+func (b *ObjectStoreBackend) GetContent(ctx context.Context, c cid.Cid) ([]byte, error) {
+	body, _, err := b.GetContentStream(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// GetContentStream returns the raw content of an IPLD block of the IPNI chain, streamed from the store.
+// Returns ErrContentNotFound if not found.
+func (b *ObjectStoreBackend) GetContentStream(ctx context.Context, c cid.Cid) (io.ReadCloser, int64, error) {
+	var body io.ReadCloser
+	var meta ObjectMeta
+	var notFound bool
+	err := b.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		body, meta, err = b.store.Get(ctx, b.prefix+c.String())
+		if errors.Is(err, ErrObjectNotFound) {
+			// not an error, no need to retry
+			notFound = true
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, 0, backendUnavailable(err)
+	}
+	if notFound {
+		return nil, 0, ErrContentNotFound
+	}
+	if meta.ContentEncoding == "gzip" {
+		// the decompressed size is unknown
+		gz, err := newGzipStream(body)
+		if err != nil {
+			_ = body.Close()
+			return nil, 0, backendUnavailable(err)
+		}
+		return gz, -1, nil
+	}
+	return body, meta.Size, nil
+}
+
+// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+// If the receiver falls behind, the oldest heads are dropped so that the latest is always delivered.
+func (b *ObjectStoreBackend) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	return b.notif.subscribe(ctx), nil
+}
+
+// ForEachBlock calls fn for the CID of every block stored in the backend, in no particular order.
+// Iteration stops at the first error returned by fn. The store must implement ObjectLister.
+func (b *ObjectStoreBackend) ForEachBlock(ctx context.Context, fn func(c cid.Cid) error) error {
+	lister, err := b.lister()
+	if err != nil {
+		return err
+	}
+	return lister.List(ctx, b.prefix, func(key string) error {
+		c, err := cid.Decode(strings.TrimPrefix(key, b.prefix))
+		if err != nil {
+			// head, or unrelated object
+			return nil
+		}
+		return fn(c)
+	})
+}
+
+// DeleteBlock removes a block from the backend. Deleting a missing block is not an error.
+// The store must implement ObjectLister.
+func (b *ObjectStoreBackend) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	lister, err := b.lister()
+	if err != nil {
+		return err
+	}
+	return b.retry.Do(ctx, func(ctx context.Context) error {
+		return lister.Delete(ctx, b.prefix+c.String())
+	})
+}
+
+func (b *ObjectStoreBackend) lister() (ObjectLister, error) {
+	lister, ok := b.store.(ObjectLister)
+	if !ok {
+		return nil, fmt.Errorf("object store %T can't list or delete the objects", b.store)
+	}
+	return lister, nil
+}
@@ -0,0 +1,117 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectStoreBackend(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake := newFakeGCS(t, "secret")
+	store := NewGCSObjectStore("bucket", WithGCSEndpoint(fake.url), WithGCSTokenSource(func(context.Context) (string, error) { return "secret", nil }))
+
+	backend := NewObjectStoreBackend(store, "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithObjectStorePrefix("chain/"), WithObjectStoreCacheControl("max-age=60", "no-cache"))
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, head.Defined())
+
+	heads, err := backend.SubscribeHead(ctx)
+	require.NoError(t, err)
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	require.Equal(t, first, <-heads)
+
+	// the layout of the HTTP publishers, with the cache headers
+	data, headers := fake.object("chain/" + first.String())
+	require.NotEmpty(t, data)
+	require.Equal(t, "max-age=60", headers.Get("Cache-Control"))
+	require.Equal(t, "application/json", headers.Get("Content-Type"))
+	_, headers = fake.object("chain/head")
+	require.Equal(t, "no-cache", headers.Get("Cache-Control"))
+	meta, err := store.Head(ctx, "chain/"+first.String())
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), meta.Size)
+	require.Equal(t, "max-age=60", meta.CacheControl)
+
+	// served as is to the indexers
+	reader := NewHttpChainReader(fake.url+"/bucket/chain", cfg.PublisherID)
+	remote, err := reader.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first, remote)
+
+	// another writer is detected
+	other := NewObjectStoreBackend(store, "/indexer/ingest/mainnet", cfg.PublisherKey, WithObjectStorePrefix("chain/"))
+	second, err := PublishRawMHs(ctx, cfg, other, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, other, second)
+	require.NoError(t, err)
+	require.Equal(t, first, ad.PreviousCid())
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(3)...))
+	require.ErrorIs(t, err, ErrHeadConflict)
+	third, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(3)...))
+	require.NoError(t, err)
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, 3, report.Ads)
+
+	// the gzipped blocks are decompressed on the read path
+	raw, err := backend.GetContent(ctx, third)
+	require.NoError(t, err)
+	compressed, err := gzipBytes(raw)
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "chain/"+third.String(), compressed, ObjectMeta{ContentEncoding: "gzip"}))
+	data, err = backend.GetContent(ctx, third)
+	require.NoError(t, err)
+	require.Equal(t, raw, data)
+
+	_, err = backend.GetContent(ctx, cid.NewCidV1(cid.DagJSON, testMultihashes(1)[0]))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	_, err = store.Head(ctx, "chain/missing")
+	require.ErrorIs(t, err, ErrObjectNotFound)
+
+	require.NoError(t, backend.DeleteBlock(ctx, first))
+	_, _, err = backend.GetContentStream(ctx, first)
+	require.ErrorIs(t, err, ErrContentNotFound)
+
+	// the BlockManager operations require an ObjectLister
+	unlisted := NewObjectStoreBackend(struct{ ObjectStore }{store}, "/indexer/ingest/mainnet", cfg.PublisherKey)
+	require.Error(t, unlisted.DeleteBlock(ctx, first))
+}
+
+func TestObjectStoreBackendUnavailable(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	store := failingObjectStore{err: errors.New("connection refused")}
+	backend := NewObjectStoreBackend(store, "/indexer/ingest/mainnet", cfg.PublisherKey)
+
+	_, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(1)...))
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	_, err = backend.GetHead(ctx)
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+}
+
+type failingObjectStore struct {
+	err error
+}
+
+func (f failingObjectStore) Put(context.Context, string, []byte, ObjectMeta) error { return f.err }
+
+func (f failingObjectStore) PutIfVersion(context.Context, string, []byte, ObjectMeta, string) (string, error) {
+	return "", f.err
+}
+
+func (f failingObjectStore) Get(context.Context, string) (io.ReadCloser, ObjectMeta, error) {
+	return nil, ObjectMeta{}, f.err
+}
+
+func (f failingObjectStore) Head(context.Context, string) (ObjectMeta, error) {
+	return ObjectMeta{}, f.err
+}
@@ -2,16 +2,20 @@ package herald
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
@@ -19,20 +23,27 @@ import (
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
 )
 
 var _ ChainWriter = &S3Backend{}
+var _ BlockManager = &S3Backend{}
+var _ ChainReader = &S3Backend{}
+var _ ContentStreamer = &S3Backend{}
 
 // S3Backend is an IPNI publishing backend storing the IPNI chain in S3, in a form that can directly be exposed publicly
-// through HTTP. As such, it doesn't need an additional publisher.
+// through HTTP. As such, it doesn't need an additional publisher. It's also a ChainReader, to verify or export the
+// chain, or to serve it with a publisher anyway.
 //
 // Note on the implementation: the idea is to "pre-render" the chain into the expected format described by
 // https://github.com/ipni/specs/blob/main/IPNI_HTTP_PROVIDER.md. Yet we still need an ipld.LinkSystem to process the
 // IPLD nodes into blocks. To do so, we attach a StorageWriteOpener function that will push the block to S3 in the correct
 // manner.
 type S3Backend struct {
-	locker sync.RWMutex // atomicity over the chain head
-	head   cid.Cid      // cache the head CID
+	locker      sync.RWMutex // atomicity over the chain head
+	head        cid.Cid      // cache the head CID
+	headVersion string       // version of the cached head, ETag of the head object or from headStore; empty if none
+	notif       headNotifier
 
 	client   *s3.Client
 	uploader *manager.Uploader
@@ -43,16 +54,217 @@ type S3Backend struct {
 	topic string
 	// providerKey is the keypair of the IPNI publisher
 	providerKey crypto.PrivKey
+
+	// prefix is the prefix of the keys of the blocks, and headKey the key of the signed head
+	prefix  string
+	headKey string
+	// blockCacheControl and headCacheControl are the Cache-Control headers set on the objects
+	blockCacheControl string
+	headCacheControl  string
+	// acl is the canned ACL set on the objects, if not empty
+	acl types.ObjectCannedACL
+	// gzip stores the blocks compressed, with Content-Encoding: gzip
+	gzip bool
+
+	// uploads are the block uploads in flight, nil if the uploads are synchronous
+	uploads           *s3Uploads
+	uploadConcurrency int
+	partSize          int64
+
+	// conditionalHead makes the head updates compare-and-swap, retried conflictRetries times on conflict
+	conditionalHead bool
+	conflictRetries int
+	// headStore is the reference store of the head if not nil, the head object being only a mirror
+	headStore HeadStore
+
+	// blocks caches the blocks recently written, for the reads; nil if disabled
+	blocks         *lru.Cache[cid.Cid, []byte]
+	blockCacheSize int
+
+	// retry is the policy applied to failed S3 requests
+	retry RetryPolicy
+	// metrics records the per-request S3 metrics and the head updates, if not nil
+	metrics *Metrics
+	logger  *zap.SugaredLogger
+}
+
+// S3BackendOption is an optional configuration for the S3Backend.
+type S3BackendOption func(*S3Backend)
+
+// WithS3RetryPolicy sets the RetryPolicy applied to failed S3 requests.
+// This comes in addition to the retries already performed by the AWS SDK.
+func WithS3RetryPolicy(policy RetryPolicy) S3BackendOption {
+	return func(s *S3Backend) {
+		s.retry = policy
+	}
+}
+
+// WithS3Metrics records per-request S3 metrics (request counts, retries, throttles, latency by API operation), as well
+// as the head updates.
+func WithS3Metrics(metrics *Metrics) S3BackendOption {
+	return func(s *S3Backend) {
+		s.metrics = metrics
+	}
+}
+
+// WithS3Logger sets the logger of the backend, instead of the default one.
+func WithS3Logger(l *zap.SugaredLogger) S3BackendOption {
+	return func(s *S3Backend) {
+		s.logger = l
+	}
+}
+
+// WithS3Client uses the given client instead of creating one from the aws.Config given to NewS3Backend.
+func WithS3Client(client *s3.Client) S3BackendOption {
+	return func(s *S3Backend) {
+		s.client = client
+	}
+}
+
+// WithS3Prefix sets the prefix of the keys of the objects. The blocks are stored at prefix+CID, and the head at
+// prefix+"head" unless set with WithS3HeadKey. Defaults to DefaultS3Prefix.
+func WithS3Prefix(prefix string) S3BackendOption {
+	return func(s *S3Backend) {
+		s.prefix = prefix
+	}
+}
+
+// WithS3HeadKey sets the key of the signed head of the chain.
+func WithS3HeadKey(key string) S3BackendOption {
+	return func(s *S3Backend) {
+		s.headKey = key
+	}
 }
 
-func NewS3Backend(awsConfig aws.Config, bucket string, topic string, providerKey crypto.PrivKey) *S3Backend {
+// WithS3CacheControl sets the Cache-Control headers of the blocks and of the head, as served by S3 or a CDN in front
+// of it. The blocks are immutable, while the head must not be cached for long.
+// Defaults to DefaultS3BlockCacheControl and DefaultS3HeadCacheControl.
+func WithS3CacheControl(block, head string) S3BackendOption {
+	return func(s *S3Backend) {
+		s.blockCacheControl = block
+		s.headCacheControl = head
+	}
+}
+
+// WithS3ACL sets the canned ACL of the objects, for example types.ObjectCannedACLPublicRead to serve the chain
+// directly from a bucket without policy. By default, no ACL is set and the bucket defaults apply.
+func WithS3ACL(acl types.ObjectCannedACL) S3BackendOption {
+	return func(s *S3Backend) {
+		s.acl = acl
+	}
+}
+
+// WithS3ConditionalHead makes the head updates compare-and-swap with S3 conditional writes, so that multiple herald
+// instances can safely share the same bucket: the head object is only replaced if it didn't change since it was read
+// (If-Match), or created if it doesn't exist yet (If-None-Match). On conflict, the new head is read and the update
+// function is run again on top of it, up to retries times, before returning ErrHeadConflict.
+//
+// Without it, the last writer wins. The S3-compatible store must support conditional writes.
+func WithS3ConditionalHead(retries int) S3BackendOption {
+	return func(s *S3Backend) {
+		s.conditionalHead = true
+		s.conflictRetries = retries
+	}
+}
+
+// HeadStore is an external store of the head of the chain of an S3Backend, with compare-and-swap semantics, for
+// example a DynamoDB table.
+type HeadStore interface {
+	// GetHead returns the head and its opaque version, or cid.Undef and an empty version if there is none.
+	GetHead(ctx context.Context) (head cid.Cid, version string, err error)
+	// SetHead replaces the head if its version is still prevVersion, empty if there is no head yet, and returns the
+	// new version. Returns ErrHeadConflict otherwise.
+	SetHead(ctx context.Context, head cid.Cid, prevVersion string) (version string, err error)
+}
+
+// WithS3HeadStore keeps the reference head of the chain in store instead of the head object, for strongly consistent
+// compare-and-swap updates shared by multiple herald instances. The blocks stay in S3, and the signed head object is
+// still written after every update, as a mirror for the HTTP chain layout. On conflict, the update function is run
+// again on the new head, up to retries times, before returning ErrHeadConflict.
+func WithS3HeadStore(store HeadStore, retries int) S3BackendOption {
+	return func(s *S3Backend) {
+		s.headStore = store
+		s.conflictRetries = retries
+	}
+}
+
+// WithS3Signer signs the head with signer instead of the private key given to NewS3Backend, which can then be nil.
+func WithS3Signer(signer Signer) S3BackendOption {
+	return func(s *S3Backend) {
+		s.providerKey = signerPrivKey(signer)
+	}
+}
+
+// DefaultS3BlockCacheSize is the default number of blocks recently written kept in memory for the reads.
+const DefaultS3BlockCacheSize = 32
+
+// WithS3BlockCache sets the number of blocks recently written kept in memory, to serve the reads of the fresh part of
+// the chain, the most requested by the indexers, without S3 requests. Zero disables the cache.
+// Defaults to DefaultS3BlockCacheSize.
+func WithS3BlockCache(size int) S3BackendOption {
+	return func(s *S3Backend) {
+		s.blockCacheSize = size
+	}
+}
+
+// WithS3Gzip stores the blocks gzipped, with Content-Encoding: gzip, for S3 or CloudFront to serve them compressed.
+// The entry chunks compress well, which saves storage and bandwidth to the indexers, which decompress them as any
+// HTTP client. The blocks are decompressed on the read path of the backend.
+func WithS3Gzip() S3BackendOption {
+	return func(s *S3Backend) {
+		s.gzip = true
+	}
+}
+
+const (
+	// DefaultS3Prefix is the default prefix of the keys of the objects
+	DefaultS3Prefix = "/ipni/v1/ad/"
+	// DefaultS3BlockCacheControl is the default Cache-Control of the blocks, which are immutable
+	DefaultS3BlockCacheControl = "public, max-age=29030400, immutable"
+	// DefaultS3HeadCacheControl is the default Cache-Control of the head
+	DefaultS3HeadCacheControl = "no-cache, no-store, must-revalidate"
+)
+
+func NewS3Backend(awsConfig aws.Config, bucket string, topic string, providerKey crypto.PrivKey, opts ...S3BackendOption) *S3Backend {
 	s := &S3Backend{
-		client:      s3.NewFromConfig(awsConfig),
-		bucket:      aws.String(bucket),
-		topic:       topic,
-		providerKey: providerKey,
+		bucket:            aws.String(bucket),
+		topic:             topic,
+		providerKey:       providerKey,
+		prefix:            DefaultS3Prefix,
+		blockCacheControl: DefaultS3BlockCacheControl,
+		headCacheControl:  DefaultS3HeadCacheControl,
+		uploadConcurrency: DefaultS3UploadConcurrency,
+		blockCacheSize:    DefaultS3BlockCacheSize,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.logger = orDefaultLogger(s.logger)
+	s.retry = s.retry.withLogger(s.logger)
+	if s.headKey == "" {
+		s.headKey = s.prefix + "head"
+	}
+	withMetrics := func(o *s3.Options) {
+		if s.metrics != nil {
+			o.APIOptions = append(o.APIOptions, s3MetricsMiddleware(s.metrics))
+		}
+	}
+	if s.client != nil {
+		s.client = s3.New(s.client.Options(), withMetrics)
+	} else {
+		s.client = s3.NewFromConfig(awsConfig, withMetrics)
+	}
+	s.uploader = manager.NewUploader(s.client, func(u *manager.Uploader) {
+		if s.partSize > 0 {
+			u.PartSize = s.partSize
+		}
+	})
+	if s.uploadConcurrency > 1 {
+		s.uploads = &s3Uploads{slots: make(chan struct{}, s.uploadConcurrency)}
+	}
+	if s.blockCacheSize > 0 {
+		s.blocks, _ = lru.New[cid.Cid, []byte](s.blockCacheSize)
 	}
-	s.uploader = manager.NewUploader(s.client)
 	s.ls = cidlink.DefaultLinkSystem()
 	s.ls.StorageWriteOpener = s.storageWriteOpener
 	return s
@@ -70,40 +282,49 @@ func (s *S3Backend) storageWriteOpener(linkCtx linking.LinkContext) (io.Writer,
 		// that we don't actually have the file at the right S3 key matching the encoding used by the client.
 		// However, go-libipni simply use cid.String(), which default to base32 for cidv1.
 		// There is no reason to do anything else client side, so that should be robust.
-		key := fmt.Sprintf("/ipni/v1/ad/%s", c.String())
-
-		var contentType string
-		switch c.Prefix().Codec {
-		case cid.DagJSON:
-			contentType = "application/json"
-		case cid.DagCBOR:
-			contentType = "application/cbor"
-		default:
-			return fmt.Errorf("unknown block codec, cid %s, coded %v", c.String(), c.Prefix().Codec)
-		}
-
-		// TODO: pre-gzip the body and set the correct HTTP header to save space, assuming that the remote indexer can ingest gzipped
-
-		// Even though PutObjectInput ask for an io.Reader for the body, an
-		// io.ReadSeeker is required. This is why we use the uploader, that
-		// will manager that complexity.
-		_, err := s.uploader.Upload(linkCtx.Ctx, &s3.PutObjectInput{
-			Bucket:       s.bucket,
-			Key:          aws.String(key),
-			Body:         buf,
-			ContentType:  aws.String(contentType),
-			CacheControl: aws.String("public, max-age=29030400, immutable"),
-		})
-		return err
+		key := s.prefix + c.String()
+
+		contentType, err := blockContentType(c)
+		if err != nil {
+			return err
+		}
+
+		// the buffer goes back to the pool, while the upload can happen in the background
+		data := bytes.Clone(buf.Bytes())
+		if s.blocks != nil {
+			s.blocks.Add(c, data)
+		}
+		upload := s3Upload{key: key, data: data, contentType: contentType}
+		if s.gzip {
+			compressed, err := gzipBytes(data)
+			if err != nil {
+				return err
+			}
+			upload.data, upload.contentEncoding = compressed, "gzip"
+		}
+		return s.enqueue(linkCtx.Ctx, upload)
 	}, nil
 }
 
+// UpdateHead perform an atomic update of the IPNI chain head.
+// With WithS3ConditionalHead, fn is run again on the new head when another writer changed it concurrently.
 func (s *S3Backend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
 	s.locker.Lock()
 	defer s.locker.Unlock()
 
+	for attempt := 1; ; attempt++ {
+		err := s.updateHead(ctx, fn)
+		if !errors.Is(err, ErrHeadConflict) || attempt > s.conflictRetries {
+			return err
+		}
+		s.logger.Warnw("conflicting head update, retrying on top of the new head", "attempt", attempt, "err", err)
+	}
+}
+
+func (s *S3Backend) updateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
 	prevHead, err := s.getHead(ctx)
 	if err != nil {
+		s.metrics.recordHeadUpdate(err)
 		return err
 	}
 
@@ -112,7 +333,16 @@ func (s *S3Backend) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (c
 		return err
 	}
 
-	return s.setHead(ctx, newHead)
+	// make sure that all the blocks are uploaded before exposing them through the head
+	err = s.Flush(ctx)
+	if err != nil {
+		err = backendUnavailable(err)
+	}
+	if err == nil {
+		err = s.setHead(ctx, newHead)
+	}
+	s.metrics.recordHeadUpdate(err)
+	return err
 }
 
 func (s *S3Backend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error) {
@@ -123,60 +353,360 @@ func (s *S3Backend) getHead(ctx context.Context) (cid.Cid, error) {
 	if s.head != cid.Undef {
 		return s.head, nil
 	}
+	if s.headStore != nil {
+		var head cid.Cid
+		var version string
+		err := s.retry.Do(ctx, func(ctx context.Context) error {
+			var err error
+			head, version, err = s.headStore.GetHead(ctx)
+			return err
+		})
+		if err != nil {
+			return cid.Undef, backendUnavailable(err)
+		}
+		s.head, s.headVersion = head, version
+		return s.head, nil
+	}
 
-	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: s.bucket,
-		Key:    aws.String("/ipni/v1/ad/head"),
-	})
+	var out *s3.GetObjectOutput
 	var noSuchKey *types.NoSuchKey
-	if errors.As(err, &noSuchKey) {
+	err := s.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		out, err = s.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: s.bucket,
+			Key:    aws.String(s.headKey),
+		})
+		if errors.As(err, &noSuchKey) {
+			// not an error, no need to retry
+			return nil
+		}
+		return err
+	})
+	if noSuchKey != nil {
+		s.headVersion = ""
 		return cid.Undef, nil
 	}
 	if err != nil {
+		return cid.Undef, backendUnavailable(err)
+	}
+	defer out.Body.Close()
+
+	stored, err := decodeSignedHead(out.Body)
+	if err != nil {
+		s.logger.Errorw("failed to decode stored head as SignedHead", "err", err)
 		return cid.Undef, err
 	}
 
-	decoded, err := head.Decode(out.Body)
+	s.head, s.headVersion = stored, aws.ToString(out.ETag)
+	return s.head, nil
+}
+
+// decodeSignedHead decodes the signed head object of the chain layout of the HTTP publishers.
+func decodeSignedHead(r io.Reader) (cid.Cid, error) {
+	decoded, err := head.Decode(r)
 	if err != nil {
-		logger.Errorw("failed to decode stored head as SignedHead", "err", err)
 		return cid.Undef, err
 	}
 	linkCid, ok := decoded.Head.(cidlink.Link)
 	if !ok {
-		logger.Errorw("unknown SignedHead type", "err", err)
-		return cid.Undef, err
+		return cid.Undef, fmt.Errorf("unknown SignedHead link type %T", decoded.Head)
 	}
-
-	s.head = linkCid.Cid
-	return s.head, nil
+	return linkCid.Cid, nil
 }
 
-func (s *S3Backend) setHead(ctx context.Context, newHead cid.Cid) error {
+// encodeSignedHead returns the signed head object of the chain layout of the HTTP publishers.
+func encodeSignedHead(newHead cid.Cid, topic string, providerKey crypto.PrivKey) ([]byte, error) {
 	if !newHead.Defined() {
 		// sanity check
-		return fmt.Errorf("trying to set an undefined chain head")
+		return nil, fmt.Errorf("trying to set an undefined chain head")
 	}
-
-	signedHead, err := head.NewSignedHead(newHead, s.topic, s.providerKey)
+	signedHead, err := head.NewSignedHead(newHead, topic, providerKey)
 	if err != nil {
-		return fmt.Errorf("failed to generate signed head message")
+		return nil, fmt.Errorf("failed to generate signed head message: %w", err)
 	}
 	encoded, err := signedHead.Encode()
 	if err != nil {
-		return fmt.Errorf("failed to encode signed head message")
+		return nil, fmt.Errorf("failed to encode signed head message: %w", err)
+	}
+	return encoded, nil
+}
+
+// resetHead drops the cached head, for the next update to read it again.
+func (s *S3Backend) resetHead() {
+	s.head, s.headVersion = cid.Undef, ""
+}
+
+// isS3PreconditionFailed returns true if err is the rejection of a conditional write.
+func isS3PreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *S3Backend) setHead(ctx context.Context, newHead cid.Cid) error {
+	encoded, err := encodeSignedHead(newHead, s.topic, s.providerKey)
+	if err != nil {
+		return err
 	}
 
-	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+	if s.headStore != nil {
+		return s.setStoredHead(ctx, newHead, encoded)
+	}
+
+	var version string
+	if s.conditionalHead {
+		version = s.headVersion
+	}
+	etag, err := s.putHeadObject(ctx, encoded, s.conditionalHead, version)
+	if errors.Is(err, ErrHeadConflict) {
+		s.resetHead()
+		s.logger.Warnw("the head was changed by another writer", "newHead", newHead)
+		return fmt.Errorf("%w: conditional write of %s rejected", ErrHeadConflict, newHead)
+	}
+	if err != nil {
+		return backendUnavailable(err)
+	}
+
+	s.head, s.headVersion = newHead, etag
+	s.notif.notify(newHead)
+	return nil
+}
+
+// setStoredHead updates the head in the HeadStore, then mirrors the signed head to the head object.
+func (s *S3Backend) setStoredHead(ctx context.Context, newHead cid.Cid, encoded []byte) error {
+	var version string
+	var conflict error
+	err := s.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		version, err = s.headStore.SetHead(ctx, newHead, s.headVersion)
+		if errors.Is(err, ErrHeadConflict) {
+			// not an error to retry as is, the head must be read again
+			conflict = err
+			return nil
+		}
+		return err
+	})
+	if conflict != nil {
+		s.resetHead()
+		s.logger.Warnw("the head was changed by another writer", "newHead", newHead)
+		return conflict
+	}
+	if err != nil {
+		return backendUnavailable(err)
+	}
+
+	s.head, s.headVersion = newHead, version
+	s.notif.notify(newHead)
+
+	// the head is already updated: a failed mirror is written again on the next update
+	if _, err := s.putHeadObject(ctx, encoded, false, ""); err != nil {
+		s.logger.Errorw("failed to mirror the head to S3", "head", newHead, "err", err)
+	}
+	return nil
+}
+
+// putHeadObject writes the signed head object, and returns its ETag. If conditional, the object is only replaced if
+// its ETag is still version, or created if version is empty; ErrHeadConflict is returned otherwise.
+func (s *S3Backend) putHeadObject(ctx context.Context, encoded []byte, conditional bool, version string) (string, error) {
+	input := &s3.PutObjectInput{
 		Bucket:       s.bucket,
-		Key:          aws.String("/ipni/v1/ad/head"),
-		Body:         bytes.NewReader(encoded),
+		Key:          aws.String(s.headKey),
 		ContentType:  aws.String("application/json"),
-		CacheControl: aws.String("no-cache, no-store, must-revalidate"),
+		CacheControl: aws.String(s.headCacheControl),
+		ACL:          s.acl,
+	}
+	if conditional {
+		if version != "" {
+			input.IfMatch = aws.String(version)
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+	}
+
+	var out *s3.PutObjectOutput
+	var conflict bool
+	err := s.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		input.Body = bytes.NewReader(encoded)
+		out, err = s.client.PutObject(ctx, input)
+		if isS3PreconditionFailed(err) {
+			// not an error to retry as is, the head must be read again
+			conflict = true
+			return nil
+		}
+		return err
 	})
+	if conflict {
+		return "", ErrHeadConflict
+	}
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// GetHead return the cid of the IPNI chain head, as last written or read by this backend.
+// Returns cid.Undef if the chain hasn't started yet.
+func (s *S3Backend) GetHead(ctx context.Context) (cid.Cid, error) {
+	s.locker.RLock()
+	head := s.head
+	s.locker.RUnlock()
+	if head.Defined() {
+		return head, nil
+	}
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.getHead(ctx)
+}
+
+// GetContent returns the raw content of an IPLD block of the IPNI chain, from the cache of the blocks recently
+// written, or from S3.
+// Returns ErrContentNotFound if not found.
+func (s *S3Backend) GetContent(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if s.blocks != nil {
+		if data, ok := s.blocks.Get(c); ok {
+			return data, nil
+		}
+	}
+	body, _, err := s.GetContentStream(ctx, c)
 	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// GetContentStream returns the raw content of an IPLD block of the IPNI chain, from the cache of the blocks recently
+// written, or streamed from S3.
+// Returns ErrContentNotFound if not found.
+func (s *S3Backend) GetContentStream(ctx context.Context, c cid.Cid) (io.ReadCloser, int64, error) {
+	if s.blocks != nil {
+		if data, ok := s.blocks.Get(c); ok {
+			return newBytesStream(data)
+		}
+	}
+	var out *s3.GetObjectOutput
+	var noSuchKey *types.NoSuchKey
+	err := s.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		out, err = s.client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: s.bucket,
+			Key:    aws.String(s.prefix + c.String()),
+		})
+		if errors.As(err, &noSuchKey) {
+			// not an error, no need to retry
+			return nil
+		}
 		return err
+	})
+	if noSuchKey != nil {
+		return nil, 0, ErrContentNotFound
+	}
+	if err != nil {
+		return nil, 0, backendUnavailable(err)
+	}
+	if aws.ToString(out.ContentEncoding) == "gzip" {
+		// the decompressed size is unknown
+		body, err := newGzipStream(out.Body)
+		if err != nil {
+			_ = out.Body.Close()
+			return nil, 0, backendUnavailable(err)
+		}
+		return body, -1, nil
 	}
+	size := int64(-1)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+	return out.Body, size, nil
+}
+
+// gzipBytes returns data gzipped.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	s.head = newHead
+// gzipStream decompresses a gzipped body, closing the body on Close.
+type gzipStream struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func newGzipStream(body io.ReadCloser) (*gzipStream, error) {
+	r, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipStream{Reader: r, body: body}, nil
+}
+
+func (g *gzipStream) Close() error {
+	return errors.Join(g.Reader.Close(), g.body.Close())
+}
+
+// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+// If the receiver falls behind, the oldest heads are dropped so that the latest is always delivered.
+func (s *S3Backend) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	return s.notif.subscribe(ctx), nil
+}
+
+// ForEachBlock calls fn for the CID of every block stored in the backend, in no particular order.
+// Iteration stops at the first error returned by fn.
+func (s *S3Backend) ForEachBlock(ctx context.Context, fn func(c cid.Cid) error) error {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: s.bucket,
+		Prefix: aws.String(s.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, obj := range page.Contents {
+			c, err := cid.Decode(strings.TrimPrefix(aws.ToString(obj.Key), s.prefix))
+			if err != nil {
+				// head, or unrelated object
+				continue
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+// DeleteBlock removes a block from the backend. Deleting a missing block is not an error.
+func (s *S3Backend) DeleteBlock(ctx context.Context, c cid.Cid) error {
+	// don't race with an upload of the same block
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+	if s.blocks != nil {
+		s.blocks.Remove(c)
+	}
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: s.bucket,
+			Key:    aws.String(s.prefix + c.String()),
+		})
+		return err
+	})
+}
@@ -0,0 +1,119 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ipfs/go-cid"
+)
+
+var _ HeadStore = &DynamoDBHeadStore{}
+
+// DynamoDBAPI is the subset of the DynamoDB client used by DynamoDBHeadStore.
+type DynamoDBAPI interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+const (
+	// DynamoDBHeadKeyAttribute is the name of the string partition key of the DynamoDB head table.
+	DynamoDBHeadKeyAttribute = "id"
+
+	dynamoDBHeadAttribute    = "head"
+	dynamoDBVersionAttribute = "version"
+)
+
+// DynamoDBHeadStore is a HeadStore keeping the head in a DynamoDB table, with strongly consistent reads and
+// conditional writes on a version number.
+type DynamoDBHeadStore struct {
+	client DynamoDBAPI
+	table  *string
+	key    string
+}
+
+// NewDynamoDBHeadStore creates a DynamoDBHeadStore using the item identified by key in table. The table must have a
+// string partition key named DynamoDBHeadKeyAttribute, and can hold the heads of multiple chains.
+func NewDynamoDBHeadStore(client DynamoDBAPI, table string, key string) *DynamoDBHeadStore {
+	return &DynamoDBHeadStore{client: client, table: aws.String(table), key: key}
+}
+
+func (d *DynamoDBHeadStore) itemKey() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		DynamoDBHeadKeyAttribute: &types.AttributeValueMemberS{Value: d.key},
+	}
+}
+
+// GetHead returns the head and its version, or cid.Undef and an empty version if there is none.
+func (d *DynamoDBHeadStore) GetHead(ctx context.Context) (cid.Cid, string, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      d.table,
+		Key:            d.itemKey(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return cid.Undef, "", err
+	}
+	if len(out.Item) == 0 {
+		return cid.Undef, "", nil
+	}
+
+	headAttr, ok := out.Item[dynamoDBHeadAttribute].(*types.AttributeValueMemberS)
+	if !ok {
+		return cid.Undef, "", fmt.Errorf("invalid head item %q: no head", d.key)
+	}
+	versionAttr, ok := out.Item[dynamoDBVersionAttribute].(*types.AttributeValueMemberN)
+	if !ok {
+		return cid.Undef, "", fmt.Errorf("invalid head item %q: no version", d.key)
+	}
+	head, err := cid.Decode(headAttr.Value)
+	if err != nil {
+		return cid.Undef, "", fmt.Errorf("invalid head item %q: %w", d.key, err)
+	}
+	return head, versionAttr.Value, nil
+}
+
+// SetHead replaces the head if its version is still prevVersion, and returns the new version.
+// Returns ErrHeadConflict otherwise.
+func (d *DynamoDBHeadStore) SetHead(ctx context.Context, head cid.Cid, prevVersion string) (string, error) {
+	var version uint64 = 1
+	if prevVersion != "" {
+		prev, err := strconv.ParseUint(prevVersion, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid head version %q: %w", prevVersion, err)
+		}
+		version = prev + 1
+	}
+
+	item := d.itemKey()
+	item[dynamoDBHeadAttribute] = &types.AttributeValueMemberS{Value: head.String()}
+	item[dynamoDBVersionAttribute] = &types.AttributeValueMemberN{Value: strconv.FormatUint(version, 10)}
+	input := &dynamodb.PutItemInput{
+		TableName: d.table,
+		Item:      item,
+	}
+	if prevVersion == "" {
+		input.ConditionExpression = aws.String("attribute_not_exists(#id)")
+		input.ExpressionAttributeNames = map[string]string{"#id": DynamoDBHeadKeyAttribute}
+	} else {
+		input.ConditionExpression = aws.String("#version = :version")
+		input.ExpressionAttributeNames = map[string]string{"#version": dynamoDBVersionAttribute}
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberN{Value: prevVersion},
+		}
+	}
+
+	_, err := d.client.PutItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return "", fmt.Errorf("%w: head version %q is outdated", ErrHeadConflict, prevVersion)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(version, 10), nil
+}
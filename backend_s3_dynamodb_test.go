@@ -0,0 +1,106 @@
+package herald
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDynamoDB is an in-memory DynamoDB table, evaluating only the conditions used by DynamoDBHeadStore.
+type fakeDynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]types.AttributeValue
+}
+
+func (f *fakeDynamoDB) GetItem(_ context.Context, params *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := params.Key[DynamoDBHeadKeyAttribute].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.items[key]}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(_ context.Context, params *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := params.Item[DynamoDBHeadKeyAttribute].(*types.AttributeValueMemberS).Value
+	current, exists := f.items[key]
+	expected, conditional := params.ExpressionAttributeValues[":version"].(*types.AttributeValueMemberN)
+	switch {
+	case conditional && (!exists || current[dynamoDBVersionAttribute].(*types.AttributeValueMemberN).Value != expected.Value):
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("version mismatch")}
+	case !conditional && exists:
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("already exists")}
+	}
+	f.items[key] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestS3BackendDynamoDBHeadStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake, client := newFakeS3(t)
+	table := &fakeDynamoDB{items: make(map[string]map[string]types.AttributeValue)}
+
+	newBackend := func(retries int) *S3Backend {
+		return NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+			WithS3Client(client), WithS3Prefix("chain/"),
+			WithS3HeadStore(NewDynamoDBHeadStore(table, "heads", "mainnet"), retries))
+	}
+	a, b := newBackend(2), newBackend(0)
+
+	first, err := PublishRawMHs(ctx, cfg, a, CatalogFromMultihashes(testMultihashes(1)...))
+	require.NoError(t, err)
+	second, err := PublishRawMHs(ctx, cfg, b, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	// a is retried on top of the second head
+	third, err := PublishRawMHs(ctx, cfg, a, CatalogFromMultihashes(testMultihashes(3)...))
+	require.NoError(t, err)
+	// b doesn't retry
+	_, err = PublishRawMHs(ctx, cfg, b, CatalogFromMultihashes(testMultihashes(4)...))
+	require.ErrorIs(t, err, ErrHeadConflict)
+
+	stored, version, err := NewDynamoDBHeadStore(table, "heads", "mainnet").GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, third, stored)
+	require.Equal(t, "3", version)
+
+	// the head object is mirrored for the HTTP chain layout
+	reader := NewHttpChainReader(fake.url+"chain", cfg.PublisherID)
+	var ads []cid.Cid
+	err = WalkAds(ctx, reader, func(adCid cid.Cid, _ schema.Advertisement) error {
+		ads = append(ads, adCid)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{third, second, first}, ads)
+
+	// once the head store is updated, a failure to mirror the head object is not a failed update
+	mirrorFails := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"),
+		WithS3HeadStore(failingMirrorHeadStore{HeadStore: NewDynamoDBHeadStore(table, "heads", "mainnet"), fake: fake}, 0))
+	fourth, err := PublishRawMHs(ctx, cfg, mirrorFails, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	fake.setFailPuts(false)
+	stored, _, err = NewDynamoDBHeadStore(table, "heads", "mainnet").GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, fourth, stored)
+}
+
+// failingMirrorHeadStore makes the S3 uploads fail once the head is set, which fails the mirror of the head object.
+type failingMirrorHeadStore struct {
+	HeadStore
+	fake *fakeS3
+}
+
+func (s failingMirrorHeadStore) SetHead(ctx context.Context, head cid.Cid, prevVersion string) (string, error) {
+	version, err := s.HeadStore.SetHead(ctx, head, prevVersion)
+	s.fake.setFailPuts(true)
+	return version, err
+}
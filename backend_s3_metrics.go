@@ -0,0 +1,49 @@
+package herald
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go/middleware"
+)
+
+var s3Throttles = retry.IsErrorThrottles(retry.DefaultThrottles)
+
+// s3MetricsMiddleware returns an AWS SDK middleware recording per-operation metrics.
+// It is attached at the initialize step, and as such observes the whole operation, including the SDK retries.
+func s3MetricsMiddleware(m *Metrics) func(stack *middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("HeraldMetrics",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				m.recordS3Request(awsmiddleware.GetOperationName(ctx), time.Since(start), metadata, err)
+				return out, metadata, err
+			}), middleware.After)
+	}
+}
+
+func (m *Metrics) recordS3Request(operation string, duration time.Duration, metadata middleware.Metadata, err error) {
+	if m == nil {
+		return
+	}
+
+	m.s3Requests.WithLabelValues(operation, resultLabel(err)).Inc()
+	m.s3Latency.WithLabelValues(operation).Observe(duration.Seconds())
+
+	attempts, ok := retry.GetAttemptResults(metadata)
+	if !ok {
+		return
+	}
+	if len(attempts.Results) > 1 {
+		m.s3Retries.WithLabelValues(operation).Add(float64(len(attempts.Results) - 1))
+	}
+	for _, attempt := range attempts.Results {
+		if attempt.Err != nil && s3Throttles.IsErrorThrottle(attempt.Err) == aws.TrueTernary {
+			m.s3Throttles.WithLabelValues(operation).Inc()
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package herald
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a minimal in-memory S3, serving a single path-style bucket.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+
+	failPuts bool     // reject all the uploads
+	ranges   []string // Range header of the reads, empty for a whole object
+	url      string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.Client) {
+	f := &fakeS3{objects: make(map[string][]byte), headers: make(map[string]http.Header)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL + "/bucket/"
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return f, client
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodPut:
+		if f.failPuts {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`))
+			return
+		}
+		current, exists := f.objects[key]
+		ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+		if (ifMatch != "" && (!exists || ifMatch != fakeETag(current))) || (ifNoneMatch == "*" && exists) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			_, _ = w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>precondition failed</Message></Error>`))
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+		f.headers[key] = r.Header.Clone()
+		w.Header().Set("ETag", fakeETag(data))
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`))
+			return
+		}
+		f.ranges = append(f.ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", fakeETag(data))
+		for _, h := range []string{"Content-Encoding", "Content-Type", "Cache-Control"} {
+			if v := f.headers[key].Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func fakeETag(data []byte) string {
+	return fmt.Sprintf("\"%x\"", md5.Sum(data))
+}
+
+func (f *fakeS3) setFailPuts(fail bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failPuts = fail
+}
+
+// count returns the number of objects with the given key prefix.
+func (f *fakeS3) count(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeS3) object(key string) ([]byte, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key], f.headers[key]
+}
+
+func TestS3BackendLayout(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake, client := newFakeS3(t)
+
+	backend := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client),
+		WithS3Prefix("chain/"),
+		WithS3HeadKey("chain-head"),
+		WithS3CacheControl("max-age=60", "no-cache"),
+		WithS3ACL(types.ObjectCannedACLPublicRead),
+	)
+
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(3)...))
+	require.NoError(t, err)
+
+	data, headers := fake.object("chain/" + head.String())
+	require.NotEmpty(t, data)
+	require.Equal(t, "max-age=60", headers.Get("Cache-Control"))
+	require.Equal(t, "public-read", headers.Get("X-Amz-Acl"))
+	require.Equal(t, "application/json", headers.Get("Content-Type"))
+
+	data, headers = fake.object("chain-head")
+	require.NotEmpty(t, data)
+	require.Equal(t, "no-cache", headers.Get("Cache-Control"))
+
+	// a new backend reads the head from the same key
+	backend = NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"), WithS3HeadKey("chain-head"))
+	var prevHead cid.Cid
+	err = backend.UpdateHead(ctx, func(h cid.Cid) (cid.Cid, error) {
+		prevHead = h
+		return h, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, head, prevHead)
+
+	require.NoError(t, backend.DeleteBlock(ctx, head))
+	data, _ = fake.object("chain/" + head.String())
+	require.Empty(t, data)
+
+	// the dag-cbor blocks have their own content type
+	cborCfg := cfg
+	cborCfg.Codec = multicodec.DagCbor
+	cborHead, err := PublishRawMHs(ctx, cborCfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	_, headers = fake.object("chain/" + cborHead.String())
+	require.Equal(t, "application/cbor", headers.Get("Content-Type"))
+}
+
+func TestS3BackendReadPath(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake, client := newFakeS3(t)
+	backend := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"))
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	stored, _ := fake.object("chain/" + head.String())
+
+	// the blocks just written are read from the cache
+	reads := len(fake.ranges)
+	data, err := backend.GetContent(ctx, head)
+	require.NoError(t, err)
+	require.Equal(t, stored, data)
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, reads, len(fake.ranges))
+
+	// without cache, from S3
+	reader := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"), WithS3BlockCache(0))
+	readHead, err := reader.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, readHead)
+	data, err = reader.GetContent(ctx, head)
+	require.NoError(t, err)
+	require.Equal(t, stored, data)
+	require.Greater(t, len(fake.ranges), reads)
+
+	_, err = reader.GetContent(ctx, cid.NewCidV1(cid.DagJSON, testMultihashes(1)[0]))
+	require.ErrorIs(t, err, ErrContentNotFound)
+	require.NoError(t, backend.DeleteBlock(ctx, head))
+	_, _, err = backend.GetContentStream(ctx, head)
+	require.ErrorIs(t, err, ErrContentNotFound)
+
+	// an empty chain
+	empty := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("empty/"), WithS3HeadKey("empty/head"))
+	emptyHead, err := empty.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, emptyHead.Defined())
+}
+
+func TestS3BackendConcurrentUploads(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake, client := newFakeS3(t)
+
+	backend := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3UploadConcurrency(4))
+
+	// many entry chunks, uploaded concurrently
+	cfg.AdEntriesChunkSize = 2
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(20)...))
+	require.NoError(t, err)
+
+	// all the blocks are uploaded before the head: 10 entry chunks and the advertisement
+	data, _ := fake.object(DefaultS3Prefix + head.String())
+	require.NotEmpty(t, data)
+	require.Equal(t, 11, fake.count(DefaultS3Prefix)-1)
+
+	// a failed upload blocks the head update until it succeeds
+	fake.setFailPuts(true)
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.Error(t, err)
+	data, _ = fake.object(DefaultS3Prefix + "head")
+	require.Contains(t, string(data), head.String())
+
+	// the uploads are synchronous until the failed ones are flushed
+	require.True(t, backend.uploads.hasFailed())
+
+	// concurrent Flushes are serialized
+	fake.setFailPuts(false)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- backend.Flush(ctx) }()
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.False(t, backend.uploads.hasFailed())
+}
+
+func TestS3BackendConditionalHead(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake, client := newFakeS3(t)
+
+	newBackend := func() *S3Backend {
+		return NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+			WithS3Client(client), WithS3Prefix("chain/"), WithS3ConditionalHead(2))
+	}
+	a, b := newBackend(), newBackend()
+
+	first, err := PublishRawMHs(ctx, cfg, a, CatalogFromMultihashes(testMultihashes(1)...))
+	require.NoError(t, err)
+	second, err := PublishRawMHs(ctx, cfg, b, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	// a still caches the first head: its conditional write is rejected, and retried on top of the second
+	third, err := PublishRawMHs(ctx, cfg, a, CatalogFromMultihashes(testMultihashes(3)...))
+	require.NoError(t, err)
+
+	reader := NewHttpChainReader(fake.url+"chain", cfg.PublisherID)
+	head, err := reader.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, third, head)
+	var ads []cid.Cid
+	err = WalkAds(ctx, reader, func(adCid cid.Cid, _ schema.Advertisement) error {
+		ads = append(ads, adCid)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{third, second, first}, ads)
+
+	// without retries, the conflict is returned
+	noRetry := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"), WithS3ConditionalHead(0))
+	_, err = PublishRawMHs(ctx, cfg, noRetry, CatalogFromMultihashes(testMultihashes(4)...))
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, b, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, noRetry, CatalogFromMultihashes(testMultihashes(6)...))
+	require.ErrorIs(t, err, ErrHeadConflict)
+	_, err = PublishRawMHs(ctx, cfg, noRetry, CatalogFromMultihashes(testMultihashes(6)...))
+	require.NoError(t, err)
+}
+
+func TestS3BackendGzip(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.AdEntriesChunkSize = 500
+	fake, client := newFakeS3(t)
+	backend := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"), WithS3Gzip())
+	head, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(500), id: []byte("foo")})
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	chunk := linkCid(ad.Entries)
+	raw, err := backend.GetContent(ctx, chunk)
+	require.NoError(t, err)
+
+	stored, headers := fake.object("chain/" + chunk.String())
+	require.Equal(t, "gzip", headers.Get("Content-Encoding"))
+	require.Less(t, len(stored), len(raw))
+	gz, err := gzip.NewReader(bytes.NewReader(stored))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, raw, decompressed)
+
+	// read back from S3, decompressed
+	reader := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"), WithS3BlockCache(0))
+	data, err := reader.GetContent(ctx, chunk)
+	require.NoError(t, err)
+	require.Equal(t, raw, data)
+	report, err := VerifyChain(ctx, reader, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+}
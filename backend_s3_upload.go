@@ -0,0 +1,170 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultS3UploadConcurrency is the default number of blocks uploaded concurrently by the S3Backend.
+const DefaultS3UploadConcurrency = 16
+
+// WithS3UploadConcurrency sets the number of blocks uploaded concurrently. Store returns as soon as an upload slot is
+// available, and the uploads are waited for before any update of the head, which is the only ordering the chain
+// requires: the CIDs linking the blocks are computed locally. A value <= 1 makes Store upload synchronously.
+// Defaults to DefaultS3UploadConcurrency.
+func WithS3UploadConcurrency(n int) S3BackendOption {
+	return func(s *S3Backend) {
+		s.uploadConcurrency = n
+	}
+}
+
+// WithS3PartSize sets the size above which a block is uploaded with a multipart upload, in parts of that size.
+// S3 requires at least 5MiB, which is also the default.
+func WithS3PartSize(size int64) S3BackendOption {
+	return func(s *S3Backend) {
+		s.partSize = size
+	}
+}
+
+// s3Upload is a block to upload.
+type s3Upload struct {
+	key         string
+	data        []byte
+	contentType string
+	// contentEncoding is the Content-Encoding of data, if compressed
+	contentEncoding string
+}
+
+// s3Uploads is the pool of the uploads in flight.
+type s3Uploads struct {
+	slots chan struct{} // one per upload in flight
+	flush sync.Mutex    // serializes the Flushes, which take all the slots
+
+	mu sync.Mutex
+	// failed are retried on Flush. While not empty, the uploads are synchronous, which bounds it to the uploads that
+	// were in flight and makes Store fail fast.
+	failed []s3Upload
+}
+
+func (u *s3Uploads) hasFailed() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.failed) > 0
+}
+
+// upload uploads a block, with retries.
+func (s *S3Backend) upload(ctx context.Context, u s3Upload) error {
+	// Even though PutObjectInput ask for an io.Reader for the body, an
+	// io.ReadSeeker is required. This is why we use the uploader, that
+	// will manager that complexity.
+	return s.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket:          s.bucket,
+			Key:             aws.String(u.key),
+			Body:            bytes.NewReader(u.data),
+			ContentType:     aws.String(u.contentType),
+			ContentEncoding: optionalString(u.contentEncoding),
+			CacheControl:    aws.String(s.blockCacheControl),
+			ACL:             s.acl,
+		})
+		return err
+	})
+}
+
+// enqueue uploads the block in the background, waiting for a free slot if needed. The data must not be reused.
+// The upload outlives ctx, which only bounds the wait for a slot.
+func (s *S3Backend) enqueue(ctx context.Context, u s3Upload) error {
+	if s.uploads == nil || s.uploads.hasFailed() {
+		return s.upload(ctx, u)
+	}
+	select {
+	case s.uploads.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	detached := context.WithoutCancel(ctx)
+	go func() {
+		defer func() { <-s.uploads.slots }()
+		if err := s.upload(detached, u); err != nil {
+			s.logger.Errorw("failed to upload block, retrying on flush", "key", u.key, "err", err)
+			s.uploads.mu.Lock()
+			s.uploads.failed = append(s.uploads.failed, u)
+			s.uploads.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// Flush waits for the uploads in flight, and retries the failed ones concurrently. It returns an error if some blocks
+// are still not uploaded, in which case they are retried again on the next Flush.
+func (s *S3Backend) Flush(ctx context.Context) error {
+	if s.uploads == nil {
+		return nil
+	}
+	s.uploads.flush.Lock()
+	defer s.uploads.flush.Unlock()
+
+	// taking all the slots waits for the uploads in flight
+	var taken int
+	defer func() {
+		for ; taken > 0; taken-- {
+			<-s.uploads.slots
+		}
+	}()
+	for taken < cap(s.uploads.slots) {
+		select {
+		case s.uploads.slots <- struct{}{}:
+			taken++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// no upload is in flight anymore: the failed ones can be taken out, and retried with the slots held
+	s.uploads.mu.Lock()
+	failed := s.uploads.failed
+	s.uploads.failed = nil
+	s.uploads.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var remaining []s3Upload
+	var errs []error
+	limit := make(chan struct{}, cap(s.uploads.slots))
+	for _, u := range failed {
+		limit <- struct{}{}
+		wg.Add(1)
+		go func(u s3Upload) {
+			defer func() { <-limit; wg.Done() }()
+			if err := s.upload(ctx, u); err != nil {
+				mu.Lock()
+				remaining = append(remaining, u)
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		s.uploads.mu.Lock()
+		s.uploads.failed = append(s.uploads.failed, remaining...)
+		s.uploads.mu.Unlock()
+		return fmt.Errorf("failed to upload %d blocks: %w", len(remaining), errors.Join(errs...))
+	}
+	return nil
+}
+
+// optionalString returns nil for an empty string, to omit the header.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
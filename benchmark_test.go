@@ -0,0 +1,195 @@
+package herald
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ ChainWriter = &discardBackend{}
+
+// discardBackend encodes the blocks, but doesn't store them. It measures the encoding cost only.
+type discardBackend struct {
+	ls ipld.LinkSystem
+}
+
+func newDiscardBackend() *discardBackend {
+	ls := cidlink.DefaultLinkSystem()
+	ls.StorageWriteOpener = func(linking.LinkContext) (io.Writer, linking.BlockWriteCommitter, error) {
+		return io.Discard, func(ipld.Link) error { return nil }, nil
+	}
+	return &discardBackend{ls: ls}
+}
+
+func (d *discardBackend) UpdateHead(_ context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) error {
+	_, err := fn(cid.Undef)
+	return err
+}
+
+func (d *discardBackend) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (datamodel.Link, error) {
+	return d.ls.Store(lnkCtx, lp, n)
+}
+
+// acceptAllTransport is a mocked S3 accepting every request.
+type acceptAllTransport struct{}
+
+func (acceptAllTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func benchMultihashes(n int) []multihash.Multihash {
+	mhs := make([]multihash.Multihash, 0, n)
+	for i := 0; i < n; i++ {
+		h, _ := multihash.Sum([]byte(strconv.Itoa(i)), multihash.SHA2_256, -1)
+		mhs = append(mhs, h)
+	}
+	return mhs
+}
+
+func BenchmarkGenerateEntries(b *testing.B) {
+	const total = 100_000
+	catalog := CatalogFromMultihashes(benchMultihashes(total)...)
+
+	for _, chunkSize := range []int{1024, 4096, DefaultAdEntriesChunkSize} {
+		b.Run(fmt.Sprintf("chunk=%d", chunkSize), func(b *testing.B) {
+			cfg := ChainConfig{AdEntriesChunkSize: chunkSize}
+			backend := newDiscardBackend()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := generateEntries(context.Background(), cfg, backend, catalog); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(total*b.N)/b.Elapsed().Seconds(), "mh/s")
+		})
+	}
+}
+
+func BenchmarkEntryChunkCodec(b *testing.B) {
+	node, err := schema.EntryChunk{Entries: benchMultihashes(DefaultAdEntriesChunkSize)}.ToNode()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ls := newDiscardBackend().ls
+
+	for _, codec := range []multicodec.Code{multicodec.DagCbor, multicodec.DagJson} {
+		b.Run(codec.String(), func(b *testing.B) {
+			lp := cidlink.LinkPrototype{Prefix: cid.Prefix{
+				Version:  1,
+				Codec:    uint64(codec),
+				MhType:   multihash.SHA2_256,
+				MhLength: -1,
+			}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := ls.Store(ipld.LinkContext{Ctx: context.Background()}, lp, node); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBackendWrite(b *testing.B) {
+	const total = 50_000
+	catalog := CatalogFromMultihashes(benchMultihashes(total)...)
+	cfg := ChainConfig{AdEntriesChunkSize: DefaultAdEntriesChunkSize}
+
+	backends := []struct {
+		name    string
+		backend func() ChainWriter
+	}{
+		{"discard", func() ChainWriter { return newDiscardBackend() }},
+		{"datastore", func() ChainWriter { return NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore())) }},
+		{"datastore-unbatched", func() ChainWriter {
+			return NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()), WithDsBatchSize(0))
+		}},
+		{"s3-mocked", func() ChainWriter {
+			awsCfg := aws.Config{
+				Region:      "us-east-1",
+				Credentials: aws.AnonymousCredentials{},
+				HTTPClient:  &http.Client{Transport: acceptAllTransport{}},
+			}
+			return NewS3Backend(awsCfg, "bucket", "/indexer/ingest/mainnet", nil)
+		}},
+	}
+
+	for _, bb := range backends {
+		b.Run(bb.name, func(b *testing.B) {
+			backend := bb.backend()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := generateEntries(context.Background(), cfg, backend, catalog); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(total*b.N)/b.Elapsed().Seconds(), "mh/s")
+		})
+	}
+}
+
+func BenchmarkBatcherThroughput(b *testing.B) {
+	const catalogSize = 10
+	total := int64(b.N * catalogSize)
+	var published atomic.Int64
+	done := make(chan struct{})
+
+	cfg := BatchConfig{
+		CountThreshold:         100,
+		MaxMHsPerAdvertisement: 10_000,
+		MaxDelay:               10 * time.Millisecond, // flush quickly the last partial batch
+		publishRawMHs: func(ctx context.Context, cfg ChainConfig, backend ChainWriter, catalog Catalog) (cid.Cid, error) {
+			if published.Add(int64(catalog.Count())) == total {
+				close(done)
+			}
+			return cid.Undef, nil
+		},
+	}
+	batcher := StartCatalogBatcher(cfg, ChainConfig{}, NewMemoryBackend(), nilAnnouncer{})
+
+	catalogs := make([]Catalog, b.N)
+	mhs := benchMultihashes(catalogSize)
+	for i := range catalogs {
+		catalogs[i] = CatalogFromMultihashes(mhs...)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, catalog := range catalogs {
+		if err := batcher.PublishCatalog(context.Background(), catalog); err != nil {
+			b.Fatal(err)
+		}
+	}
+	<-done
+	b.ReportMetric(float64(total)/b.Elapsed().Seconds(), "mh/s")
+}
@@ -31,3 +31,20 @@ type MhIterator interface {
 	// Done returns true if there is no more multihash.
 	Done() bool
 }
+
+// FallibleMhIterator is a MhIterator that can fail while iterating, for example when reading from the network.
+// Once the iterator is done, Err tells whether the iteration completed, so that a truncated catalog is not published.
+type FallibleMhIterator interface {
+	MhIterator
+
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+}
+
+// iteratorErr returns the error that stopped the iteration, if the iterator can fail.
+func iteratorErr(iter MhIterator) error {
+	if fallible, ok := iter.(FallibleMhIterator); ok {
+		return fallible.Err()
+	}
+	return nil
+}
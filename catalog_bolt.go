@@ -0,0 +1,232 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/multiformats/go-multihash"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// boltPageSize is the number of multihashes read within a single bolt read transaction.
+// Reading by pages avoids holding a transaction open for the whole (unbounded) iteration.
+const boltPageSize = 4096
+
+// BoltCatalogOption is an optional configuration of a BoltCatalog.
+type BoltCatalogOption func(*BoltCatalog)
+
+// WithBoltPrefix restricts the catalog to the keys starting with prefix.
+func WithBoltPrefix(prefix []byte) BoltCatalogOption {
+	return func(c *BoltCatalog) {
+		c.prefix = prefix
+	}
+}
+
+// WithBoltRange restricts the catalog to the keys in [start, end).
+// A nil start means the beginning of the bucket, a nil end means the end of the bucket.
+func WithBoltRange(start, end []byte) BoltCatalogOption {
+	return func(c *BoltCatalog) {
+		c.start = start
+		c.end = end
+	}
+}
+
+// WithBoltValues makes the catalog interpret the values of the bucket as multihashes, instead of the keys.
+func WithBoltValues() BoltCatalogOption {
+	return func(c *BoltCatalog) {
+		c.values = true
+	}
+}
+
+// WithBoltLogger sets the logger of the catalog, instead of the default one.
+func WithBoltLogger(l *zap.SugaredLogger) BoltCatalogOption {
+	return func(c *BoltCatalog) {
+		c.logger = l
+	}
+}
+
+// CatalogFromBolt creates a catalog iterating over the keys of a bbolt bucket, interpreted as multihashes.
+// Entries that are not valid multihashes, as well as nested buckets, are skipped.
+func CatalogFromBolt(db *bolt.DB, bucket []byte, id []byte, opts ...BoltCatalogOption) *BoltCatalog {
+	c := &BoltCatalog{db: db, bucket: bucket, id: id}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = orDefaultLogger(c.logger)
+	return c
+}
+
+var _ Catalog = &BoltCatalog{}
+
+type BoltCatalog struct {
+	db     *bolt.DB
+	bucket []byte
+	id     []byte
+
+	prefix []byte
+	start  []byte
+	end    []byte
+	values bool
+	logger *zap.SugaredLogger
+}
+
+func (c *BoltCatalog) ID() []byte {
+	return c.id
+}
+
+func (c *BoltCatalog) Count() int {
+	count := 0
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(c.bucket)
+		if b == nil {
+			return fmt.Errorf("bucket %q not found", c.bucket)
+		}
+		// the bucket is scanned to skip the same entries as the iterator does
+		cur := b.Cursor()
+		for k, v := cur.Seek(c.seekStart()); k != nil && c.inRange(k); k, v = cur.Next() {
+			if _, ok := c.rawMultihash(k, v); ok {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return -1
+	}
+	return count
+}
+
+func (c *BoltCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	err := c.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(c.bucket) == nil {
+			return fmt.Errorf("bucket %q not found", c.bucket)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltIterator{catalog: c, ctx: ctx}, nil
+}
+
+// seekStart returns the first key to consider
+func (c *BoltCatalog) seekStart() []byte {
+	if c.start != nil && bytes.Compare(c.start, c.prefix) > 0 {
+		return c.start
+	}
+	return c.prefix
+}
+
+// inRange returns true if the key is within the configured prefix and range.
+func (c *BoltCatalog) inRange(k []byte) bool {
+	if c.prefix != nil && !bytes.HasPrefix(k, c.prefix) {
+		return false
+	}
+	if c.end != nil && bytes.Compare(k, c.end) >= 0 {
+		return false
+	}
+	return true
+}
+
+// multihash extracts the multihash from an entry of the bucket.
+// The returned multihash is a copy, valid outside the transaction.
+func (c *BoltCatalog) multihash(k, v []byte) (multihash.Multihash, bool) {
+	raw, ok := c.rawMultihash(k, v)
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(raw), true
+}
+
+// rawMultihash returns the multihash of an entry of the bucket, only valid within the transaction, and false if the
+// entry is not a valid multihash.
+func (c *BoltCatalog) rawMultihash(k, v []byte) ([]byte, bool) {
+	if v == nil {
+		// nested bucket
+		return nil, false
+	}
+	raw := k
+	if c.values {
+		raw = v
+	}
+	if _, err := multihash.Cast(raw); err != nil {
+		c.logger.Debugw("skipping invalid multihash in bolt bucket", "bucket", string(c.bucket), "err", err)
+		return nil, false
+	}
+	return raw, true
+}
+
+var _ FallibleMhIterator = &BoltIterator{}
+
+type BoltIterator struct {
+	catalog *BoltCatalog
+	ctx     context.Context
+
+	page    []multihash.Multihash
+	lastKey []byte
+	done    bool
+	err     error
+}
+
+func (b *BoltIterator) Next() multihash.Multihash {
+	if b.Done() {
+		panic("iterator already done")
+	}
+	next := b.page[0]
+	b.page = b.page[1:]
+	return next
+}
+
+func (b *BoltIterator) Done() bool {
+	for len(b.page) == 0 && !b.done {
+		if err := b.loadPage(); err != nil {
+			b.done = true
+			b.err = err
+		}
+	}
+	return len(b.page) == 0
+}
+
+func (b *BoltIterator) Err() error {
+	return b.err
+}
+
+// loadPage reads the next page of multihashes, in a new read transaction.
+func (b *BoltIterator) loadPage() error {
+	if err := b.ctx.Err(); err != nil {
+		return err
+	}
+	return b.catalog.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.catalog.bucket)
+		if bucket == nil {
+			return fmt.Errorf("bucket %q not found", b.catalog.bucket)
+		}
+		cur := bucket.Cursor()
+
+		var k, v []byte
+		if b.lastKey == nil {
+			k, v = cur.Seek(b.catalog.seekStart())
+		} else {
+			k, v = cur.Seek(b.lastKey)
+			if k != nil && bytes.Equal(k, b.lastKey) {
+				k, v = cur.Next()
+			}
+		}
+
+		// scanned counts the keys visited, to bound the transaction even when skipping invalid entries
+		for scanned := 0; scanned < boltPageSize; scanned++ {
+			if k == nil || !b.catalog.inRange(k) {
+				b.done = true
+				return nil
+			}
+			b.lastKey = bytes.Clone(k)
+			if mh, ok := b.catalog.multihash(k, v); ok {
+				b.page = append(b.page, mh)
+			}
+			k, v = cur.Next()
+		}
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package herald
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltCatalog(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	mhs := testMultihashes(5000)
+	bucket := []byte("blocks")
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(bucket)
+		if err != nil {
+			return err
+		}
+		for _, mh := range mhs {
+			if err := b.Put(mh, []byte("v")); err != nil {
+				return err
+			}
+		}
+		// not a multihash, should be skipped
+		return b.Put([]byte("garbage"), []byte("v"))
+	})
+	require.NoError(t, err)
+
+	collect := func(cat Catalog) []multihash.Multihash {
+		iter, err := cat.Iterator(context.Background())
+		require.NoError(t, err)
+		var res []multihash.Multihash
+		for !iter.Done() {
+			res = append(res, iter.Next())
+		}
+		return res
+	}
+
+	id := []byte("fooo")
+	cat := CatalogFromBolt(db, bucket, id)
+	require.Equal(t, id, cat.ID())
+	require.ElementsMatch(t, mhs, collect(cat))
+	// the invalid multihash is not counted either
+	require.Equal(t, len(mhs), cat.Count())
+
+	// sha2-256 multihashes all start with 0x12 0x20, and the third byte is the first byte of the digest
+	prefix := []byte{0x12, 0x20, 0x00}
+	cat = CatalogFromBolt(db, bucket, id, WithBoltPrefix(prefix))
+	var expected []multihash.Multihash
+	for _, mh := range mhs {
+		if mh[2] == 0x00 {
+			expected = append(expected, mh)
+		}
+	}
+	require.ElementsMatch(t, expected, collect(cat))
+	require.Equal(t, len(expected), cat.Count())
+
+	_, err = CatalogFromBolt(db, []byte("missing"), id).Iterator(context.Background())
+	require.Error(t, err)
+
+	// a failing page is not mistaken for the end of the catalog
+	ctx, cancel := context.WithCancel(context.Background())
+	iter, err := CatalogFromBolt(db, bucket, id).Iterator(ctx)
+	require.NoError(t, err)
+	require.False(t, iter.Done())
+	cancel()
+	var read int
+	for !iter.Done() {
+		iter.Next()
+		read++
+	}
+	require.Less(t, read, len(mhs))
+	require.ErrorIs(t, iteratorErr(iter), context.Canceled)
+}
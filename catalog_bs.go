@@ -0,0 +1,141 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// BsCatalogOption is an optional configuration of a BsCatalog.
+type BsCatalogOption func(*BsCatalog)
+
+// WithBsContextID sets the ID of the catalog, used as ContextID.
+func WithBsContextID(id []byte) BsCatalogOption {
+	return func(c *BsCatalog) {
+		c.id = id
+	}
+}
+
+// WithBsCount makes Count scan the whole blockstore once, instead of returning -1.
+// This is useful to let the batcher pick the publishing strategy, at the cost of a full scan.
+func WithBsCount() BsCatalogOption {
+	return func(c *BsCatalog) {
+		c.count = true
+	}
+}
+
+// WithBsLogger sets the logger of the catalog, instead of the default one.
+func WithBsLogger(l *zap.SugaredLogger) BsCatalogOption {
+	return func(c *BsCatalog) {
+		c.logger = l
+	}
+}
+
+// CatalogFromBlockstore creates a catalog of all the blocks of a blockstore.
+// Identity multihashes are skipped, as they are not meant to be indexed.
+func CatalogFromBlockstore(bs blockstore.Blockstore, opts ...BsCatalogOption) *BsCatalog {
+	c := &BsCatalog{bs: bs, counted: -1}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = orDefaultLogger(c.logger)
+	return c
+}
+
+var _ Catalog = &BsCatalog{}
+
+type BsCatalog struct {
+	bs blockstore.Blockstore
+	id []byte
+
+	count   bool
+	counted int
+	logger  *zap.SugaredLogger
+}
+
+func (c *BsCatalog) ID() []byte {
+	return c.id
+}
+
+func (c *BsCatalog) Count() int {
+	if !c.count || c.counted >= 0 {
+		return c.counted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.bs.AllKeysChan(ctx)
+	if err != nil {
+		c.logger.Errorw("failed to list the blockstore keys", "err", err)
+		return -1
+	}
+	count := 0
+	for k := range ch {
+		if indexable(k) {
+			count++
+		}
+	}
+	c.counted = count
+	return count
+}
+
+func (c *BsCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	ch, err := c.bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &BsIterator{ctx: ctx, ch: ch}, nil
+}
+
+func indexable(k cid.Cid) bool {
+	return k.Prefix().MhType != multihash.IDENTITY
+}
+
+var _ FallibleMhIterator = &BsIterator{}
+
+// BsIterator iterates lazily over the keys of a blockstore.
+// The iteration stops early if the context given to BsCatalog.Iterator is done, which is reported by Err. The
+// blockstore doesn't report the failures of AllKeysChan, which only closes the channel early.
+type BsIterator struct {
+	ctx  context.Context
+	ch   <-chan cid.Cid
+	next multihash.Multihash
+	done bool
+	err  error
+}
+
+func (b *BsIterator) Next() multihash.Multihash {
+	if b.Done() {
+		panic("iterator already done")
+	}
+	next := b.next
+	b.next = nil
+	return next
+}
+
+func (b *BsIterator) Done() bool {
+	for b.next == nil && !b.done {
+		var k cid.Cid
+		var ok bool
+		select {
+		case k, ok = <-b.ch:
+		case <-b.ctx.Done():
+		}
+		if !ok || b.ctx.Err() != nil {
+			// the channel is also closed when the context is done
+			b.done = true
+			b.err = b.ctx.Err()
+			break
+		}
+		if indexable(k) {
+			b.next = k.Hash()
+		}
+	}
+	return b.next == nil
+}
+
+func (b *BsIterator) Err() error {
+	return b.err
+}
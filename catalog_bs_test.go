@@ -0,0 +1,61 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBsCatalog(t *testing.T) {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	var expected []multihash.Multihash
+	for i := 0; i < 100; i++ {
+		blk := blocks.NewBlock([]byte{byte(i)})
+		require.NoError(t, bs.Put(ctx, blk))
+		expected = append(expected, blk.Cid().Hash())
+	}
+	// identity multihashes are skipped
+	idHash, err := multihash.Sum([]byte("inline"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	idBlk, err := blocks.NewBlockWithCid([]byte("inline"), cid.NewCidV1(cid.Raw, idHash))
+	require.NoError(t, err)
+	require.NoError(t, bs.Put(ctx, idBlk))
+
+	cat := CatalogFromBlockstore(bs)
+	require.Nil(t, cat.ID())
+	require.Equal(t, -1, cat.Count())
+
+	iter, err := cat.Iterator(ctx)
+	require.NoError(t, err)
+	var res []multihash.Multihash
+	for !iter.Done() {
+		res = append(res, iter.Next())
+	}
+	require.ElementsMatch(t, expected, res)
+	require.NoError(t, iteratorErr(iter))
+	require.Panics(t, func() { iter.Next() })
+
+	cat = CatalogFromBlockstore(bs, WithBsContextID([]byte("foo")), WithBsCount())
+	require.Equal(t, []byte("foo"), cat.ID())
+	require.Equal(t, 100, cat.Count())
+
+	// cancelling the context stops the iteration
+	cctx, cancel := context.WithCancel(ctx)
+	iter, err = cat.Iterator(cctx)
+	require.NoError(t, err)
+	require.False(t, iter.Done())
+	iter.Next()
+	cancel()
+	require.True(t, iter.Done())
+	// and is not mistaken for a complete one
+	require.ErrorIs(t, iteratorErr(iter), context.Canceled)
+}
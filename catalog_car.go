@@ -1,75 +1,227 @@
 package herald
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"sync"
 
-	"github.com/ipfs/go-cid"
-	"github.com/ipld/go-car/v2/blockstore"
+	carv2 "github.com/ipld/go-car/v2"
 	"github.com/ipld/go-car/v2/index"
 	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
 )
 
-func CatalogFromCar(path string, id []byte) (*CarCatalog, error) {
-	car, err := blockstore.OpenReadOnly(path)
+// CatalogFromCar creates a catalog of the multihashes of the blocks of a CARv1 or CARv2 file. The index of a CARv2 is
+// used if it can be iterated, otherwise an index is generated in memory on first use. The identity multihashes and
+// the duplicate blocks are skipped. Close releases the file.
+func CatalogFromCar(path string, id []byte, opts ...CarCatalogOption) (*CarCatalog, error) {
+	r, err := carv2.OpenReader(path)
 	if err != nil {
 		return nil, err
 	}
-	return &CarCatalog{car: car, id: id}, nil
+	c := &CarCatalog{r: r, id: id}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = orDefaultLogger(c.logger)
+	return c, nil
+}
+
+// CarCatalogOption is an optional configuration of a CarCatalog.
+type CarCatalogOption func(*CarCatalog)
+
+// WithCarLogger sets the logger of the catalog, instead of the default one.
+func WithCarLogger(l *zap.SugaredLogger) CarCatalogOption {
+	return func(c *CarCatalog) {
+		c.logger = l
+	}
 }
 
 var _ Catalog = &CarCatalog{}
 
 type CarCatalog struct {
-	car *blockstore.ReadOnly
-	id  []byte
+	r      *carv2.Reader
+	id     []byte
+	logger *zap.SugaredLogger
+
+	mu    sync.Mutex
+	idx   index.IterableIndex
+	count int
 }
 
 func (c *CarCatalog) ID() []byte {
 	return c.id
 }
 
+// Count returns the number of distinct multihashes, or -1 if the index failed to load.
 func (c *CarCatalog) Count() int {
-	idx, ok := c.car.Index().(index.IterableIndex)
-	if !ok {
-		return -1
-	}
-	var count int
-	err := idx.ForEach(func(mh multihash.Multihash, u uint64) error {
-		count++
-		return nil
-	})
-	if err != nil {
+	if _, err := c.index(); err != nil {
+		c.logger.Errorw("failed to load the CAR index", "err", err)
 		return -1
 	}
-	return count
+	return c.count
 }
 
+// carIteratorChunk is the number of multihashes handed over at once from the walk of the index to the CarIterator.
+const carIteratorChunk = 1024
+
+// Iterator walks the index in the background as the multihashes are consumed, a chunk ahead. The walk stops once the
+// iterator is done, or when ctx is canceled, which must be done if the iterator is abandoned before.
 func (c *CarCatalog) Iterator(ctx context.Context) (MhIterator, error) {
-	cids, err := c.car.AllKeysChan(ctx)
+	idx, err := c.index()
 	if err != nil {
 		return nil, err
 	}
-	return &CarIterator{
-		c: cids,
-	}, nil
+	iter := &CarIterator{ctx: ctx, chunks: make(chan []multihash.Multihash, 1)}
+	go func() {
+		defer close(iter.chunks)
+		send := func(mhs []multihash.Multihash) error {
+			select {
+			case iter.chunks <- mhs:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		mhs := make([]multihash.Multihash, 0, carIteratorChunk)
+		iter.walkErr = forEachCarMultihash(idx, func(mh multihash.Multihash) error {
+			mhs = append(mhs, mh)
+			if len(mhs) < carIteratorChunk {
+				return nil
+			}
+			if err := send(mhs); err != nil {
+				return err
+			}
+			mhs = make([]multihash.Multihash, 0, carIteratorChunk)
+			return nil
+		})
+		if iter.walkErr == nil && len(mhs) > 0 {
+			iter.walkErr = send(mhs)
+		}
+	}()
+	return iter, nil
+}
+
+// Close closes the CAR file.
+func (c *CarCatalog) Close() error {
+	return c.r.Close()
 }
 
-var _ MhIterator = &CarIterator{}
+var _ FallibleMhIterator = &CarIterator{}
 
+// CarIterator iterates over the multihashes of a CarCatalog.
 type CarIterator struct {
-	c    <-chan cid.Cid
-	next multihash.Multihash
+	ctx     context.Context
+	chunks  chan []multihash.Multihash
+	walkErr error // the error that stopped the walk of the index, set before chunks is closed
+	mhs     []multihash.Multihash
+	done    bool
+	err     error
 }
 
 func (c *CarIterator) Next() multihash.Multihash {
-	return c.next
+	if c.Done() {
+		panic("iterator already done")
+	}
+	next := c.mhs[0]
+	c.mhs = c.mhs[1:]
+	return next
 }
 
 func (c *CarIterator) Done() bool {
-	val, ok := <-c.c
-	if ok {
-		c.next = val.Hash()
-		return false
+	for len(c.mhs) == 0 && !c.done {
+		if err := c.ctx.Err(); err != nil {
+			// the walk stops too
+			c.err, c.done = err, true
+			break
+		}
+		mhs, ok := <-c.chunks
+		if !ok {
+			c.err, c.done = c.walkErr, true
+			break
+		}
+		c.mhs = mhs
+	}
+	return len(c.mhs) == 0
+}
+
+func (c *CarIterator) Err() error {
+	return c.err
+}
+
+// index returns the iterable index of the CAR, loaded or generated on first use.
+func (c *CarCatalog) index() (index.IterableIndex, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idx != nil {
+		return c.idx, nil
+	}
+	idx, err := loadIterableIndex(c.r, c.logger)
+	if err != nil {
+		return nil, err
 	}
-	return true
+	count := 0
+	err = forEachCarMultihash(idx, func(multihash.Multihash) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.idx, c.count = idx, count
+	return idx, nil
+}
+
+// loadIterableIndex reads the index of a CARv2 if it can be iterated, or generates one from the data.
+func loadIterableIndex(r *carv2.Reader, logger *zap.SugaredLogger) (index.IterableIndex, error) {
+	ir, err := r.IndexReader()
+	if err != nil {
+		return nil, err
+	}
+	if ir != nil {
+		idx, err := index.ReadFrom(ir)
+		if err == nil {
+			if iterable, ok := idx.(index.IterableIndex); ok {
+				return iterable, nil
+			}
+			logger.Debugw("generating an iterable index for the CAR", "codec", idx.Codec())
+		} else {
+			logger.Debugw("generating an index for the CAR, failed to read its own", "err", err)
+		}
+	}
+
+	dr, err := r.DataReader()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := carv2.GenerateIndex(dr)
+	if err != nil {
+		return nil, err
+	}
+	iterable, ok := idx.(index.IterableIndex)
+	if !ok {
+		return nil, fmt.Errorf("the generated CAR index %s can't be iterated", idx.Codec())
+	}
+	return iterable, nil
+}
+
+// forEachCarMultihash calls fn with the distinct multihashes of the index, but the identity ones, until fn returns an
+// error. The index being sorted, the duplicates are consecutive.
+func forEachCarMultihash(idx index.IterableIndex, fn func(multihash.Multihash) error) error {
+	var prev multihash.Multihash
+	return idx.ForEach(func(mh multihash.Multihash, _ uint64) error {
+		if bytes.Equal(mh, prev) {
+			return nil
+		}
+		prev = mh
+		decoded, err := multihash.Decode(mh)
+		if err != nil {
+			return err
+		}
+		if decoded.Code != multihash.IDENTITY {
+			return fn(mh)
+		}
+		return nil
+	})
 }
@@ -1,9 +1,20 @@
 package herald
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
@@ -11,6 +22,7 @@ func TestCarCatalog(t *testing.T) {
 	id := []byte("fooo")
 	cat, err := CatalogFromCar("testdata/1.car", id)
 	require.NoError(t, err)
+	defer cat.Close()
 	require.Equal(t, 5, cat.Count())
 	require.Equal(t, id, cat.ID())
 
@@ -26,3 +38,169 @@ func TestCarCatalog(t *testing.T) {
 
 	require.Equal(t, 5, count)
 }
+
+func TestCarCatalogVersions(t *testing.T) {
+	dir := t.TempDir()
+	expected := carMultihashes(t, "testdata/1.car")
+	require.Len(t, expected, 5)
+
+	src, err := os.ReadFile("testdata/1.car")
+	require.NoError(t, err)
+
+	// CARv2 with an iterable index
+	indexed := filepath.Join(dir, "indexed.car")
+	require.NoError(t, carv2.WrapV1File("testdata/1.car", indexed))
+
+	// CARv2 with an index that can't be iterated
+	sorted := filepath.Join(dir, "sorted.car")
+	var buf bytes.Buffer
+	require.NoError(t, carv2.WrapV1(bytes.NewReader(src), &buf, carv2.UseIndexCodec(multicodec.CarIndexSorted)))
+	require.NoError(t, os.WriteFile(sorted, buf.Bytes(), 0o644))
+
+	// CARv2 without index
+	noIndex := filepath.Join(dir, "noindex.car")
+	buf.Reset()
+	header := carv2.NewHeader(uint64(len(src)))
+	header.IndexOffset = 0
+	buf.Write(carv2.Pragma)
+	_, err = header.WriteTo(&buf)
+	require.NoError(t, err)
+	buf.Write(src)
+	require.NoError(t, os.WriteFile(noIndex, buf.Bytes(), 0o644))
+
+	for name, path := range map[string]string{
+		"v1":              "testdata/1.car",
+		"v2 indexed":      indexed,
+		"v2 sorted index": sorted,
+		"v2 no index":     noIndex,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cat, err := CatalogFromCar(path, nil)
+			require.NoError(t, err)
+			require.Equal(t, len(expected), cat.Count())
+			require.ElementsMatch(t, expected, iterateCatalog(t, cat))
+			// the catalog can be iterated again
+			require.ElementsMatch(t, expected, iterateCatalog(t, cat))
+			require.NoError(t, cat.Close())
+		})
+	}
+
+	_, err = CatalogFromCar(filepath.Join(dir, "missing.car"), nil)
+	require.Error(t, err)
+}
+
+func TestCarCatalogDuplicatesAndIdentity(t *testing.T) {
+	dir := t.TempDir()
+	a := blocks.NewBlock([]byte("a"))
+	b := blocks.NewBlock([]byte("b"))
+	identityHash, err := multihash.Sum([]byte("inlined"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	identity, err := blocks.NewBlockWithCid([]byte("inlined"), cid.NewCidV1(cid.Raw, identityHash))
+	require.NoError(t, err)
+
+	for name, opts := range map[string][]carv2.Option{
+		"v1": {carv2.WriteAsCarV1(true)},
+		"v2": {carv2.StoreIdentityCIDs(true)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+".car")
+			opts = append(opts, carv2.AllowDuplicatePuts(true))
+			rw, err := blockstore.OpenReadWrite(path, []cid.Cid{a.Cid()}, opts...)
+			require.NoError(t, err)
+			for _, blk := range []blocks.Block{a, b, identity, a} {
+				require.NoError(t, rw.Put(context.Background(), blk))
+			}
+			require.NoError(t, rw.Finalize())
+
+			cat, err := CatalogFromCar(path, nil)
+			require.NoError(t, err)
+			defer cat.Close()
+			require.Equal(t, 2, cat.Count())
+			require.ElementsMatch(t, []multihash.Multihash{a.Cid().Hash(), b.Cid().Hash()}, iterateCatalog(t, cat))
+		})
+	}
+}
+
+func TestCarIterator(t *testing.T) {
+	cat, err := CatalogFromCar("testdata/1.car", nil)
+	require.NoError(t, err)
+	defer cat.Close()
+	expected := carMultihashes(t, "testdata/1.car")
+
+	// Done doesn't consume, Next returns every multihash
+	iter, err := cat.Iterator(context.Background())
+	require.NoError(t, err)
+	var got []multihash.Multihash
+	for !iter.Done() {
+		require.False(t, iter.Done())
+		got = append(got, iter.Next())
+	}
+	require.ElementsMatch(t, expected, got)
+	require.True(t, iter.Done())
+	require.Panics(t, func() { iter.Next() })
+}
+
+func TestCarIteratorChunks(t *testing.T) {
+	// more blocks than a chunk of the walk of the index
+	path := filepath.Join(t.TempDir(), "large.car")
+	var expected []multihash.Multihash
+	var roots []cid.Cid
+	var blks []blocks.Block
+	for i := 0; i < 2*carIteratorChunk+10; i++ {
+		blk := blocks.NewBlock([]byte(fmt.Sprint(i)))
+		blks = append(blks, blk)
+		expected = append(expected, blk.Cid().Hash())
+	}
+	roots = append(roots, blks[0].Cid())
+	rw, err := blockstore.OpenReadWrite(path, roots)
+	require.NoError(t, err)
+	require.NoError(t, rw.PutMany(context.Background(), blks))
+	require.NoError(t, rw.Finalize())
+
+	cat, err := CatalogFromCar(path, nil)
+	require.NoError(t, err)
+	defer cat.Close()
+	require.Equal(t, len(expected), cat.Count())
+	require.ElementsMatch(t, expected, iterateCatalog(t, cat))
+
+	// an abandoned iteration is stopped with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	iter, err := cat.Iterator(ctx)
+	require.NoError(t, err)
+	require.False(t, iter.Done())
+	iter.Next()
+	cancel()
+	for !iter.Done() {
+		iter.Next()
+	}
+	require.ErrorIs(t, iteratorErr(iter), context.Canceled)
+}
+
+// carMultihashes reads the multihashes of the blocks of the CAR file.
+func carMultihashes(t *testing.T, path string) []multihash.Multihash {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	br, err := carv2.NewBlockReader(f)
+	require.NoError(t, err)
+	var mhs []multihash.Multihash
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			return mhs
+		}
+		require.NoError(t, err)
+		mhs = append(mhs, blk.Cid().Hash())
+	}
+}
+
+func iterateCatalog(t *testing.T, cat Catalog) []multihash.Multihash {
+	iter, err := cat.Iterator(context.Background())
+	require.NoError(t, err)
+	var mhs []multihash.Multihash
+	for !iter.Done() {
+		mhs = append(mhs, iter.Next())
+	}
+	require.NoError(t, iteratorErr(iter))
+	return mhs
+}
@@ -0,0 +1,161 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// DsKeyDecoder extracts the multihash from a key of a datastore.
+type DsKeyDecoder func(key datastore.Key) (multihash.Multihash, error)
+
+// DecodeDsKeyBase32 decodes the last component of the key as a multihash in unpadded base32, the encoding of the
+// blockstores (see dshelp.MultihashToDsKey). This is the default DsKeyDecoder.
+func DecodeDsKeyBase32(key datastore.Key) (multihash.Multihash, error) {
+	raw, err := dshelp.BinaryFromDsKey(datastore.NewKey(key.BaseNamespace()))
+	if err != nil {
+		return nil, err
+	}
+	return multihash.Cast(raw)
+}
+
+// DsCatalogOption is an optional configuration of a DsCatalog.
+type DsCatalogOption func(*DsCatalog)
+
+// WithDsKeyDecoder sets how the keys are decoded as multihashes. Defaults to DecodeDsKeyBase32.
+func WithDsKeyDecoder(decoder DsKeyDecoder) DsCatalogOption {
+	return func(c *DsCatalog) {
+		c.decode = decoder
+	}
+}
+
+// WithDsCatalogLogger sets the logger of the catalog, instead of the default one.
+func WithDsCatalogLogger(l *zap.SugaredLogger) DsCatalogOption {
+	return func(c *DsCatalog) {
+		c.logger = l
+	}
+}
+
+// CatalogFromDatastore creates a catalog iterating over the keys of a datastore under prefix, decoded as multihashes.
+// This exposes an existing multihash index, for example in a LevelDB or Pebble datastore. The keys failing to decode
+// are skipped.
+func CatalogFromDatastore(ds datastore.Datastore, prefix datastore.Key, id []byte, opts ...DsCatalogOption) *DsCatalog {
+	c := &DsCatalog{ds: ds, prefix: prefix, id: id, decode: DecodeDsKeyBase32, counted: -1}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = orDefaultLogger(c.logger)
+	return c
+}
+
+var _ Catalog = &DsCatalog{}
+
+type DsCatalog struct {
+	ds     datastore.Datastore
+	prefix datastore.Key
+	id     []byte
+	decode DsKeyDecoder
+	logger *zap.SugaredLogger
+
+	counted int
+}
+
+func (c *DsCatalog) ID() []byte {
+	return c.id
+}
+
+// Count returns the number of keys decoding as multihashes, counted with a query the first time.
+func (c *DsCatalog) Count() int {
+	if c.counted >= 0 {
+		return c.counted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iter, err := c.Iterator(ctx)
+	if err != nil {
+		c.logger.Errorw("failed to query the datastore keys", "prefix", c.prefix, "err", err)
+		return -1
+	}
+	count := 0
+	for !iter.Done() {
+		iter.Next()
+		count++
+	}
+	if err := iteratorErr(iter); err != nil {
+		c.logger.Errorw("failed to count the datastore keys", "prefix", c.prefix, "err", err)
+		return -1
+	}
+	c.counted = count
+	return count
+}
+
+func (c *DsCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	results, err := c.ds.Query(ctx, query.Query{Prefix: c.prefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return &DsIterator{ctx: ctx, catalog: c, results: results}, nil
+}
+
+var _ FallibleMhIterator = &DsIterator{}
+
+// DsIterator iterates lazily over the keys of a datastore.
+// The iteration stops with an error if the context given to DsCatalog.Iterator is done.
+type DsIterator struct {
+	ctx     context.Context
+	catalog *DsCatalog
+	results query.Results
+	next    multihash.Multihash
+	done    bool
+	err     error
+}
+
+func (d *DsIterator) Next() multihash.Multihash {
+	if d.Done() {
+		panic("iterator already done")
+	}
+	next := d.next
+	d.next = nil
+	return next
+}
+
+func (d *DsIterator) Done() bool {
+	for d.next == nil && !d.done {
+		if err := d.ctx.Err(); err != nil {
+			d.finish(err)
+			break
+		}
+		res, ok := d.results.NextSync()
+		switch {
+		case !ok:
+			d.finish(d.ctx.Err())
+		case res.Error != nil:
+			d.finish(res.Error)
+		default:
+			mh, err := d.catalog.decode(datastore.RawKey(res.Key))
+			if err != nil {
+				d.catalog.logger.Debugw("skipping datastore key not decoding as a multihash", "key", res.Key, "err", err)
+				continue
+			}
+			d.next = mh
+		}
+	}
+	return d.next == nil
+}
+
+// finish ends the iteration with err, if any, and releases the query.
+func (d *DsIterator) finish(err error) {
+	d.done = true
+	d.err = err
+	if err := d.results.Close(); err != nil {
+		d.catalog.logger.Warnw("failed to close the datastore query", "prefix", d.catalog.prefix, "err", err)
+	}
+}
+
+func (d *DsIterator) Err() error {
+	return d.err
+}
@@ -0,0 +1,69 @@
+package herald
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/go-datastore"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDsCatalog(t *testing.T) {
+	ctx := context.Background()
+	ds := datastore.NewMapDatastore()
+	mhs := testMultihashes(100)
+	prefix := datastore.NewKey("/blocks")
+	for _, mh := range mhs {
+		require.NoError(t, ds.Put(ctx, prefix.Child(dshelp.MultihashToDsKey(mh)), []byte("v")))
+	}
+	// not a multihash, or outside the prefix: skipped
+	require.NoError(t, ds.Put(ctx, prefix.ChildString("garbage"), []byte("v")))
+	require.NoError(t, ds.Put(ctx, datastore.NewKey("/other").Child(dshelp.MultihashToDsKey(testMultihashes(1)[0])), []byte("v")))
+
+	collect := func(cat Catalog) []multihash.Multihash {
+		iter, err := cat.Iterator(ctx)
+		require.NoError(t, err)
+		var res []multihash.Multihash
+		for !iter.Done() {
+			res = append(res, iter.Next())
+		}
+		require.NoError(t, iteratorErr(iter))
+		return res
+	}
+
+	id := []byte("foo")
+	cat := CatalogFromDatastore(ds, prefix, id)
+	require.Equal(t, id, cat.ID())
+	require.Equal(t, len(mhs), cat.Count())
+	require.ElementsMatch(t, mhs, collect(cat))
+
+	// a custom key encoding
+	hexDs := datastore.NewMapDatastore()
+	for _, mh := range mhs[:10] {
+		require.NoError(t, hexDs.Put(ctx, prefix.ChildString(hex.EncodeToString(mh)), []byte("v")))
+	}
+	cat = CatalogFromDatastore(hexDs, prefix, id, WithDsKeyDecoder(func(key datastore.Key) (multihash.Multihash, error) {
+		raw, err := hex.DecodeString(key.BaseNamespace())
+		if err != nil {
+			return nil, err
+		}
+		return multihash.Cast(raw)
+	}))
+	require.Equal(t, 10, cat.Count())
+	require.ElementsMatch(t, mhs[:10], collect(cat))
+
+	// a canceled iteration is not mistaken for a complete one
+	cctx, cancel := context.WithCancel(ctx)
+	iter, err := CatalogFromDatastore(ds, prefix, id).Iterator(cctx)
+	require.NoError(t, err)
+	require.False(t, iter.Done())
+	iter.Next()
+	cancel()
+	for !iter.Done() {
+		iter.Next()
+	}
+	require.ErrorIs(t, iteratorErr(iter), context.Canceled)
+}
@@ -0,0 +1,110 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// ErrCatalogConsumed is returned when iterating a second time over a single-use catalog.
+var ErrCatalogConsumed = errors.New("catalog already consumed")
+
+// CatalogFromIterator creates a catalog reading the multihashes from next, for example from a database cursor or a
+// network stream, without holding them in memory. next returns io.EOF once there are no more multihashes. Any other
+// error aborts the publication or retraction.
+//
+// count is the total number of multihashes, or -1 if unknown. As the source can't be rewound, the catalog can only be
+// iterated once.
+func CatalogFromIterator(id []byte, count int, next func(ctx context.Context) (multihash.Multihash, error)) *FuncCatalog {
+	return &FuncCatalog{id: id, count: count, next: next}
+}
+
+// CatalogFromChan creates a catalog reading the multihashes from a channel, until it's closed. As for
+// CatalogFromIterator, count can be -1 and the catalog can only be iterated once.
+func CatalogFromChan(id []byte, count int, ch <-chan multihash.Multihash) *FuncCatalog {
+	return CatalogFromIterator(id, count, func(ctx context.Context) (multihash.Multihash, error) {
+		select {
+		case mh, ok := <-ch:
+			if !ok {
+				return nil, io.EOF
+			}
+			return mh, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+}
+
+var _ Catalog = &FuncCatalog{}
+
+// FuncCatalog is a single-use catalog, reading the multihashes from a function.
+type FuncCatalog struct {
+	id    []byte
+	count int
+	next  func(ctx context.Context) (multihash.Multihash, error)
+
+	mu       sync.Mutex
+	consumed bool
+}
+
+func (c *FuncCatalog) ID() []byte {
+	return c.id
+}
+
+func (c *FuncCatalog) Count() int {
+	return c.count
+}
+
+func (c *FuncCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.consumed {
+		return nil, ErrCatalogConsumed
+	}
+	c.consumed = true
+	return &FuncIterator{ctx: ctx, next: c.next}, nil
+}
+
+var _ FallibleMhIterator = &FuncIterator{}
+
+// FuncIterator iterates over the multihashes returned by a function.
+type FuncIterator struct {
+	ctx  context.Context
+	next func(ctx context.Context) (multihash.Multihash, error)
+	mh   multihash.Multihash
+	done bool
+	err  error
+}
+
+func (f *FuncIterator) Next() multihash.Multihash {
+	if f.Done() {
+		panic("iterator already done")
+	}
+	mh := f.mh
+	f.mh = nil
+	return mh
+}
+
+func (f *FuncIterator) Done() bool {
+	if f.mh == nil && !f.done {
+		mh, err := f.next(f.ctx)
+		switch {
+		case errors.Is(err, io.EOF):
+			f.done = true
+		case err != nil:
+			f.err, f.done = err, true
+		case mh == nil:
+			f.err, f.done = errors.New("nil multihash"), true
+		default:
+			f.mh = mh
+		}
+	}
+	return f.mh == nil
+}
+
+func (f *FuncIterator) Err() error {
+	return f.err
+}
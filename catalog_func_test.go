@@ -0,0 +1,88 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuncCatalog(t *testing.T) {
+	ctx := context.Background()
+	mhs := testMultihashes(5)
+
+	var i int
+	cat := CatalogFromIterator([]byte("foo"), len(mhs), func(ctx context.Context) (multihash.Multihash, error) {
+		if i >= len(mhs) {
+			return nil, io.EOF
+		}
+		i++
+		return mhs[i-1], nil
+	})
+	require.Equal(t, []byte("foo"), cat.ID())
+	require.Equal(t, 5, cat.Count())
+
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	head, err := PublishWithContextID(ctx, cfg, backend, cat)
+	require.NoError(t, err)
+	var published []multihash.Multihash
+	err = WalkEntries(ctx, backend, head, func(mh multihash.Multihash) error {
+		published = append(published, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, mhs, published)
+
+	// single use
+	_, err = cat.Iterator(ctx)
+	require.ErrorIs(t, err, ErrCatalogConsumed)
+}
+
+func TestFuncCatalogChan(t *testing.T) {
+	mhs := testMultihashes(3)
+	ch := make(chan multihash.Multihash)
+	go func() {
+		defer close(ch)
+		for _, mh := range mhs {
+			ch <- mh
+		}
+	}()
+	require.Equal(t, []multihash.Multihash(mhs), collectMhs(t, CatalogFromChan(nil, -1, ch)))
+
+	// the iteration stops with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	iter, err := CatalogFromChan(nil, -1, make(chan multihash.Multihash)).Iterator(ctx)
+	require.NoError(t, err)
+	require.True(t, iter.Done())
+	require.ErrorIs(t, iteratorErr(iter), context.Canceled)
+}
+
+func TestFuncCatalogError(t *testing.T) {
+	ctx := context.Background()
+	mhs := testMultihashes(5)
+
+	var i int
+	cat := CatalogFromIterator([]byte("foo"), -1, func(ctx context.Context) (multihash.Multihash, error) {
+		if i >= 3 {
+			return nil, errors.New("connection reset")
+		}
+		i++
+		return mhs[i-1], nil
+	})
+
+	// a failed iteration is not published
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	_, err := PublishWithContextID(ctx, cfg, backend, cat)
+	require.ErrorContains(t, err, "connection reset")
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, head.Defined())
+}
@@ -0,0 +1,80 @@
+package herald
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// CatalogOverrides are per-catalog overrides of the ChainConfig, for multi-tenant setups where catalogs have
+// different retrieval protocols, addresses or providers. Zero fields are not overridden.
+type CatalogOverrides struct {
+	// Metadata replaces ChainConfig.Metadata
+	Metadata []byte
+
+	// ProviderAddrs replaces ChainConfig.ProviderAddrs
+	ProviderAddrs []string
+
+	// ProviderID replaces ChainConfig.ProviderID
+	ProviderID peer.ID
+
+	// ProviderKey replaces ChainConfig.ProviderKey. If ProviderID is not set, it's derived from this key.
+	ProviderKey crypto.PrivKey
+}
+
+// CatalogWithOverrides wraps a catalog to publish or retract it with the given overrides.
+// A retraction must use the same provider as the publication.
+//
+// Catalogs with overrides are never batched with other catalogs by a CatalogBatcher.
+func CatalogWithOverrides(catalog Catalog, overrides CatalogOverrides) Catalog {
+	return &OverriddenCatalog{Catalog: catalog, overrides: overrides}
+}
+
+var _ Catalog = &OverriddenCatalog{}
+
+type OverriddenCatalog struct {
+	Catalog
+	overrides CatalogOverrides
+}
+
+func (c *OverriddenCatalog) Overrides() CatalogOverrides {
+	return c.overrides
+}
+
+// forCatalog returns the configuration to use for the catalog, with its overrides if any.
+func (cfg ChainConfig) forCatalog(catalog Catalog) (ChainConfig, error) {
+	oc, ok := catalog.(*OverriddenCatalog)
+	if !ok {
+		return cfg, nil
+	}
+	o := oc.overrides
+	if o.Metadata != nil {
+		cfg.Metadata = o.Metadata
+	}
+	if o.ProviderAddrs != nil {
+		cfg.ProviderAddrs = o.ProviderAddrs
+	}
+	if o.ProviderKey != nil {
+		id, err := peer.IDFromPrivateKey(o.ProviderKey)
+		if err != nil {
+			return ChainConfig{}, err
+		}
+		if o.ProviderID != "" && o.ProviderID != id {
+			return ChainConfig{}, fmt.Errorf("provider key is for %s, expected %s", id, o.ProviderID)
+		}
+		cfg.ProviderKey = o.ProviderKey
+		cfg.ProviderID = id
+	} else if o.ProviderID != "" {
+		cfg.ProviderID = o.ProviderID
+		// signed by the publisher on behalf of the provider
+		cfg.ProviderKey = nil
+	}
+	return cfg, nil
+}
+
+// hasOverrides returns true if the catalog carries overrides of the ChainConfig.
+func hasOverrides(catalog Catalog) bool {
+	_, ok := catalog.(*OverriddenCatalog)
+	return ok
+}
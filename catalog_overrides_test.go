@@ -0,0 +1,84 @@
+package herald
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogOverrides(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	providerKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	providerID, err := peer.IDFromPrivateKey(providerKey)
+	require.NoError(t, err)
+	mdv := metadata.Default.New(metadata.IpfsGatewayHttp{})
+	md, err := mdv.MarshalBinary()
+	require.NoError(t, err)
+
+	overrides := CatalogOverrides{
+		Metadata:      md,
+		ProviderAddrs: []string{"/dns4/example.com/tcp/443/https"},
+		ProviderKey:   providerKey,
+	}
+	adCid, err := PublishWithContextID(ctx, cfg, backend, CatalogWithOverrides(testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")}, overrides))
+	require.NoError(t, err)
+
+	ad, err := LoadAdvertisement(ctx, backend, adCid)
+	require.NoError(t, err)
+	require.Equal(t, md, ad.Metadata)
+	require.Equal(t, overrides.ProviderAddrs, ad.Addresses)
+	require.Equal(t, providerID.String(), ad.Provider)
+	signer, err := ad.VerifySignature()
+	require.NoError(t, err)
+	require.Equal(t, providerID, signer)
+
+	// provider only: signed by the publisher on behalf of the provider
+	adCid, err = RetractWithContextID(ctx, cfg, backend, CatalogWithOverrides(testCatalog{id: []byte("foo")}, CatalogOverrides{ProviderID: providerID}))
+	require.NoError(t, err)
+	ad, err = LoadAdvertisement(ctx, backend, adCid)
+	require.NoError(t, err)
+	require.Equal(t, providerID.String(), ad.Provider)
+	require.Equal(t, cfg.Metadata, ad.Metadata)
+	signer, err = ad.VerifySignature()
+	require.NoError(t, err)
+	require.Equal(t, cfg.PublisherID, signer)
+
+	// mismatching key and ID
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogWithOverrides(CatalogFromMultihashes(testMultihashes(3)...), CatalogOverrides{ProviderID: cfg.PublisherID, ProviderKey: providerKey}))
+	require.Error(t, err)
+}
+
+func TestBatchingOverridesBypass(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         100,
+		MaxMHsPerAdvertisement: 1000,
+		MaxDelay:               DefaultMaxDelay,
+	}, cfg, backend, nilAnnouncer{})
+
+	md := []byte("custom")
+	err := batcher.PublishCatalog(ctx, CatalogWithOverrides(CatalogFromMultihashes(testMultihashes(3)...), CatalogOverrides{Metadata: md}))
+	require.NoError(t, err)
+
+	// published right away, not batched
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	require.Equal(t, md, ad.Metadata)
+	require.Empty(t, ad.ContextID)
+}
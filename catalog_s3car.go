@@ -0,0 +1,287 @@
+package herald
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+)
+
+// CatalogFromS3Car creates a catalog of the blocks of a CAR stored on S3, without downloading it to disk.
+//
+// For a CARv2 with a multihash index, each iteration streams the index, with a ranged GET, rather than the whole CAR.
+// Otherwise, the CAR is streamed. In both cases, the multihashes are read as they are consumed, and Count returns -1
+// until the catalog was iterated completely once. As for CatalogFromBlockstore, identity multihashes are skipped.
+func CatalogFromS3Car(ctx context.Context, client S3GetObjectAPI, bucket, key string, id []byte) (*S3CarCatalog, error) {
+	c := &S3CarCatalog{client: client, bucket: bucket, key: key, id: id, count: -1}
+
+	header, err := c.get(ctx, fmt.Sprintf("bytes=0-%d", carv2.PragmaSize+carv2.HeaderSize-1))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, carv2.Pragma) {
+		// CARv1, or too short to be a CARv2
+		return c, nil
+	}
+	var h carv2.Header
+	if _, err := h.ReadFrom(bytes.NewReader(header[carv2.PragmaSize:])); err != nil {
+		return nil, fmt.Errorf("invalid CARv2 header for s3://%s/%s: %w", bucket, key, err)
+	}
+	if !h.HasIndex() {
+		return c, nil
+	}
+
+	// only the multihash index gives the multihashes, the other formats only have the digests
+	codec, err := c.get(ctx, fmt.Sprintf("bytes=%d-%d", h.IndexOffset, h.IndexOffset+binary.MaxVarintLen64-1))
+	if err != nil {
+		return nil, err
+	}
+	if code, n := binary.Uvarint(codec); n > 0 && multicodec.Code(code) == multicodec.CarMultihashIndexSorted {
+		c.indexOffset = h.IndexOffset
+	}
+	return c, nil
+}
+
+var _ Catalog = &S3CarCatalog{}
+
+// S3CarCatalog is a catalog of the blocks of a CAR stored on S3.
+type S3CarCatalog struct {
+	client S3GetObjectAPI
+	bucket string
+	key    string
+	id     []byte
+
+	// indexOffset is the offset of the multihash index of a CARv2, if any
+	indexOffset uint64
+
+	mu    sync.Mutex
+	count int // -1 until iterated completely
+}
+
+func (c *S3CarCatalog) ID() []byte {
+	return c.id
+}
+
+// Count returns the number of multihashes, or -1 (unknown) until the catalog was iterated completely once.
+func (c *S3CarCatalog) Count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count
+}
+
+func (c *S3CarCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	input := &s3.GetObjectInput{Bucket: aws.String(c.bucket), Key: aws.String(c.key)}
+	if c.indexOffset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", c.indexOffset))
+	}
+	out, err := c.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", c.bucket, c.key, err)
+	}
+	iter := &S3CarIterator{catalog: c, body: out.Body}
+	if c.indexOffset > 0 {
+		ir, err := newMhIndexReader(bufio.NewReader(out.Body))
+		if err != nil {
+			_ = out.Body.Close()
+			return nil, fmt.Errorf("invalid CARv2 index for s3://%s/%s: %w", c.bucket, c.key, err)
+		}
+		iter.read = ir.next
+		return iter, nil
+	}
+	reader, err := carv2.NewBlockReader(out.Body)
+	if err != nil {
+		_ = out.Body.Close()
+		return nil, fmt.Errorf("invalid CAR s3://%s/%s: %w", c.bucket, c.key, err)
+	}
+	iter.read = func() (multihash.Multihash, error) {
+		block, err := reader.SkipNext()
+		if err != nil {
+			return nil, err
+		}
+		if !indexable(block.Cid) {
+			return nil, nil
+		}
+		return block.Cid.Hash(), nil
+	}
+	return iter, nil
+}
+
+// get reads a range of the object.
+func (c *S3CarCatalog) get(ctx context.Context, byteRange string) ([]byte, error) {
+	out, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key),
+		Range:  aws.String(byteRange),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3://%s/%s: %w", c.bucket, c.key, err)
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+var _ FallibleMhIterator = &S3CarIterator{}
+
+// S3CarIterator streams the multihashes of a CAR stored on S3, from its blocks or from its index.
+type S3CarIterator struct {
+	catalog *S3CarCatalog
+	body    io.ReadCloser
+	// read returns the next multihash, nil to skip one, or io.EOF at the end
+	read  func() (multihash.Multihash, error)
+	next  multihash.Multihash
+	count int
+	done  bool
+	err   error
+}
+
+func (s *S3CarIterator) Next() multihash.Multihash {
+	if s.Done() {
+		panic("iterator already done")
+	}
+	next := s.next
+	s.next = nil
+	return next
+}
+
+func (s *S3CarIterator) Done() bool {
+	for s.next == nil && !s.done {
+		mh, err := s.read()
+		if err != nil {
+			s.done = true
+			_ = s.body.Close()
+			if !errors.Is(err, io.EOF) {
+				s.err = err
+				break
+			}
+			s.catalog.mu.Lock()
+			s.catalog.count = s.count
+			s.catalog.mu.Unlock()
+			break
+		}
+		if mh != nil {
+			s.next = mh
+			s.count++
+		}
+	}
+	return s.next == nil
+}
+
+func (s *S3CarIterator) Err() error {
+	return s.err
+}
+
+// mhIndexReader reads the multihashes of a serialized CARv2 MultihashIndexSorted one record at a time: the
+// multihash codes, each with buckets of records of the same width, each record being a digest and an offset.
+// The records of a bucket are sorted, so that the duplicate blocks follow each other.
+type mhIndexReader struct {
+	r *bufio.Reader
+
+	codes     int32  // remaining codes
+	code      uint64 // current code
+	widths    int32  // remaining buckets of the current code
+	remaining uint64 // remaining records of the current bucket
+	record    []byte
+	prev      multihash.Multihash
+}
+
+// newMhIndexReader reads the codec and the number of codes of the index.
+func newMhIndexReader(r *bufio.Reader) (*mhIndexReader, error) {
+	codec, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if multicodec.Code(codec) != multicodec.CarMultihashIndexSorted {
+		return nil, fmt.Errorf("unexpected index codec %s", multicodec.Code(codec))
+	}
+	ir := &mhIndexReader{r: r}
+	if err := ir.readInt(&ir.codes); err != nil {
+		return nil, err
+	}
+	if ir.codes < 0 {
+		return nil, errors.New("negative count of multihash codes")
+	}
+	return ir, nil
+}
+
+func (ir *mhIndexReader) next() (multihash.Multihash, error) {
+	for {
+		switch {
+		case ir.remaining > 0:
+			ir.remaining--
+			if err := ir.readFull(ir.record); err != nil {
+				return nil, err
+			}
+			if ir.code == multihash.IDENTITY {
+				continue
+			}
+			// the record is the digest, followed by the offset of the block
+			mh, err := multihash.Encode(ir.record[:len(ir.record)-8], ir.code)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(mh, ir.prev) {
+				continue
+			}
+			ir.prev = mh
+			return mh, nil
+		case ir.widths > 0:
+			ir.widths--
+			var width uint32
+			var size int64
+			if err := ir.readInt(&width); err != nil {
+				return nil, err
+			}
+			if err := ir.readInt(&size); err != nil {
+				return nil, err
+			}
+			if width < 8 || width > 32<<20 || size < 0 || size%int64(width) != 0 {
+				return nil, fmt.Errorf("malformed index bucket: width %d, size %d", width, size)
+			}
+			ir.record, ir.remaining = make([]byte, width), uint64(size)/uint64(width)
+		case ir.codes > 0:
+			ir.codes--
+			if err := ir.readInt(&ir.code); err != nil {
+				return nil, err
+			}
+			if err := ir.readInt(&ir.widths); err != nil {
+				return nil, err
+			}
+			if ir.widths < 0 {
+				return nil, errors.New("negative count of index buckets")
+			}
+		default:
+			return nil, io.EOF
+		}
+	}
+}
+
+// readInt reads a little-endian integer. The index ending early is an error.
+func (ir *mhIndexReader) readInt(v any) error {
+	if err := binary.Read(ir.r, binary.LittleEndian, v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+func (ir *mhIndexReader) readFull(buf []byte) error {
+	if _, err := io.ReadFull(ir.r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package herald
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func collectMhs(t *testing.T, catalog Catalog) []multihash.Multihash {
+	t.Helper()
+	iter, err := catalog.Iterator(context.Background())
+	require.NoError(t, err)
+	var mhs []multihash.Multihash
+	for !iter.Done() {
+		mhs = append(mhs, iter.Next())
+	}
+	require.NoError(t, iteratorErr(iter))
+	return mhs
+}
+
+func TestS3CarCatalog(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeS3(t)
+	v1, err := os.ReadFile("testdata/1.car")
+	require.NoError(t, err)
+	v2Path := filepath.Join(t.TempDir(), "1.car")
+	require.NoError(t, carv2.WrapV1File("testdata/1.car", v2Path))
+	v2, err := os.ReadFile(v2Path)
+	require.NoError(t, err)
+	fake.objects["v1.car"] = v1
+	fake.objects["v2.car"] = v2
+
+	local, err := CatalogFromCar("testdata/1.car", nil)
+	require.NoError(t, err)
+	expected := collectMhs(t, local)
+	require.NoError(t, local.Close())
+
+	// CARv1: streamed
+	cat, err := CatalogFromS3Car(ctx, client, "bucket", "v1.car", []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), cat.ID())
+	require.Equal(t, -1, cat.Count())
+	require.ElementsMatch(t, expected, collectMhs(t, cat))
+	require.Equal(t, len(expected), cat.Count())
+
+	// CARv2 with an index: only the header and the index are read, the index being streamed by the iteration
+	fake.ranges = nil
+	cat, err = CatalogFromS3Car(ctx, client, "bucket", "v2.car", nil)
+	require.NoError(t, err)
+	require.Equal(t, -1, cat.Count())
+	require.Len(t, fake.ranges, 2)
+	require.ElementsMatch(t, expected, collectMhs(t, cat))
+	require.Equal(t, len(expected), cat.Count())
+	require.Len(t, fake.ranges, 3)
+	for _, r := range fake.ranges {
+		require.NotEmpty(t, r)
+	}
+
+	// a truncated index fails the iteration
+	fake.objects["truncated.car"] = v2[:len(v2)-10]
+	cat, err = CatalogFromS3Car(ctx, client, "bucket", "truncated.car", nil)
+	require.NoError(t, err)
+	iter, err := cat.Iterator(ctx)
+	require.NoError(t, err)
+	for !iter.Done() {
+		iter.Next()
+	}
+	require.ErrorIs(t, iteratorErr(iter), io.ErrUnexpectedEOF)
+	require.Equal(t, -1, cat.Count())
+
+	_, err = CatalogFromS3Car(ctx, client, "bucket", "missing.car", nil)
+	require.Error(t, err)
+}
+
+func TestS3CarCatalogTruncated(t *testing.T) {
+	ctx := context.Background()
+	fake, client := newFakeS3(t)
+	v1, err := os.ReadFile("testdata/1.car")
+	require.NoError(t, err)
+	fake.objects["truncated.car"] = v1[:len(v1)-10]
+
+	cat, err := CatalogFromS3Car(ctx, client, "bucket", "truncated.car", []byte("foo"))
+	require.NoError(t, err)
+	iter, err := cat.Iterator(ctx)
+	require.NoError(t, err)
+	for !iter.Done() {
+		iter.Next()
+	}
+	require.Error(t, iteratorErr(iter))
+
+	// a truncated catalog is not published
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	_, err = PublishWithContextID(ctx, cfg, backend, cat)
+	require.Error(t, err)
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, head.Defined())
+}
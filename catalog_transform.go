@@ -0,0 +1,119 @@
+package herald
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/multiformats/go-multihash"
+)
+
+// FilterCatalog wraps a catalog to publish or retract only the multihashes for which keep returns true, for example
+// to skip the identity multihashes or those already advertised. The count of the filtered catalog is unknown.
+func FilterCatalog(inner Catalog, keep func(multihash.Multihash) bool) Catalog {
+	return transformCatalog(inner, true, func(mh multihash.Multihash) (multihash.Multihash, error) {
+		if !keep(mh) {
+			return nil, nil
+		}
+		return mh, nil
+	})
+}
+
+// MapCatalog wraps a catalog to publish or retract the multihashes transformed by fn, for example to re-hash them. An
+// error returned by fn aborts the publication or retraction. Use FilterCatalog to skip multihashes.
+func MapCatalog(inner Catalog, fn func(multihash.Multihash) (multihash.Multihash, error)) Catalog {
+	return transformCatalog(inner, false, func(mh multihash.Multihash) (multihash.Multihash, error) {
+		mapped, err := fn(mh)
+		if err == nil && mapped == nil {
+			return nil, fmt.Errorf("multihash %s mapped to nil", mh)
+		}
+		return mapped, err
+	})
+}
+
+// KeepHashFunctions returns a FilterCatalog predicate keeping the multihashes of the given hash functions, for
+// example multihash.SHA2_256.
+func KeepHashFunctions(codes ...uint64) func(multihash.Multihash) bool {
+	return func(mh multihash.Multihash) bool {
+		decoded, err := multihash.Decode(mh)
+		if err != nil {
+			return false
+		}
+		for _, code := range codes {
+			if decoded.Code == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// transformCatalog wraps inner with transform, which returns nil to skip a multihash if filtering. The overrides of
+// inner, if any, are kept on the outside.
+func transformCatalog(inner Catalog, filtering bool, transform func(multihash.Multihash) (multihash.Multihash, error)) Catalog {
+	if oc, ok := inner.(*OverriddenCatalog); ok {
+		return CatalogWithOverrides(transformCatalog(oc.Catalog, filtering, transform), oc.overrides)
+	}
+	return &TransformedCatalog{Catalog: inner, filtering: filtering, transform: transform}
+}
+
+var _ Catalog = &TransformedCatalog{}
+
+// TransformedCatalog is a catalog filtered or mapped by FilterCatalog or MapCatalog.
+type TransformedCatalog struct {
+	Catalog
+	filtering bool
+	transform func(multihash.Multihash) (multihash.Multihash, error)
+}
+
+// Count returns the count of the inner catalog if mapped, or -1 if filtered.
+func (c *TransformedCatalog) Count() int {
+	if c.filtering {
+		return -1
+	}
+	return c.Catalog.Count()
+}
+
+func (c *TransformedCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	inner, err := c.Catalog.Iterator(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &TransformedIterator{inner: inner, transform: c.transform}, nil
+}
+
+var _ FallibleMhIterator = &TransformedIterator{}
+
+// TransformedIterator iterates over the transformed multihashes of a TransformedCatalog.
+type TransformedIterator struct {
+	inner     MhIterator
+	transform func(multihash.Multihash) (multihash.Multihash, error)
+	next      multihash.Multihash
+	err       error
+}
+
+func (t *TransformedIterator) Next() multihash.Multihash {
+	if t.Done() {
+		panic("iterator already done")
+	}
+	next := t.next
+	t.next = nil
+	return next
+}
+
+func (t *TransformedIterator) Done() bool {
+	for t.next == nil && t.err == nil && !t.inner.Done() {
+		t.next, t.err = t.transform(t.inner.Next())
+	}
+	if t.err != nil {
+		t.next = nil
+	}
+	return t.next == nil
+}
+
+// Err returns the error of the transformation, or else of the inner iterator.
+func (t *TransformedIterator) Err() error {
+	if t.err != nil {
+		return t.err
+	}
+	return iteratorErr(t.inner)
+}
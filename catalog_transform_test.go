@@ -0,0 +1,80 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterCatalog(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	mhs := testMultihashes(4)
+	identity, err := multihash.Sum([]byte("inlined"), multihash.IDENTITY, -1)
+	require.NoError(t, err)
+	inner := testCatalog{MhCatalog: append([]multihash.Multihash{identity}, mhs...), id: []byte("foo")}
+
+	filtered := FilterCatalog(inner, KeepHashFunctions(multihash.SHA2_256))
+	require.Equal(t, []byte("foo"), filtered.ID())
+	require.Equal(t, -1, filtered.Count())
+
+	head, err := PublishWithContextID(ctx, cfg, backend, filtered)
+	require.NoError(t, err)
+	var published []multihash.Multihash
+	err = WalkEntries(ctx, backend, head, func(mh multihash.Multihash) error {
+		published = append(published, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, mhs, published)
+
+	// everything filtered out
+	iter, err := FilterCatalog(inner, func(multihash.Multihash) bool { return false }).Iterator(ctx)
+	require.NoError(t, err)
+	require.True(t, iter.Done())
+
+	// the overrides are kept
+	overridden := FilterCatalog(CatalogWithOverrides(inner, CatalogOverrides{Metadata: []byte("md")}), KeepHashFunctions(multihash.SHA2_256))
+	require.True(t, hasOverrides(overridden))
+	require.Equal(t, []byte("md"), overridden.(*OverriddenCatalog).Overrides().Metadata)
+	require.Equal(t, -1, overridden.Count())
+}
+
+func TestMapCatalog(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	mhs := testMultihashes(3)
+	var rehashed []multihash.Multihash
+	mapped := MapCatalog(testCatalog{MhCatalog: mhs, id: []byte("foo")}, func(mh multihash.Multihash) (multihash.Multihash, error) {
+		res, err := multihash.Sum(mh, multihash.SHA2_512, -1)
+		rehashed = append(rehashed, res)
+		return res, err
+	})
+	require.Equal(t, 3, mapped.Count())
+	head, err := PublishWithContextID(ctx, cfg, backend, mapped)
+	require.NoError(t, err)
+	var published []multihash.Multihash
+	err = WalkEntries(ctx, backend, head, func(mh multihash.Multihash) error {
+		published = append(published, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, rehashed, published)
+
+	// a failing mapping aborts the publication
+	failing := MapCatalog(testCatalog{MhCatalog: mhs, id: []byte("bar")}, func(mh multihash.Multihash) (multihash.Multihash, error) {
+		return nil, errors.New("boom")
+	})
+	_, err = PublishWithContextID(ctx, cfg, backend, failing)
+	require.ErrorContains(t, err, "boom")
+	current, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, current)
+}
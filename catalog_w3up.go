@@ -0,0 +1,241 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// W3upUpload is a single entry of a w3up "upload/list" result: the root CID of an upload and the CIDs of the CAR
+// shards that store it.
+type W3upUpload struct {
+	Root   cid.Cid
+	Shards []cid.Cid
+}
+
+// W3upUploadPage is a single page of a w3up "upload/list" result.
+type W3upUploadPage struct {
+	Results []W3upUpload
+	// Cursor is the cursor to pass to retrieve the next page. Empty if this is the last page.
+	Cursor string
+}
+
+// W3upUploadLister gives access to the "upload/list" capability of a w3up (web3.storage) space.
+// It is meant to be implemented on top of a w3up client, which hold the agent key and the delegation for the space.
+type W3upUploadLister interface {
+	// ListUploads returns a page of uploads of the given space, starting at cursor.
+	// An empty cursor means the first page.
+	ListUploads(ctx context.Context, space string, cursor string) (W3upUploadPage, error)
+}
+
+// W3upShardFetcher gives access to the content of the CAR shards of a w3up (web3.storage) space, for example through
+// an IPFS gateway or the w3s.link CAR endpoint.
+type W3upShardFetcher interface {
+	// FetchShard returns the CAR content of the shard, to be closed.
+	FetchShard(ctx context.Context, shard cid.Cid) (io.ReadCloser, error)
+}
+
+// CatalogFromW3up creates a catalog of the uploads of a w3up (web3.storage) space. It yields the multihashes of the
+// CAR shards of the uploads, and of the blocks they contain, read from the shards fetched with fetcher.
+// The uploads are listed and the shards fetched while iterating, one at a time. As for CatalogFromCar, identity
+// multihashes and duplicates are skipped.
+func CatalogFromW3up(lister W3upUploadLister, fetcher W3upShardFetcher, space string, id []byte, opts ...W3upCatalogOption) *W3upCatalog {
+	w := &W3upCatalog{lister: lister, fetcher: fetcher, space: space, id: id, count: -1}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.logger = orDefaultLogger(w.logger)
+	return w
+}
+
+// W3upCatalogOption is an optional configuration of a W3upCatalog.
+type W3upCatalogOption func(*W3upCatalog)
+
+// WithW3upLogger sets the logger of the catalog, instead of the default one.
+func WithW3upLogger(l *zap.SugaredLogger) W3upCatalogOption {
+	return func(w *W3upCatalog) {
+		w.logger = l
+	}
+}
+
+var _ Catalog = &W3upCatalog{}
+
+type W3upCatalog struct {
+	lister  W3upUploadLister
+	fetcher W3upShardFetcher
+	space   string
+	id      []byte
+	logger  *zap.SugaredLogger
+
+	mu    sync.Mutex
+	count int // -1 until iterated completely
+}
+
+func (w *W3upCatalog) ID() []byte {
+	return w.id
+}
+
+// Count returns the number of distinct multihashes, or -1 (unknown) until the catalog was iterated completely once,
+// as counting requires fetching all the shards of the space.
+func (w *W3upCatalog) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *W3upCatalog) Iterator(ctx context.Context) (MhIterator, error) {
+	return &W3upIterator{
+		ctx:        ctx,
+		catalog:    w,
+		seenShards: make(map[cid.Cid]struct{}),
+		seen:       make(map[string]struct{}),
+	}, nil
+}
+
+var _ FallibleMhIterator = &W3upIterator{}
+
+// W3upIterator lists the uploads of a w3up space page by page, and reads the blocks of their shards one shard at a
+// time. The failures of the remote APIs stop the iteration, and are reported by Err.
+type W3upIterator struct {
+	ctx     context.Context
+	catalog *W3upCatalog
+
+	cursor   string
+	lastPage bool
+	uploads  []W3upUpload // remaining uploads of the current page
+	shards   []cid.Cid    // remaining shards of the current upload
+
+	// shard is the shard being read by blocks, closed by closer
+	shard  cid.Cid
+	blocks *carv2.BlockReader
+	closer io.Closer
+
+	seenShards map[cid.Cid]struct{}
+	seen       map[string]struct{}
+	next       multihash.Multihash
+	count      int
+	done       bool
+	err        error
+}
+
+func (w *W3upIterator) Next() multihash.Multihash {
+	if w.Done() {
+		panic("iterator already done")
+	}
+	next := w.next
+	w.next = nil
+	return next
+}
+
+func (w *W3upIterator) Done() bool {
+	for w.next == nil && !w.done {
+		mh, err := w.advance()
+		if err != nil || mh == nil {
+			w.finish(err)
+			break
+		}
+		if _, ok := w.seen[string(mh)]; ok {
+			continue
+		}
+		w.seen[string(mh)] = struct{}{}
+		if decoded, err := multihash.Decode(mh); err == nil && decoded.Code != multihash.IDENTITY {
+			w.next = mh
+			w.count++
+		}
+	}
+	return w.next == nil
+}
+
+func (w *W3upIterator) Err() error {
+	return w.err
+}
+
+// advance returns the next multihash of the space, duplicates included, or nil once all the uploads are read.
+func (w *W3upIterator) advance() (multihash.Multihash, error) {
+	for {
+		if err := w.ctx.Err(); err != nil {
+			return nil, err
+		}
+		switch {
+		case w.blocks != nil:
+			block, err := w.blocks.SkipNext()
+			if errors.Is(err, io.EOF) {
+				w.closeShard()
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("indexing shard %s: %w", w.shard, err)
+			}
+			return block.Cid.Hash(), nil
+		case len(w.shards) > 0:
+			shard := w.shards[0]
+			w.shards = w.shards[1:]
+			if _, ok := w.seenShards[shard]; ok || !shard.Defined() {
+				continue
+			}
+			w.seenShards[shard] = struct{}{}
+			if err := w.openShard(shard); err != nil {
+				return nil, fmt.Errorf("indexing shard %s: %w", shard, err)
+			}
+			return shard.Hash(), nil
+		case len(w.uploads) > 0:
+			w.shards = w.uploads[0].Shards
+			w.uploads = w.uploads[1:]
+		case w.lastPage:
+			return nil, nil
+		default:
+			page, err := w.catalog.lister.ListUploads(w.ctx, w.catalog.space, w.cursor)
+			if err != nil {
+				return nil, err
+			}
+			w.uploads = page.Results
+			w.lastPage = page.Cursor == "" || page.Cursor == w.cursor
+			w.cursor = page.Cursor
+		}
+	}
+}
+
+// openShard fetches the shard, to read its blocks.
+func (w *W3upIterator) openShard(shard cid.Cid) error {
+	r, err := w.catalog.fetcher.FetchShard(w.ctx, shard)
+	if err != nil {
+		return err
+	}
+	br, err := carv2.NewBlockReader(r)
+	if err != nil {
+		_ = r.Close()
+		return err
+	}
+	w.shard, w.blocks, w.closer = shard, br, r
+	return nil
+}
+
+func (w *W3upIterator) closeShard() {
+	if w.closer != nil {
+		if err := w.closer.Close(); err != nil {
+			w.catalog.logger.Debugw("failed to close w3up shard", "shard", w.shard, "err", err)
+		}
+	}
+	w.shard, w.blocks, w.closer = cid.Undef, nil, nil
+}
+
+// finish ends the iteration with err, if any. A complete iteration gives the count of the catalog.
+func (w *W3upIterator) finish(err error) {
+	w.closeShard()
+	w.done = true
+	w.err = err
+	if err == nil {
+		w.catalog.mu.Lock()
+		w.catalog.count = w.count
+		w.catalog.mu.Unlock()
+		w.catalog.logger.Infow("Indexed w3up space", "space", w.catalog.space, "shards", len(w.seenShards), "mhCount", w.count)
+	}
+	w.seen, w.seenShards = nil, nil
+}
@@ -0,0 +1,109 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeW3upLister struct {
+	pages map[string]W3upUploadPage
+}
+
+func (f fakeW3upLister) ListUploads(_ context.Context, _ string, cursor string) (W3upUploadPage, error) {
+	return f.pages[cursor], nil
+}
+
+type fakeW3upFetcher struct {
+	shards map[cid.Cid][]byte
+}
+
+func (f fakeW3upFetcher) FetchShard(_ context.Context, shard cid.Cid) (io.ReadCloser, error) {
+	data, ok := f.shards[shard]
+	if !ok {
+		return nil, errors.New("shard not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// makeW3upShard writes the blocks as a CAR shard, and returns its CID and content.
+func makeW3upShard(t *testing.T, blks ...blocks.Block) (cid.Cid, []byte) {
+	path := filepath.Join(t.TempDir(), "shard.car")
+	rw, err := blockstore.OpenReadWrite(path, []cid.Cid{blks[0].Cid()}, carv2.WriteAsCarV1(true))
+	require.NoError(t, err)
+	for _, blk := range blks {
+		require.NoError(t, rw.Put(context.Background(), blk))
+	}
+	require.NoError(t, rw.Finalize())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	h, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	return cid.NewCidV1(uint64(multicodec.Car), h), data
+}
+
+func TestW3upCatalog(t *testing.T) {
+	a := blocks.NewBlock([]byte("a"))
+	b := blocks.NewBlock([]byte("b"))
+	c := blocks.NewBlock([]byte("c"))
+	shard1, data1 := makeW3upShard(t, a, b)
+	// b is stored in both shards, and should not be duplicated
+	shard2, data2 := makeW3upShard(t, c, b)
+
+	lister := fakeW3upLister{pages: map[string]W3upUploadPage{
+		"": {
+			Results: []W3upUpload{
+				{Root: a.Cid(), Shards: []cid.Cid{shard1}},
+			},
+			Cursor: "next",
+		},
+		"next": {
+			Results: []W3upUpload{
+				// shard 1 is shared between uploads and should not be read again
+				{Root: c.Cid(), Shards: []cid.Cid{shard1, shard2}},
+			},
+		},
+	}}
+	fetcher := fakeW3upFetcher{shards: map[cid.Cid][]byte{shard1: data1, shard2: data2}}
+
+	id := []byte("fooo")
+	cat := CatalogFromW3up(lister, fetcher, "did:key:space", id)
+	require.Equal(t, id, cat.ID())
+	// unknown until iterated, which fetches the shards
+	require.Equal(t, -1, cat.Count())
+	require.ElementsMatch(t, []multihash.Multihash{
+		shard1.Hash(), shard2.Hash(), a.Cid().Hash(), b.Cid().Hash(), c.Cid().Hash(),
+	}, iterateCatalog(t, cat))
+	require.Equal(t, 5, cat.Count())
+
+	// a shard that can't be fetched fails the iteration
+	fetcher = fakeW3upFetcher{shards: map[cid.Cid][]byte{shard1: data1}}
+	cat = CatalogFromW3up(lister, fetcher, "did:key:space", id)
+	iter, err := cat.Iterator(context.Background())
+	require.NoError(t, err)
+	for !iter.Done() {
+		iter.Next()
+	}
+	require.ErrorContains(t, iteratorErr(iter), shard2.String())
+	require.Equal(t, -1, cat.Count())
+
+	// the caller's context stops the iteration
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	iter, err = CatalogFromW3up(lister, fetcher, "did:key:space", id).Iterator(ctx)
+	require.NoError(t, err)
+	require.True(t, iter.Done())
+	require.ErrorIs(t, iteratorErr(iter), context.Canceled)
+}
@@ -0,0 +1,189 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// ExportChainToCar writes the whole IPNI chain, advertisements and entry chunks, as an indexed CARv2 whose root is
+// the head of the chain. The blocks are written from the head to the genesis, each advertisement followed by its
+// entry chunks.
+//
+// If w implements io.WriterAt, like an *os.File, the CAR is written directly from its offset 0. Otherwise, it is
+// first written to a temporary file, as the CARv2 header can only be written once the blocks are.
+func ExportChainToCar(ctx context.Context, backend ChainReader, w io.Writer, opts ...OperationOption) error {
+	head, err := backend.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	if !head.Defined() {
+		return errors.New("the chain is empty")
+	}
+
+	if _, ok := w.(io.WriterAt); ok {
+		return writeChainCar(ctx, backend, head, w, operationLogger(opts))
+	}
+
+	tmp, err := os.CreateTemp("", "herald-export-*.car")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := writeChainCar(ctx, backend, head, tmp, operationLogger(opts)); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+	return err
+}
+
+// writeChainCar writes the chain starting at head as a CARv2 into w, which must implement io.WriterAt.
+func writeChainCar(ctx context.Context, backend ChainReader, head cid.Cid, w io.Writer, logger *zap.SugaredLogger) error {
+	out, err := storage.NewWritable(w, []cid.Cid{head})
+	if err != nil {
+		return err
+	}
+
+	// the entry chunks can be shared between advertisements
+	seen := make(map[cid.Cid]struct{})
+	put := func(c cid.Cid) ([]byte, error) {
+		data, err := backend.GetContent(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block %s: %w", c, err)
+		}
+		if _, ok := seen[c]; ok {
+			return data, nil
+		}
+		seen[c] = struct{}{}
+		return data, out.Put(ctx, c.KeyString(), data)
+	}
+
+	var ads, chunks int
+	for next := head; next.Defined(); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := put(next)
+		if err != nil {
+			return err
+		}
+		ad, err := schema.BytesToAdvertisement(next, data)
+		if err != nil {
+			return err
+		}
+		ads++
+
+		if _, ok := seen[linkCid(ad.Entries)]; !ok {
+			err = WalkEntryBlocks(ctx, backend, ad.Entries, func(c cid.Cid, data []byte, _ []multihash.Multihash) error {
+				if _, ok := seen[c]; ok {
+					return nil
+				}
+				seen[c] = struct{}{}
+				chunks++
+				return out.Put(ctx, c.KeyString(), data)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		next = ad.PreviousCid()
+	}
+
+	if err := out.Finalize(); err != nil {
+		return err
+	}
+	logger.Infow("Exported the chain to CAR", "head", head, "ads", ads, "chunks", chunks)
+	return nil
+}
+
+// ImportChainFromCar stores all the blocks of a CAR, as written by ExportChainToCar, into backend, then sets the
+// head of the chain to the root of the CAR. Both CARv1 and CARv2 are accepted, and the blocks are verified against
+// their CID.
+//
+// The backend must not hold another chain already: importing is meant for restoring a backup or migrating to a new
+// backend. Importing the same chain again is a no-op. It returns the head of the imported chain.
+func ImportChainFromCar(ctx context.Context, backend ChainWriter, r io.Reader, opts ...OperationOption) (cid.Cid, error) {
+	br, err := car.NewBlockReader(r, car.WithTrustedCAR(false))
+	if err != nil {
+		return cid.Undef, err
+	}
+	if len(br.Roots) != 1 {
+		return cid.Undef, fmt.Errorf("expected a single root in the CAR, got %d", len(br.Roots))
+	}
+	head := br.Roots[0]
+
+	var count int
+	var hasHead bool
+	for {
+		if err := ctx.Err(); err != nil {
+			return cid.Undef, err
+		}
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := storeRawBlock(ctx, backend, blk.Cid(), blk.RawData()); err != nil {
+			return cid.Undef, err
+		}
+		hasHead = hasHead || blk.Cid() == head
+		count++
+	}
+	if !hasHead {
+		return cid.Undef, fmt.Errorf("the head %s is missing from the CAR", head)
+	}
+
+	// the blocks are all stored before the head is exposed
+	err = backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		if prevHead.Defined() && prevHead != head {
+			return cid.Undef, fmt.Errorf("the backend already holds a chain, with head %s", prevHead)
+		}
+		return head, nil
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	operationLogger(opts).Infow("Imported the chain from CAR", "head", head, "blocks", count)
+	return head, nil
+}
+
+// storeRawBlock stores an encoded block through ChainWriter.Store, which only accepts IPLD nodes: the block is
+// decoded and re-encoded with the codec and hash of its CID, which must yield the same CID.
+func storeRawBlock(ctx context.Context, backend ChainWriter, c cid.Cid, data []byte) error {
+	decoder, err := multicodec.LookupDecoder(c.Prefix().Codec)
+	if err != nil {
+		return fmt.Errorf("block %s: %w", c, err)
+	}
+	node, err := ipld.DecodeUsingPrototype(data, decoder, basicnode.Prototype.Any)
+	if err != nil {
+		return fmt.Errorf("failed to decode block %s: %w", c, err)
+	}
+	lnk, err := backend.Store(ipld.LinkContext{Ctx: ctx}, cidlink.LinkPrototype{Prefix: c.Prefix()}, node)
+	if err != nil {
+		return err
+	}
+	if linkCid(lnk) != c {
+		return fmt.Errorf("block %s is not canonically encoded, stored as %s", c, linkCid(lnk))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-car/v2/blockstore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainCar(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	source := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	mhs := testMultihashes(10)
+
+	_, err := PublishWithContextID(ctx, cfg, source, testCatalog{MhCatalog: mhs[0:5], id: []byte("a")})
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, source, CatalogFromMultihashes(mhs[5:10]...))
+	require.NoError(t, err)
+	head, err := RetractWithContextID(ctx, cfg, source, testCatalog{id: []byte("a")})
+	require.NoError(t, err)
+
+	// not seekable: goes through a temporary file
+	var buf bytes.Buffer
+	require.NoError(t, ExportChainToCar(ctx, source, &buf))
+
+	// seekable: written directly, and readable as an indexed CARv2
+	path := filepath.Join(t.TempDir(), "chain.car")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, ExportChainToCar(ctx, source, f))
+	require.NoError(t, f.Close())
+	robs, err := blockstore.OpenReadOnly(path)
+	require.NoError(t, err)
+	defer robs.Close()
+	roots, err := robs.Roots()
+	require.NoError(t, err)
+	require.Equal(t, head, roots[0])
+	// 3 advertisements, and 3+3 entry chunks
+	keys, err := robs.AllKeysChan(ctx)
+	require.NoError(t, err)
+	var count int
+	for range keys {
+		count++
+	}
+	require.Equal(t, 9, count)
+
+	dest := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	imported, err := ImportChainFromCar(ctx, dest, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, head, imported)
+
+	report, err := VerifyChain(ctx, dest, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, 3, report.Ads)
+	require.Equal(t, 6, report.Chunks)
+
+	// importing again is a no-op
+	_, err = ImportChainFromCar(ctx, dest, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	// but not over another chain
+	other := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	_, err = PublishRawMHs(ctx, cfg, other, CatalogFromMultihashes(mhs[0]))
+	require.NoError(t, err)
+	_, err = ImportChainFromCar(ctx, other, bytes.NewReader(buf.Bytes()))
+	require.Error(t, err)
+}
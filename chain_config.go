@@ -0,0 +1,152 @@
+package herald
+
+import (
+	"errors"
+	"fmt"
+
+	_ "github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+)
+
+// ErrInvalidConfig is returned by ChainConfig.Validate, and by the publish functions given an invalid ChainConfig.
+var ErrInvalidConfig = errors.New("invalid chain configuration")
+
+// DefaultChainConfig returns a ChainConfig with the default chunking and the Bitswap metadata. The identity of the
+// publisher and the provider addresses are left to the caller.
+func DefaultChainConfig() ChainConfig {
+	bitswap := metadata.Default.New(metadata.Bitswap{})
+	md, _ := bitswap.MarshalBinary()
+	return ChainConfig{
+		AdEntriesChunkSize: DefaultAdEntriesChunkSize,
+		EntriesChunkBytes:  DefaultEntriesChunkBytes,
+		Metadata:           md,
+	}
+}
+
+// Validate checks the configuration before anything is published with it:
+//   - the chunking limits are within bounds,
+//   - PublisherID matches PublisherKey or Signer, and ProviderID matches ProviderKey,
+//   - Codec is dag-json or dag-cbor,
+//   - ProviderAddrs are valid and publishable multiaddrs,
+//   - PublisherHttpAddrs are HTTP multiaddrs and PublisherLibp2pAddrs libp2p ones, see ValidatePublisherAddrs,
+//   - Metadata is set, as required to publish.
+//
+// The errors wrap ErrInvalidConfig, and ErrIdentityMismatch for the identities.
+func (cfg ChainConfig) Validate() error {
+	return cfg.validate(false)
+}
+
+// validate is Validate, not requiring Metadata nor valid ProviderAddrs if retracting, as the indexers ignore them for
+// the retractions.
+func (cfg ChainConfig) validate(retracting bool) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.AdEntriesChunkSize < 0 {
+		return invalid("negative AdEntriesChunkSize %d", cfg.AdEntriesChunkSize)
+	}
+	if cfg.EntriesChunkBytes < 0 || (cfg.EntriesChunkBytes > 0 && cfg.EntriesChunkBytes <= 2*entriesChunkOverhead) {
+		return invalid("EntriesChunkBytes %d is too small for an entry chunk", cfg.EntriesChunkBytes)
+	}
+	if cfg.MaxMHsPerAdvertisement < 0 {
+		return invalid("negative MaxMHsPerAdvertisement %d", cfg.MaxMHsPerAdvertisement)
+	}
+
+	switch cfg.Codec {
+	case 0, multicodec.DagJson, multicodec.DagCbor:
+	default:
+		return invalid("unsupported Codec %s, only dag-json and dag-cbor are", cfg.Codec)
+	}
+
+	if cfg.PublisherID == "" {
+		return invalid("PublisherID is required")
+	}
+	switch {
+	case cfg.Signer != nil:
+		if err := checkIdentity(cfg.PublisherID, signerPrivKey(cfg.Signer)); err != nil {
+			return fmt.Errorf("%w: publisher signer: %w", ErrInvalidConfig, err)
+		}
+	case cfg.PublisherKey != nil:
+		if err := checkIdentity(cfg.PublisherID, cfg.PublisherKey); err != nil {
+			return fmt.Errorf("%w: publisher key: %w", ErrInvalidConfig, err)
+		}
+	default:
+		return invalid("PublisherKey or Signer is required")
+	}
+	if cfg.ProviderKey != nil {
+		provider := cfg.ProviderID
+		if provider == "" {
+			provider = cfg.PublisherID
+		}
+		if err := checkIdentity(provider, cfg.ProviderKey); err != nil {
+			return fmt.Errorf("%w: provider key: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	// a retraction must go through whatever the addresses, which the indexers ignore for it
+	if !retracting {
+		if err := ValidateProviderAddrs(cfg.ProviderAddrs); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+	for _, a := range cfg.PublisherHttpAddrs {
+		if a != nil && !isHttpAddr(a) {
+			return invalid("publisher address %s is not an HTTP address", a)
+		}
+	}
+	for _, a := range cfg.PublisherLibp2pAddrs {
+		if a != nil && isHttpAddr(a) {
+			return invalid("publisher address %s is an HTTP address, not a libp2p one", a)
+		}
+	}
+	if err := ValidatePublisherAddrs(cfg.PublisherAddrs(), cfg.PublisherID); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	if !retracting && len(cfg.Metadata) == 0 {
+		return invalid("Metadata is required to publish")
+	}
+	return nil
+}
+
+// isHttpAddr returns true if the multiaddr has an http or https component.
+func isHttpAddr(a multiaddr.Multiaddr) bool {
+	for _, p := range a.Protocols() {
+		if p.Code == multiaddr.P_HTTP || p.Code == multiaddr.P_HTTPS {
+			return true
+		}
+	}
+	return false
+}
+
+// provider returns the provider of the advertisements, ProviderID or else PublisherID.
+func (cfg ChainConfig) provider() peer.ID {
+	if cfg.ProviderID != "" {
+		return cfg.ProviderID
+	}
+	return cfg.PublisherID
+}
+
+// PublisherAddrs returns the addresses announced to the indexers: PublisherHttpAddrs then PublisherLibp2pAddrs.
+func (cfg ChainConfig) PublisherAddrs() []multiaddr.Multiaddr {
+	addrs := make([]multiaddr.Multiaddr, 0, len(cfg.PublisherHttpAddrs)+len(cfg.PublisherLibp2pAddrs))
+	addrs = append(addrs, cfg.PublisherHttpAddrs...)
+	return append(addrs, cfg.PublisherLibp2pAddrs...)
+}
+
+// linkPrototype returns the prototype of the links to the blocks generated with cfg, following cfg.Codec.
+func (cfg ChainConfig) linkPrototype() datamodel.LinkPrototype {
+	if cfg.Codec == 0 || cfg.Codec == multicodec.DagJson {
+		return schema.Linkproto
+	}
+	prefix := schema.Linkproto.Prefix
+	prefix.Codec = uint64(cfg.Codec)
+	return cidlink.LinkPrototype{Prefix: prefix}
+}
@@ -0,0 +1,91 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainConfigValidate(t *testing.T) {
+	cfg := testChainConfig(t)
+	require.NoError(t, cfg.Validate())
+
+	otherID, otherKey, err := GenerateProviderIdentity()
+	require.NoError(t, err)
+
+	mismatch := cfg
+	mismatch.PublisherID = otherID
+	require.ErrorIs(t, mismatch.Validate(), ErrIdentityMismatch)
+
+	// signed by the provider itself
+	provider := cfg
+	provider.ProviderID, provider.ProviderKey = otherID, otherKey
+	require.NoError(t, provider.Validate())
+
+	// a provider key without matching provider produces invalid advertisements, which are refused
+	mismatch = cfg
+	mismatch.ProviderKey = otherKey
+	require.ErrorIs(t, mismatch.Validate(), ErrIdentityMismatch)
+	_, err = PublishRawMHs(context.Background(), mismatch, NewMemoryBackend(), CatalogFromMultihashes(testMultihashes(1)...))
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	require.ErrorIs(t, err, ErrIdentityMismatch)
+	require.Equal(t, ErrorClassConfig, ClassifyError(err))
+
+	for name, modify := range map[string]func(cfg *ChainConfig){
+		"negative chunk size":      func(cfg *ChainConfig) { cfg.AdEntriesChunkSize = -1 },
+		"tiny chunk bytes":         func(cfg *ChainConfig) { cfg.EntriesChunkBytes = 10 },
+		"no publisher key":         func(cfg *ChainConfig) { cfg.PublisherKey = nil },
+		"no publisher ID":          func(cfg *ChainConfig) { cfg.PublisherID = "" },
+		"no provider address":      func(cfg *ChainConfig) { cfg.ProviderAddrs = nil },
+		"invalid provider address": func(cfg *ChainConfig) { cfg.ProviderAddrs = []string{"not a multiaddr"} },
+		"non-HTTP publisher address": func(cfg *ChainConfig) {
+			cfg.PublisherHttpAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/80")}
+		},
+		"unspecified publisher address": func(cfg *ChainConfig) {
+			cfg.PublisherHttpAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/0.0.0.0/tcp/80/http")}
+		},
+		"HTTP address as libp2p publisher address": func(cfg *ChainConfig) {
+			cfg.PublisherLibp2pAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/80/http")}
+		},
+		"libp2p publisher address without transport": func(cfg *ChainConfig) {
+			cfg.PublisherLibp2pAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4")}
+		},
+		"libp2p publisher address of another peer": func(cfg *ChainConfig) {
+			cfg.PublisherLibp2pAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + otherID.String())}
+		},
+		"no metadata":       func(cfg *ChainConfig) { cfg.Metadata = nil },
+		"unsupported codec": func(cfg *ChainConfig) { cfg.Codec = multicodec.Raw },
+	} {
+		t.Run(name, func(t *testing.T) {
+			invalid := testChainConfig(t)
+			modify(&invalid)
+			require.ErrorIs(t, invalid.Validate(), ErrInvalidConfig)
+		})
+	}
+
+	// the retractions don't need metadata
+	ctx := context.Background()
+	backend := NewMemoryBackend()
+	_, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte("foo")})
+	require.NoError(t, err)
+	noMetadata := cfg
+	noMetadata.Metadata = nil
+	_, err = PublishWithContextID(ctx, noMetadata, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte("bar")})
+	require.ErrorIs(t, err, ErrInvalidConfig)
+	_, err = RetractContextIDs(ctx, noMetadata, backend, []CatalogID{CatalogID("foo")})
+	require.NoError(t, err)
+
+	// the defaults only lack the identity and the addresses
+	defaults := DefaultChainConfig()
+	defaults.PublisherKey, defaults.PublisherID = cfg.PublisherKey, cfg.PublisherID
+	defaults.ProviderAddrs = cfg.ProviderAddrs
+	defaults.PublisherHttpAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/dns4/example.com/tcp/443/https")}
+	defaults.PublisherLibp2pAddrs = []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + cfg.PublisherID.String()),
+		multiaddr.StringCast("/dnsaddr/example.com"),
+	}
+	require.NoError(t, defaults.Validate())
+}
@@ -0,0 +1,240 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	mhreg "github.com/multiformats/go-multihash/core"
+	"go.uber.org/zap"
+)
+
+// maxHttpBlockSize is the maximum size of a block fetched from a remote publisher.
+// The IPNI specification recommends entry chunks to stay below 4MB.
+const maxHttpBlockSize = 8 << 20
+
+var _ ChainReader = &HttpChainReader{}
+var _ ContentStreamer = &HttpChainReader{}
+
+// HttpChainReader is a read access to the IPNI chain of a remote HTTP publisher.
+// The blocks fetched are verified against their CID.
+type HttpChainReader struct {
+	baseUrl     string
+	publisherID peer.ID
+	client      *http.Client
+
+	// pollInterval is the interval at which the head is polled for SubscribeHead
+	pollInterval time.Duration
+
+	logger *zap.SugaredLogger
+}
+
+// HttpChainReaderOption is an optional configuration for the HttpChainReader.
+type HttpChainReaderOption func(*HttpChainReader)
+
+// WithHttpChainReaderLogger sets the logger of the reader, instead of the default one.
+func WithHttpChainReaderLogger(l *zap.SugaredLogger) HttpChainReaderOption {
+	return func(h *HttpChainReader) {
+		h.logger = l
+	}
+}
+
+// NewHttpChainReader creates a ChainReader for the chain served at baseUrl, where the head is available at
+// baseUrl + "/head" and the blocks at baseUrl + "/<cid>", typically "http://host:port/ipni/v1/ad".
+// If publisherID is not empty, the signature of the head is verified to match.
+func NewHttpChainReader(baseUrl string, publisherID peer.ID, opts ...HttpChainReaderOption) *HttpChainReader {
+	h := &HttpChainReader{
+		baseUrl:      strings.TrimSuffix(baseUrl, "/"),
+		publisherID:  publisherID,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		pollInterval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.logger = orDefaultLogger(h.logger)
+	return h
+}
+
+// GetHead return the cid of the IPNI chain head
+// Returns cid.Undef if the chain hasn't started yet.
+func (h *HttpChainReader) GetHead(ctx context.Context) (cid.Cid, error) {
+	body, status, err := h.get(ctx, "head")
+	if err != nil {
+		return cid.Undef, err
+	}
+	defer body.Close()
+	switch status {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return cid.Undef, nil
+	default:
+		return cid.Undef, fmt.Errorf("unexpected HTTP status %d while fetching head", status)
+	}
+
+	signedHead, err := head.Decode(io.LimitReader(body, maxHttpBlockSize))
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid head message: %w", err)
+	}
+	signer, err := signedHead.Validate()
+	if err != nil {
+		return cid.Undef, fmt.Errorf("invalid head signature: %w", err)
+	}
+	if h.publisherID != "" && signer != h.publisherID {
+		return cid.Undef, fmt.Errorf("head is signed by %s, expected %s", signer, h.publisherID)
+	}
+	return linkCid(signedHead.Head), nil
+}
+
+// GetContent returns the raw content of an IPLD block of the IPNI chain.
+// Returns ErrContentNotFound if not found.
+func (h *HttpChainReader) GetContent(ctx context.Context, c cid.Cid) ([]byte, error) {
+	body, status, err := h.get(ctx, c.String())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	switch status {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrContentNotFound
+	default:
+		return nil, fmt.Errorf("unexpected HTTP status %d while fetching %s", status, c)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxHttpBlockSize))
+	if err != nil {
+		return nil, err
+	}
+
+	// the remote is not trusted, verify the content
+	computed, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !computed.Equals(c) {
+		return nil, fmt.Errorf("content of %s doesn't match its CID", c)
+	}
+	return data, nil
+}
+
+// GetContentStream returns the raw content of an IPLD block of the IPNI chain as a stream. The content is verified
+// against its CID while read: reading a mismatching content fails at its end.
+// Returns ErrContentNotFound if not found.
+func (h *HttpChainReader) GetContentStream(ctx context.Context, c cid.Cid) (io.ReadCloser, int64, error) {
+	resp, err := h.getResponse(ctx, c.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, 0, ErrContentNotFound
+	default:
+		_ = resp.Body.Close()
+		return nil, 0, fmt.Errorf("unexpected HTTP status %d while fetching %s", resp.StatusCode, c)
+	}
+	prefix := c.Prefix()
+	hasher, err := mhreg.GetVariableHasher(prefix.MhType, prefix.MhLength)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, 0, err
+	}
+	return &verifyingReader{
+		Reader: io.LimitReader(resp.Body, maxHttpBlockSize),
+		body:   resp.Body,
+		hasher: hasher,
+		cid:    c,
+	}, resp.ContentLength, nil
+}
+
+// verifyingReader hashes a block while it's read, and fails at its end if it doesn't match its CID.
+type verifyingReader struct {
+	io.Reader
+	body   io.Closer
+	hasher hash.Hash
+	cid    cid.Cid
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.Reader.Read(p)
+	v.hasher.Write(p[:n])
+	if err == io.EOF {
+		prefix := v.cid.Prefix()
+		digest := v.hasher.Sum(nil)
+		if prefix.MhLength >= 0 && prefix.MhLength < len(digest) {
+			digest = digest[:prefix.MhLength]
+		}
+		computed, encErr := multihash.Encode(digest, prefix.MhType)
+		if encErr != nil {
+			return n, encErr
+		}
+		if !bytes.Equal(computed, v.cid.Hash()) {
+			return n, fmt.Errorf("content of %s doesn't match its CID", v.cid)
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
+
+// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+// The remote head is polled, so intermediate heads between two polls are not observed.
+func (h *HttpChainReader) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	ch := make(chan cid.Cid, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(h.pollInterval)
+		defer ticker.Stop()
+
+		last := cid.Undef
+		for {
+			current, err := h.GetHead(ctx)
+			if err != nil {
+				h.logger.Warnw("failed to poll remote head", "url", h.baseUrl, "err", err)
+			} else if current.Defined() && !current.Equals(last) {
+				last = current
+				select {
+				case <-ch:
+				default:
+				}
+				ch <- current
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (h *HttpChainReader) get(ctx context.Context, path string) (io.ReadCloser, int, error) {
+	resp, err := h.getResponse(ctx, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.StatusCode, nil
+}
+
+func (h *HttpChainReader) getResponse(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.baseUrl+"/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.client.Do(req)
+}
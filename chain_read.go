@@ -0,0 +1,239 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+)
+
+// LoadAdvertisement fetches and decodes the advertisement adCid from the backend.
+func LoadAdvertisement(ctx context.Context, backend ChainReader, adCid cid.Cid) (schema.Advertisement, error) {
+	data, err := backend.GetContent(ctx, adCid)
+	if err != nil {
+		return schema.Advertisement{}, err
+	}
+	ad, err := schema.BytesToAdvertisement(adCid, data)
+	if err != nil {
+		return schema.Advertisement{}, fmt.Errorf("failed to decode advertisement %s: %w", adCid, err)
+	}
+	return ad, nil
+}
+
+// LoadEntryChunk fetches and decodes the entry chunk chunkCid from the backend.
+func LoadEntryChunk(ctx context.Context, backend ChainReader, chunkCid cid.Cid) (schema.EntryChunk, error) {
+	data, err := backend.GetContent(ctx, chunkCid)
+	if err != nil {
+		return schema.EntryChunk{}, err
+	}
+	chunk, err := schema.BytesToEntryChunk(chunkCid, data)
+	if err != nil {
+		return schema.EntryChunk{}, fmt.Errorf("failed to decode entry chunk %s: %w", chunkCid, err)
+	}
+	return chunk, nil
+}
+
+// WalkEntries decodes the advertisement adCid and calls fn for every multihash of its entries, in chain order for
+// entry chunks. Advertisements without entries (retraction by ContextID, metadata update) yield nothing.
+// Iteration stops at the first error returned by fn.
+func WalkEntries(ctx context.Context, backend ChainReader, adCid cid.Cid, fn func(mh multihash.Multihash) error) error {
+	ad, err := LoadAdvertisement(ctx, backend, adCid)
+	if err != nil {
+		return err
+	}
+	return WalkEntryBlocks(ctx, backend, ad.Entries, func(_ cid.Cid, _ []byte, mhs []multihash.Multihash) error {
+		for _, mh := range mhs {
+			if err := fn(mh); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WalkAds calls fn for every advertisement of the chain, decoded, from the head to the genesis.
+// Returning ErrStopWalk from fn stops the walk without error.
+func WalkAds(ctx context.Context, backend ChainReader, fn func(adCid cid.Cid, ad schema.Advertisement) error) error {
+	head, err := backend.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	return WalkAdsFrom(ctx, backend, head, fn)
+}
+
+// WalkAdsFrom calls fn for every advertisement of the chain, from the given head to the genesis.
+// Returning ErrStopWalk from fn stops the walk without error.
+func WalkAdsFrom(ctx context.Context, backend ChainReader, head cid.Cid, fn func(adCid cid.Cid, ad schema.Advertisement) error) error {
+	for next := head; next.Defined(); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ad, err := LoadAdvertisement(ctx, backend, next)
+		if err != nil {
+			return err
+		}
+		if err := fn(next, ad); errors.Is(err, ErrStopWalk) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		next = ad.PreviousCid()
+	}
+	return nil
+}
+
+// ErrStopWalk can be returned by a walk callback to stop the walk without error.
+var ErrStopWalk = errors.New("stop walk")
+
+// ContextIDHistory returns the advertisements of the given ContextID, as found in the advertisements (see
+// NamespacedContextID), from the most recent to the oldest. The first one tells the current state of the ContextID:
+// published with its entries and metadata, or retracted. The multihashes of each can be read with WalkEntries.
+func ContextIDHistory(ctx context.Context, backend ChainReader, contextID CatalogID) ([]cid.Cid, error) {
+	var history []cid.Cid
+	err := WalkAds(ctx, backend, func(adCid cid.Cid, ad schema.Advertisement) error {
+		if bytes.Equal(ad.ContextID, contextID) {
+			history = append(history, adCid)
+		}
+		return nil
+	})
+	return history, err
+}
+
+// WalkEntryChunks calls fn for every entry chunk of the chain starting at entries.
+// Returning ErrStopWalk from fn stops the walk without error.
+func WalkEntryChunks(ctx context.Context, backend ChainReader, entries ipld.Link, fn func(chunkCid cid.Cid, chunk schema.EntryChunk) error) error {
+	next := linkCid(entries)
+	for next.Defined() && next != schema.NoEntries.Cid {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		chunk, err := LoadEntryChunk(ctx, backend, next)
+		if err != nil {
+			return err
+		}
+		if err := fn(next, chunk); errors.Is(err, ErrStopWalk) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		next = cid.Undef
+		if chunk.Next != nil {
+			next = linkCid(chunk.Next)
+		}
+	}
+	return nil
+}
+
+func linkCid(lnk ipld.Link) cid.Cid {
+	if lnk == nil {
+		return cid.Undef
+	}
+	if cl, ok := lnk.(cidlink.Link); ok {
+		return cl.Cid
+	}
+	return cid.Undef
+}
+
+// WalkEntryBlocks calls fn for every block of the entries starting at entries, whatever their format: the entry
+// chunks in chain order, or the nodes of a HAMT depth-first. fn receives the raw block and the multihashes it holds.
+// Returning ErrStopWalk from fn stops the walk without error.
+func WalkEntryBlocks(ctx context.Context, backend ChainReader, entries ipld.Link, fn func(blockCid cid.Cid, data []byte, mhs []multihash.Multihash) error) error {
+	first := linkCid(entries)
+	if !first.Defined() || first == schema.NoEntries.Cid {
+		return nil
+	}
+	data, err := readEntryBlock(ctx, backend, first)
+	if err != nil {
+		return err
+	}
+	chunk, chunkErr := schema.BytesToEntryChunk(first, data)
+	if chunkErr != nil {
+		keys, children, err := decodeHAMTBlock(first, data, true)
+		if err != nil {
+			// most likely a broken entry chunk
+			return &entryBlockError{block: first, decode: true, err: chunkErr}
+		}
+		err = walkHAMT(ctx, backend, first, data, keys, children, fn)
+		if errors.Is(err, ErrStopWalk) {
+			return nil
+		}
+		return err
+	}
+
+	for next := first; ; {
+		if err := fn(next, data, chunk.Entries); errors.Is(err, ErrStopWalk) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		next = linkCid(chunk.Next)
+		if !next.Defined() || next == schema.NoEntries.Cid {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if data, err = readEntryBlock(ctx, backend, next); err != nil {
+			return err
+		}
+		if chunk, err = schema.BytesToEntryChunk(next, data); err != nil {
+			return &entryBlockError{block: next, decode: true, err: err}
+		}
+	}
+}
+
+// walkHAMT calls fn for the decoded HAMT node c, then for its children, depth-first.
+func walkHAMT(ctx context.Context, backend ChainReader, c cid.Cid, data []byte, keys []multihash.Multihash, children []cid.Cid, fn func(blockCid cid.Cid, data []byte, mhs []multihash.Multihash) error) error {
+	if err := fn(c, data, keys); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		childData, err := readEntryBlock(ctx, backend, child)
+		if err != nil {
+			return err
+		}
+		childKeys, grandChildren, err := decodeHAMTBlock(child, childData, false)
+		if err != nil {
+			return &entryBlockError{block: child, decode: true, err: err}
+		}
+		if err := walkHAMT(ctx, backend, child, childData, childKeys, grandChildren, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readEntryBlock(ctx context.Context, backend ChainReader, c cid.Cid) ([]byte, error) {
+	data, err := backend.GetContent(ctx, c)
+	if err != nil {
+		return nil, &entryBlockError{block: c, err: err}
+	}
+	return data, nil
+}
+
+// entryBlockError is a failure to read or to decode a block of entries.
+type entryBlockError struct {
+	block  cid.Cid
+	decode bool
+	err    error
+}
+
+func (e *entryBlockError) Error() string {
+	if e.decode {
+		return fmt.Sprintf("failed to decode entries block %s: %v", e.block, e.err)
+	}
+	return fmt.Sprintf("failed to read entries block %s: %v", e.block, e.err)
+}
+
+func (e *entryBlockError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,126 @@
+package herald
+
+import (
+	"context"
+	"crypto/rand"
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func testChainConfig(t *testing.T) ChainConfig {
+	t.Helper()
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(key)
+	require.NoError(t, err)
+	mdv := metadata.Default.New(metadata.Bitswap{})
+	md, err := mdv.MarshalBinary()
+	require.NoError(t, err)
+	return ChainConfig{
+		AdEntriesChunkSize: 2,
+		PublisherKey:       key,
+		PublisherID:        id,
+		ProviderAddrs:      []string{"/ip4/127.0.0.1/tcp/4001"},
+		Metadata:           md,
+	}
+}
+
+func testMultihashes(n int) []multihash.Multihash {
+	mhs := make([]multihash.Multihash, 0, n)
+	for i := 0; i < n; i++ {
+		h, _ := multihash.Sum([]byte(strconv.Itoa(i)), multihash.SHA2_256, -1)
+		mhs = append(mhs, h)
+	}
+	return mhs
+}
+
+// testCatalog is a MhCatalog with a ContextID
+type testCatalog struct {
+	MhCatalog
+	id []byte
+}
+
+func (c testCatalog) ID() []byte {
+	return c.id
+}
+
+func TestWalkEntries(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	mhs := testMultihashes(5)
+	adCid, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(mhs...))
+	require.NoError(t, err)
+
+	ad, err := LoadAdvertisement(ctx, backend, adCid)
+	require.NoError(t, err)
+	require.Equal(t, cfg.PublisherID.String(), ad.Provider)
+
+	var got []multihash.Multihash
+	err = WalkEntries(ctx, backend, adCid, func(mh multihash.Multihash) error {
+		got = append(got, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, mhs, got)
+
+	// a retraction by ContextID has no entries
+	retractCid, err := RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+	err = WalkEntries(ctx, backend, retractCid, func(mh multihash.Multihash) error {
+		t.Fatal("unexpected entry")
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestWalkAds(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	first, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")})
+	require.NoError(t, err)
+	second, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)[3:]...))
+	require.NoError(t, err)
+	third, err := RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+
+	var walked []cid.Cid
+	err = WalkAds(ctx, backend, func(adCid cid.Cid, ad schema.Advertisement) error {
+		walked = append(walked, adCid)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{third, second, first}, walked)
+
+	// stopping early
+	walked = nil
+	err = WalkAds(ctx, backend, func(adCid cid.Cid, ad schema.Advertisement) error {
+		walked = append(walked, adCid)
+		if adCid == second {
+			return ErrStopWalk
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{third, second}, walked)
+
+	history, err := ContextIDHistory(ctx, backend, []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{third, first}, history)
+	history, err = ContextIDHistory(ctx, backend, []byte("bar"))
+	require.NoError(t, err)
+	require.Empty(t, history)
+}
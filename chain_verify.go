@@ -0,0 +1,294 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// ChainIssueKind is the category of a problem found by VerifyChain.
+type ChainIssueKind string
+
+const (
+	// IssueMissingBlock is an advertisement or entry chunk not found in the backend
+	IssueMissingBlock ChainIssueKind = "missing block"
+	// IssueUndecodable is a block that can't be decoded as an advertisement or entry chunk
+	IssueUndecodable ChainIssueKind = "undecodable block"
+	// IssueSignature is an advertisement with an invalid signature, or signed by an unexpected peer
+	IssueSignature ChainIssueKind = "invalid signature"
+	// IssueInconsistent is an advertisement with inconsistent fields, or inconsistent with the rest of the chain
+	IssueInconsistent ChainIssueKind = "inconsistent advertisement"
+)
+
+// ChainIssue is a problem found by VerifyChain.
+type ChainIssue struct {
+	Kind ChainIssueKind
+	// Ad is the advertisement affected
+	Ad cid.Cid
+	// Block is the faulty block, if different from Ad
+	Block cid.Cid
+	Err   error
+}
+
+func (i ChainIssue) String() string {
+	if i.Block.Defined() && i.Block != i.Ad {
+		return fmt.Sprintf("%s in %s (block %s): %v", i.Kind, i.Ad, i.Block, i.Err)
+	}
+	return fmt.Sprintf("%s in %s: %v", i.Kind, i.Ad, i.Err)
+}
+
+// VerifyOptions configures VerifyChain.
+type VerifyOptions struct {
+	// PublisherID, if set, is accepted as a signer of the advertisements, in addition to their provider.
+	PublisherID peer.ID
+
+	// SkipEntries skips reading the entry chunks, for a faster verification of the advertisements only.
+	SkipEntries bool
+
+	// Repair, if set, rebuilds the chain when broken blocks are found. See RepairOptions.
+	Repair *RepairOptions
+
+	// Logger, if set, is used instead of the default logger. The repair logs with the logger of its Config.
+	Logger *zap.SugaredLogger
+}
+
+// RepairOptions configures the repair of a broken chain.
+//
+// The readable advertisements are re-signed and re-chained on top of a new genesis, preserving their ContextID,
+// metadata, addresses, provider, entries and retraction semantic. The advertisements whose entries are lost are
+// dropped, as well as everything older than a missing or undecodable advertisement.
+type RepairOptions struct {
+	Config  ChainConfig
+	Backend ChainWriter
+}
+
+// ChainReport is the result of VerifyChain.
+type ChainReport struct {
+	Head cid.Cid
+
+	// Ads and Chunks are the number of advertisements and entry chunks verified
+	Ads    int
+	Chunks int
+
+	Issues []ChainIssue
+
+	// Truncated is true if the walk stopped before the genesis, due to a missing or undecodable advertisement
+	Truncated bool
+
+	// RepairedHead is the head of the repaired chain, if a repair happened
+	RepairedHead cid.Cid
+}
+
+// OK returns true if no issue was found.
+func (r *ChainReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// verifiedAd is an advertisement walked by VerifyChain.
+type verifiedAd struct {
+	schema.Advertisement
+	// broken is true if the advertisement can't be carried over by a repair
+	broken bool
+}
+
+// VerifyChain walks the chain from the head to the genesis, and checks that every advertisement is decodable,
+// correctly signed and consistent, and that all the entry chunks are reachable and decodable.
+// The returned error is only for failures to perform the verification; the problems found are in the report.
+func VerifyChain(ctx context.Context, backend ChainReader, opts VerifyOptions) (*ChainReport, error) {
+	head, err := backend.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &ChainReport{Head: head}
+
+	var ads []verifiedAd // newest first
+	// ContextIDs updated or retracted by a newer advertisement, which must have been published by an older one
+	pending := make(map[string]cid.Cid)
+
+	for next := head; next.Defined(); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := backend.GetContent(ctx, next)
+		if errors.Is(err, ErrContentNotFound) {
+			report.Issues = append(report.Issues, ChainIssue{Kind: IssueMissingBlock, Ad: next, Err: err})
+			report.Truncated = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ad, err := schema.BytesToAdvertisement(next, data)
+		if err != nil {
+			report.Issues = append(report.Issues, ChainIssue{Kind: IssueUndecodable, Ad: next, Err: err})
+			report.Truncated = true
+			break
+		}
+		report.Ads++
+
+		v := verifiedAd{Advertisement: ad}
+		report.Issues = append(report.Issues, verifySignature(next, ad, opts.PublisherID)...)
+		report.Issues = append(report.Issues, verifyConsistency(next, ad, pending)...)
+
+		if !opts.SkipEntries {
+			issue, chunks, err := verifyEntries(ctx, backend, next, ad.Entries)
+			if err != nil {
+				return nil, err
+			}
+			report.Chunks += chunks
+			if issue != nil {
+				report.Issues = append(report.Issues, *issue)
+				v.broken = true
+			}
+		}
+
+		ads = append(ads, v)
+		next = ad.PreviousCid()
+	}
+
+	if !report.Truncated {
+		// the chain is complete: what's still pending was never published
+		for contextID, adCid := range pending {
+			report.Issues = append(report.Issues, ChainIssue{
+				Kind: IssueInconsistent,
+				Ad:   adCid,
+				Err:  fmt.Errorf("ContextID %x is updated or retracted, but never published", contextID),
+			})
+		}
+	}
+
+	orDefaultLogger(opts.Logger).Infow("Verified the chain", "head", head, "ads", report.Ads, "chunks", report.Chunks, "issues", len(report.Issues), "truncated", report.Truncated)
+
+	if opts.Repair != nil && needsRepair(report) {
+		report.RepairedHead, err = repairChain(ctx, *opts.Repair, report.Head, ads)
+		if err != nil {
+			return report, fmt.Errorf("failed to repair the chain: %w", err)
+		}
+	}
+	return report, nil
+}
+
+func verifySignature(adCid cid.Cid, ad schema.Advertisement, publisherID peer.ID) []ChainIssue {
+	signer, err := ad.VerifySignature()
+	if err != nil {
+		return []ChainIssue{{Kind: IssueSignature, Ad: adCid, Err: err}}
+	}
+	if signer.String() == ad.Provider || (publisherID != "" && signer == publisherID) {
+		return nil
+	}
+	return []ChainIssue{{Kind: IssueSignature, Ad: adCid, Err: fmt.Errorf("signed by unexpected peer %s", signer)}}
+}
+
+// verifyConsistency checks the fields of the advertisement. pending tracks the ContextIDs expecting an older
+// publication, as the chain is walked from the newest.
+func verifyConsistency(adCid cid.Cid, ad schema.Advertisement, pending map[string]cid.Cid) []ChainIssue {
+	var issues []ChainIssue
+	inconsistent := func(format string, args ...any) {
+		issues = append(issues, ChainIssue{Kind: IssueInconsistent, Ad: adCid, Err: fmt.Errorf(format, args...)})
+	}
+
+	if err := ad.Validate(); err != nil {
+		inconsistent("%v", err)
+	}
+	if _, err := peer.Decode(ad.Provider); err != nil {
+		inconsistent("invalid provider: %v", err)
+	}
+	if !ad.IsRm {
+		if err := ValidateProviderAddrs(ad.Addresses); err != nil {
+			inconsistent("%v", err)
+		}
+	}
+
+	hasEntries := linkCid(ad.Entries) != schema.NoEntries.Cid
+	switch {
+	case len(ad.ContextID) == 0 && !hasEntries && (ad.IsRm || len(ad.Addresses) == 0):
+		inconsistent("no ContextID and no entries")
+	case len(ad.ContextID) > 0 && ad.IsRm && hasEntries:
+		inconsistent("retraction by ContextID with entries")
+	case len(ad.ContextID) > 0 && (ad.IsRm || !hasEntries):
+		// retraction or metadata update: must be preceded by a publication, unless already expected
+		if _, ok := pending[string(ad.ContextID)]; !ok {
+			pending[string(ad.ContextID)] = adCid
+		}
+	case len(ad.ContextID) > 0:
+		delete(pending, string(ad.ContextID))
+	}
+	return issues
+}
+
+// verifyEntries walks the entries blocks, and returns the first problem found along with the number of blocks read.
+func verifyEntries(ctx context.Context, backend ChainReader, adCid cid.Cid, entries ipld.Link) (*ChainIssue, int, error) {
+	var chunks int
+	err := WalkEntryBlocks(ctx, backend, entries, func(cid.Cid, []byte, []multihash.Multihash) error {
+		chunks++
+		return nil
+	})
+	var blockErr *entryBlockError
+	switch {
+	case err == nil:
+		return nil, chunks, nil
+	case !errors.As(err, &blockErr):
+		return nil, chunks, err
+	case blockErr.decode:
+		return &ChainIssue{Kind: IssueUndecodable, Ad: adCid, Block: blockErr.block, Err: blockErr.err}, chunks, nil
+	case errors.Is(err, ErrContentNotFound):
+		return &ChainIssue{Kind: IssueMissingBlock, Ad: adCid, Block: blockErr.block, Err: blockErr.err}, chunks, nil
+	default:
+		return nil, chunks, err
+	}
+}
+
+// needsRepair returns true if the issues found can be fixed by rebuilding the chain.
+func needsRepair(report *ChainReport) bool {
+	for _, issue := range report.Issues {
+		if issue.Kind != IssueInconsistent {
+			return true
+		}
+	}
+	return false
+}
+
+// repairChain rebuilds the chain from the readable advertisements, newest first. It fails with ErrHeadConflict if the
+// head moved since it was verified, as the advertisements published since would be dropped.
+func repairChain(ctx context.Context, opts RepairOptions, verified cid.Cid, ads []verifiedAd) (cid.Cid, error) {
+	var newHead cid.Cid
+	var dropped int
+	err := opts.Backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		if prevHead != verified {
+			return cid.Undef, fmt.Errorf("%w: verified %s, now %s", ErrHeadConflict, verified, prevHead)
+		}
+		dropped = 0
+		head := cid.Undef
+		for i := len(ads) - 1; i >= 0; i-- {
+			if ads[i].broken {
+				dropped++
+				continue
+			}
+			adCfg, err := resignConfig(opts.Config, ads[i].Advertisement)
+			if err != nil {
+				return cid.Undef, err
+			}
+			head, err = storeAdvertisement(ctx, adCfg, opts.Backend, head, ads[i].ContextID, ads[i].Entries, ads[i].IsRm)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		if !head.Defined() {
+			return cid.Undef, errors.New("no readable advertisement to rebuild the chain from")
+		}
+		newHead = head
+		return head, nil
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	opts.Config.logger().Infow("Repaired the chain", "head", newHead, "ads", len(ads)-dropped, "dropped", dropped)
+	return newHead, nil
+}
@@ -0,0 +1,134 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyChain(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	mhs := testMultihashes(10)
+
+	genesis, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: mhs[0:3], id: []byte("a")})
+	require.NoError(t, err)
+	broken, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(mhs[3:8]...))
+	require.NoError(t, err)
+	_, err = RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("a")})
+	require.NoError(t, err)
+
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, 3, report.Ads)
+	require.Equal(t, 5, report.Chunks)
+	require.False(t, report.Truncated)
+
+	// lose an entry chunk in the middle of the chain
+	ad, err := LoadAdvertisement(ctx, backend, broken)
+	require.NoError(t, err)
+	lost := linkCid(ad.Entries)
+	require.NoError(t, backend.DeleteBlock(ctx, lost))
+
+	report, err = VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, IssueMissingBlock, report.Issues[0].Kind)
+	require.Equal(t, broken, report.Issues[0].Ad)
+	require.Equal(t, lost, report.Issues[0].Block)
+	require.False(t, report.RepairedHead.Defined())
+
+	// the head moved since the verification: the repair would drop the new advertisement
+	stale := staleHeadReader{ChainReader: backend, head: report.Head}
+	moved, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(mhs[8:]...))
+	require.NoError(t, err)
+	_, err = VerifyChain(ctx, stale, VerifyOptions{
+		PublisherID: cfg.PublisherID,
+		Repair:      &RepairOptions{Config: cfg, Backend: backend},
+	})
+	require.ErrorIs(t, err, ErrHeadConflict)
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, moved, head)
+	require.NoError(t, backend.UpdateHead(ctx, func(cid.Cid) (cid.Cid, error) { return stale.head, nil }))
+
+	// repair: the broken advertisement is dropped, the others are carried over
+	report, err = VerifyChain(ctx, backend, VerifyOptions{
+		PublisherID: cfg.PublisherID,
+		Repair:      &RepairOptions{Config: cfg, Backend: backend},
+	})
+	require.NoError(t, err)
+	require.True(t, report.RepairedHead.Defined())
+	head, err = backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, report.RepairedHead, head)
+
+	var ads []schema.Advertisement
+	err = WalkAds(ctx, backend, func(_ cid.Cid, ad schema.Advertisement) error {
+		ads = append(ads, ad)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, ads, 2)
+	require.True(t, ads[0].IsRm)
+	require.Equal(t, []byte("a"), ads[1].ContextID)
+	require.Equal(t, genesis, ads[0].PreviousCid())
+
+	report, err = VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, 2, report.Ads)
+}
+
+// staleHeadReader reads the chain from an outdated head.
+type staleHeadReader struct {
+	ChainReader
+	head cid.Cid
+}
+
+func (r staleHeadReader) GetHead(context.Context) (cid.Cid, error) {
+	return r.head, nil
+}
+
+func TestVerifyChainIssues(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	other := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	retract, err := RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("never published")})
+	require.NoError(t, err)
+
+	// not signed by the expected publisher, and the retraction has nothing to retract
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: other.PublisherID, SkipEntries: true})
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Chunks)
+	var kinds []ChainIssueKind
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+		if issue.Kind == IssueInconsistent {
+			require.Equal(t, retract, issue.Ad)
+		}
+	}
+	require.Contains(t, kinds, IssueInconsistent)
+	require.NotContains(t, kinds, IssueSignature) // the publisher is also the provider
+
+	// a missing advertisement truncates the walk
+	require.NoError(t, backend.DeleteBlock(ctx, first))
+	report, err = VerifyChain(ctx, backend, VerifyOptions{})
+	require.NoError(t, err)
+	require.True(t, report.Truncated)
+	require.Equal(t, 1, report.Ads)
+	require.Len(t, report.Issues, 1)
+	require.Equal(t, IssueMissingBlock, report.Issues[0].Kind)
+	require.Equal(t, first, report.Issues[0].Ad)
+}
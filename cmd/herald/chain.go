@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/herald"
+)
+
+// runChain runs the subcommands inspecting the chain: ls, verify and export.
+func runChain(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: herald chain ls|verify|export [flags]")
+	}
+	var cfg cliConfig
+	var timeout time.Duration
+	var skipEntries bool
+	var out string
+	err := cfg.parse("chain "+args[0], args[1:], func(fs *flag.FlagSet) {
+		fs.DurationVar(&timeout, "timeout", 10*time.Minute, "overall timeout")
+		switch args[0] {
+		case "verify":
+			fs.BoolVar(&skipEntries, "skip-entries", false, "only verify the advertisements, not their entries")
+		case "export":
+			fs.StringVar(&out, "out", "", "path of the CAR file to write, or stdout if empty")
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	h, _, err := cfg.newHerald(ctx, false)
+	if err != nil {
+		return err
+	}
+	reader, ok := h.Backend().(herald.ChainReader)
+	if !ok {
+		return fmt.Errorf("backend %T can't be read", h.Backend())
+	}
+
+	switch args[0] {
+	case "ls":
+		return herald.WalkAds(ctx, reader, func(adCid cid.Cid, ad schema.Advertisement) error {
+			kind := "publish"
+			if ad.IsRm {
+				kind = "retract"
+			}
+			_, err := fmt.Printf("%s\t%s\t%q\t%s\n", adCid, kind, ad.ContextID, ad.Entries)
+			return err
+		})
+
+	case "verify":
+		opts := herald.VerifyOptions{SkipEntries: skipEntries}
+		if cfg.KeyFile != "" || cfg.KMSKey != "" {
+			opts.PublisherID = h.ChainConfig().PublisherID
+		}
+		report, err := herald.VerifyChain(ctx, reader, opts)
+		if err != nil {
+			return err
+		}
+		for _, issue := range report.Issues {
+			fmt.Println(issue)
+		}
+		fmt.Printf("head %s: %d advertisements, %d entry blocks, %d issues\n", report.Head, report.Ads, report.Chunks, len(report.Issues))
+		if !report.OK() {
+			return errors.New("the chain has issues")
+		}
+		return nil
+
+	case "export":
+		if out == "" {
+			// hide the Seek of os.Stdout, which fails on a pipe
+			return herald.ExportChainToCar(ctx, reader, struct{ io.Writer }{os.Stdout})
+		}
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		return errors.Join(herald.ExportChainToCar(ctx, reader, f), f.Close())
+
+	default:
+		return fmt.Errorf("unknown chain command %q", args[0])
+	}
+}
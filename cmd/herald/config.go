@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+)
+
+// cliConfig is the configuration shared by the commands, read from a JSON file with -config and overridden by the
+// flags. The repeatable flags add to the lists of the file.
+type cliConfig struct {
+	KeyFile        string   `json:"keyFile"`
+	KMSKey         string   `json:"kmsKey"`
+	Topic          string   `json:"topic"`
+	Backend        string   `json:"backend"`
+	DatastoreDir   string   `json:"datastoreDir"`
+	S3Bucket       string   `json:"s3Bucket"`
+	S3Prefix       string   `json:"s3Prefix"`
+	S3Gzip         bool     `json:"s3Gzip"`
+	GCSBucket      string   `json:"gcsBucket"`
+	AzureContainer string   `json:"azureContainer"`
+	ProviderAddrs  []string `json:"providerAddrs"`
+	PublisherAddrs []string `json:"publisherAddrs"`
+	AnnounceURLs   []string `json:"announceURLs"`
+	AnnounceDNS    []string `json:"announceDNS"`
+	Transport      string   `json:"transport"`
+	Codec          string   `json:"codec"`
+	LogLevel       string   `json:"logLevel"`
+}
+
+func (c *cliConfig) register(fs *flag.FlagSet) {
+	fs.String("config", "", "path to a JSON configuration file, overridden by the flags")
+	fs.StringVar(&c.KeyFile, "key-file", "", "path to the publisher private key, in the libp2p protobuf encoding")
+	fs.StringVar(&c.KMSKey, "kms-key", "", "AWS KMS key ID, ARN or alias signing as the publisher, in place of -key-file")
+	fs.StringVar(&c.Topic, "topic", "/indexer/ingest/mainnet", "IPNI topic")
+	fs.StringVar(&c.Backend, "backend", "ds", "backend storing the chain: ds, s3, gcs or azure")
+	fs.StringVar(&c.DatastoreDir, "datastore-dir", "herald-chain", "LevelDB directory of the ds backend")
+	fs.StringVar(&c.S3Bucket, "s3-bucket", "", "S3 bucket of the s3 backend")
+	fs.StringVar(&c.S3Prefix, "s3-prefix", herald.DefaultS3Prefix, "prefix of the objects of the s3, gcs and azure backends")
+	fs.BoolVar(&c.S3Gzip, "s3-gzip", false, "store the blocks of the s3 backend gzipped, with Content-Encoding: gzip")
+	fs.StringVar(&c.GCSBucket, "gcs-bucket", "", "Google Cloud Storage bucket of the gcs backend")
+	fs.StringVar(&c.AzureContainer, "azure-container", "", "URL of the Azure Blob container of the azure backend, e.g. https://account.blob.core.windows.net/container")
+	fs.Var((*stringsFlag)(&c.ProviderAddrs), "provider-addr", "multiaddr of the provider serving the content (repeatable)")
+	fs.Var((*stringsFlag)(&c.PublisherAddrs), "publisher-addr", "HTTP or libp2p multiaddr from which the indexers fetch the chain, derived from -listen if unset (repeatable)")
+	fs.Var((*stringsFlag)(&c.AnnounceURLs), "announce-url", "URL of an indexer announce endpoint (repeatable)")
+	fs.Var((*stringsFlag)(&c.AnnounceDNS), "announce-dns", "DNS name whose TXT records are indexer announce URLs (repeatable)")
+	fs.StringVar(&c.Transport, "transport", "bitswap", "retrieval protocol of the provider: bitswap or http")
+	fs.StringVar(&c.Codec, "codec", "dag-json", "IPLD codec of the blocks of the chain: dag-json or dag-cbor")
+	fs.StringVar(&c.LogLevel, "log-level", "", "level of the logs: debug, info, warn or error; defaults to GOLOG_LOG_LEVEL")
+}
+
+// parse loads the configuration file, if any, then applies the flags on top, and sets the log level. register adds the
+// flags specific to the command.
+func (c *cliConfig) parse(name string, args []string, register func(fs *flag.FlagSet)) error {
+	if err := c.parseFlags(name, args, register); err != nil {
+		return err
+	}
+	if c.LogLevel != "" {
+		if err := herald.SetLogLevel(c.LogLevel); err != nil {
+			return fmt.Errorf("invalid -log-level: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *cliConfig) parseFlags(name string, args []string, register func(fs *flag.FlagSet)) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	c.register(fs)
+	register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	path := fs.Lookup("config").Value.String()
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// start over from the file, then parse the flags again so that they override it
+	*c = cliConfig{}
+	withFile := flag.NewFlagSet(name, flag.ExitOnError)
+	c.register(withFile)
+	register(withFile)
+	if err := json.Unmarshal(raw, c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return withFile.Parse(args)
+}
+
+// options returns the herald options of the configuration, and the sender of the announcements if any. A publisher
+// key is required if needKey is true, to write or serve the chain.
+func (c *cliConfig) options(ctx context.Context, needKey bool) ([]herald.Option, announce.Sender, error) {
+	opts := []herald.Option{herald.WithTopic(c.Topic)}
+
+	var signer herald.Signer
+	switch {
+	case c.KeyFile != "" && c.KMSKey != "":
+		return nil, nil, errors.New("-key-file and -kms-key are exclusive")
+	case c.KeyFile != "":
+		_, key, err := herald.LoadIdentityFromFile(c.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer = herald.KeySigner(key)
+	case c.KMSKey != "":
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if signer, err = herald.NewKMSSigner(ctx, kms.NewFromConfig(awsCfg), c.KMSKey); err != nil {
+			return nil, nil, err
+		}
+	case needKey:
+		return nil, nil, errors.New("-key-file or -kms-key is required")
+	}
+
+	var sender announce.Sender
+	if signer != nil {
+		opts = append(opts, herald.WithSigner(signer))
+		var err error
+		if sender, err = c.announcer(ctx, signer.PublicKey()); err != nil {
+			return nil, nil, err
+		}
+		if sender != nil {
+			opts = append(opts, herald.WithAnnouncer(sender))
+		}
+	}
+
+	switch c.Backend {
+	case "ds":
+		if err := os.MkdirAll(c.DatastoreDir, 0o755); err != nil {
+			return nil, nil, err
+		}
+		ds, err := leveldb.NewDatastore(c.DatastoreDir, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, herald.WithDatastoreBackend(ds))
+	case "s3":
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		s3Opts := []herald.S3BackendOption{herald.WithS3Prefix(c.S3Prefix)}
+		if c.S3Gzip {
+			s3Opts = append(s3Opts, herald.WithS3Gzip())
+		}
+		opts = append(opts, herald.WithS3Backend(awsCfg, c.S3Bucket, s3Opts...))
+	case "gcs":
+		if c.GCSBucket == "" {
+			return nil, nil, errors.New("-gcs-bucket is required")
+		}
+		store := herald.NewGCSObjectStore(c.GCSBucket, herald.WithGCSTokenSource(gcsTokenSource()))
+		opts = append(opts, herald.WithObjectStoreBackend(store, herald.WithObjectStorePrefix(c.S3Prefix)))
+	case "azure":
+		store, err := herald.NewAzureBlobObjectStore(c.AzureContainer, azureCredentials()...)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, herald.WithObjectStoreBackend(store, herald.WithObjectStorePrefix(c.S3Prefix)))
+	default:
+		return nil, nil, fmt.Errorf("unknown backend %q", c.Backend)
+	}
+
+	var md metadata.Metadata
+	switch c.Transport {
+	case "bitswap":
+		md = metadata.Default.New(metadata.Bitswap{})
+	case "http":
+		md = metadata.Default.New(metadata.IpfsGatewayHttp{})
+	default:
+		return nil, nil, fmt.Errorf("unknown transport %q", c.Transport)
+	}
+	opts = append(opts, herald.WithMetadata(md))
+
+	switch c.Codec {
+	case "dag-json":
+		opts = append(opts, herald.WithCodec(multicodec.DagJson))
+	case "dag-cbor":
+		opts = append(opts, herald.WithCodec(multicodec.DagCbor))
+	default:
+		return nil, nil, fmt.Errorf("unknown codec %q", c.Codec)
+	}
+
+	for _, s := range c.ProviderAddrs {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid provider address %q: %w", s, err)
+		}
+		opts = append(opts, herald.WithProviderAddress(a))
+	}
+	var publisherAddrs []multiaddr.Multiaddr
+	for _, s := range c.PublisherAddrs {
+		a, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid publisher address %q: %w", s, err)
+		}
+		publisherAddrs = append(publisherAddrs, a)
+	}
+	if len(publisherAddrs) > 0 {
+		opts = append(opts, herald.WithPublisherAddress(publisherAddrs...))
+	}
+	return opts, sender, nil
+}
+
+// announcer returns the sender of the announcements on behalf of the publisher key, or nil without indexers.
+func (c *cliConfig) announcer(ctx context.Context, key crypto.PubKey) (announce.Sender, error) {
+	if len(c.AnnounceURLs) == 0 && len(c.AnnounceDNS) == 0 {
+		return nil, nil
+	}
+	id, err := peer.IDFromPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	opts := []herald.AnnouncersOption{herald.WithAnnounceURLs(c.AnnounceURLs...)}
+	for _, name := range c.AnnounceDNS {
+		opts = append(opts, herald.WithAnnounceDNS(name))
+	}
+	return herald.NewAnnouncers(ctx, id, opts...)
+}
+
+// newHerald creates a Herald from the configuration, with the extra options. It also returns the sender of the
+// announcements, if any, which is closed by Herald.Shutdown.
+func (c *cliConfig) newHerald(ctx context.Context, needKey bool, extra ...herald.Option) (*herald.Herald, announce.Sender, error) {
+	opts, sender, err := c.options(ctx, needKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := herald.New(append(opts, extra...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, sender, nil
+}
+
+// gcsTokenSource returns the access token of $GOOGLE_OAUTH_ACCESS_TOKEN if set, or else of the metadata server.
+func gcsTokenSource() func(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return func(context.Context) (string, error) { return token, nil }
+	}
+	return herald.GCEMetadataTokenSource()
+}
+
+// azureCredentials returns the credentials of the storage account in $AZURE_STORAGE_ACCOUNT and $AZURE_STORAGE_KEY,
+// or else of $AZURE_STORAGE_SAS_TOKEN, as used by the Azure CLI.
+func azureCredentials() []herald.AzureBlobOption {
+	if account, key := os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"); account != "" && key != "" {
+		return []herald.AzureBlobOption{herald.WithAzureSharedKey(account, key)}
+	}
+	if token := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); token != "" {
+		return []herald.AzureBlobOption{herald.WithAzureSASToken(token)}
+	}
+	return nil
+}
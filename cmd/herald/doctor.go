@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "path to the publisher private key, in the libp2p protobuf encoding")
+	peerID := fs.String("peer-id", "", "expected peer ID of the publisher")
+	publicURL := fs.String("public-url", "", "public base URL from which the chain is served")
+	s3Bucket := fs.String("s3-bucket", "", "S3 bucket of the S3 backend, if any")
+	topic := fs.String("topic", "/indexer/ingest/mainnet", "IPNI topic")
+	timeout := fs.Duration("timeout", time.Minute, "overall timeout of the checks")
+	var announceURLs stringsFlag
+	fs.Var(&announceURLs, "announce-url", "URL of an indexer announce endpoint (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cfg := herald.DoctorConfig{
+		PublicURL:    *publicURL,
+		AnnounceURLs: announceURLs,
+	}
+
+	if *keyFile != "" {
+		var err error
+		if _, cfg.PublisherKey, err = herald.LoadIdentityFromFile(*keyFile); err != nil {
+			return err
+		}
+	}
+	if *peerID != "" {
+		var err error
+		cfg.PublisherID, err = peer.Decode(*peerID)
+		if err != nil {
+			return fmt.Errorf("invalid peer ID: %w", err)
+		}
+	}
+	if *s3Bucket != "" {
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return err
+		}
+		cfg.Backend = herald.NewS3Backend(awsCfg, *s3Bucket, *topic, cfg.PublisherKey)
+	}
+
+	failed := false
+	for _, check := range herald.Doctor(ctx, cfg) {
+		fmt.Println(check)
+		failed = failed || !check.OK()
+	}
+	if failed {
+		return errors.New("some checks failed")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/ipni/herald"
+)
+
+// runKeygen generates a new identity, written to a new key file, and prints its peer ID.
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "path of the new private key file, which must not exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyFile == "" {
+		return errors.New("-key-file is required")
+	}
+	id, key, err := herald.GenerateProviderIdentity()
+	if err != nil {
+		return err
+	}
+	if err := herald.SaveIdentityToFile(*keyFile, key); err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
@@ -0,0 +1,60 @@
+// Command herald is a command-line front door to the herald IPNI publisher.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{name: "publish", usage: "publish the multihashes of a CAR file, and announce the new head", run: runPublish},
+	{name: "retract", usage: "retract a ContextID or the multihashes of a CAR file, and announce the new head", run: runRetract},
+	{name: "serve", usage: "serve the chain over HTTP", run: runServe},
+	{name: "chain", usage: "inspect the chain: ls, verify or export to a CAR file", run: runChain},
+	{name: "keygen", usage: "generate a new publisher identity", run: runKeygen},
+	{name: "doctor", usage: "check the configuration and reachability of a deployment", run: runDoctor},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	_, _ = fmt.Fprintln(os.Stderr, "usage: herald <command> [flags]")
+	_, _ = fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+}
+
+// stringsFlag is a repeatable string flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
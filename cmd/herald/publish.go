@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+)
+
+func runPublish(args []string) error {
+	return runPublishOrRetract("publish", args)
+}
+
+func runRetract(args []string) error {
+	return runPublishOrRetract("retract", args)
+}
+
+// runPublishOrRetract publishes or retracts the multihashes of a CAR file, or retracts a ContextID, and announces the
+// new head.
+func runPublishOrRetract(name string, args []string) error {
+	var cfg cliConfig
+	var carPath, contextID string
+	var timeout time.Duration
+	var progress bool
+	err := cfg.parse(name, args, func(fs *flag.FlagSet) {
+		fs.StringVar(&carPath, "car", "", "path to a CARv2 file with an index, holding the multihashes")
+		fs.StringVar(&contextID, "context-id", "", "ContextID of the advertisement; without it, the multihashes are published without ContextID")
+		fs.DurationVar(&timeout, "timeout", 10*time.Minute, "overall timeout")
+		fs.BoolVar(&progress, "progress", true, "print the progress on stderr")
+	})
+	if err != nil {
+		return err
+	}
+	if carPath == "" && (name == "publish" || contextID == "") {
+		return errors.New("-car is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var extra []herald.Option
+	if progress {
+		extra = append(extra, herald.WithProgress(progressPrinter(time.Second)))
+	}
+	h, sender, err := cfg.newHerald(ctx, true, extra...)
+	if err != nil {
+		return err
+	}
+	publisher := herald.NewPublisher(h.ChainConfig(), h.Backend(), sender, herald.WithAnnounceRetryPolicy(herald.DefaultRetryPolicy))
+
+	var head cid.Cid
+	switch {
+	case name == "retract" && carPath == "":
+		head, err = publisher.RetractContextID(ctx, herald.CatalogID(contextID))
+	default:
+		var catalog *herald.CarCatalog
+		catalog, err = herald.CatalogFromCar(carPath, []byte(contextID))
+		if err != nil {
+			return err
+		}
+		defer catalog.Close()
+		switch {
+		case name == "publish" && contextID != "":
+			head, err = publisher.PublishWithContextID(ctx, catalog)
+		case name == "publish":
+			head, err = publisher.PublishRawMHs(ctx, catalog)
+		case contextID != "":
+			head, err = publisher.RetractWithContextID(ctx, catalog)
+		default:
+			head, err = publisher.RetractRawMHs(ctx, catalog)
+		}
+	}
+	// the backend is flushed even if only the announcement failed
+	err = errors.Join(err, h.Shutdown(ctx))
+	if head.Defined() {
+		fmt.Println(head)
+	}
+	return err
+}
+
+// progressPrinter returns a progress hook printing on stderr, at most once per interval.
+func progressPrinter(interval time.Duration) func(herald.ProgressEvent) {
+	var last time.Time
+	return func(e herald.ProgressEvent) {
+		if !e.Done && time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+		total := "?"
+		if e.Total >= 0 {
+			total = strconv.Itoa(e.Total)
+		}
+		line := fmt.Sprintf("%s: %d/%s multihashes, %d chunks, %.1f MB, %s elapsed",
+			e.Operation, e.Multihashes, total, e.Chunks, float64(e.Bytes)/1e6, e.Elapsed.Round(time.Second))
+		if e.Remaining > 0 {
+			line += fmt.Sprintf(", %s remaining", e.Remaining.Round(time.Second))
+		}
+		_, _ = fmt.Fprintln(os.Stderr, line)
+	}
+}
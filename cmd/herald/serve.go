@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// runServe serves the chain over HTTP until interrupted.
+func runServe(args []string) error {
+	var cfg cliConfig
+	var listenAddr string
+	var readCache, maxConcurrent int
+	var rateLimit float64
+	var accessLog, compress bool
+	var cors string
+	var mirrorURL, mirrorPeer string
+	var mirrorVerbatim bool
+	var mirrorInterval, heartbeat time.Duration
+	err := cfg.parse("serve", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&listenAddr, "listen", "0.0.0.0:40080", "listen address of the HTTP publisher")
+		fs.IntVar(&readCache, "read-cache", 64<<20, "bytes of blocks cached in memory, 0 to disable")
+		fs.IntVar(&maxConcurrent, "max-concurrent", 0, "maximum number of requests served concurrently, 0 for no limit")
+		fs.Float64Var(&rateLimit, "rate-limit", 0, "requests per second allowed per client IP, 0 for no limit")
+		fs.BoolVar(&accessLog, "access-log", false, "log every request served")
+		fs.BoolVar(&compress, "compress", true, "compress the responses with zstd or gzip, as accepted by the clients")
+		fs.StringVar(&cors, "cors", "", "comma-separated origins allowed to read the chain from a browser, * for any")
+		fs.StringVar(&mirrorURL, "mirror", "", "base URL of a remote publisher chain to mirror, e.g. https://example.com/ipni/v1/ad")
+		fs.StringVar(&mirrorPeer, "mirror-peer", "", "peer ID of the mirrored publisher, signing its head")
+		fs.BoolVar(&mirrorVerbatim, "mirror-verbatim", false, "replicate the mirrored chain as is, instead of republishing it under our identity")
+		fs.DurationVar(&mirrorInterval, "mirror-interval", herald.DefaultMirrorInterval, "interval between two syncs of the mirrored chain")
+		fs.DurationVar(&heartbeat, "heartbeat", 0, "re-announce the head when nothing was announced for this interval, 0 to disable")
+	})
+	if err != nil {
+		return err
+	}
+	var mirrorID peer.ID
+	if mirrorURL != "" {
+		if mirrorID, err = peer.Decode(mirrorPeer); err != nil {
+			return fmt.Errorf("invalid -mirror-peer: %w", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var pubOpts []herald.HttpPublisherOption
+	if maxConcurrent > 0 {
+		pubOpts = append(pubOpts, herald.WithHttpPublisherMaxConcurrentRequests(maxConcurrent))
+	}
+	if rateLimit > 0 {
+		// bursts of a few seconds worth of requests, for the indexers fetching the chain
+		pubOpts = append(pubOpts, herald.WithHttpPublisherRateLimit(rateLimit, max(1, int(5*rateLimit))))
+	}
+	if accessLog {
+		pubOpts = append(pubOpts, herald.WithHttpPublisherAccessLog())
+	}
+	if compress {
+		pubOpts = append(pubOpts, herald.WithHttpPublisherCompression())
+	}
+	if cors != "" {
+		pubOpts = append(pubOpts, herald.WithHttpPublisherCORS(strings.Split(cors, ",")...))
+	}
+
+	heraldOpts := []herald.Option{herald.WithHTTPPublisher(listenAddr, pubOpts...), herald.WithReadCache(readCache)}
+	if heartbeat > 0 {
+		heraldOpts = append(heraldOpts, herald.WithHeartbeat(heartbeat))
+	}
+	h, sender, err := cfg.newHerald(ctx, true, heraldOpts...)
+	if err != nil {
+		return err
+	}
+	if err := h.Start(ctx); err != nil {
+		return err
+	}
+	var mirror *herald.Mirror
+	if mirrorURL != "" {
+		mirrorCfg := herald.MirrorConfig{Interval: mirrorInterval, Verbatim: mirrorVerbatim}
+		source := herald.NewHttpChainReader(mirrorURL, mirrorID)
+		mirror = herald.NewMirror(mirrorCfg, h.ChainConfig(), source, h.Backend(), sender)
+		mirror.Start()
+		_, _ = fmt.Fprintf(os.Stderr, "mirroring the chain of %s from %s\n", mirrorID, mirrorURL)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "serving the chain of %s on %s\n", h.ChainConfig().PublisherID, listenAddr)
+	<-ctx.Done()
+
+	if mirror != nil {
+		_ = mirror.Close()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return h.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,150 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+)
+
+// ContextIDState is the state of a ContextID in the chain.
+type ContextIDState int
+
+const (
+	// ContextIDUnknown is a ContextID never published in the chain.
+	ContextIDUnknown ContextIDState = iota
+	// ContextIDLive is a ContextID published, and not retracted since.
+	ContextIDLive
+	// ContextIDRetracted is a ContextID retracted.
+	ContextIDRetracted
+)
+
+func (s ContextIDState) String() string {
+	switch s {
+	case ContextIDUnknown:
+		return "unknown"
+	case ContextIDLive:
+		return "live"
+	case ContextIDRetracted:
+		return "retracted"
+	default:
+		return fmt.Sprintf("ContextIDState(%d)", int(s))
+	}
+}
+
+// ContextIDInfo is the indexed state of a ContextID.
+type ContextIDInfo struct {
+	State ContextIDState
+
+	// Ad is the last advertisement publishing, updating or retracting the ContextID.
+	Ad cid.Cid
+
+	// Metadata is the metadata of the ContextID, while live.
+	Metadata []byte
+}
+
+// DuplicateContextIDPolicy is what PublishWithContextID does when the ContextID is already live with the same
+// metadata, a publication that the indexers ignore. It only applies with a backend maintaining a ContextIDIndex.
+type DuplicateContextIDPolicy int
+
+const (
+	// DuplicateContextIDAllow publishes the advertisement anyway. This is the default.
+	DuplicateContextIDAllow DuplicateContextIDPolicy = iota
+	// DuplicateContextIDSkip publishes nothing, and returns cid.Undef as the new head.
+	DuplicateContextIDSkip
+	// DuplicateContextIDError publishes nothing, and returns ErrContextIDLive.
+	DuplicateContextIDError
+)
+
+var ErrContextIDLive = errors.New("the ContextID is already live with the same metadata")
+
+// isDuplicate returns true if publishing id with the metadata of cfg would be ignored by the indexers.
+// It returns false if the backend doesn't maintain a ContextIDIndex.
+func (cfg ChainConfig) isDuplicate(ctx context.Context, backend ChainWriter, id CatalogID) (bool, error) {
+	index, ok := backend.(ContextIDIndex)
+	if !ok {
+		return false, nil
+	}
+	info, err := index.ContextIDState(ctx, id)
+	switch {
+	case errors.Is(err, ErrNoContextIDIndex):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return info.State == ContextIDLive && bytes.Equal(info.Metadata, cfg.Metadata), nil
+}
+
+// warnNotLive logs a warning for each ContextID about to be retracted or updated while not live, which is a wasted
+// advertisement. It does nothing if the backend doesn't maintain a ContextIDIndex.
+func (cfg ChainConfig) warnNotLive(ctx context.Context, backend ChainWriter, operation string, ids ...CatalogID) {
+	index, ok := backend.(ContextIDIndex)
+	if !ok {
+		return
+	}
+	for _, id := range ids {
+		info, err := index.ContextIDState(ctx, id)
+		if err != nil {
+			if !errors.Is(err, ErrNoContextIDIndex) {
+				cfg.withLogFields(id).logger().Errorw("failed to read the ContextID index", "err", err)
+			}
+			return
+		}
+		if info.State != ContextIDLive {
+			cfg.withLogFields(id).logger().Warnw("ContextID is not live", "operation", operation, "state", info.State)
+		}
+	}
+}
+
+// findIdenticalAds returns the advertisements of the live ContextID id, oldest first, if they are the ones publishing
+// the entries parts with cfg, or nil otherwise. It returns nil if the backend doesn't maintain a ContextIDIndex or
+// can't be read.
+func (cfg ChainConfig) findIdenticalAds(ctx context.Context, backend ChainWriter, id CatalogID, parts []ipld.Link) ([]cid.Cid, error) {
+	index, ok := backend.(ContextIDIndex)
+	if !ok {
+		return nil, nil
+	}
+	reader, ok := backend.(ChainReader)
+	if !ok {
+		return nil, nil
+	}
+	info, err := index.ContextIDState(ctx, id)
+	switch {
+	case errors.Is(err, ErrNoContextIDIndex):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	if info.State != ContextIDLive || !bytes.Equal(info.Metadata, cfg.Metadata) {
+		return nil, nil
+	}
+
+	provider := cfg.PublisherID
+	if cfg.ProviderID != "" {
+		provider = cfg.ProviderID
+	}
+	// a split catalog is published as consecutive advertisements, the last one being indexed
+	ads := make([]cid.Cid, len(parts))
+	adCid := info.Ad
+	for i := len(parts) - 1; i >= 0; i-- {
+		if !adCid.Defined() {
+			return nil, nil
+		}
+		ad, err := LoadAdvertisement(ctx, reader, adCid)
+		if err != nil {
+			return nil, err
+		}
+		if ad.IsRm || !bytes.Equal(ad.ContextID, id) || !bytes.Equal(ad.Metadata, cfg.Metadata) ||
+			ad.Provider != provider.String() || !slices.Equal(ad.Addresses, cfg.ProviderAddrs) ||
+			!linkCid(ad.Entries).Equals(linkCid(parts[i])) {
+			return nil, nil
+		}
+		ads[i] = adCid
+		adCid = linkCid(ad.PreviousID)
+	}
+	return ads, nil
+}
@@ -0,0 +1,250 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// DoctorConfig describes a deployment to be checked by Doctor.
+// Checks for which the needed information is not provided are skipped.
+type DoctorConfig struct {
+	// PublisherKey is the keypair of the IPNI publisher
+	PublisherKey crypto.PrivKey
+
+	// PublisherID is the expected peer.ID of the publisher
+	PublisherID peer.ID
+
+	// Backend is the backend storing the chain. It is checked for writability by storing a tiny probe block, which
+	// is deleted afterward if the backend implements BlockManager. If it also implements ChainReader, it is used as a
+	// reference for the freshness of the public head.
+	Backend ChainWriter
+
+	// PublicURL is the base URL from which the chain is publicly served, as seen by the indexers.
+	// The head must be available at PublicURL + "/head".
+	PublicURL string
+
+	// AnnounceURLs are the URLs of the indexers receiving announcements.
+	AnnounceURLs []string
+
+	// MaxHeadAge is the maximum tolerated age of a cached head served by a CDN. Defaults to one minute.
+	MaxHeadAge time.Duration
+
+	// HttpClient is the client used for external requests. Defaults to a client with a 10s timeout.
+	HttpClient *http.Client
+}
+
+// DoctorCheck is the result of a single check performed by Doctor.
+type DoctorCheck struct {
+	// Name is a short description of the check
+	Name string
+	// Skipped is true if the check could not be performed due to missing configuration
+	Skipped bool
+	// Err is the failure, if any
+	Err error
+	// Hint is an actionable suggestion to fix the failure
+	Hint string
+}
+
+// OK returns true if the check passed or was skipped.
+func (c DoctorCheck) OK() bool {
+	return c.Err == nil
+}
+
+func (c DoctorCheck) String() string {
+	switch {
+	case c.Skipped:
+		return fmt.Sprintf("[SKIP] %s", c.Name)
+	case c.Err == nil:
+		return fmt.Sprintf("[ OK ] %s", c.Name)
+	case c.Hint != "":
+		return fmt.Sprintf("[FAIL] %s: %v\n       hint: %s", c.Name, c.Err, c.Hint)
+	default:
+		return fmt.Sprintf("[FAIL] %s: %v", c.Name, c.Err)
+	}
+}
+
+// Doctor validates a deployment end to end, and reports the result of each check.
+func Doctor(ctx context.Context, cfg DoctorConfig) []DoctorCheck {
+	if cfg.HttpClient == nil {
+		cfg.HttpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.MaxHeadAge == 0 {
+		cfg.MaxHeadAge = time.Minute
+	}
+
+	var checks []DoctorCheck
+	checks = append(checks, doctorCheckIdentity(cfg))
+	checks = append(checks, doctorCheckBackend(ctx, cfg))
+	checks = append(checks, doctorCheckPublicHead(ctx, cfg)...)
+	for _, u := range cfg.AnnounceURLs {
+		checks = append(checks, doctorCheckAnnounce(ctx, cfg, u))
+	}
+	return checks
+}
+
+func doctorCheckIdentity(cfg DoctorConfig) DoctorCheck {
+	check := DoctorCheck{Name: "publisher key matches the publisher ID"}
+	if cfg.PublisherKey == nil {
+		check.Skipped = true
+		return check
+	}
+	id, err := peer.IDFromPrivateKey(cfg.PublisherKey)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	if cfg.PublisherID != "" && id != cfg.PublisherID {
+		check.Err = fmt.Errorf("key is for %s, expected %s", id, cfg.PublisherID)
+		check.Hint = "advertisements signed with this key would be rejected by indexers; use the matching key or fix the configured ID"
+	}
+	return check
+}
+
+func doctorCheckBackend(ctx context.Context, cfg DoctorConfig) DoctorCheck {
+	check := DoctorCheck{Name: "backend is writable"}
+	if cfg.Backend == nil {
+		check.Skipped = true
+		return check
+	}
+	// store a tiny, deterministic block: this is harmless, even if repeated
+	lnk, err := cfg.Backend.Store(ipld.LinkContext{Ctx: ctx}, schema.Linkproto, basicnode.NewString("herald doctor"))
+	if err != nil {
+		check.Err = err
+		check.Hint = "verify the backend credentials and permissions"
+		return check
+	}
+	// the upload may be asynchronous: its failure must not be mistaken for a refused deletion
+	if f, ok := cfg.Backend.(interface{ Flush(context.Context) error }); ok {
+		if err := f.Flush(ctx); err != nil {
+			check.Err = fmt.Errorf("uploading the probe block: %w", err)
+			check.Hint = "verify the backend credentials and permissions"
+			return check
+		}
+	}
+	// don't leave the probe behind, when the backend allows it
+	if bm, ok := cfg.Backend.(BlockManager); ok {
+		if err := bm.DeleteBlock(ctx, lnk.(cidlink.Link).Cid); err != nil {
+			check.Err = fmt.Errorf("removing the probe block: %w", err)
+			check.Hint = "verify the backend permissions allow deletions"
+		}
+	}
+	return check
+}
+
+func doctorCheckPublicHead(ctx context.Context, cfg DoctorConfig) []DoctorCheck {
+	readable := DoctorCheck{Name: "head is publicly readable and correctly signed"}
+	fresh := DoctorCheck{Name: "public head is fresh"}
+	if cfg.PublicURL == "" {
+		readable.Skipped = true
+		fresh.Skipped = true
+		return []DoctorCheck{readable, fresh}
+	}
+
+	headUrl := strings.TrimSuffix(cfg.PublicURL, "/") + "/head"
+	publicHead, resp, err := doctorFetchHead(ctx, cfg, headUrl)
+	if err != nil {
+		readable.Err = err
+		readable.Hint = fmt.Sprintf("verify that %s is reachable from outside your network", headUrl)
+		fresh.Skipped = true
+		return []DoctorCheck{readable, fresh}
+	}
+
+	if age, err := strconv.Atoi(resp.Header.Get("Age")); err == nil && time.Duration(age)*time.Second > cfg.MaxHeadAge {
+		fresh.Err = fmt.Errorf("head served from a cache %ds old", age)
+		fresh.Hint = "configure the CDN to not cache the head, or with a short TTL"
+		return []DoctorCheck{readable, fresh}
+	}
+
+	reader, ok := cfg.Backend.(ChainReader)
+	if !ok {
+		return []DoctorCheck{readable, fresh}
+	}
+	backendHead, err := reader.GetHead(ctx)
+	if err != nil {
+		fresh.Err = fmt.Errorf("failed to read the backend head: %w", err)
+		return []DoctorCheck{readable, fresh}
+	}
+	if !backendHead.Equals(publicHead) {
+		fresh.Err = fmt.Errorf("public head %s differs from the backend head %s", publicHead, backendHead)
+		fresh.Hint = "the CDN or a proxy is likely serving a stale head; configure it to not cache the head"
+	}
+	return []DoctorCheck{readable, fresh}
+}
+
+func doctorFetchHead(ctx context.Context, cfg DoctorConfig, headUrl string) (cid.Cid, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, headUrl, nil)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	resp, err := cfg.HttpClient.Do(req)
+	if err != nil {
+		return cid.Undef, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return cid.Undef, resp, errors.New("no head published yet")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cid.Undef, resp, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+
+	signedHead, err := head.Decode(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return cid.Undef, resp, fmt.Errorf("invalid head message: %w", err)
+	}
+	signer, err := signedHead.Validate()
+	if err != nil {
+		return cid.Undef, resp, fmt.Errorf("invalid head signature: %w", err)
+	}
+	expected := cfg.PublisherID
+	if expected == "" && cfg.PublisherKey != nil {
+		expected, _ = peer.IDFromPrivateKey(cfg.PublisherKey)
+	}
+	if expected != "" && signer != expected {
+		return cid.Undef, resp, fmt.Errorf("head is signed by %s, expected %s", signer, expected)
+	}
+	return linkCid(signedHead.Head), resp, nil
+}
+
+func doctorCheckAnnounce(ctx context.Context, cfg DoctorConfig, announceUrl string) DoctorCheck {
+	check := DoctorCheck{Name: fmt.Sprintf("announce endpoint %s is reachable", announceUrl)}
+	u, err := url.Parse(announceUrl)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	resp, err := cfg.HttpClient.Do(req)
+	if err != nil {
+		check.Err = err
+		check.Hint = "verify the indexer URL and the outbound network access"
+		return check
+	}
+	_ = resp.Body.Close()
+	// any HTTP answer means the endpoint is reachable; announce itself requires a PUT with a valid message
+	if resp.StatusCode >= 500 {
+		check.Err = fmt.Errorf("indexer answered with %s", resp.Status)
+	}
+	return check
+}
@@ -0,0 +1,90 @@
+package herald
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoctor(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+	backend := NewDsPublisher(ds)
+
+	adCid, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(3)...))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/head" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		signed, err := head.NewSignedHead(adCid, "/indexer/ingest/mainnet", cfg.PublisherKey)
+		require.NoError(t, err)
+		encoded, err := signed.Encode()
+		require.NoError(t, err)
+		_, _ = w.Write(encoded)
+	}))
+	defer server.Close()
+
+	before := countKeys(t, ds)
+	checks := Doctor(ctx, DoctorConfig{
+		PublisherKey: cfg.PublisherKey,
+		PublisherID:  cfg.PublisherID,
+		Backend:      backend,
+		PublicURL:    server.URL,
+		AnnounceURLs: []string{server.URL + "/announce"},
+	})
+	for _, check := range checks {
+		require.True(t, check.OK(), check.String())
+		require.False(t, check.Skipped, check.String())
+	}
+	// the probe block is removed
+	require.Equal(t, before, countKeys(t, ds))
+
+	// a mismatching identity is reported
+	other := testChainConfig(t)
+	checks = Doctor(ctx, DoctorConfig{
+		PublisherKey: cfg.PublisherKey,
+		PublisherID:  other.PublisherID,
+	})
+	require.False(t, checks[0].OK())
+}
+
+func TestDoctorBackendUploadFailure(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake, client := newFakeS3(t)
+	fake.setFailPuts(true)
+	backend := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3UploadConcurrency(4), WithS3RetryPolicy(RetryPolicy{MaxAttempts: 1}))
+
+	// the asynchronous upload fails: it is reported as such, not as a refused deletion
+	checks := Doctor(ctx, DoctorConfig{Backend: backend})
+	var found bool
+	for _, check := range checks {
+		if check.Name == "backend is writable" {
+			found = true
+			require.ErrorContains(t, check.Err, "uploading the probe block")
+			require.NotContains(t, check.Hint, "deletions")
+		}
+	}
+	require.True(t, found)
+}
+
+func countKeys(t *testing.T, ds datastore.Datastore) int {
+	res, err := ds.Query(context.Background(), query.Query{KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	return len(entries)
+}
@@ -0,0 +1,102 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrEmptyCatalog is returned when publishing a catalog without any multihash, or retracting one without ContextID.
+	ErrEmptyCatalog = errors.New("the catalog has no multihash")
+	// ErrEntryChunkTooLarge is returned when a single multihash doesn't fit in an entry chunk, see
+	// ChainConfig.EntriesChunkBytes.
+	ErrEntryChunkTooLarge = errors.New("the multihash is too large for an entry chunk")
+	// ErrBackendUnavailable wraps the failures of the backend storage to store the blocks or to read or write the
+	// head of the chain. Nothing is published, and the operation can be retried.
+	ErrBackendUnavailable = errors.New("the backend is unavailable")
+	// ErrHeadConflict is returned when the stored head of the chain was changed by another writer sharing the same
+	// storage. The head is read again on the next update, so the operation can be retried.
+	ErrHeadConflict = errors.New("the head of the chain was changed concurrently")
+	// ErrSignFailed is returned when an advertisement can't be signed, typically because of an invalid key.
+	ErrSignFailed = errors.New("failed to sign the advertisement")
+	// ErrBatcherSaturated is returned by the CatalogBatcher when the queue of a batching lane is full, according to
+	// its OverflowPolicy. The catalog is not accepted, and can be submitted again later.
+	ErrBatcherSaturated = errors.New("the batcher is saturated")
+	// ErrBatcherClosed is returned by the CatalogBatcher once closed, for the catalogs submitted or still queued.
+	ErrBatcherClosed = errors.New("the batcher is closed")
+	// ErrMirrorDiverged is returned by a Mirror sync when the last advertisement mirrored is not found in the remote
+	// chain, typically because the remote chain was rewritten.
+	ErrMirrorDiverged = errors.New("the remote chain diverged from the mirrored one")
+)
+
+// ErrorClass is the category of a publishing failure, for the callers to decide on retries and alerting.
+type ErrorClass int
+
+const (
+	// ErrorClassNone is the class of a nil error.
+	ErrorClassNone ErrorClass = iota
+	// ErrorClassUnknown is an error not recognized by herald, for example from a catalog.
+	ErrorClassUnknown
+	// ErrorClassTransient is a temporary failure of the environment: retrying the operation may succeed.
+	ErrorClassTransient
+	// ErrorClassInvalid is an invalid input: retrying the same operation will fail again.
+	ErrorClassInvalid
+	// ErrorClassConfig is a misconfiguration, like an invalid key: it needs the attention of an operator.
+	ErrorClassConfig
+	// ErrorClassCanceled is the cancellation or expiration of the context of the operation.
+	ErrorClassCanceled
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassNone:
+		return "none"
+	case ErrorClassUnknown:
+		return "unknown"
+	case ErrorClassTransient:
+		return "transient"
+	case ErrorClassInvalid:
+		return "invalid"
+	case ErrorClassConfig:
+		return "config"
+	case ErrorClassCanceled:
+		return "canceled"
+	default:
+		return fmt.Sprintf("ErrorClass(%d)", int(c))
+	}
+}
+
+// ClassifyError returns the class of an error returned by the publish functions, the batcher or the backends.
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassCanceled
+	case errors.Is(err, ErrSignFailed), errors.Is(err, ErrIdentityMismatch),
+		errors.Is(err, ErrInvalidConfig):
+		return ErrorClassConfig
+	case errors.Is(err, ErrEmptyCatalog), errors.Is(err, ErrEntryChunkTooLarge), errors.Is(err, ErrContextIDLive),
+		errors.Is(err, ErrCatalogConsumed):
+		return ErrorClassInvalid
+	case errors.Is(err, ErrBackendUnavailable), errors.Is(err, ErrHeadConflict), errors.Is(err, ErrBatcherSaturated):
+		return ErrorClassTransient
+	default:
+		return ErrorClassUnknown
+	}
+}
+
+// IsRetryable reports whether err is transient, and the operation worth retrying. It can be used as
+// RetryPolicy.Retryable.
+func IsRetryable(err error) bool {
+	return ClassifyError(err) == ErrorClassTransient
+}
+
+// backendUnavailable wraps a failure of the backend storage with ErrBackendUnavailable, unless already classified.
+func backendUnavailable(err error) error {
+	if ClassifyError(err) != ErrorClassUnknown {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+}
@@ -0,0 +1,78 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/failstore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// failingKey is a private key failing to sign.
+type failingKey struct {
+	crypto.PrivKey
+}
+
+func (failingKey) Sign([]byte) ([]byte, error) {
+	return nil, errors.New("no signing today")
+}
+
+func TestPublishErrors(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	_, err := PublishWithContextID(ctx, cfg, backend, testCatalog{id: []byte("foo")})
+	require.ErrorIs(t, err, ErrEmptyCatalog)
+	require.Equal(t, ErrorClassInvalid, ClassifyError(err))
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes())
+	require.ErrorIs(t, err, ErrEmptyCatalog)
+
+	huge, err := multihash.Encode(make([]byte, MaxEntriesChunkBytes), multihash.IDENTITY)
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(huge))
+	require.ErrorIs(t, err, ErrEntryChunkTooLarge)
+	require.False(t, IsRetryable(err))
+
+	badKey := cfg
+	badKey.PublisherKey = failingKey{cfg.PublisherKey}
+	_, err = PublishRawMHs(ctx, badKey, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.ErrorIs(t, err, ErrSignFailed)
+	require.Equal(t, ErrorClassConfig, ClassifyError(err))
+
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, head.Defined())
+
+	require.Equal(t, ErrorClassNone, ClassifyError(nil))
+	require.Equal(t, ErrorClassCanceled, ClassifyError(context.Canceled))
+	require.Equal(t, ErrorClassUnknown, ClassifyError(errors.New("boom")))
+}
+
+func TestBackendErrors(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	ds := sync.MutexWrap(datastore.NewMapDatastore())
+
+	var failing bool
+	fs := failstore.NewFailstore(ds, func(op string) error {
+		if failing {
+			return errors.New("disk on fire")
+		}
+		return nil
+	})
+	backend := NewDatastoreBackend(fs)
+	failing = true
+	_, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.ErrorIs(t, err, ErrBackendUnavailable)
+	require.True(t, IsRetryable(err))
+	failing = false
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+
+}
@@ -7,27 +7,24 @@ func Example() {
 	//
 	// announcer := httpsender.New()
 	//
+	// chainCfg := DefaultChainConfig()
+	// chainCfg.PublisherID, chainCfg.PublisherKey, _ = LoadIdentityFromFile("publisher.key")
+	// chainCfg.ProviderAddrs = []string{"/dns4/provider.example.com/tcp/443/https"}
+	// chainCfg.PublisherHttpAddrs = []multiaddr.Multiaddr{multiaddr.StringCast("/dns4/chain.example.com/tcp/443/https")}
+	// if err := chainCfg.Validate(); err != nil {
+	// 	return err
+	// }
+	//
 	// batcher := StartCatalogBatcher(BatchConfig{
 	// 	CountThreshold:         1000,
 	// 	MaxDelay:               DefaultMaxDelay,
 	// 	MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
-	// }, ChainConfig{
-	// 	AdEntriesChunkSize: 0,
-	// 	PublisherId:        "",
-	// 	PublisherKey:       nil,
-	// 	ProviderAddrs:      nil,
-	// 	PublisherHttpAddrs: nil,
-	// 	Metadata:           nil,
-	// }, backend, announcer)
+	// }, chainCfg, backend, announcer)
 	//
-	// for {
-	// 	// read from SQS
-	// 	msg := read()
-	//
-	// 	if msg.Publish {
-	// 		batcher.PublishCatalog(ctx, CatalogFromFoo(...))
-	// 	} else {
-	// 		batcher.RetractCatalog(ctx, CatalogFromFoo(...))
-	// 	}
-	// }
+	// consumer, err := StartSQSConsumer(SQSConsumerConfig{
+	// 	Client:   sqs.NewFromConfig(awsCfg),
+	// 	QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/catalogs",
+	// 	S3:       s3.NewFromConfig(awsCfg),
+	// }, batcher)
+	// defer consumer.Close()
 }
@@ -0,0 +1,239 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// GCPolicy is the retention policy applied by GC.
+type GCPolicy struct {
+	// KeepLast is the number of most recent advertisements kept as they are. Older advertisements are compacted.
+	KeepLast int
+
+	// Delete makes GC delete the blocks not reachable anymore. The backend must implement BlockManager.
+	// Otherwise, those blocks are only listed in the report, and can be deleted later with DeleteOrphans, once the
+	// indexers had time to sync the new chain.
+	Delete bool
+}
+
+// GCReport is the result of a garbage collection.
+type GCReport struct {
+	OldHead cid.Cid
+	NewHead cid.Cid
+
+	// AdsBefore and AdsAfter are the lengths of the chain before and after the collection
+	AdsBefore int
+	AdsAfter  int
+
+	// Unreachable are the blocks of the old chain that are not reachable from the new head
+	Unreachable []cid.Cid
+}
+
+// errNothingToCollect aborts the head update when the chain is already within the policy.
+var errNothingToCollect = errors.New("nothing to collect")
+
+// gcAd is an advertisement of the chain being collected.
+type gcAd struct {
+	ad      schema.Advertisement
+	entries ipld.Link
+}
+
+// GC compacts the chain: the advertisements older than the policy retention are dropped if they have no effect
+// anymore, namely the ContextIDs retracted later and the multihashes retracted later. The ones still in effect are
+// carried over, reusing their entry chunks when possible.
+//
+// Advertisements being immutable and chained, the whole chain is re-signed with cfg on top of a new genesis, as
+// for a chain truncation: the indexers already in sync re-ingest the chain and end up in the same state, while the
+// new indexers only ingest what is still in effect.
+func GC(ctx context.Context, cfg ChainConfig, backend ChainWriter, reader ChainReader, policy GCPolicy) (*GCReport, error) {
+	if policy.KeepLast < 0 {
+		return nil, fmt.Errorf("invalid KeepLast: %d", policy.KeepLast)
+	}
+	var blocks BlockManager
+	if policy.Delete {
+		var ok bool
+		if blocks, ok = backend.(BlockManager); !ok {
+			return nil, fmt.Errorf("backend %T doesn't support deleting blocks", backend)
+		}
+	}
+
+	// blocks created concurrently are not reachable from this head, which makes sure they are not collected
+	oldReachable, err := reachableBlocks(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{}
+	err = backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
+		report.OldHead = head
+
+		var ads []gcAd // newest first
+		err := WalkAdsFrom(ctx, reader, head, func(_ cid.Cid, ad schema.Advertisement) error {
+			ads = append(ads, gcAd{ad: ad, entries: ad.Entries})
+			return nil
+		})
+		if err != nil {
+			return cid.Undef, err
+		}
+		report.AdsBefore = len(ads)
+		if len(ads) <= policy.KeepLast {
+			return cid.Undef, errNothingToCollect
+		}
+
+		kept, err := gcCompact(ctx, cfg, backend, reader, ads[:policy.KeepLast], ads[policy.KeepLast:])
+		if err != nil {
+			return cid.Undef, err
+		}
+		kept = append(ads[:policy.KeepLast:policy.KeepLast], kept...)
+		report.AdsAfter = len(kept)
+
+		// rebuild the chain, oldest first
+		newHead := cid.Undef
+		for i := len(kept) - 1; i >= 0; i-- {
+			adCfg, err := resignConfig(cfg, kept[i].ad)
+			if err != nil {
+				return cid.Undef, err
+			}
+			newHead, err = storeAdvertisement(ctx, adCfg, backend, newHead, kept[i].ad.ContextID, kept[i].entries, kept[i].ad.IsRm)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		report.NewHead = newHead
+		return newHead, nil
+	})
+	if errors.Is(err, errNothingToCollect) {
+		report.NewHead = report.OldHead
+		report.AdsAfter = report.AdsBefore
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reach := newReachability(reader)
+	if err := reach.update(ctx); err != nil {
+		return nil, err
+	}
+	for c := range oldReachable {
+		if _, ok := reach.reachable[c]; !ok {
+			report.Unreachable = append(report.Unreachable, c)
+		}
+	}
+
+	cfg.logger().Infow("Collected the chain", "oldHead", report.OldHead, "newHead", report.NewHead,
+		"adsBefore", report.AdsBefore, "adsAfter", report.AdsAfter, "unreachable", len(report.Unreachable))
+
+	if blocks != nil {
+		// the blocks reused by the advertisements published since the collection are kept
+		if err := deleteOrphans(ctx, blocks, &OrphanReport{Blocks: report.Unreachable}, reach, cfg.logger()); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// gcCompact returns the advertisements of old still in effect, given the more recent ones. Both are newest first.
+func gcCompact(ctx context.Context, cfg ChainConfig, backend ChainWriter, reader ChainReader, recent, old []gcAd) ([]gcAd, error) {
+	retracted := make(map[string]struct{}) // ContextIDs retracted by a newer advertisement
+	removed := make(map[string]struct{})   // multihashes retracted by a newer advertisement
+
+	track := func(ad schema.Advertisement) error {
+		switch {
+		case !ad.IsRm:
+		case len(ad.ContextID) > 0:
+			retracted[string(ad.ContextID)] = struct{}{}
+		default:
+			return WalkEntryBlocks(ctx, reader, ad.Entries, func(_ cid.Cid, _ []byte, mhs []multihash.Multihash) error {
+				for _, mh := range mhs {
+					removed[string(mh)] = struct{}{}
+				}
+				return nil
+			})
+		}
+		return nil
+	}
+
+	for _, a := range recent {
+		if err := track(a.ad); err != nil {
+			return nil, err
+		}
+	}
+
+	var kept []gcAd
+	for _, a := range old {
+		switch {
+		case a.ad.IsRm:
+			// the advertisements retracted are older, and dropped as well
+		case len(a.ad.ContextID) > 0:
+			if _, ok := retracted[string(a.ad.ContextID)]; !ok {
+				kept = append(kept, a)
+			}
+		default:
+			entries, err := gcFilterEntries(ctx, cfg, backend, reader, a.ad.Entries, removed)
+			if err != nil {
+				return nil, err
+			}
+			if entries != nil {
+				kept = append(kept, gcAd{ad: a.ad, entries: entries})
+			}
+		}
+		if err := track(a.ad); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// gcFilterEntries returns the entries without the removed multihashes: the same entries if none is removed, new
+// entries if some are, or nil if all are.
+func gcFilterEntries(ctx context.Context, cfg ChainConfig, backend ChainWriter, reader ChainReader, entries ipld.Link, removed map[string]struct{}) (ipld.Link, error) {
+	var remaining []multihash.Multihash
+	var total int
+	err := WalkEntryBlocks(ctx, reader, entries, func(_ cid.Cid, _ []byte, mhs []multihash.Multihash) error {
+		for _, mh := range mhs {
+			total++
+			if _, ok := removed[string(mh)]; !ok {
+				remaining = append(remaining, mh)
+			}
+		}
+		return nil
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case len(remaining) == 0:
+		return nil, nil
+	case len(remaining) == total:
+		return entries, nil
+	default:
+		lnk, _, err := generateEntries(ctx, cfg, backend, CatalogFromMultihashes(remaining...))
+		return lnk, err
+	}
+}
+
+// resignConfig returns the configuration to re-sign the advertisement, preserving its properties.
+func resignConfig(cfg ChainConfig, ad schema.Advertisement) (ChainConfig, error) {
+	cfg.Metadata = ad.Metadata
+	cfg.ProviderAddrs = ad.Addresses
+	provider, err := peer.Decode(ad.Provider)
+	if err != nil {
+		return ChainConfig{}, fmt.Errorf("invalid provider in advertisement: %w", err)
+	}
+	switch provider {
+	case cfg.ProviderID:
+	case cfg.PublisherID:
+		cfg.ProviderID, cfg.ProviderKey = "", nil
+	default:
+		// signed by the publisher on behalf of the provider
+		cfg.ProviderID, cfg.ProviderKey = provider, nil
+	}
+	return cfg, nil
+}
@@ -0,0 +1,89 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGC(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	mhs := testMultihashes(20)
+
+	_, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: mhs[0:3], id: []byte("a")})
+	require.NoError(t, err)
+	_, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: mhs[3:6], id: []byte("b")})
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(mhs[10:14]...))
+	require.NoError(t, err)
+	_, err = RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("a")})
+	require.NoError(t, err)
+	_, err = RetractRawMHs(ctx, cfg, backend, CatalogFromMultihashes(mhs[10:12]...))
+	require.NoError(t, err)
+	oldHead, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(mhs[15]))
+	require.NoError(t, err)
+
+	// within the policy: nothing to do
+	report, err := GC(ctx, cfg, backend, backend, GCPolicy{KeepLast: 10, Delete: true})
+	require.NoError(t, err)
+	require.Equal(t, oldHead, report.NewHead)
+	require.Equal(t, 6, report.AdsBefore)
+	require.Equal(t, 6, report.AdsAfter)
+	require.Empty(t, report.Unreachable)
+
+	report, err = GC(ctx, cfg, backend, backend, GCPolicy{KeepLast: 1, Delete: true})
+	require.NoError(t, err)
+	require.Equal(t, oldHead, report.OldHead)
+	require.Equal(t, 6, report.AdsBefore)
+	require.Equal(t, 3, report.AdsAfter)
+	require.NotEmpty(t, report.Unreachable)
+
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, report.NewHead, head)
+	_, err = backend.GetContent(ctx, oldHead)
+	require.ErrorIs(t, err, ErrContentNotFound)
+
+	// the compacted chain, newest first
+	type state struct {
+		contextID string
+		mhs       []multihash.Multihash
+	}
+	var got []state
+	err = WalkAds(ctx, backend, func(adCid cid.Cid, ad schema.Advertisement) error {
+		require.False(t, ad.IsRm)
+		s := state{contextID: string(ad.ContextID)}
+		err := WalkEntries(ctx, backend, adCid, func(mh multihash.Multihash) error {
+			s.mhs = append(s.mhs, mh)
+			return nil
+		})
+		got = append(got, s)
+		return err
+	})
+	require.NoError(t, err)
+	expected := []state{
+		{mhs: mhs[15:16]},
+		{mhs: mhs[12:14]},
+		{contextID: "b", mhs: mhs[3:6]},
+	}
+	require.Len(t, got, len(expected))
+	for i := range expected {
+		require.Equal(t, expected[i].contextID, got[i].contextID)
+		// the chunks are chained from the last one, the order of the multihashes is not preserved
+		require.ElementsMatch(t, expected[i].mhs, got[i].mhs)
+	}
+
+	// nothing left behind
+	orphans, err := FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+	require.Empty(t, orphans.Ads)
+	require.Empty(t, orphans.Blocks)
+}
@@ -0,0 +1,298 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/multicodec"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipni/go-libipni/ingest/schema"
+	mc "github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// EntriesFormat is the data structure holding the multihashes of an advertisement.
+type EntriesFormat int
+
+const (
+	// EntriesChunks is a linked list of EntryChunk, the default.
+	EntriesChunks EntriesFormat = iota
+	// EntriesHAMT is an IPLD HAMT used as a set of multihashes, see
+	// https://ipld.io/specs/advanced-data-layouts/hamt/spec. It allows indexers random access to the multihashes.
+	// It's built in memory, and holds up to 2^20 multihashes: a larger catalog with a ContextID is split into several
+	// advertisements, as with MaxMHsPerAdvertisement.
+	EntriesHAMT
+)
+
+func (f EntriesFormat) String() string {
+	switch f {
+	case EntriesChunks:
+		return "chunks"
+	case EntriesHAMT:
+		return "hamt"
+	default:
+		return fmt.Sprintf("EntriesFormat(%d)", int(f))
+	}
+}
+
+const (
+	// hamtBitWidth is the number of bits of the hash used at each level: one byte, for 256 elements per node
+	hamtBitWidth = 8
+	// hamtBucketSize is the maximum number of keys in a bucket, before it is replaced by a child node
+	hamtBucketSize = 3
+	// hamtHashAlg is the hash function of the keys
+	hamtHashAlg = mc.Sha2_256
+	// hamtMaxMHs bounds the multihashes of a HAMT, and so the memory used to build it
+	hamtMaxMHs = 1 << 20
+	// hamtMaxEntrySize is the maximum encoded size of a bucket entry, so that a node whose slots all hold a full
+	// bucket, or the root holding such a node, stays within MaxEntriesChunkBytes: 2KiB are left for the bitmap, the
+	// lists of the buckets and the fields of the root
+	hamtMaxEntrySize = (MaxEntriesChunkBytes - 2048) / (hamtBucketSize << hamtBitWidth)
+)
+
+// hamtEntrySize returns the size of a multihash in an encoded bucket, as DAG-JSON: [{"/":{"bytes":"..."}},true],
+func hamtEntrySize(mh multihash.Multihash) int {
+	return encodedEntrySize(mh) + len(`[,true]`)
+}
+
+// hamtNode is a node of a HAMT being built in memory.
+type hamtNode struct {
+	slots [1 << hamtBitWidth]*hamtSlot
+}
+
+// hamtSlot is an element of a hamtNode: a bucket of keys sorted, or a child node.
+type hamtSlot struct {
+	bucket []hamtEntry
+	child  *hamtNode
+}
+
+type hamtEntry struct {
+	key  multihash.Multihash
+	hash [sha256.Size]byte
+}
+
+// insert adds e to the node at the given depth, and returns false if the key is already present.
+func (n *hamtNode) insert(e hamtEntry, depth int) (bool, error) {
+	if depth >= len(e.hash) {
+		return false, errors.New("HAMT hash exhausted")
+	}
+	idx := e.hash[depth]
+	slot := n.slots[idx]
+	switch {
+	case slot == nil:
+		n.slots[idx] = &hamtSlot{bucket: []hamtEntry{e}}
+		return true, nil
+	case slot.child != nil:
+		return slot.child.insert(e, depth+1)
+	}
+
+	pos := sort.Search(len(slot.bucket), func(i int) bool {
+		return bytes.Compare(slot.bucket[i].key, e.key) >= 0
+	})
+	if pos < len(slot.bucket) && bytes.Equal(slot.bucket[pos].key, e.key) {
+		return false, nil
+	}
+	if len(slot.bucket) < hamtBucketSize {
+		slot.bucket = append(slot.bucket, hamtEntry{})
+		copy(slot.bucket[pos+1:], slot.bucket[pos:])
+		slot.bucket[pos] = e
+		return true, nil
+	}
+
+	// the bucket is full: its keys move to a new child node, one level deeper
+	child := &hamtNode{}
+	for _, old := range slot.bucket {
+		if _, err := child.insert(old, depth+1); err != nil {
+			return false, err
+		}
+	}
+	slot.bucket, slot.child = nil, child
+	return child.insert(e, depth+1)
+}
+
+// build stores the child nodes in the backend, and returns the IPLD node of n. It counts the blocks stored.
+func (n *hamtNode) build(ctx context.Context, lp ipld.LinkPrototype, backend ChainWriter, blocks *int) (datamodel.Node, error) {
+	var bitmap [(1 << hamtBitWidth) / 8]byte
+	type element struct {
+		link   ipld.Link
+		bucket []hamtEntry
+	}
+	var elements []element
+	for i, slot := range n.slots {
+		if slot == nil {
+			continue
+		}
+		// the bitmap is read from its first byte, and each byte from its highest bit
+		bitmap[i/8] |= 1 << (7 - i%8)
+		if slot.child == nil {
+			elements = append(elements, element{bucket: slot.bucket})
+			continue
+		}
+		childNode, err := slot.child.build(ctx, lp, backend, blocks)
+		if err != nil {
+			return nil, err
+		}
+		lnk, err := backend.Store(ipld.LinkContext{Ctx: ctx}, lp, childNode)
+		if err != nil {
+			return nil, backendUnavailable(err)
+		}
+		*blocks++
+		elements = append(elements, element{link: lnk})
+	}
+
+	return qp.BuildList(basicnode.Prototype.Any, 2, func(la datamodel.ListAssembler) {
+		qp.ListEntry(la, qp.Bytes(bitmap[:]))
+		qp.ListEntry(la, qp.List(int64(len(elements)), func(la datamodel.ListAssembler) {
+			for _, e := range elements {
+				if e.link != nil {
+					qp.ListEntry(la, qp.Link(e.link))
+					continue
+				}
+				qp.ListEntry(la, qp.List(int64(len(e.bucket)), func(la datamodel.ListAssembler) {
+					for _, entry := range e.bucket {
+						qp.ListEntry(la, qp.List(2, func(la datamodel.ListAssembler) {
+							qp.ListEntry(la, qp.Bytes(entry.key))
+							qp.ListEntry(la, qp.Bool(true))
+						}))
+					}
+				}))
+			}
+		}))
+	})
+}
+
+// generateEntriesHAMT generates a HAMT holding up to maxMHs multihashes of the iterator, and at most hamtMaxMHs. The
+// HAMT is a set: duplicated multihashes are only counted once.
+func generateEntriesHAMT(ctx context.Context, cfg ChainConfig, backend ChainWriter, iter *entriesIterator, maxMHs int) (_ ipld.Link, _ int, err error) {
+	var mhCount, blockCount int
+	ctx, span := cfg.tracer().Start(ctx, "generateEntries")
+	defer func() {
+		span.SetAttributes(attribute.Int("herald.multihashes", mhCount), attribute.Int("herald.chunks", blockCount))
+		endSpan(span, err)
+	}()
+
+	_, maxBytes := cfg.entriesChunkLimits()
+	maxMHs = min(maxMHs, hamtMaxMHs)
+	root := &hamtNode{}
+	for !iter.Done() && mhCount < maxMHs {
+		mh := iter.Next()
+		if encodedEntrySize(mh) > maxBytes || hamtEntrySize(mh) > hamtMaxEntrySize {
+			return nil, 0, fmt.Errorf("%w: multihash of %d bytes", ErrEntryChunkTooLarge, len(mh))
+		}
+		added, err := root.insert(hamtEntry{key: mh, hash: sha256.Sum256(mh)}, 0)
+		if err != nil {
+			return nil, 0, err
+		}
+		if added {
+			mhCount++
+		}
+	}
+	if iter.Done() {
+		// a truncated catalog must not be published
+		if err := iteratorErr(iter.iter); err != nil {
+			return nil, 0, err
+		}
+	}
+	if mhCount == 0 {
+		return nil, 0, nil
+	}
+
+	rootNode, err := root.build(ctx, cfg.linkPrototype(), backend, &blockCount)
+	if err != nil {
+		return nil, 0, err
+	}
+	hamt, err := qp.BuildMap(basicnode.Prototype.Any, 3, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "hashAlg", qp.Int(int64(hamtHashAlg)))
+		qp.MapEntry(ma, "bucketSize", qp.Int(hamtBucketSize))
+		qp.MapEntry(ma, "hamt", qp.Node(rootNode))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	lnk, err := backend.Store(ipld.LinkContext{Ctx: ctx}, cfg.linkPrototype(), hamt)
+	if err != nil {
+		return nil, 0, backendUnavailable(err)
+	}
+	blockCount++
+	cfg.progress.stored(mhCount, blockCount, 0)
+	cfg.logger().Infow("Generated HAMT of multihashes", "link", lnk, "totalMhCount", mhCount, "blockCount", blockCount)
+	return lnk, mhCount, nil
+}
+
+// decodeHAMTBlock decodes a block of a HAMT, the root if root is true, and returns the keys it holds and the CIDs of
+// its child nodes.
+func decodeHAMTBlock(c cid.Cid, data []byte, root bool) ([]multihash.Multihash, []cid.Cid, error) {
+	decoder, err := multicodec.LookupDecoder(c.Prefix().Codec)
+	if err != nil {
+		return nil, nil, err
+	}
+	node, err := ipld.DecodeUsingPrototype(data, decoder, basicnode.Prototype.Any)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root {
+		if node, err = node.LookupByString("hamt"); err != nil {
+			return nil, nil, fmt.Errorf("not a HAMT root: %w", err)
+		}
+	}
+	elements, err := node.LookupByIndex(1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid HAMT node: %w", err)
+	}
+
+	var keys []multihash.Multihash
+	var children []cid.Cid
+	for it := elements.ListIterator(); it != nil && !it.Done(); {
+		_, element, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if element.Kind() == datamodel.Kind_Link {
+			lnk, _ := element.AsLink()
+			children = append(children, linkCid(lnk))
+			continue
+		}
+		for bucket := element.ListIterator(); bucket != nil && !bucket.Done(); {
+			_, entry, err := bucket.Next()
+			if err != nil {
+				return nil, nil, err
+			}
+			keyNode, err := entry.LookupByIndex(0)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid HAMT bucket: %w", err)
+			}
+			key, err := keyNode.AsBytes()
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid HAMT key: %w", err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, children, nil
+}
+
+// entriesFormatOf returns the format of the entries starting at entries.
+func entriesFormatOf(ctx context.Context, backend ChainReader, entries ipld.Link) (EntriesFormat, error) {
+	c := linkCid(entries)
+	data, err := readEntryBlock(ctx, backend, c)
+	if err != nil {
+		return 0, err
+	}
+	_, chunkErr := schema.BytesToEntryChunk(c, data)
+	if chunkErr == nil {
+		return EntriesChunks, nil
+	}
+	if _, _, err := decodeHAMTBlock(c, data, true); err == nil {
+		return EntriesHAMT, nil
+	}
+	return 0, &entryBlockError{block: c, decode: true, err: chunkErr}
+}
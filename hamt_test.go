@@ -0,0 +1,198 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/bits"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	hamt "github.com/ipld/go-ipld-adl-hamt"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/node/bindnode"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// hamtLookup finds key in the HAMT rooted at root, following the IPLD HAMT specification.
+func hamtLookup(t *testing.T, ctx context.Context, backend ChainReader, root cid.Cid, key []byte) bool {
+	decode := func(c cid.Cid) datamodel.Node {
+		data, err := backend.GetContent(ctx, c)
+		require.NoError(t, err)
+		node, err := ipld.DecodeUsingPrototype(data, dagjson.Decode, basicnode.Prototype.Any)
+		require.NoError(t, err)
+		return node
+	}
+	rootNode := decode(root)
+	hashAlg, err := rootNode.LookupByString("hashAlg")
+	require.NoError(t, err)
+	alg, err := hashAlg.AsInt()
+	require.NoError(t, err)
+	require.EqualValues(t, multihash.SHA2_256, alg)
+	node, err := rootNode.LookupByString("hamt")
+	require.NoError(t, err)
+
+	hash := sha256.Sum256(key)
+	for depth := 0; ; depth++ {
+		bitmapNode, err := node.LookupByIndex(0)
+		require.NoError(t, err)
+		bitmap, err := bitmapNode.AsBytes()
+		require.NoError(t, err)
+		require.Len(t, bitmap, 32)
+		idx := int(hash[depth])
+		if bitmap[idx/8]&(1<<(7-idx%8)) == 0 {
+			return false
+		}
+		// the position of the element is the number of bits set below idx
+		var pos int
+		for i := 0; i < idx; i++ {
+			pos += bits.OnesCount8(bitmap[i/8] >> (7 - i%8) & 1)
+		}
+		data, err := node.LookupByIndex(1)
+		require.NoError(t, err)
+		element, err := data.LookupByIndex(int64(pos))
+		require.NoError(t, err)
+		if element.Kind() == datamodel.Kind_Link {
+			lnk, err := element.AsLink()
+			require.NoError(t, err)
+			node = decode(linkCid(lnk))
+			continue
+		}
+		for it := element.ListIterator(); !it.Done(); {
+			_, entry, err := it.Next()
+			require.NoError(t, err)
+			k, err := entry.LookupByIndex(0)
+			require.NoError(t, err)
+			kb, err := k.AsBytes()
+			require.NoError(t, err)
+			if bytes.Equal(kb, key) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestEntriesHAMT(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.EntriesFormat = EntriesHAMT
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	mhs := testMultihashes(2000)
+	// duplicates are only stored once
+	head, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: append(mhs, mhs[:10]...), id: []byte("foo")})
+	require.NoError(t, err)
+
+	var walked []multihash.Multihash
+	err = WalkEntries(ctx, backend, head, func(mh multihash.Multihash) error {
+		walked = append(walked, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, mhs, walked)
+
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	var blocks int
+	err = WalkEntryBlocks(ctx, backend, ad.Entries, func(cid.Cid, []byte, []multihash.Multihash) error {
+		blocks++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Greater(t, blocks, 1)
+
+	for _, mh := range mhs[:100] {
+		require.True(t, hamtLookup(t, ctx, backend, linkCid(ad.Entries), mh))
+	}
+	require.False(t, hamtLookup(t, ctx, backend, linkCid(ad.Entries), testMultihashes(2001)[2000]))
+
+	// the chain tools handle both formats
+	cfg.EntriesFormat = EntriesChunks
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	report, err := VerifyChain(ctx, backend, VerifyOptions{})
+	require.NoError(t, err)
+	require.Empty(t, report.Issues)
+	require.Equal(t, blocks+3, report.Chunks)
+
+	var car bytes.Buffer
+	require.NoError(t, ExportChainToCar(ctx, backend, &car))
+	imported := NewMemoryBackend()
+	_, err = ImportChainFromCar(ctx, imported, &car)
+	require.NoError(t, err)
+	walked = walked[:0]
+	err = WalkEntries(ctx, imported, head, func(mh multihash.Multihash) error {
+		walked = append(walked, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, mhs, walked)
+}
+
+// TestEntriesHAMTInterop reads the HAMT with go-ipld-adl-hamt, the reference implementation of the specification.
+func TestEntriesHAMTInterop(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.EntriesFormat = EntriesHAMT
+	backend := NewMemoryBackend()
+
+	mhs := testMultihashes(3000)
+	head, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: mhs, id: []byte("foo")})
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		data, err := backend.GetContent(lctx.Ctx, linkCid(lnk))
+		return bytes.NewReader(data), err
+	}
+	rootNode, err := lsys.Load(ipld.LinkContext{Ctx: ctx}, ad.Entries, hamt.HashMapRootPrototype.Representation())
+	require.NoError(t, err)
+	root := (&hamt.Node{HashMapRoot: *bindnode.Unwrap(rootNode).(*hamt.HashMapRoot)}).WithLinking(lsys, cfg.linkPrototype())
+
+	var keys []multihash.Multihash
+	for it := root.MapIterator(); !it.Done(); {
+		k, _, err := it.Next()
+		require.NoError(t, err)
+		key, err := k.AsString()
+		require.NoError(t, err)
+		keys = append(keys, multihash.Multihash(key))
+	}
+	require.ElementsMatch(t, mhs, keys)
+	require.EqualValues(t, len(mhs), root.Length())
+
+	for _, mh := range mhs[:100] {
+		value, err := root.LookupByString(string(mh))
+		require.NoError(t, err)
+		present, err := value.AsBool()
+		require.NoError(t, err)
+		require.True(t, present)
+	}
+	_, err = root.LookupByString(string(testMultihashes(3001)[3000]))
+	require.ErrorAs(t, err, &datamodel.ErrNotExists{})
+}
+
+func TestEntriesHAMTLimits(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.EntriesFormat = EntriesHAMT
+	backend := NewMemoryBackend()
+
+	// a node full of the largest multihashes accepted fits in a block
+	require.LessOrEqual(t, (hamtMaxEntrySize*hamtBucketSize+len(`[],`))<<hamtBitWidth+1024, MaxEntriesChunkBytes)
+	huge := make([]byte, hamtMaxEntrySize)
+	mh, err := multihash.Encode(huge, multihash.IDENTITY)
+	require.NoError(t, err)
+	_, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: MhCatalog{mh}, id: []byte("foo")})
+	require.ErrorIs(t, err, ErrEntryChunkTooLarge)
+}
@@ -0,0 +1,62 @@
+package herald
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+)
+
+// headSubscriptionBuffer is the number of head changes buffered for a slow subscriber.
+const headSubscriptionBuffer = 16
+
+// headNotifier dispatches head changes to subscribers.
+// It never blocks the writer: if a subscriber falls behind, the oldest pending head is dropped in favor of the newest.
+type headNotifier struct {
+	mu   sync.Mutex
+	subs map[chan cid.Cid]struct{}
+}
+
+// subscribe returns a channel receiving every new head, until ctx is done.
+func (n *headNotifier) subscribe(ctx context.Context) <-chan cid.Cid {
+	ch := make(chan cid.Cid, headSubscriptionBuffer)
+
+	n.mu.Lock()
+	if n.subs == nil {
+		n.subs = make(map[chan cid.Cid]struct{})
+	}
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		delete(n.subs, ch)
+		close(ch)
+		n.mu.Unlock()
+	}()
+
+	return ch
+}
+
+// notify sends the new head to all the subscribers.
+func (n *headNotifier) notify(head cid.Cid) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ch := range n.subs {
+		for {
+			select {
+			case ch <- head:
+			default:
+				// subscriber is lagging, drop the oldest head and try again
+				select {
+				case <-ch:
+				default:
+				}
+				continue
+			}
+			break
+		}
+	}
+}
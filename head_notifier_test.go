@@ -0,0 +1,42 @@
+package herald
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeHead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	heads, err := backend.SubscribeHead(ctx)
+	require.NoError(t, err)
+
+	var expected []cid.Cid
+	for i := 0; i < 3; i++ {
+		h, _ := multihash.Sum([]byte(strconv.Itoa(i)), multihash.SHA2_256, -1)
+		c := cid.NewCidV1(cid.DagCBOR, h)
+		expected = append(expected, c)
+		err = backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+			return c, nil
+		})
+		require.NoError(t, err)
+	}
+
+	for _, c := range expected {
+		require.Equal(t, c, <-heads)
+	}
+
+	cancel()
+	_, ok := <-heads
+	require.False(t, ok)
+}
@@ -0,0 +1,96 @@
+package herald
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+// heartbeatJitter is the fraction of the heartbeat interval that is randomized, so that many publishers started
+// together don't re-announce at the same time.
+const heartbeatJitter = 0.1
+
+// heartbeat re-announces the current head when no announcement was sent for an interval, in case the indexers
+// missed the previous ones.
+type heartbeat struct {
+	interval time.Duration
+	head     func(ctx context.Context) (cid.Cid, error)
+	lastSent func() time.Time
+	announce func(ctx context.Context, head cid.Cid) error
+	logger   *zap.SugaredLogger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startHeartbeat(interval time.Duration, head func(ctx context.Context) (cid.Cid, error), lastSent func() time.Time,
+	announce func(ctx context.Context, head cid.Cid) error, logger *zap.SugaredLogger) *heartbeat {
+	hb := &heartbeat{
+		interval: interval,
+		head:     head,
+		lastSent: lastSent,
+		announce: announce,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go hb.run()
+	return hb
+}
+
+func (hb *heartbeat) run() {
+	defer close(hb.done)
+	jitter := RetryPolicy{Jitter: heartbeatJitter}
+	timer := time.NewTimer(jitter.jittered(hb.interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-hb.stop:
+			return
+		case <-timer.C:
+		}
+		// a head announced since the last beat postpones the next one
+		delay := hb.interval
+		if last := hb.lastSent(); !last.IsZero() && time.Since(last) < hb.interval {
+			delay = hb.interval - time.Since(last)
+		} else {
+			hb.beat()
+		}
+		timer.Reset(jitter.jittered(delay))
+	}
+}
+
+func (hb *heartbeat) beat() {
+	ctx, cancel := context.WithTimeout(context.Background(), hb.interval)
+	defer cancel()
+	go func() {
+		select {
+		case <-hb.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	head, err := hb.head(ctx)
+	if err != nil {
+		hb.logger.Errorw("heartbeat failed to read the head", "err", err)
+		return
+	}
+	if !head.Defined() {
+		return
+	}
+	if err := hb.announce(ctx, head); err != nil {
+		// the next beat tries again
+		hb.logger.Warnw("failed to re-announce the head", "head", head, "err", err)
+		return
+	}
+	hb.logger.Debugw("Re-announced the head", "head", head)
+}
+
+// close stops the heartbeat, interrupting a re-announcement in progress.
+func (hb *heartbeat) close() {
+	close(hb.stop)
+	<-hb.done
+}
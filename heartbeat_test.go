@@ -0,0 +1,119 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	gosync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeat(t *testing.T) {
+	head := testMultihashes(1)[0]
+	headCid := cid.NewCidV1(cid.Raw, head)
+	interval := 20 * time.Millisecond
+
+	var mu gosync.Mutex
+	var last time.Time
+	var beats atomic.Int32
+	var failing atomic.Bool
+	lastSent := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+	announce := func(_ context.Context, c cid.Cid) error {
+		require.Equal(t, headCid, c)
+		beats.Add(1)
+		if failing.Load() {
+			return errors.New("unreachable")
+		}
+		mu.Lock()
+		last = time.Now()
+		mu.Unlock()
+		return nil
+	}
+	getHead := func(context.Context) (cid.Cid, error) { return headCid, nil }
+
+	hb := startHeartbeat(interval, getHead, lastSent, announce, defaultLogger())
+	require.Eventually(t, func() bool { return beats.Load() >= 3 }, 2*time.Second, time.Millisecond)
+
+	// the announcements sent meanwhile postpone the beats
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				mu.Lock()
+				last = time.Now()
+				mu.Unlock()
+			}
+		}
+	}()
+	time.Sleep(2 * interval)
+	before := beats.Load()
+	time.Sleep(5 * interval)
+	require.Equal(t, before, beats.Load())
+	close(stop)
+
+	// a failed beat is tried again at the next interval
+	failing.Store(true)
+	before = beats.Load()
+	require.Eventually(t, func() bool { return beats.Load() >= before+2 }, 2*time.Second, time.Millisecond)
+
+	hb.close()
+	before = beats.Load()
+	time.Sleep(3 * interval)
+	require.Equal(t, before, beats.Load())
+}
+
+func TestHeraldHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	common := []Option{
+		WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+		WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")),
+		WithPublisherAddress(multiaddr.StringCast("/dns/example.com/tcp/443/https")),
+		WithBatching(BatchConfig{
+			CountThreshold:         1,
+			MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+			MaxDelay:               time.Second,
+		}),
+	}
+
+	_, err := New(append(common, WithHeartbeat(0))...)
+	require.Error(t, err)
+	_, err = New(append(common, WithHeartbeat(time.Minute))...)
+	require.ErrorContains(t, err, "announcer")
+
+	announcer := &recordingSender{}
+	h, err := New(append(common, WithAnnouncer(announcer), WithHeartbeat(20*time.Millisecond))...)
+	require.NoError(t, err)
+	require.NoError(t, h.Start(ctx))
+
+	// nothing to re-announce on an empty chain
+	time.Sleep(60 * time.Millisecond)
+	require.Empty(t, announcer.heads())
+
+	require.NoError(t, h.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")}))
+	require.Eventually(t, func() bool { return len(announcer.heads()) >= 3 }, 5*time.Second, 5*time.Millisecond)
+	head, err := h.Backend().(ChainReader).GetHead(ctx)
+	require.NoError(t, err)
+	for _, c := range announcer.heads() {
+		require.Equal(t, head, c)
+	}
+
+	require.NoError(t, h.Shutdown(ctx))
+	count := len(announcer.heads())
+	time.Sleep(60 * time.Millisecond)
+	require.Len(t, announcer.heads(), count)
+}
@@ -1,25 +1,37 @@
 package herald
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-log/v2"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
 )
 
 var (
-	logger = log.Logger("herald")
+	logger = log.Logger(LogSubsystem)
 )
 
-// TODO: rework the options
-// TODO: construct and assemble components based on options
-
+// Herald assembles the components of an IPNI publisher: a backend storing the chain, optional HTTP and libp2p
+// publishers serving it, an optional announcer and a batcher to publish and retract catalogs.
 type Herald struct {
 	*options
-	publisher *HttpPublisher
-
-	// backend
-	// optional: publisher (http)
-	// announcer (http, pubsub) - announce.Sender
-	//
+	chainConfig     ChainConfig
+	backend         ChainWriter
+	publisher       *HttpPublisher
+	libp2pPublisher *Libp2pPublisher
+	readCache       *CachingReader
+	lastAnnounce    func() time.Time // time of the last successful announcement, nil without announcer
 
+	mu        sync.Mutex
+	batcher   *CatalogBatcher
+	heartbeat *heartbeat
+	stopped   bool // set by Shutdown, the publishers can't be started again
 }
 
 func New(o ...Option) (*Herald, error) {
@@ -28,22 +40,274 @@ func New(o ...Option) (*Herald, error) {
 		return nil, err
 	}
 	h := &Herald{options: opts}
-	// dspub, err := newDsPublisher(h)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// h.publisher, err = NewHttpPublisher(h, dspub)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	return h, err
-}
-
-//
-// func (h *Herald) Start(ctx context.Context) error {
-// 	return h.publisher.Start(ctx)
-// }
-//
-// func (h *Herald) Shutdown(ctx context.Context) error {
-// 	return h.publisher.Shutdown(ctx)
-// }
+
+	h.chainConfig = ChainConfig{
+		AdEntriesChunkSize:     opts.adEntriesChunkSize,
+		EntriesChunkBytes:      opts.entriesChunkBytes,
+		EntriesFormat:          opts.entriesFormat,
+		MaxMHsPerAdvertisement: opts.batchConfig.MaxMHsPerAdvertisement,
+		PublisherKey:           opts.identity,
+		PublisherID:            opts.id,
+		PublisherHttpAddrs:     opts.publisherHttpAddrs,
+		PublisherLibp2pAddrs:   opts.publisherLibp2pAddrs,
+		ProviderAddrs:          opts.providerAddrs,
+		Metadata:               opts.metadata,
+		Metrics:                opts.metrics,
+		Logger:                 opts.logger,
+		DuplicateContextIDs:    opts.duplicateContextIDs,
+		DeduplicateAds:         opts.deduplicateAds,
+		Codec:                  opts.codec,
+		Progress:               opts.progress,
+	}
+	if err := h.chainConfig.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.tracerProvider != nil {
+		h.chainConfig.Tracer = opts.tracerProvider.Tracer(TracerName)
+	}
+
+	h.backend, err = opts.backend(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.announcer != nil {
+		sender := &lastSentSender{Sender: opts.announcer}
+		opts.announcer, h.lastAnnounce = sender, sender.lastSent
+	}
+	if opts.heartbeatInterval > 0 {
+		if opts.announcer == nil {
+			return nil, errors.New("the heartbeat requires an announcer")
+		}
+		if _, ok := h.backend.(ChainReader); !ok {
+			return nil, fmt.Errorf("backend %T can't be read by the heartbeat", h.backend)
+		}
+	}
+
+	if opts.httpPublisher || opts.libp2pHost != nil {
+		reader, ok := h.backend.(ChainReader)
+		if !ok {
+			return nil, fmt.Errorf("backend %T can't be served by a publisher", h.backend)
+		}
+		if opts.readCacheBytes > 0 {
+			h.readCache = NewCachingReader(reader, opts.readCacheBytes, WithCachingReaderMetrics(opts.metrics),
+				WithCachingReaderLogger(opts.logger))
+			reader = h.readCache
+		}
+		if opts.httpPublisher {
+			pubOpts := []HttpPublisherOption{
+				WithHttpPublisherMetrics(opts.metrics), WithHttpPublisherMetricsEndpoint(opts.metricsGatherer),
+				WithHttpPublisherLogger(opts.logger),
+			}
+			if opts.publisherStatus {
+				pubOpts = append(pubOpts, WithHttpPublisherStatus(h.lastAnnounce))
+			}
+			pubOpts = append(pubOpts, opts.httpPublisherOpts...)
+			h.publisher, err = NewHttpPublisher(reader, opts.httpPublisherListenAddr, opts.topic, opts.identity, pubOpts...)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if opts.libp2pHost != nil {
+			if opts.libp2pHost.ID() != opts.id {
+				return nil, fmt.Errorf("libp2p host identity %s doesn't match the publisher identity %s", opts.libp2pHost.ID(), opts.id)
+			}
+			if opts.codec == multicodec.DagCbor {
+				return nil, errors.New("the libp2p publisher serves the blocks as DAG-JSON, it can't publish a DAG-CBOR chain")
+			}
+			h.libp2pPublisher, err = NewLibp2pPublisher(reader, opts.libp2pHost, opts.topic, opts.identity,
+				WithLibp2pPublisherLogger(opts.logger))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if opts.batchConfig.RetryPolicy.MaxAttempts == 0 {
+		opts.batchConfig.RetryPolicy = opts.retryPolicy
+	}
+
+	return h, nil
+}
+
+// Start starts the publishers, if any, the batcher and the heartbeat. A Herald without publishers can be started
+// again after Shutdown.
+func (h *Herald) Start(_ context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.batcher != nil {
+		return errors.New("already started")
+	}
+	if h.stopped && (h.publisher != nil || h.libp2pPublisher != nil) {
+		return errors.New("the publishers can't be started again after a shutdown")
+	}
+	if h.publisher != nil {
+		if err := h.publisher.Start(); err != nil {
+			return err
+		}
+	}
+	if h.libp2pPublisher != nil {
+		if err := h.libp2pPublisher.Start(); err != nil {
+			if h.publisher != nil {
+				_ = h.publisher.Close()
+			}
+			return err
+		}
+	}
+	h.derivePublisherAddrs()
+	h.batcher = StartCatalogBatcher(h.batchConfig, h.chainConfig, h.backend, h.announcer)
+	if h.heartbeatInterval > 0 {
+		h.heartbeat = startHeartbeat(h.heartbeatInterval, h.backend.(ChainReader).GetHead, h.lastAnnounce,
+			h.reannounce, h.chainConfig.withLogFields(nil).logger())
+	}
+	return nil
+}
+
+// reannounce sends the announcement of head once, the heartbeat trying again later on failure.
+func (h *Herald) reannounce(ctx context.Context, head cid.Cid) error {
+	return announceHead(ctx, h.ChainConfig(), h.announcer, RetryPolicy{}, head)
+}
+
+// derivePublisherAddrs sets the announced addresses not configured from the running publishers, the HTTP listener
+// and the libp2p host.
+func (h *Herald) derivePublisherAddrs() {
+	valid := func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		var res []multiaddr.Multiaddr
+		for _, a := range addrs {
+			if err := validatePublisherAddr(a, h.id); err == nil {
+				res = append(res, a)
+			}
+		}
+		return res
+	}
+	derived := false
+	if len(h.chainConfig.PublisherHttpAddrs) == 0 && h.publisher != nil {
+		addrs, err := h.publisher.Addrs()
+		if err != nil {
+			h.chainConfig.logger().Warnw("failed to derive the publisher HTTP addresses, they must be set to be announced", "err", err)
+		}
+		h.chainConfig.PublisherHttpAddrs = valid(addrs)
+		derived = true
+	}
+	if len(h.chainConfig.PublisherLibp2pAddrs) == 0 && h.libp2pPublisher != nil {
+		h.chainConfig.PublisherLibp2pAddrs = valid(h.libp2pHost.Addrs())
+		derived = true
+	}
+	if derived {
+		h.chainConfig.logger().Infow("Derived the publisher addresses", "addrs", h.chainConfig.PublisherAddrs())
+	}
+}
+
+// Shutdown closes the batcher, stops the publishers, flushes the backend and the delayed announcement, and closes
+// the announcer. Catalogs still pending in the batcher are not published, and fail with ErrBatcherClosed.
+func (h *Herald) Shutdown(ctx context.Context) error {
+	var errs []error
+	h.mu.Lock()
+	hb, batcher := h.heartbeat, h.batcher
+	h.heartbeat, h.batcher = nil, nil
+	h.stopped = true
+	h.mu.Unlock()
+	if hb != nil {
+		hb.close()
+	}
+	// the delayed announcement is sent by Close while the publishers still serve the chain
+	if batcher != nil {
+		errs = append(errs, batcher.Close(ctx))
+	}
+	if h.publisher != nil {
+		errs = append(errs, h.publisher.Shutdown(ctx))
+	}
+	if h.libp2pPublisher != nil {
+		errs = append(errs, h.libp2pPublisher.Close())
+	}
+	if h.readCache != nil {
+		errs = append(errs, h.readCache.Close())
+	}
+	if f, ok := h.backend.(interface{ Flush(context.Context) error }); ok {
+		errs = append(errs, f.Flush(ctx))
+	}
+	if h.announcer != nil {
+		errs = append(errs, h.announcer.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// PublishCatalog publishes the catalog, through the batcher.
+func (h *Herald) PublishCatalog(ctx context.Context, catalog Catalog) error {
+	batcher, err := h.getBatcher()
+	if err != nil {
+		return err
+	}
+	return batcher.PublishCatalog(ctx, catalog)
+}
+
+// RetractCatalog retracts the catalog, through the batcher.
+func (h *Herald) RetractCatalog(ctx context.Context, catalog Catalog) error {
+	batcher, err := h.getBatcher()
+	if err != nil {
+		return err
+	}
+	return batcher.RetractCatalog(ctx, catalog)
+}
+
+// RetractContextID retracts the ContextID, without the catalog.
+func (h *Herald) RetractContextID(ctx context.Context, id CatalogID) error {
+	batcher, err := h.getBatcher()
+	if err != nil {
+		return err
+	}
+	return batcher.RetractContextID(ctx, id)
+}
+
+// RetractNamespace retracts all the live ContextIDs of the namespace, for example to offboard a tenant.
+func (h *Herald) RetractNamespace(ctx context.Context, namespace []byte) error {
+	batcher, err := h.getBatcher()
+	if err != nil {
+		return err
+	}
+	return batcher.RetractNamespace(ctx, namespace)
+}
+
+// UpdateMetadata updates the metadata of an already published ContextID, without sending its entries again.
+func (h *Herald) UpdateMetadata(ctx context.Context, contextID CatalogID, metadata []byte) error {
+	batcher, err := h.getBatcher()
+	if err != nil {
+		return err
+	}
+	return batcher.UpdateMetadata(ctx, contextID, metadata)
+}
+
+// UpdateProviderAddrs publishes the new addresses of the provider, which are used for all the subsequent
+// advertisements.
+func (h *Herald) UpdateProviderAddrs(ctx context.Context, addrs []string) error {
+	batcher, err := h.getBatcher()
+	if err != nil {
+		return err
+	}
+	err = batcher.UpdateProviderAddrs(ctx, addrs)
+	// the addresses are updated even if only the announcement failed
+	h.mu.Lock()
+	h.chainConfig.ProviderAddrs = batcher.config().ProviderAddrs
+	h.mu.Unlock()
+	return err
+}
+
+// Backend returns the backend storing the IPNI chain.
+func (h *Herald) Backend() ChainWriter {
+	return h.backend
+}
+
+// ChainConfig returns the configuration of the IPNI chain, for direct use of the lower level functions.
+func (h *Herald) ChainConfig() ChainConfig {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.chainConfig
+}
+
+func (h *Herald) getBatcher() (*CatalogBatcher, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.batcher == nil {
+		return nil, errors.New("herald is not started")
+	}
+	return h.batcher, nil
+}
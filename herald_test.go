@@ -0,0 +1,96 @@
+package herald
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHerald(t *testing.T) {
+	ctx := context.Background()
+
+	// find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listenAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+	port := l.Addr().(*net.TCPAddr).Port
+	announcer := &recordingSender{}
+
+	h, err := New(
+		WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+		WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")),
+		WithDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore())),
+		WithHTTPPublisher(listenAddr),
+		WithAnnouncer(announcer),
+		WithBatching(BatchConfig{
+			CountThreshold:         1,
+			MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+			MaxDelay:               time.Second,
+		}),
+	)
+	require.NoError(t, err)
+
+	err = h.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.Error(t, err, "not started")
+
+	require.NoError(t, h.Start(ctx))
+	require.Error(t, h.Start(ctx))
+
+	// the publisher address is derived from the listener
+	httpAddr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/" + strconv.Itoa(port) + "/http")
+	require.Equal(t, []multiaddr.Multiaddr{httpAddr}, h.ChainConfig().PublisherHttpAddrs)
+
+	err = h.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.NoError(t, err)
+
+	local, err := h.Backend().(ChainReader).GetHead(ctx)
+	require.NoError(t, err)
+	require.True(t, local.Defined())
+
+	remote, err := NewHttpChainReader("http://"+listenAddr, h.ChainConfig().PublisherID).GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, local, remote)
+
+	require.NoError(t, h.Shutdown(ctx))
+	announced, err := announcer.last.GetAddrs()
+	require.NoError(t, err)
+	require.Equal(t, []multiaddr.Multiaddr{httpAddr}, announced)
+	require.Equal(t, local, announcer.last.Cid)
+}
+
+func TestHeraldOptions(t *testing.T) {
+	md := WithMetadata(metadata.Default.New(metadata.Bitswap{}))
+	addr := WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001"))
+
+	_, err := New(addr)
+	require.Error(t, err, "metadata is required")
+
+	_, err = New(md, addr, WithBatching(BatchConfig{}))
+	require.Error(t, err)
+
+	h, err := New(md, addr)
+	require.NoError(t, err)
+	require.IsType(t, &DsBackend{}, h.Backend())
+	require.Equal(t, DefaultRetryPolicy, h.batchConfig.RetryPolicy)
+
+	// the publisher addresses are sorted by transport, and announced together
+	httpAddr := multiaddr.StringCast("/dns4/chain.example.com/tcp/443/https")
+	libp2pAddr := multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1")
+	h, err = New(md, addr, WithPublisherAddress(libp2pAddr, httpAddr))
+	require.NoError(t, err)
+	require.Equal(t, []multiaddr.Multiaddr{httpAddr}, h.ChainConfig().PublisherHttpAddrs)
+	require.Equal(t, []multiaddr.Multiaddr{libp2pAddr}, h.ChainConfig().PublisherLibp2pAddrs)
+	require.Equal(t, []multiaddr.Multiaddr{httpAddr, libp2pAddr}, h.ChainConfig().PublisherAddrs())
+
+	_, err = New(md, addr, WithPublisherAddress(multiaddr.StringCast("/ip4/0.0.0.0/tcp/80/http")))
+	require.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,223 @@
+// Package heraldtest provides an in-process harness to test the publication of catalogs end to end: the chain is
+// published to a memory backend, served by an HTTP publisher on an ephemeral port, and synced back with the dagsync
+// client of go-libipni, as an indexer does. It lets the users of herald check in CI that their catalogs and
+// configuration produce a chain that an indexer can ingest.
+package heraldtest
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// Topic is the topic of the chains published by the Harness.
+const Topic = "/indexer/ingest/mainnet"
+
+// Harness publishes a chain to a memory backend and serves it over HTTP on the loopback, for the duration of a test.
+type Harness struct {
+	// Config is the configuration of the chain, with a generated publisher identity.
+	Config herald.ChainConfig
+	// Backend stores the chain.
+	Backend *herald.MemoryBackend
+	// Publisher serves the chain.
+	Publisher *herald.HttpPublisher
+	// AddrInfo is the identity and the HTTP address of the publisher, to sync the chain from.
+	AddrInfo peer.AddrInfo
+
+	t        testing.TB
+	ingester *Ingester
+}
+
+// Option is an optional configuration for the Harness.
+type Option func(*options)
+
+type options struct {
+	config        []func(cfg *herald.ChainConfig)
+	publisherOpts []herald.HttpPublisherOption
+}
+
+// WithChainConfig modifies the ChainConfig of the harness before it's validated, for example to set the Codec, the
+// chunking, the metadata or a ContextIDNamespace. The publisher identity and the provider addresses are already set.
+func WithChainConfig(fn func(cfg *herald.ChainConfig)) Option {
+	return func(o *options) {
+		o.config = append(o.config, fn)
+	}
+}
+
+// WithHttpPublisherOptions adds options to the HTTP publisher.
+func WithHttpPublisherOptions(opts ...herald.HttpPublisherOption) Option {
+	return func(o *options) {
+		o.publisherOpts = append(o.publisherOpts, opts...)
+	}
+}
+
+// New starts a Harness, stopped at the end of the test.
+func New(t testing.TB, opts ...Option) *Harness {
+	t.Helper()
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg := herald.DefaultChainConfig()
+	var err error
+	cfg.PublisherID, cfg.PublisherKey, err = herald.GenerateProviderIdentity()
+	require.NoError(t, err)
+	cfg.ProviderAddrs = []string{"/ip4/127.0.0.1/tcp/4001"}
+	for _, fn := range o.config {
+		fn(&cfg)
+	}
+
+	backend := herald.NewMemoryBackend()
+	publisherOpts := o.publisherOpts
+	if cfg.Signer != nil {
+		publisherOpts = append(publisherOpts, herald.WithHttpPublisherSigner(cfg.Signer))
+	}
+	pub, err := herald.NewHttpPublisher(backend, "127.0.0.1:0", Topic, cfg.PublisherKey, publisherOpts...)
+	require.NoError(t, err)
+	require.NoError(t, pub.Start())
+	t.Cleanup(func() { _ = pub.Close() })
+	addrs, err := pub.Addrs()
+	require.NoError(t, err)
+	cfg.PublisherHttpAddrs = addrs
+	require.NoError(t, cfg.Validate())
+
+	h := &Harness{
+		Config:    cfg,
+		Backend:   backend,
+		Publisher: pub,
+		AddrInfo:  peer.AddrInfo{ID: cfg.PublisherID, Addrs: addrs},
+		t:         t,
+	}
+	t.Cleanup(func() {
+		if h.ingester != nil {
+			_ = h.ingester.Close()
+		}
+	})
+	return h
+}
+
+// Publish publishes the catalog with its ContextID, or without ContextID if it has none, and returns the new head.
+func (h *Harness) Publish(catalog herald.Catalog) cid.Cid {
+	h.t.Helper()
+	var head cid.Cid
+	var err error
+	if len(catalog.ID()) == 0 {
+		head, err = herald.PublishRawMHs(context.Background(), h.Config, h.Backend, catalog)
+	} else {
+		head, err = herald.PublishWithContextID(context.Background(), h.Config, h.Backend, catalog)
+	}
+	require.NoError(h.t, err)
+	return head
+}
+
+// Retract retracts the ContextID, and returns the new head.
+func (h *Harness) Retract(id herald.CatalogID) cid.Cid {
+	h.t.Helper()
+	head, err := herald.RetractContextID(context.Background(), h.Config, h.Backend, id)
+	require.NoError(h.t, err)
+	return head
+}
+
+// Ingester returns the Ingester of the chain of the harness, which keeps its index across the syncs.
+func (h *Harness) Ingester() *Ingester {
+	h.t.Helper()
+	if h.ingester == nil {
+		ingester, err := NewIngester(h.AddrInfo)
+		require.NoError(h.t, err)
+		h.ingester = ingester
+	}
+	return h.ingester
+}
+
+// Sync syncs the chain with the Ingester, and returns the synced head, which is checked to be the head of the
+// backend.
+func (h *Harness) Sync() cid.Cid {
+	h.t.Helper()
+	ctx := context.Background()
+	synced, err := h.Ingester().Sync(ctx)
+	require.NoError(h.t, err)
+	head, err := h.Backend.GetHead(ctx)
+	require.NoError(h.t, err)
+	require.Equal(h.t, head, synced, "the synced head is not the head of the chain")
+	return synced
+}
+
+// RequireIngested syncs the chain, and checks that all the multihashes of the catalog are indexed under its
+// ContextID, within the ContextIDNamespace of the config if any. The catalog is iterated again, so it must support
+// more than one iteration.
+func (h *Harness) RequireIngested(catalog herald.Catalog) {
+	h.t.Helper()
+	h.Sync()
+	contextID := h.contextID(catalog.ID())
+	mhs := readCatalog(h.t, catalog)
+	var missing []multihash.Multihash
+	for _, mh := range mhs {
+		if !h.ingester.Has(contextID, mh) {
+			missing = append(missing, mh)
+		}
+	}
+	require.Empty(h.t, missing, "%d of the %d multihashes are not indexed", len(missing), len(mhs))
+}
+
+// RequireRetracted syncs the chain, and checks that no multihash is indexed under the ContextID, within the
+// ContextIDNamespace of the config if any.
+func (h *Harness) RequireRetracted(id herald.CatalogID) {
+	h.t.Helper()
+	h.Sync()
+	require.Empty(h.t, h.ingester.Multihashes(h.contextID(id)), "the ContextID is still indexed")
+}
+
+func (h *Harness) contextID(id herald.CatalogID) []byte {
+	if len(id) == 0 {
+		return nil
+	}
+	contextID, err := herald.NamespacedContextID(h.Config.ContextIDNamespace, id)
+	require.NoError(h.t, err)
+	return contextID
+}
+
+func readCatalog(t testing.TB, catalog herald.Catalog) []multihash.Multihash {
+	t.Helper()
+	it, err := catalog.Iterator(context.Background())
+	require.NoError(t, err)
+	var mhs []multihash.Multihash
+	for !it.Done() {
+		mhs = append(mhs, it.Next())
+	}
+	return mhs
+}
+
+// Catalog returns a catalog of the multihashes with the ContextID id, which can be iterated any number of times.
+func Catalog(id []byte, mhs ...multihash.Multihash) herald.Catalog {
+	return idCatalog{MhCatalog: herald.CatalogFromMultihashes(mhs...), id: id}
+}
+
+type idCatalog struct {
+	herald.MhCatalog
+	id []byte
+}
+
+func (c idCatalog) ID() []byte {
+	return c.id
+}
+
+// RandomMultihashes returns n random sha2-256 multihashes.
+func RandomMultihashes(n int) []multihash.Multihash {
+	mhs := make([]multihash.Multihash, n)
+	buf := make([]byte, 32)
+	for i := range mhs {
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("failed to read random bytes: %v", err))
+		}
+		mhs[i], _ = multihash.Sum(buf, multihash.SHA2_256, -1)
+	}
+	return mhs
+}
@@ -0,0 +1,75 @@
+package heraldtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/herald"
+	"github.com/multiformats/go-multicodec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHarness(t *testing.T) {
+	for _, codec := range []multicodec.Code{multicodec.DagJson, multicodec.DagCbor} {
+		t.Run(codec.String(), func(t *testing.T) {
+			h := New(t, WithChainConfig(func(cfg *herald.ChainConfig) {
+				cfg.Codec = codec
+				cfg.AdEntriesChunkSize = 3
+				cfg.ContextIDNamespace = []byte("tenant")
+			}))
+
+			foo := Catalog([]byte("foo"), RandomMultihashes(10)...)
+			bar := Catalog([]byte("bar"), RandomMultihashes(2)...)
+			h.Publish(foo)
+			h.Publish(bar)
+			h.RequireIngested(foo)
+			h.RequireIngested(bar)
+			require.Equal(t, 2, h.Ingester().Advertisements())
+
+			// synced incrementally
+			h.Retract([]byte("foo"))
+			h.RequireRetracted([]byte("foo"))
+			h.RequireIngested(bar)
+			require.Len(t, h.Ingester().ContextIDs(), 1)
+			require.Equal(t, 3, h.Ingester().Advertisements())
+
+			// the metadata updates keep the multihashes
+			gateway := metadata.Default.New(metadata.IpfsGatewayHttp{})
+			http, err := gateway.MarshalBinary()
+			require.NoError(t, err)
+			_, err = herald.UpdateMetadataWithContextID(context.Background(), h.Config, h.Backend, []byte("bar"), http)
+			require.NoError(t, err)
+			h.RequireIngested(bar)
+			md, ok := h.Ingester().Metadata(h.contextID([]byte("bar")))
+			require.True(t, ok)
+			require.Equal(t, http, md)
+		})
+	}
+}
+
+func TestHarnessRawMultihashes(t *testing.T) {
+	h := New(t)
+	mhs := RandomMultihashes(5)
+	h.Publish(herald.CatalogFromMultihashes(mhs...))
+	h.RequireIngested(herald.CatalogFromMultihashes(mhs...))
+
+	_, err := herald.RetractRawMHs(context.Background(), h.Config, h.Backend, herald.CatalogFromMultihashes(mhs[:2]...))
+	require.NoError(t, err)
+	h.Sync()
+	require.ElementsMatch(t, mhs[2:], h.Ingester().Multihashes(nil))
+}
+
+func TestIngesterBadSignature(t *testing.T) {
+	h := New(t)
+	other := New(t)
+	h.Publish(Catalog([]byte("foo"), RandomMultihashes(1)...))
+
+	// the chain of h, claimed by the identity of other
+	ingester, err := NewIngester(h.AddrInfo)
+	require.NoError(t, err)
+	defer ingester.Close()
+	ingester.publisher.ID = other.AddrInfo.ID
+	_, err = ingester.Sync(context.Background())
+	require.Error(t, err)
+}
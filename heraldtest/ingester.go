@@ -0,0 +1,271 @@
+package heraldtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipni/go-libipni/dagsync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/herald"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// Ingester syncs a chain from a publisher with the dagsync client of go-libipni, as an indexer does, and keeps the
+// resulting index: the multihashes and metadata of every ContextID of the provider.
+type Ingester struct {
+	publisher peer.AddrInfo
+	store     *memstore.Store
+	sub       *dagsync.Subscriber
+
+	mu       sync.Mutex
+	head     cid.Cid
+	ads      int
+	contexts map[string]*ingestedContext
+}
+
+type ingestedContext struct {
+	metadata []byte
+	mhs      map[string]multihash.Multihash
+}
+
+// NewIngester creates an Ingester of the chain served by the HTTP publisher.
+func NewIngester(publisher peer.AddrInfo) (*Ingester, error) {
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	sub, err := dagsync.NewSubscriber(nil, lsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Ingester{
+		publisher: publisher,
+		store:     store,
+		sub:       sub,
+		contexts:  make(map[string]*ingestedContext),
+	}, nil
+}
+
+// Sync syncs the advertisements published since the previous sync, with their entries, and applies them to the
+// index from the oldest to the newest. It returns the synced head.
+func (i *Ingester) Sync(ctx context.Context) (cid.Cid, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	head, err := i.sub.SyncAdChain(ctx, i.publisher)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("failed to sync the advertisements: %w", err)
+	}
+	if !head.Defined() || head == i.head {
+		return i.head, nil
+	}
+
+	type syncedAd struct {
+		cid cid.Cid
+		ad  schema.Advertisement
+	}
+	var ads []syncedAd
+	err = herald.WalkAdsFrom(ctx, i.reader(), head, func(adCid cid.Cid, ad schema.Advertisement) error {
+		if adCid == i.head {
+			return herald.ErrStopWalk
+		}
+		ads = append(ads, syncedAd{cid: adCid, ad: ad})
+		return nil
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	for j := len(ads) - 1; j >= 0; j-- {
+		if err := i.ingest(ctx, ads[j].cid, ads[j].ad); err != nil {
+			return cid.Undef, err
+		}
+		i.head = ads[j].cid
+	}
+	return head, nil
+}
+
+// ingest applies an advertisement to the index, following the semantics of the indexers.
+func (i *Ingester) ingest(ctx context.Context, adCid cid.Cid, ad schema.Advertisement) error {
+	signer, err := ad.VerifySignature()
+	if err != nil {
+		return fmt.Errorf("advertisement %s: %w", adCid, err)
+	}
+	if signer != i.publisher.ID && signer.String() != ad.Provider {
+		return fmt.Errorf("advertisement %s: signed by %s, neither the publisher nor the provider", adCid, signer)
+	}
+	i.ads++
+
+	id := string(ad.ContextID)
+	hasEntries := ad.Entries != nil && ad.Entries != schema.NoEntries
+	switch {
+	case ad.IsRm && id != "":
+		// the whole ContextID is removed, whatever the entries
+		delete(i.contexts, id)
+		return nil
+	case !hasEntries:
+		// a metadata update, or an update of the provider only
+		if record, ok := i.contexts[id]; ok && !ad.IsRm {
+			record.metadata = ad.Metadata
+		}
+		return nil
+	}
+
+	mhs, err := i.syncEntries(ctx, ad.Entries)
+	if err != nil {
+		return fmt.Errorf("advertisement %s: %w", adCid, err)
+	}
+	record, ok := i.contexts[id]
+	if ad.IsRm {
+		// the retraction of the listed multihashes, without ContextID
+		if ok {
+			for _, mh := range mhs {
+				delete(record.mhs, string(mh))
+			}
+		}
+		return nil
+	}
+	if !ok {
+		record = &ingestedContext{mhs: make(map[string]multihash.Multihash)}
+		i.contexts[id] = record
+	}
+	record.metadata = ad.Metadata
+	for _, mh := range mhs {
+		record.mhs[string(mh)] = mh
+	}
+	return nil
+}
+
+// syncEntries syncs the entries of an advertisement, an entry chunk chain or a HAMT, and returns their multihashes.
+func (i *Ingester) syncEntries(ctx context.Context, entries ipld.Link) ([]multihash.Multihash, error) {
+	first := entries.(cidlink.Link).Cid
+	if err := i.sub.SyncOneEntry(ctx, i.publisher, first); err != nil {
+		return nil, fmt.Errorf("failed to sync the entries %s: %w", first, err)
+	}
+	data, err := i.store.Get(ctx, first.KeyString())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := schema.BytesToEntryChunk(first, data); err == nil {
+		err = i.sub.SyncEntries(ctx, i.publisher, first)
+	} else {
+		err = i.sub.SyncHAMTEntries(ctx, i.publisher, first)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sync the entries %s: %w", first, err)
+	}
+
+	var mhs []multihash.Multihash
+	err = herald.WalkEntryBlocks(ctx, i.reader(), entries, func(_ cid.Cid, _ []byte, blockMhs []multihash.Multihash) error {
+		mhs = append(mhs, blockMhs...)
+		return nil
+	})
+	return mhs, err
+}
+
+func (i *Ingester) reader() storeReader {
+	return storeReader{store: i.store}
+}
+
+// Head returns the head of the last sync, or cid.Undef if nothing was synced yet.
+func (i *Ingester) Head() cid.Cid {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.head
+}
+
+// Advertisements returns the number of advertisements ingested.
+func (i *Ingester) Advertisements() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.ads
+}
+
+// ContextIDs returns the ContextIDs with indexed multihashes, sorted. The multihashes published without ContextID
+// are under the empty ContextID.
+func (i *Ingester) ContextIDs() [][]byte {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ids := make([][]byte, 0, len(i.contexts))
+	for id, record := range i.contexts {
+		if len(record.mhs) > 0 {
+			ids = append(ids, []byte(id))
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool { return bytes.Compare(ids[a], ids[b]) < 0 })
+	return ids
+}
+
+// Multihashes returns the multihashes indexed for the ContextID, sorted.
+func (i *Ingester) Multihashes(contextID []byte) []multihash.Multihash {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	record, ok := i.contexts[string(contextID)]
+	if !ok {
+		return nil
+	}
+	mhs := make([]multihash.Multihash, 0, len(record.mhs))
+	for _, mh := range record.mhs {
+		mhs = append(mhs, mh)
+	}
+	sort.Slice(mhs, func(a, b int) bool { return bytes.Compare(mhs[a], mhs[b]) < 0 })
+	return mhs
+}
+
+// Has returns true if the multihash is indexed for the ContextID.
+func (i *Ingester) Has(contextID []byte, mh multihash.Multihash) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	record, ok := i.contexts[string(contextID)]
+	if !ok {
+		return false
+	}
+	_, ok = record.mhs[string(mh)]
+	return ok
+}
+
+// Metadata returns the metadata of the ContextID, as last advertised.
+func (i *Ingester) Metadata(contextID []byte) ([]byte, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	record, ok := i.contexts[string(contextID)]
+	if !ok || len(record.mhs) == 0 {
+		return nil, false
+	}
+	return record.metadata, true
+}
+
+// Close stops the dagsync client.
+func (i *Ingester) Close() error {
+	return i.sub.Close()
+}
+
+var _ herald.ChainReader = storeReader{}
+
+// storeReader reads the synced blocks with the chain readers of herald.
+type storeReader struct {
+	store *memstore.Store
+}
+
+func (r storeReader) GetHead(context.Context) (cid.Cid, error) {
+	return cid.Undef, errors.New("the synced blocks have no head")
+}
+
+func (r storeReader) GetContent(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if has, _ := r.store.Has(ctx, c.KeyString()); !has {
+		return nil, herald.ErrContentNotFound
+	}
+	return r.store.Get(ctx, c.KeyString())
+}
+
+func (r storeReader) SubscribeHead(context.Context) (<-chan cid.Cid, error) {
+	return nil, errors.New("the synced blocks have no head")
+}
@@ -0,0 +1,134 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrIdentityMismatch is returned when a peer.ID doesn't match the key supposed to sign on its behalf, which would
+// produce advertisements or heads rejected by the indexers.
+var ErrIdentityMismatch = errors.New("the peer ID doesn't match the key")
+
+// The identities are persisted as the libp2p protobuf encoding of the private key, as a binary file, or encoded in
+// base64 in the environment variables and secret strings. The files can be either.
+
+// GenerateProviderIdentity generates a new Ed25519 identity.
+func GenerateProviderIdentity() (peer.ID, crypto.PrivKey, error) {
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+// SaveIdentityToFile writes the private key to a new file readable only by its owner. It fails if the file exists,
+// to never overwrite an identity.
+func SaveIdentityToFile(path string, key crypto.PrivKey) error {
+	raw, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadIdentityFromFile reads a private key written by SaveIdentityToFile, or its base64 encoding.
+func LoadIdentityFromFile(path string) (peer.ID, crypto.PrivKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	id, key, err := decodeIdentity(raw)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid identity in %s: %w", path, err)
+	}
+	return id, key, nil
+}
+
+// LoadIdentityFromEnv reads the base64 encoded private key from the environment variable name.
+func LoadIdentityFromEnv(name string) (peer.ID, crypto.PrivKey, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return "", nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	id, key, err := decodeIdentity([]byte(v))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid identity in %s: %w", name, err)
+	}
+	return id, key, nil
+}
+
+// SecretsManagerAPI is the subset of the AWS Secrets Manager client used by LoadIdentityFromAWSSecretsManager.
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// LoadIdentityFromAWSSecretsManager reads the private key from the current version of a secret, either binary or a
+// base64 encoded string. secretID is the name or the ARN of the secret.
+func LoadIdentityFromAWSSecretsManager(ctx context.Context, client SecretsManagerAPI, secretID string) (peer.ID, crypto.PrivKey, error) {
+	out, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get secret %s: %w", secretID, err)
+	}
+	raw := out.SecretBinary
+	if raw == nil {
+		raw = []byte(aws.ToString(out.SecretString))
+	}
+	id, key, err := decodeIdentity(raw)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid identity in secret %s: %w", secretID, err)
+	}
+	return id, key, nil
+}
+
+// decodeIdentity decodes a private key in the libp2p protobuf encoding, or in its base64 encoding.
+func decodeIdentity(raw []byte) (peer.ID, crypto.PrivKey, error) {
+	key, err := crypto.UnmarshalPrivateKey(raw)
+	if err != nil {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(raw)))
+		if decodeErr != nil {
+			return "", nil, err
+		}
+		if key, err = crypto.UnmarshalPrivateKey(decoded); err != nil {
+			return "", nil, err
+		}
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+// checkIdentity returns ErrIdentityMismatch if the key of signer doesn't match id.
+func checkIdentity(id peer.ID, signer crypto.PrivKey) error {
+	actual, err := peer.IDFromPublicKey(signer.GetPublic())
+	if err != nil {
+		return err
+	}
+	if actual != id {
+		return fmt.Errorf("%w: the key is for %s, expected %s", ErrIdentityMismatch, actual, id)
+	}
+	return nil
+}
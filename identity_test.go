@@ -0,0 +1,72 @@
+package herald
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretsManager holds secrets by ID, either binary or strings.
+type fakeSecretsManager map[string]*secretsmanager.GetSecretValueOutput
+
+func (f fakeSecretsManager) GetSecretValue(_ context.Context, params *secretsmanager.GetSecretValueInput, _ ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	out, ok := f[aws.ToString(params.SecretId)]
+	if !ok {
+		return nil, errors.New("secret not found")
+	}
+	return out, nil
+}
+
+func TestIdentity(t *testing.T) {
+	id, key, err := GenerateProviderIdentity()
+	require.NoError(t, err)
+	raw, err := crypto.MarshalPrivateKey(key)
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(raw)
+
+	requireIdentity := func(loadedID any, loadedKey crypto.PrivKey, err error) {
+		t.Helper()
+		require.NoError(t, err)
+		require.Equal(t, id, loadedID)
+		require.True(t, key.Equals(loadedKey))
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, SaveIdentityToFile(path, key))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	// an existing identity is never overwritten
+	require.ErrorIs(t, SaveIdentityToFile(path, key), os.ErrExist)
+	requireIdentity(LoadIdentityFromFile(path))
+
+	base64Path := filepath.Join(t.TempDir(), "key.b64")
+	require.NoError(t, os.WriteFile(base64Path, []byte(encoded+"\n"), 0o600))
+	requireIdentity(LoadIdentityFromFile(base64Path))
+
+	t.Setenv("HERALD_TEST_IDENTITY", encoded)
+	requireIdentity(LoadIdentityFromEnv("HERALD_TEST_IDENTITY"))
+	_, _, err = LoadIdentityFromEnv("HERALD_TEST_MISSING")
+	require.Error(t, err)
+	t.Setenv("HERALD_TEST_INVALID", "not a key")
+	_, _, err = LoadIdentityFromEnv("HERALD_TEST_INVALID")
+	require.Error(t, err)
+
+	secrets := fakeSecretsManager{
+		"binary": {SecretBinary: raw},
+		"string": {SecretString: aws.String(encoded)},
+	}
+	ctx := context.Background()
+	requireIdentity(LoadIdentityFromAWSSecretsManager(ctx, secrets, "binary"))
+	requireIdentity(LoadIdentityFromAWSSecretsManager(ctx, secrets, "string"))
+	_, _, err = LoadIdentityFromAWSSecretsManager(ctx, secrets, "missing")
+	require.Error(t, err)
+}
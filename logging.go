@@ -0,0 +1,78 @@
+package herald
+
+import (
+	"encoding/hex"
+
+	"github.com/ipfs/go-log/v2"
+	"go.uber.org/zap"
+)
+
+// LogSubsystem is the go-log subsystem of the default logger, for example to set its level with
+// GOLOG_LOG_LEVEL="herald=debug".
+const LogSubsystem = "herald"
+
+// SetLogLevel sets the level of the default logger: debug, info, warn, error, dpanic, panic or fatal. The loggers given
+// with WithLogger and the like keep their own level.
+func SetLogLevel(level string) error {
+	return log.SetLogLevel(LogSubsystem, level)
+}
+
+// defaultLogger returns the logger used when none is given.
+func defaultLogger() *zap.SugaredLogger {
+	return &logger.SugaredLogger
+}
+
+// orDefaultLogger returns l, or the default logger if nil.
+func orDefaultLogger(l *zap.SugaredLogger) *zap.SugaredLogger {
+	if l == nil {
+		return defaultLogger()
+	}
+	return l
+}
+
+// OperationOption is an optional configuration of the chain maintenance operations not given a ChainConfig, like
+// RollbackHead or FindOrphans.
+type OperationOption func(*operationConfig)
+
+type operationConfig struct {
+	logger *zap.SugaredLogger
+}
+
+// WithOperationLogger logs with the given logger instead of the default one.
+func WithOperationLogger(l *zap.SugaredLogger) OperationOption {
+	return func(c *operationConfig) {
+		c.logger = l
+	}
+}
+
+// operationLogger returns the logger configured by opts, or the default one.
+func operationLogger(opts []OperationOption) *zap.SugaredLogger {
+	var cfg operationConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return orDefaultLogger(cfg.logger)
+}
+
+// logger returns the logger of the operations with cfg, with the fields of the operation in progress if any.
+func (cfg ChainConfig) logger() *zap.SugaredLogger {
+	l := orDefaultLogger(cfg.Logger)
+	if len(cfg.logFields) > 0 {
+		l = l.With(cfg.logFields...)
+	}
+	return l
+}
+
+// withLogFields returns cfg logging the provider and, if not nil, the ContextID of an operation.
+func (cfg ChainConfig) withLogFields(id CatalogID) ChainConfig {
+	cfg.logFields = []any{"provider", cfg.provider()}
+	if id != nil {
+		cfg.logFields = append(cfg.logFields, "contextID", logContextID(id))
+	}
+	return cfg
+}
+
+// logContextID formats a ContextID for the logs, in hex.
+func logContextID(id []byte) string {
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,76 @@
+package herald
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestChainConfigLogger(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.DebugLevel)
+	cfg := testChainConfig(t)
+	cfg.Logger = zap.New(core).Sugar().With("tenant", "foo")
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	id := []byte("bar")
+	ad, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(5), id: id})
+	require.NoError(t, err)
+
+	stored := logs.FilterMessage("Stored advertisement").All()
+	require.Len(t, stored, 1)
+	fields := stored[0].ContextMap()
+	require.Equal(t, "foo", fields["tenant"])
+	require.Equal(t, cfg.PublisherID.String(), fields["provider"])
+	require.Equal(t, hex.EncodeToString(id), fields["contextID"])
+	require.Equal(t, ad.String(), fields["ad"])
+	require.Equal(t, false, fields["isRm"])
+
+	// the fields of a publication don't leak into the next one
+	_, err = RetractContextIDs(ctx, cfg, backend, []CatalogID{id})
+	require.NoError(t, err)
+	retracted := logs.FilterMessage("Retracted ContextIDs").All()
+	require.Len(t, retracted, 1)
+	require.NotContains(t, retracted[0].ContextMap(), "contextID")
+	require.Equal(t, cfg.PublisherID.String(), retracted[0].ContextMap()["provider"])
+}
+
+func TestHeraldWithLogger(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.DebugLevel)
+	h, err := New(
+		WithLogger(zap.New(core).Sugar()),
+		WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+		WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")),
+		WithBatching(BatchConfig{
+			CountThreshold:         1,
+			MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+			MaxDelay:               time.Second,
+		}),
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, logs.FilterMessage("using in-memory datastore").All())
+
+	require.NoError(t, h.Start(ctx))
+	defer h.Shutdown(ctx)
+	require.NoError(t, h.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")}))
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("Stored advertisement").Len() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, h.ChainConfig().PublisherID.String(), logs.FilterMessage("Stored advertisement").All()[0].ContextMap()["provider"])
+}
+
+func TestSetLogLevel(t *testing.T) {
+	require.NoError(t, SetLogLevel("info"))
+	require.Error(t, SetLogLevel("loud"))
+}
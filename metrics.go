@@ -0,0 +1,251 @@
+package herald
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "herald"
+
+// Metrics holds the prometheus collectors of herald. A nil *Metrics is valid and records nothing, which allows the
+// components to be used without metrics.
+type Metrics struct {
+	s3Requests  *prometheus.CounterVec
+	s3Retries   *prometheus.CounterVec
+	s3Throttles *prometheus.CounterVec
+	s3Latency   *prometheus.HistogramVec
+
+	multihashes *prometheus.CounterVec
+	ads         *prometheus.CounterVec
+	headUpdates *prometheus.CounterVec
+
+	batchSize    *prometheus.HistogramVec
+	batchLatency *prometheus.HistogramVec
+	batchFlushes *prometheus.CounterVec
+	queueDepth   *prometheus.GaugeVec
+	overflows    *prometheus.CounterVec
+	crashes      *prometheus.CounterVec
+
+	httpRequests *prometheus.CounterVec
+	httpLatency  *prometheus.HistogramVec
+
+	cacheRequests *prometheus.CounterVec
+
+	announces *prometheus.CounterVec
+}
+
+// NewMetrics creates the herald metrics, and registers them into reg.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		s3Requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "s3",
+			Name:      "requests_total",
+			Help:      "Number of S3 operations, by API operation and result.",
+		}, []string{"operation", "result"}),
+		s3Retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "s3",
+			Name:      "retries_total",
+			Help:      "Number of S3 request retries performed by the AWS SDK, by API operation.",
+		}, []string{"operation"}),
+		s3Throttles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "s3",
+			Name:      "throttles_total",
+			Help:      "Number of S3 request attempts throttled by S3, by API operation.",
+		}, []string{"operation"}),
+		s3Latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "s3",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of S3 operations, including retries, by API operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"operation"}),
+		multihashes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "chain",
+			Name:      "multihashes_total",
+			Help:      "Number of multihashes published or retracted, by operation.",
+		}, []string{"operation"}),
+		ads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "chain",
+			Name:      "advertisements_total",
+			Help:      "Number of advertisements generated, by operation.",
+		}, []string{"operation"}),
+		headUpdates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "chain",
+			Name:      "head_updates_total",
+			Help:      "Number of updates of the chain head by the backend, by result.",
+		}, []string{"result"}),
+		batchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "batcher",
+			Name:      "batch_size",
+			Help:      "Number of multihashes in the flushed batches, by lane.",
+			Buckets:   prometheus.ExponentialBuckets(10, 4, 9),
+		}, []string{"lane"}),
+		batchLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "batcher",
+			Name:      "flush_duration_seconds",
+			Help:      "Duration of the batch flushes, including retries, by lane.",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+		}, []string{"lane"}),
+		batchFlushes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "batcher",
+			Name:      "flushes_total",
+			Help:      "Number of batch flushes, by lane and result.",
+		}, []string{"lane", "result"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "batcher",
+			Name:      "queue_depth",
+			Help:      "Number of catalogs waiting for their batching lane, queued or spilled, by lane.",
+		}, []string{"lane"}),
+		overflows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "batcher",
+			Name:      "overflows_total",
+			Help:      "Number of catalogs submitted while the queue of their lane was full, by lane and outcome (rejected, spilled or timeout).",
+		}, []string{"lane", "outcome"}),
+		crashes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "batcher",
+			Name:      "crashes_total",
+			Help:      "Number of crashes of the batching lanes, restarted after, by lane.",
+		}, []string{"lane"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http_publisher",
+			Name:      "requests_total",
+			Help:      "Number of requests served by the HTTP publisher, by handler and status code.",
+		}, []string{"handler", "code"}),
+		httpLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http_publisher",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the requests served by the HTTP publisher, by handler.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"handler"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "read_cache",
+			Name:      "requests_total",
+			Help:      "Number of reads of the CachingReader, by kind (head or block) and result (hit or miss).",
+		}, []string{"kind", "result"}),
+		announces: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "announce",
+			Name:      "sends_total",
+			Help:      "Number of announcements sent by the AnnouncePolicy, by target and result.",
+		}, []string{"target", "result"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.s3Requests, m.s3Retries, m.s3Throttles, m.s3Latency,
+		m.multihashes, m.ads, m.headUpdates,
+		m.batchSize, m.batchLatency, m.batchFlushes, m.queueDepth, m.overflows, m.crashes,
+		m.httpRequests, m.httpLatency,
+		m.cacheRequests,
+		m.announces,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func (m *Metrics) recordMultihashes(operation string, n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.multihashes.WithLabelValues(operation).Add(float64(n))
+}
+
+func (m *Metrics) recordAdvertisement(isRm bool) {
+	if m == nil {
+		return
+	}
+	operation := "publish"
+	if isRm {
+		operation = "retract"
+	}
+	m.ads.WithLabelValues(operation).Inc()
+}
+
+func (m *Metrics) recordHeadUpdate(err error) {
+	if m == nil {
+		return
+	}
+	m.headUpdates.WithLabelValues(resultLabel(err)).Inc()
+}
+
+func (m *Metrics) recordBatchFlush(lane string, size int, duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.batchSize.WithLabelValues(lane).Observe(float64(size))
+	m.batchLatency.WithLabelValues(lane).Observe(duration.Seconds())
+	m.batchFlushes.WithLabelValues(lane, resultLabel(err)).Inc()
+}
+
+func (m *Metrics) recordBatcherQueueDepth(lane string, depth int) {
+	if m == nil {
+		return
+	}
+	m.queueDepth.WithLabelValues(lane).Set(float64(depth))
+}
+
+func (m *Metrics) recordBatcherOverflow(lane string, outcome string) {
+	if m == nil {
+		return
+	}
+	m.overflows.WithLabelValues(lane, outcome).Inc()
+}
+
+func (m *Metrics) recordBatcherCrash(lane string) {
+	if m == nil {
+		return
+	}
+	m.crashes.WithLabelValues(lane).Inc()
+}
+
+func (m *Metrics) recordHttpRequest(handler string, code int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequests.WithLabelValues(handler, strconv.Itoa(code)).Inc()
+	m.httpLatency.WithLabelValues(handler).Observe(duration.Seconds())
+}
+
+func (m *Metrics) recordCacheRequest(kind string, hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheRequests.WithLabelValues(kind, result).Inc()
+}
+
+func (m *Metrics) recordAnnounce(target string, err error) {
+	if m == nil {
+		return
+	}
+	m.announces.WithLabelValues(target, resultLabel(err)).Inc()
+}
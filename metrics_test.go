@@ -0,0 +1,77 @@
+package herald
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listenAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(reg)
+	require.NoError(t, err)
+
+	h, err := New(
+		WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+		WithProviderAddress(multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")),
+		WithDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore())),
+		WithHTTPPublisher(listenAddr),
+		WithBatching(BatchConfig{
+			CountThreshold:         3,
+			MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+			MaxDelay:               10 * time.Millisecond,
+		}),
+		WithMetrics(metrics),
+		WithMetricsEndpoint(reg),
+	)
+	require.NoError(t, err)
+	require.NoError(t, h.Start(ctx))
+	defer h.Shutdown(ctx)
+
+	mhs := testMultihashes(7)
+	// large: published right away with a ContextID
+	require.NoError(t, h.PublishCatalog(ctx, testCatalog{MhCatalog: mhs[:5], id: []byte("foo")}))
+	require.NoError(t, h.RetractCatalog(ctx, testCatalog{MhCatalog: mhs[:5], id: []byte("foo")}))
+	// small: batched
+	require.NoError(t, h.PublishCatalog(ctx, CatalogFromMultihashes(mhs[5:]...)))
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.batchFlushes.WithLabelValues("publish", "success")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, float64(7), testutil.ToFloat64(metrics.multihashes.WithLabelValues("publish")))
+	require.Equal(t, float64(5), testutil.ToFloat64(metrics.multihashes.WithLabelValues("retract")))
+	require.Equal(t, float64(2), testutil.ToFloat64(metrics.ads.WithLabelValues("publish")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.ads.WithLabelValues("retract")))
+	require.Equal(t, float64(3), testutil.ToFloat64(metrics.headUpdates.WithLabelValues("success")))
+
+	_, err = NewHttpChainReader("http://"+listenAddr, h.ChainConfig().PublisherID).GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.httpRequests.WithLabelValues("head", "200")))
+
+	resp, err := http.Get("http://" + listenAddr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "herald_batcher_flushes_total")
+	require.Contains(t, string(body), "herald_http_publisher_requests_total")
+}
@@ -0,0 +1,166 @@
+package herald
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// MigrateOptions configures MigrateChain.
+type MigrateOptions struct {
+	// ResumeAfter is the last advertisement migrated by a previous, interrupted migration, as reported by
+	// MigrationProgress.LastAd. The advertisements up to it are not copied again.
+	ResumeAfter cid.Cid
+
+	// OnProgress, if set, is called after each advertisement is migrated along with its entry chunks.
+	OnProgress func(MigrationProgress)
+
+	// Logger, if set, is used instead of the default logger.
+	Logger *zap.SugaredLogger
+}
+
+// MigrationProgress is the state of a running migration.
+type MigrationProgress struct {
+	// Ads is the number of advertisements migrated, out of TotalAds known so far
+	Ads      int
+	TotalAds int
+
+	// Blocks is the number of blocks copied, advertisements and entry chunks
+	Blocks int
+
+	// LastAd is the last advertisement migrated. Everything older is migrated as well, which makes it a checkpoint
+	// to resume from with MigrateOptions.ResumeAfter.
+	LastAd cid.Cid
+}
+
+// MigrateChain copies every block of the chain of from into to, verbatim, then switches the head of to. The chain is
+// not republished: the advertisements keep their CIDs and signatures, which makes the migration transparent to the
+// indexers once the publisher serves the new backend.
+//
+// The advertisements are copied oldest first, so that an interrupted migration can be resumed with
+// MigrateOptions.ResumeAfter. The advertisements published on from while migrating are caught up with, but
+// publishing should be stopped before the final switch for the migration to be complete; otherwise, calling
+// MigrateChain again with ResumeAfter set to the returned head copies the rest.
+//
+// The head of to is only updated once all the blocks are copied. to must be empty, or hold a part of the same
+// chain, from a previous migration. It returns the new head of to.
+func MigrateChain(ctx context.Context, from ChainReader, to ChainWriter, opts MigrateOptions) (cid.Cid, error) {
+	progress := MigrationProgress{LastAd: opts.ResumeAfter}
+	// the advertisements known to be part of the migrated chain
+	migrated := make(map[cid.Cid]struct{})
+	if opts.ResumeAfter.Defined() {
+		migrated[opts.ResumeAfter] = struct{}{}
+	}
+	seen := make(map[cid.Cid]struct{}) // entry chunks already copied
+	head := opts.ResumeAfter
+
+	// catch up until the head of the source is stable
+	for {
+		pending, err := pendingAds(ctx, from, head)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if len(pending) == 0 {
+			break
+		}
+		progress.TotalAds += len(pending)
+
+		for i := len(pending) - 1; i >= 0; i-- {
+			if err := ctx.Err(); err != nil {
+				return cid.Undef, err
+			}
+			n, err := migrateAd(ctx, from, to, pending[i], seen)
+			if err != nil {
+				return cid.Undef, fmt.Errorf("failed to migrate advertisement %s: %w", pending[i], err)
+			}
+			migrated[pending[i]] = struct{}{}
+			progress.Ads++
+			progress.Blocks += n
+			progress.LastAd = pending[i]
+			if opts.OnProgress != nil {
+				opts.OnProgress(progress)
+			}
+		}
+		head = pending[0]
+	}
+
+	if !head.Defined() {
+		return cid.Undef, fmt.Errorf("the chain is empty")
+	}
+
+	err := to.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		if _, ok := migrated[prevHead]; prevHead.Defined() && !ok {
+			return cid.Undef, fmt.Errorf("the destination already holds another chain, with head %s", prevHead)
+		}
+		return head, nil
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	orDefaultLogger(opts.Logger).Infow("Migrated the chain", "head", head, "ads", progress.Ads, "blocks", progress.Blocks)
+	return head, nil
+}
+
+// pendingAds returns the advertisements of the chain more recent than last, newest first.
+func pendingAds(ctx context.Context, backend ChainReader, last cid.Cid) ([]cid.Cid, error) {
+	head, err := backend.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []cid.Cid
+	next := head
+	for next.Defined() && next != last {
+		ad, err := LoadAdvertisement(ctx, backend, next)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, next)
+		next = ad.PreviousCid()
+	}
+	if next != last {
+		// reached the genesis: the chain has been rewritten, by a GC for example
+		return nil, fmt.Errorf("advertisement %s is not part of the chain anymore", last)
+	}
+	return pending, nil
+}
+
+// migrateAd copies an advertisement and its entry chunks, and returns the number of blocks copied.
+// The entry chunks are copied first, so that the advertisement is never stored without them.
+func migrateAd(ctx context.Context, from ChainReader, to ChainWriter, adCid cid.Cid, seen map[cid.Cid]struct{}) (int, error) {
+	data, err := from.GetContent(ctx, adCid)
+	if err != nil {
+		return 0, err
+	}
+	ad, err := schema.BytesToAdvertisement(adCid, data)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	if _, ok := seen[linkCid(ad.Entries)]; !ok {
+		err = WalkEntryBlocks(ctx, from, ad.Entries, func(c cid.Cid, chunkData []byte, _ []multihash.Multihash) error {
+			if _, ok := seen[c]; ok {
+				// shared with a previous advertisement
+				return nil
+			}
+			if err := storeRawBlock(ctx, to, c, chunkData); err != nil {
+				return err
+			}
+			seen[c] = struct{}{}
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+
+	if err := storeRawBlock(ctx, to, adCid, data); err != nil {
+		return count, err
+	}
+	return count + 1, nil
+}
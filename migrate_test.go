@@ -0,0 +1,72 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateChain(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	from := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	mhs := testMultihashes(10)
+
+	_, err := PublishWithContextID(ctx, cfg, from, testCatalog{MhCatalog: mhs[0:5], id: []byte("a")})
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, from, CatalogFromMultihashes(mhs[5:10]...))
+	require.NoError(t, err)
+	head, err := RetractWithContextID(ctx, cfg, from, testCatalog{id: []byte("a")})
+	require.NoError(t, err)
+
+	// interrupt the migration after the first advertisement
+	to := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	var checkpoint MigrationProgress
+	interruptCtx, cancel := context.WithCancel(ctx)
+	_, err = MigrateChain(interruptCtx, from, to, MigrateOptions{OnProgress: func(p MigrationProgress) {
+		checkpoint = p
+		cancel()
+	}})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, checkpoint.Ads)
+	require.Equal(t, 3, checkpoint.TotalAds)
+	require.Equal(t, 4, checkpoint.Blocks)
+	toHead, err := to.GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, toHead.Defined())
+
+	// more is published while interrupted
+	head, err = PublishRawMHs(ctx, cfg, from, CatalogFromMultihashes(mhs[0]))
+	require.NoError(t, err)
+
+	var progress []MigrationProgress
+	migrated, err := MigrateChain(ctx, from, to, MigrateOptions{
+		ResumeAfter: checkpoint.LastAd,
+		OnProgress:  func(p MigrationProgress) { progress = append(progress, p) },
+	})
+	require.NoError(t, err)
+	require.Equal(t, head, migrated)
+	require.Len(t, progress, 3)
+	require.Equal(t, head, progress[2].LastAd)
+	// 3 advertisements, 3 entry chunks for the raw multihashes and 1 for the last one
+	require.Equal(t, 7, progress[2].Blocks)
+
+	report, err := VerifyChain(ctx, to, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	require.Equal(t, 4, report.Ads)
+
+	// the destination holds another chain
+	other := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	_, err = PublishRawMHs(ctx, cfg, other, CatalogFromMultihashes(mhs[1]))
+	require.NoError(t, err)
+	_, err = MigrateChain(ctx, from, other, MigrateOptions{})
+	require.Error(t, err)
+
+	// the checkpoint is not part of the chain
+	_, err = MigrateChain(ctx, other, to, MigrateOptions{ResumeAfter: checkpoint.LastAd})
+	require.Error(t, err)
+}
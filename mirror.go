@@ -0,0 +1,310 @@
+package herald
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+)
+
+// DefaultMirrorInterval is the default interval at which a Mirror syncs the remote chain.
+const DefaultMirrorInterval = 5 * time.Minute
+
+type MirrorConfig struct {
+	// Interval is the interval between two syncs of the remote chain
+	Interval time.Duration
+
+	// StartAfter is the last remote advertisement already mirrored, to resume a previous mirroring.
+	// If undefined, the whole remote chain is mirrored, or in Verbatim mode the chain after the local head.
+	StartAfter cid.Cid
+
+	// Verbatim copies the remote advertisements and entries as they are, signed by the remote provider, and moves
+	// the local head to the remote one. The local chain is then identical to the remote chain, and can be served
+	// through our HttpPublisher or S3 layout in front of the remote publisher. The indexers must accept our
+	// publisher identity for the remote provider.
+	//
+	// The blocks are stored through the ChainWriter, which re-encodes them: a remote block not canonically encoded
+	// in its codec can't be stored under its CID, and fails the sync. Republishing, the default, re-encodes the
+	// advertisements and entry chunks instead; only the HAMT entries are copied as they are.
+	Verbatim bool
+
+	// MaxDepth is the maximum number of remote advertisements walked back by a sync to find the last one mirrored,
+	// above which the sync fails. Zero means no limit.
+	MaxDepth int
+}
+
+// Mirror continuously syncs the chain of a remote publisher. By default, it republishes the advertisements under our
+// own identity and provider addresses: the ContextID, metadata, entries and retraction semantic are preserved, which
+// makes the same content retrievable from our endpoints. See MirrorConfig.Verbatim to replicate the chain instead.
+type Mirror struct {
+	cfg       MirrorConfig
+	chainCfg  ChainConfig
+	source    ChainReader
+	backend   ChainWriter
+	announcer announce.Sender
+
+	mu         sync.Mutex // serialize the syncs
+	lastSynced cid.Cid
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewMirror creates a Mirror of the chain read from source, republished into backend with chainCfg.
+// If announcer is not nil, the new head is announced after each sync bringing new advertisements.
+func NewMirror(cfg MirrorConfig, chainCfg ChainConfig, source ChainReader, backend ChainWriter, announcer announce.Sender) *Mirror {
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultMirrorInterval
+	}
+	return &Mirror{
+		cfg:        cfg,
+		chainCfg:   chainCfg.withLogFields(nil),
+		source:     source,
+		backend:    backend,
+		announcer:  announcer,
+		lastSynced: cfg.StartAfter,
+	}
+}
+
+// Start runs the periodic sync in the background, until Close is called.
+func (m *Mirror) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := m.Sync(ctx); err != nil && ctx.Err() == nil {
+				m.chainCfg.logger().Errorw("failed to sync the mirrored chain", "err", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the periodic sync.
+func (m *Mirror) Close() error {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+	return nil
+}
+
+// LastSynced returns the CID of the last remote advertisement mirrored.
+func (m *Mirror) LastSynced() cid.Cid {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastSynced
+}
+
+// Sync performs a single sync of the remote chain, and returns the number of advertisements republished.
+func (m *Mirror) Sync(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cfg.Verbatim && !m.lastSynced.Defined() {
+		// resume after the chain already replicated
+		if reader, ok := m.backend.(ChainReader); ok {
+			localHead, err := reader.GetHead(ctx)
+			if err != nil {
+				return 0, err
+			}
+			m.lastSynced = localHead
+		}
+	}
+
+	remoteHead, err := m.source.GetHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !remoteHead.Defined() || remoteHead.Equals(m.lastSynced) {
+		return 0, nil
+	}
+
+	// walk back the remote chain up to the last mirrored advertisement
+	var pending []cid.Cid
+	next := remoteHead
+	for next.Defined() && !next.Equals(m.lastSynced) {
+		if m.cfg.MaxDepth > 0 && len(pending) >= m.cfg.MaxDepth {
+			return 0, fmt.Errorf("%w: %s not found in the last %d remote advertisements", ErrMirrorDiverged, m.lastSynced, m.cfg.MaxDepth)
+		}
+		ad, err := LoadAdvertisement(ctx, m.source, next)
+		if err != nil {
+			return 0, err
+		}
+		pending = append(pending, next)
+		next = ad.PreviousCid()
+	}
+	if m.lastSynced.Defined() && !next.Defined() {
+		// the remote chain was rewritten: mirroring it whole would duplicate what is already mirrored
+		return 0, fmt.Errorf("%w: %s is not in the remote chain", ErrMirrorDiverged, m.lastSynced)
+	}
+
+	var newHead cid.Cid
+	var count int
+	if m.cfg.Verbatim {
+		newHead, count, err = m.replicate(ctx, pending)
+	} else {
+		// republish, oldest first, keeping the head of the last success to announce
+		for i := len(pending) - 1; i >= 0; i-- {
+			var head cid.Cid
+			head, err = m.republish(ctx, pending[i])
+			if err != nil {
+				break
+			}
+			newHead = head
+			m.lastSynced = pending[i]
+			count++
+		}
+	}
+
+	if count > 0 {
+		m.chainCfg.logger().Infow("Mirrored remote advertisements", "count", count, "remoteHead", m.lastSynced, "head", newHead)
+		if m.announcer != nil {
+			if err := announce.Send(ctx, newHead, m.chainCfg.PublisherAddrs(), m.announcer); err != nil {
+				m.chainCfg.logger().Errorw("failed to announce mirrored head", "err", err, "head", newHead)
+			}
+		}
+	}
+	return count, err
+}
+
+// republish copies the entries of the remote advertisement and publishes it under our identity.
+func (m *Mirror) republish(ctx context.Context, remoteCid cid.Cid) (cid.Cid, error) {
+	ad, err := LoadAdvertisement(ctx, m.source, remoteCid)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	entries := ad.Entries
+	if linkCid(entries) != schema.NoEntries.Cid {
+		entries, err = copyEntries(ctx, m.chainCfg.linkPrototype(), m.source, m.backend, entries)
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	cfg := m.chainCfg
+	cfg.Metadata = ad.Metadata
+	return generateAdvertisement(ctx, cfg, m.backend, ad.ContextID, entries, ad.IsRm)
+}
+
+// replicate copies the remote advertisements as they are, oldest first, then moves the local head to the last one
+// copied. It returns the new head and the number of advertisements copied.
+func (m *Mirror) replicate(ctx context.Context, pending []cid.Cid) (cid.Cid, int, error) {
+	base := m.lastSynced
+	var copied cid.Cid
+	var count int
+	var err error
+	for i := len(pending) - 1; i >= 0; i-- {
+		if err = m.copyAdvertisement(ctx, pending[i]); err != nil {
+			break
+		}
+		copied = pending[i]
+		count++
+	}
+	if count == 0 {
+		return cid.Undef, 0, err
+	}
+
+	// the blocks are all stored before the head is exposed
+	headErr := m.backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		if prevHead.Defined() && prevHead != base {
+			return cid.Undef, fmt.Errorf("the local head %s moved, expected %s", prevHead, base)
+		}
+		return copied, nil
+	})
+	if headErr != nil {
+		return cid.Undef, 0, headErr
+	}
+	m.lastSynced = copied
+	return copied, count, err
+}
+
+// copyAdvertisement copies the raw blocks of the remote advertisement and its entries, the advertisement last.
+func (m *Mirror) copyAdvertisement(ctx context.Context, adCid cid.Cid) error {
+	ad, err := LoadAdvertisement(ctx, m.source, adCid)
+	if err != nil {
+		return err
+	}
+	if err := copyEntryBlocks(ctx, m.source, m.backend, ad.Entries); err != nil {
+		return err
+	}
+	data, err := m.source.GetContent(ctx, adCid)
+	if err != nil {
+		return err
+	}
+	return storeRawBlock(ctx, m.backend, adCid, data)
+}
+
+// copyEntries copies the entries from source into backend, and returns the link to them. A HAMT is copied as is, a
+// chain of entry chunks is re-encoded with lp if needed.
+func copyEntries(ctx context.Context, lp ipld.LinkPrototype, source ChainReader, backend ChainWriter, entries ipld.Link) (ipld.Link, error) {
+	format, err := entriesFormatOf(ctx, source, entries)
+	if err != nil {
+		return nil, err
+	}
+	if format == EntriesHAMT {
+		return entries, copyEntryBlocks(ctx, source, backend, entries)
+	}
+
+	var chunkCids []cid.Cid
+	identical := true
+
+	// Optimistically store the chunks as they are. If the remote uses the same encoding as we do, the CIDs are the
+	// same and the chain of chunks remains valid.
+	err = WalkEntryChunks(ctx, source, entries, func(chunkCid cid.Cid, chunk schema.EntryChunk) error {
+		chunkCids = append(chunkCids, chunkCid)
+		if !identical {
+			return nil
+		}
+		lnk, err := generateEntriesChunk(ctx, lp, backend, chunk.Next, chunk.Entries)
+		if err != nil {
+			return err
+		}
+		identical = linkCid(lnk).Equals(chunkCid)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if identical {
+		return entries, nil
+	}
+
+	// The encoding differs: rebuild the chain from its tail, re-linking the chunks.
+	var next ipld.Link
+	for i := len(chunkCids) - 1; i >= 0; i-- {
+		chunk, err := LoadEntryChunk(ctx, source, chunkCids[i])
+		if err != nil {
+			return nil, err
+		}
+		next, err = generateEntriesChunk(ctx, lp, backend, next, chunk.Entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+// copyEntryBlocks copies the raw blocks of the entries from source into backend, as they are read. The entries are
+// only reachable once the advertisement linking to them is stored, after.
+func copyEntryBlocks(ctx context.Context, source ChainReader, backend ChainWriter, entries ipld.Link) error {
+	return WalkEntryBlocks(ctx, source, entries, func(c cid.Cid, data []byte, _ []multihash.Multihash) error {
+		return storeRawBlock(ctx, backend, c, data)
+	})
+}
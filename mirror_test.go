@@ -0,0 +1,163 @@
+package herald
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// serveChain exposes a DsBackend with the layout expected by HttpChainReader.
+func serveChain(t *testing.T, backend *DsBackend, cfg ChainConfig) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		if path == "head" {
+			h, err := backend.GetHead(r.Context())
+			require.NoError(t, err)
+			signed, err := head.NewSignedHead(h, "/indexer/ingest/mainnet", cfg.PublisherKey)
+			require.NoError(t, err)
+			encoded, err := signed.Encode()
+			require.NoError(t, err)
+			_, _ = w.Write(encoded)
+			return
+		}
+		c, err := cid.Decode(path)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		content, err := backend.GetContent(r.Context(), c)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	}))
+}
+
+func TestMirror(t *testing.T) {
+	ctx := context.Background()
+
+	remoteCfg := testChainConfig(t)
+	remote := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	server := serveChain(t, remote, remoteCfg)
+	defer server.Close()
+
+	localCfg := testChainConfig(t)
+	local := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	source := NewHttpChainReader(server.URL, remoteCfg.PublisherID)
+	mirror := NewMirror(MirrorConfig{}, localCfg, source, local, nil)
+
+	mhs := testMultihashes(5)
+	_, err := PublishWithContextID(ctx, remoteCfg, remote, testCatalog{MhCatalog: mhs, id: []byte("foo")})
+	require.NoError(t, err)
+
+	count, err := mirror.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	_, err = RetractWithContextID(ctx, remoteCfg, remote, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+
+	count, err = mirror.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// nothing new
+	count, err = mirror.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	localHead, err := local.GetHead(ctx)
+	require.NoError(t, err)
+	retract, err := LoadAdvertisement(ctx, local, localHead)
+	require.NoError(t, err)
+	require.True(t, retract.IsRm)
+	require.Equal(t, []byte("foo"), retract.ContextID)
+	require.Equal(t, localCfg.PublisherID.String(), retract.Provider)
+
+	publish, err := LoadAdvertisement(ctx, local, retract.PreviousCid())
+	require.NoError(t, err)
+	require.False(t, publish.IsRm)
+	require.Equal(t, localCfg.ProviderAddrs, publish.Addresses)
+	signer, err := publish.VerifySignature()
+	require.NoError(t, err)
+	require.Equal(t, localCfg.PublisherID, signer)
+
+	var got []multihash.Multihash
+	err = WalkEntries(ctx, local, retract.PreviousCid(), func(mh multihash.Multihash) error {
+		got = append(got, mh)
+		return nil
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, mhs, got)
+
+	// the remote head must be signed by the expected publisher
+	_, err = NewHttpChainReader(server.URL, localCfg.PublisherID).GetHead(ctx)
+	require.Error(t, err)
+
+	// the last mirrored advertisement is not in the remote chain, or too far back
+	diverged := NewMirror(MirrorConfig{StartAfter: localHead}, localCfg, source, local, nil)
+	_, err = diverged.Sync(ctx)
+	require.ErrorIs(t, err, ErrMirrorDiverged)
+	shallow := NewMirror(MirrorConfig{MaxDepth: 1}, localCfg, source, local, nil)
+	_, err = shallow.Sync(ctx)
+	require.ErrorIs(t, err, ErrMirrorDiverged)
+}
+
+func TestMirrorVerbatim(t *testing.T) {
+	ctx := context.Background()
+
+	remoteCfg := testChainConfig(t)
+	remote := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	server := serveChain(t, remote, remoteCfg)
+	defer server.Close()
+
+	localCfg := testChainConfig(t)
+	local := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	source := NewHttpChainReader(server.URL, remoteCfg.PublisherID)
+
+	_, err := PublishWithContextID(ctx, remoteCfg, remote, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.NoError(t, err)
+	remoteHead, err := RetractWithContextID(ctx, remoteCfg, remote, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+
+	mirror := NewMirror(MirrorConfig{Verbatim: true}, localCfg, source, local, nil)
+	count, err := mirror.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	// the local chain is the remote one, signed by the remote provider
+	localHead, err := local.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, remoteHead, localHead)
+	report, err := VerifyChain(ctx, local, VerifyOptions{PublisherID: remoteCfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+
+	// a new mirror resumes after the local head
+	remoteHead, err = PublishWithContextID(ctx, remoteCfg, remote, testCatalog{MhCatalog: testMultihashes(3), id: []byte("bar")})
+	require.NoError(t, err)
+	mirror = NewMirror(MirrorConfig{Verbatim: true}, localCfg, source, local, nil)
+	count, err = mirror.Sync(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Equal(t, remoteHead, mirror.LastSynced())
+
+	// the local chain diverged from the mirrored one
+	_, err = PublishWithContextID(ctx, localCfg, local, testCatalog{MhCatalog: testMultihashes(1), id: []byte("baz")})
+	require.NoError(t, err)
+	_, err = PublishWithContextID(ctx, remoteCfg, remote, testCatalog{MhCatalog: testMultihashes(2), id: []byte("qux")})
+	require.NoError(t, err)
+	_, err = mirror.Sync(ctx)
+	require.ErrorContains(t, err, "local head")
+}
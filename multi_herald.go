@@ -0,0 +1,291 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"go.uber.org/zap"
+)
+
+// tenantNameRegexp restricts the tenant names to what can be used as a path segment and a datastore key.
+var tenantNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// MultiHerald publishes for many providers from a single process. Each tenant is a Herald, with its own identity,
+// ChainConfig, chain, batcher and announcer, so that they publish and announce independently.
+//
+// The chains can share a datastore, each tenant's chain being stored under /tenants/<name>, and an HTTP server,
+// serving each tenant's chain under /<name>/ipni/v1/ad/. The publisher address of a tenant, as given with
+// WithPublisherAddress, must then include that path, for example /dns/example.com/tcp/443/https/http-path/<name>.
+type MultiHerald struct {
+	ds         datastore.Datastore
+	common     []Option
+	listenAddr string // of the shared HTTP server, none if empty
+	logger     *zap.SugaredLogger
+
+	mu       sync.RWMutex
+	started  bool
+	server   *http.Server // created by Start
+	tenants  map[string]*Herald
+	handlers map[string]http.Handler
+}
+
+// MultiHeraldOption is an optional configuration for the MultiHerald.
+type MultiHeraldOption func(*MultiHerald)
+
+// WithMultiDatastore stores the chains of all the tenants in ds, each under its own namespace. A backend given in the
+// options of a tenant takes precedence.
+func WithMultiDatastore(ds datastore.Datastore) MultiHeraldOption {
+	return func(m *MultiHerald) {
+		m.ds = ds
+	}
+}
+
+// WithMultiHTTPPublisher serves the chains of all the tenants on a single HTTP server, listening on listenAddr.
+func WithMultiHTTPPublisher(listenAddr string) MultiHeraldOption {
+	return func(m *MultiHerald) {
+		m.listenAddr = listenAddr
+	}
+}
+
+// WithMultiCommonOptions sets the Herald options shared by all the tenants, for example the metadata or the batching.
+// The options of a tenant are applied after, and take precedence.
+func WithMultiCommonOptions(opts ...Option) MultiHeraldOption {
+	return func(m *MultiHerald) {
+		m.common = opts
+	}
+}
+
+// WithMultiLogger logs with the given logger instead of the default one. The tenants log with it too, with their
+// name as a field, unless given their own with WithLogger.
+func WithMultiLogger(l *zap.SugaredLogger) MultiHeraldOption {
+	return func(m *MultiHerald) {
+		m.logger = l
+	}
+}
+
+// NewMultiHerald creates a MultiHerald without tenants.
+func NewMultiHerald(opts ...MultiHeraldOption) *MultiHerald {
+	m := &MultiHerald{
+		tenants:  make(map[string]*Herald),
+		handlers: make(map[string]http.Handler),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.logger = orDefaultLogger(m.logger)
+	return m
+}
+
+// AddTenant creates the Herald of a tenant, with the common options then the given options. Its identity should be
+// set with WithIdentity. If the MultiHerald is already started, so is the tenant.
+func (m *MultiHerald) AddTenant(ctx context.Context, name string, opts ...Option) (*Herald, error) {
+	if !tenantNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid tenant name %q", name)
+	}
+
+	all := append([]Option{}, m.common...)
+	if m.ds != nil {
+		all = append(all, WithDatastoreBackend(m.ds, WithDsNamespace("/tenants/"+name)))
+	}
+	all = append(all, opts...)
+	all = append(all, withTenantLogger(m.logger, name))
+	h, err := New(all...)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", name, err)
+	}
+
+	var handler http.Handler
+	if m.listenAddr != "" {
+		reader, ok := h.backend.(ChainReader)
+		if !ok {
+			return nil, fmt.Errorf("tenant %s: backend %T can't be served by a publisher", name, h.backend)
+		}
+		pub, err := NewHttpPublisher(reader, "", h.topic, h.identity,
+			WithHttpPublisherPathPrefix(name), WithHttpPublisherLegacyPaths(false), WithHttpPublisherMetrics(h.metrics),
+			WithHttpPublisherLogger(h.chainConfig.Logger))
+		if err != nil {
+			return nil, err
+		}
+		handler = pub.Handler()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[name]; ok {
+		return nil, fmt.Errorf("tenant %s already exists", name)
+	}
+	if m.started {
+		if err := h.Start(ctx); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+	}
+	m.tenants[name] = h
+	if handler != nil {
+		m.handlers[name] = handler
+	}
+	m.logger.Infow("Added tenant", "tenant", name, "publisherID", h.id)
+	return h, nil
+}
+
+// withTenantLogger adds the tenant field to the logger set by the previous options, or to base.
+func withTenantLogger(base *zap.SugaredLogger, name string) Option {
+	return func(o *options) error {
+		if o.logger == nil {
+			o.logger = base
+		}
+		o.logger = o.logger.With("tenant", name)
+		return nil
+	}
+}
+
+// RemoveTenant stops serving and publishing for a tenant. Its chain is kept in the backend.
+func (m *MultiHerald) RemoveTenant(ctx context.Context, name string) error {
+	m.mu.Lock()
+	h, ok := m.tenants[name]
+	delete(m.tenants, name)
+	delete(m.handlers, name)
+	started := m.started
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown tenant %s", name)
+	}
+	if started {
+		return h.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Tenant returns the Herald of a tenant.
+func (m *MultiHerald) Tenant(name string) (*Herald, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok := m.tenants[name]
+	return h, ok
+}
+
+// Tenants returns the names of the tenants.
+func (m *MultiHerald) Tenants() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.tenants))
+	for name := range m.tenants {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Start starts the HTTP server, if any, and all the tenants.
+func (m *MultiHerald) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started {
+		return errors.New("already started")
+	}
+	var started []*Herald
+	for name, h := range m.tenants {
+		if err := h.Start(ctx); err != nil {
+			for _, h := range started {
+				_ = h.Shutdown(ctx)
+			}
+			return fmt.Errorf("tenant %s: %w", name, err)
+		}
+		started = append(started, h)
+	}
+	if m.listenAddr != "" {
+		listener, err := net.Listen("tcp", m.listenAddr)
+		if err != nil {
+			for _, h := range started {
+				_ = h.Shutdown(ctx)
+			}
+			return err
+		}
+		// a closed server can't serve again, a new one is created on each start
+		server := &http.Server{
+			Addr:              m.listenAddr,
+			Handler:           http.HandlerFunc(m.serveHTTP),
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      10 * time.Second,
+		}
+		m.server = server
+		go func() {
+			err := server.Serve(listener)
+			if errors.Is(err, http.ErrServerClosed) {
+				m.logger.Info("Multi-tenant HTTP publisher stopped successfully.")
+			} else {
+				m.logger.Errorw("Multi-tenant HTTP publisher stopped erroneously.", "err", err)
+			}
+		}()
+		m.logger.Infow("Multi-tenant HTTP publisher started successfully.", "address", listener.Addr())
+	}
+	m.started = true
+	return nil
+}
+
+// Shutdown stops the HTTP server, if any, and all the tenants. The MultiHerald can be started again after.
+func (m *MultiHerald) Shutdown(ctx context.Context) error {
+	// the lock is released before waiting for the server, whose requests being served need it
+	m.mu.Lock()
+	if !m.started {
+		m.mu.Unlock()
+		return nil
+	}
+	m.started = false
+	server := m.server
+	m.server = nil
+	tenants := make(map[string]*Herald, len(m.tenants))
+	for name, h := range m.tenants {
+		tenants[name] = h
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	if server != nil {
+		errs = append(errs, server.Shutdown(ctx))
+	}
+	for name, h := range tenants {
+		if err := h.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PublishCatalog publishes the catalog in the chain of the tenant.
+func (m *MultiHerald) PublishCatalog(ctx context.Context, tenant string, catalog Catalog) error {
+	h, ok := m.Tenant(tenant)
+	if !ok {
+		return fmt.Errorf("unknown tenant %s", tenant)
+	}
+	return h.PublishCatalog(ctx, catalog)
+}
+
+// RetractCatalog retracts the catalog from the chain of the tenant.
+func (m *MultiHerald) RetractCatalog(ctx context.Context, tenant string, catalog Catalog) error {
+	h, ok := m.Tenant(tenant)
+	if !ok {
+		return fmt.Errorf("unknown tenant %s", tenant)
+	}
+	return h.RetractCatalog(ctx, catalog)
+}
+
+// serveHTTP dispatches the requests to the publisher of the tenant named by the first path segment.
+func (m *MultiHerald) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	m.mu.RLock()
+	handler, ok := m.handlers[name]
+	m.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,100 @@
+package herald
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiHerald(t *testing.T) {
+	ctx := context.Background()
+
+	// find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listenAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	m := NewMultiHerald(
+		WithMultiDatastore(sync.MutexWrap(datastore.NewMapDatastore())),
+		WithMultiHTTPPublisher(listenAddr),
+		WithMultiCommonOptions(
+			WithMetadata(metadata.Default.New(metadata.Bitswap{})),
+			WithBatching(BatchConfig{
+				CountThreshold:         1,
+				MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+				MaxDelay:               time.Second,
+			}),
+		),
+	)
+
+	addTenant := func(name string) *Herald {
+		key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		h, err := m.AddTenant(ctx, name,
+			WithIdentity(key),
+			WithProviderAddress(multiaddr.StringCast("/dns/"+name+".example.com/tcp/4001")),
+		)
+		require.NoError(t, err)
+		return h
+	}
+	alice := addTenant("alice")
+	_, err = m.AddTenant(ctx, "alice")
+	require.Error(t, err)
+	_, err = m.AddTenant(ctx, "alice/bob")
+	require.Error(t, err)
+
+	require.NoError(t, m.Start(ctx))
+	// added after the start
+	bob := addTenant("bob")
+	require.ElementsMatch(t, []string{"alice", "bob"}, m.Tenants())
+
+	require.NoError(t, m.PublishCatalog(ctx, "alice", testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")}))
+	require.NoError(t, m.PublishCatalog(ctx, "bob", testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")}))
+	require.Error(t, m.PublishCatalog(ctx, "carol", testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")}))
+
+	// each tenant has its own chain, signed with its own identity, served on the shared server
+	for _, h := range []*Herald{alice, bob} {
+		local, err := h.Backend().(ChainReader).GetHead(ctx)
+		require.NoError(t, err)
+		require.True(t, local.Defined())
+		ad, err := LoadAdvertisement(ctx, h.Backend().(ChainReader), local)
+		require.NoError(t, err)
+		require.Equal(t, h.ChainConfig().PublisherID.String(), ad.Provider)
+		require.False(t, ad.PreviousCid().Defined())
+
+		name := "alice"
+		if h == bob {
+			name = "bob"
+		}
+		remote, err := NewHttpChainReader("http://"+listenAddr+"/"+name+"/ipni/v1/ad", h.ChainConfig().PublisherID).GetHead(ctx)
+		require.NoError(t, err)
+		require.Equal(t, local, remote)
+	}
+
+	require.NoError(t, m.RemoveTenant(ctx, "bob"))
+	remote, err := NewHttpChainReader("http://"+listenAddr+"/bob/ipni/v1/ad", "").GetHead(ctx)
+	require.NoError(t, err)
+	require.False(t, remote.Defined())
+
+	require.NoError(t, m.Shutdown(ctx))
+	_, err = NewHttpChainReader("http://"+listenAddr+"/alice/ipni/v1/ad", "").GetHead(ctx)
+	require.Error(t, err)
+
+	// started again, with a new server
+	require.NoError(t, m.Start(ctx))
+	remote, err = NewHttpChainReader("http://"+listenAddr+"/alice/ipni/v1/ad", alice.ChainConfig().PublisherID).GetHead(ctx)
+	require.NoError(t, err)
+	require.True(t, remote.Defined())
+	require.NoError(t, m.PublishCatalog(ctx, "alice", testCatalog{MhCatalog: testMultihashes(4), id: []byte("bar")}))
+	require.NoError(t, m.Shutdown(ctx))
+}
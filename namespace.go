@@ -0,0 +1,176 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// namespaceMarker starts the namespaced ContextIDs. The ContextIDs published without namespace can't start with it,
+// so that a namespaced ContextID is never mistaken for another one.
+var namespaceMarker = []byte{0xff, 'n', 's'}
+
+// NamespacedContextID returns the ContextID of id within a tenant namespace.
+// The namespace is marked and length-prefixed, which guarantees that ContextIDs of two different namespaces never
+// collide, nor collide with the ContextIDs without namespace. Without namespace, id is returned as is, and must not
+// start with the marker.
+func NamespacedContextID(namespace []byte, id CatalogID) (CatalogID, error) {
+	if len(namespace) == 0 {
+		if bytes.HasPrefix(id, namespaceMarker) {
+			return nil, fmt.Errorf("ContextID %x starts with the namespace marker", id)
+		}
+		return id, validateContextID(id)
+	}
+	if len(namespace) > 255 {
+		return nil, fmt.Errorf("namespace is too long: %d bytes", len(namespace))
+	}
+	res := make(CatalogID, 0, len(namespaceMarker)+1+len(namespace)+len(id))
+	res = append(res, namespaceMarker...)
+	res = append(res, byte(len(namespace)))
+	res = append(res, namespace...)
+	res = append(res, id...)
+	return res, validateContextID(res)
+}
+
+// SplitNamespacedContextID is the reverse operation of NamespacedContextID.
+func SplitNamespacedContextID(contextID CatalogID) (namespace []byte, id CatalogID, err error) {
+	rest, ok := bytes.CutPrefix(contextID, namespaceMarker)
+	if !ok || len(rest) == 0 || rest[0] == 0 || len(rest) <= 1+int(rest[0]) {
+		return nil, nil, errors.New("not a namespaced ContextID")
+	}
+	l := int(rest[0])
+	return rest[1 : 1+l], rest[1+l:], nil
+}
+
+// validateContextID checks a ContextID against the IPNI limits.
+func validateContextID(id CatalogID) error {
+	if len(id) > schema.MaxContextIDLen {
+		return fmt.Errorf("ContextID is too long: %d bytes, maximum is %d", len(id), schema.MaxContextIDLen)
+	}
+	return nil
+}
+
+// contextID returns the ContextID of id, within the namespace of the config if any.
+func (cfg ChainConfig) contextID(id CatalogID) (CatalogID, error) {
+	if len(id) == 0 {
+		return nil, nil
+	}
+	return NamespacedContextID(cfg.ContextIDNamespace, id)
+}
+
+// RetractNamespace retracts all the ContextIDs of the namespace that are currently published, under a single head
+// update. The live ContextIDs are listed with the ContextIDIndex of the backend if it maintains one. Otherwise, or if
+// the head moved since, the chain is read through reader from the head being updated, so that a ContextID published
+// concurrently is either retracted or makes the update fail with a conflict.
+// It returns the new head, or cid.Undef if there was nothing to retract.
+func RetractNamespace(ctx context.Context, cfg ChainConfig, backend ChainWriter, reader ChainReader, namespace []byte) (newHead cid.Cid, err error) {
+	ctx, span := cfg.tracer().Start(ctx, "RetractNamespace", trace.WithAttributes(attribute.String("herald.namespace", string(namespace))))
+	defer func() { endSpan(span, err) }()
+	backend = cfg.traced(backend)
+	if len(namespace) == 0 {
+		return cid.Undef, errors.New("empty namespace")
+	}
+	if _, err := NamespacedContextID(namespace, nil); err != nil {
+		return cid.Undef, err
+	}
+	if err := cfg.validate(true); err != nil {
+		return cid.Undef, err
+	}
+	cfg = cfg.withLogFields(nil)
+
+	indexedHead, indexed, ok, err := indexedNamespace(ctx, backend, reader, namespace)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	var count int
+	err = backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
+		live := indexed
+		if !ok || !indexedHead.Equals(head) {
+			var err error
+			live, err = walkNamespace(ctx, reader, head, namespace)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		if len(live) == 0 {
+			return cid.Undef, errNothingToRetract
+		}
+		for _, id := range live {
+			var err error
+			head, err = storeAdvertisement(ctx, cfg.withLogFields(id), backend, head, id, schema.NoEntries, true)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		newHead, count = head, len(live)
+		return newHead, nil
+	})
+	if errors.Is(err, errNothingToRetract) {
+		return cid.Undef, nil
+	}
+	if err != nil {
+		return cid.Undef, err
+	}
+	cfg.logger().Infow("Retracted namespace", "namespace", namespace, "count", count, "head", newHead)
+	return newHead, nil
+}
+
+// errNothingToRetract aborts the head update when no ContextID of the namespace is live.
+var errNothingToRetract = errors.New("nothing to retract")
+
+// indexedNamespace lists the live ContextIDs of the namespace with the ContextIDIndex of backend, as of the returned
+// head. It returns false if the backend doesn't maintain a ContextIDIndex.
+func indexedNamespace(ctx context.Context, backend ChainWriter, reader ChainReader, namespace []byte) (cid.Cid, []CatalogID, bool, error) {
+	index, ok := backend.(ContextIDIndex)
+	if !ok {
+		return cid.Undef, nil, false, nil
+	}
+	// the index is synced with a head at least as recent, which is checked again under the head update
+	head, err := reader.GetHead(ctx)
+	if err != nil {
+		return cid.Undef, nil, false, err
+	}
+	var live []CatalogID
+	err = index.ListContextIDs(ctx, func(id CatalogID, info ContextIDInfo) error {
+		if ns, _, err := SplitNamespacedContextID(id); err == nil && bytes.Equal(ns, namespace) && info.State == ContextIDLive {
+			live = append(live, id)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNoContextIDIndex) {
+		return cid.Undef, nil, false, nil
+	}
+	if err != nil {
+		return cid.Undef, nil, false, err
+	}
+	return head, live, true, nil
+}
+
+// walkNamespace lists the live ContextIDs of the namespace by walking the chain from head.
+func walkNamespace(ctx context.Context, reader ChainReader, head cid.Cid, namespace []byte) ([]CatalogID, error) {
+	// walking from the head, the first advertisement seen for a ContextID gives its current state
+	seen := make(map[string]struct{})
+	var live []CatalogID
+	err := WalkAdsFrom(ctx, reader, head, func(_ cid.Cid, ad schema.Advertisement) error {
+		ns, _, err := SplitNamespacedContextID(ad.ContextID)
+		if err != nil || !bytes.Equal(ns, namespace) {
+			return nil
+		}
+		if _, ok := seen[string(ad.ContextID)]; ok {
+			return nil
+		}
+		seen[string(ad.ContextID)] = struct{}{}
+		if !ad.IsRm {
+			live = append(live, ad.ContextID)
+		}
+		return nil
+	})
+	return live, err
+}
@@ -0,0 +1,116 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespacedContextID(t *testing.T) {
+	// "ab"+"c" and "a"+"bc" must not collide
+	id1, err := NamespacedContextID([]byte("ab"), []byte("c"))
+	require.NoError(t, err)
+	id2, err := NamespacedContextID([]byte("a"), []byte("bc"))
+	require.NoError(t, err)
+	require.NotEqual(t, id1, id2)
+
+	ns, id, err := SplitNamespacedContextID(id1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("ab"), ns)
+	require.Equal(t, CatalogID("c"), id)
+
+	_, err = NamespacedContextID([]byte("tenant"), bytes.Repeat([]byte("x"), 60))
+	require.Error(t, err)
+
+	// a ContextID without namespace is never taken for a namespaced one
+	_, _, err = SplitNamespacedContextID([]byte("\x02abc"))
+	require.Error(t, err)
+	_, err = NamespacedContextID(nil, append(bytes.Clone(namespaceMarker), "\x01ab"...))
+	require.Error(t, err)
+}
+
+func TestRetractNamespace(t *testing.T) {
+	ctx := context.Background()
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	tenantA := testChainConfig(t)
+	tenantA.ContextIDNamespace = []byte("a")
+	tenantB := tenantA
+	tenantB.ContextIDNamespace = []byte("b")
+
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := PublishWithContextID(ctx, tenantA, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte(id)})
+		require.NoError(t, err)
+	}
+	_, err := PublishWithContextID(ctx, tenantB, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte("1")})
+	require.NoError(t, err)
+	_, err = RetractWithContextID(ctx, tenantA, backend, testCatalog{id: []byte("2")})
+	require.NoError(t, err)
+
+	head, err := RetractNamespace(ctx, tenantA, backend, backend, []byte("a"))
+	require.NoError(t, err)
+
+	var retracted []string
+	err = WalkAds(ctx, backend, func(_ cid.Cid, ad schema.Advertisement) error {
+		if !ad.IsRm {
+			return ErrStopWalk
+		}
+		ns, id, err := SplitNamespacedContextID(ad.ContextID)
+		require.NoError(t, err)
+		require.Equal(t, []byte("a"), ns)
+		retracted = append(retracted, string(id))
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, cid.Undef, head)
+	// "2" was already retracted before, "1" and "3" are retracted by RetractNamespace
+	require.ElementsMatch(t, []string{"1", "3", "2"}, retracted)
+
+	// nothing left
+	head, err = RetractNamespace(ctx, tenantA, backend, backend, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, head)
+}
+
+func TestRetractNamespaceIndexed(t *testing.T) {
+	ctx := context.Background()
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()), WithDsContextIDIndex())
+	sender := &recordingSender{}
+
+	tenantA := testChainConfig(t)
+	tenantA.ContextIDNamespace = []byte("a")
+	tenantB := tenantA
+	tenantB.ContextIDNamespace = []byte("b")
+	for _, cfg := range []ChainConfig{tenantA, tenantB} {
+		_, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(2), id: []byte("1")})
+		require.NoError(t, err)
+	}
+
+	pub := NewPublisher(tenantA, backend, sender)
+	head, err := pub.RetractNamespace(ctx, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{head}, sender.heads())
+
+	idA, err := NamespacedContextID([]byte("a"), []byte("1"))
+	require.NoError(t, err)
+	idB, err := NamespacedContextID([]byte("b"), []byte("1"))
+	require.NoError(t, err)
+	info, err := backend.ContextIDState(ctx, idA)
+	require.NoError(t, err)
+	require.Equal(t, ContextIDRetracted, info.State)
+	info, err = backend.ContextIDState(ctx, idB)
+	require.NoError(t, err)
+	require.Equal(t, ContextIDLive, info.State)
+
+	// nothing left, nothing announced
+	head, err = pub.RetractNamespace(ctx, []byte("a"))
+	require.NoError(t, err)
+	require.Equal(t, cid.Undef, head)
+	require.Len(t, sender.heads(), 1)
+}
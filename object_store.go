@@ -0,0 +1,73 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+)
+
+var (
+	// ErrObjectNotFound is returned by an ObjectStore reading an object that doesn't exist.
+	ErrObjectNotFound = errors.New("object not found")
+	// ErrObjectModified is returned by a conditional write of an ObjectStore when the object is not at the expected
+	// version.
+	ErrObjectModified = errors.New("object modified")
+)
+
+// ObjectMeta is the metadata of an object of an ObjectStore, served as HTTP headers by the storage or its CDN.
+type ObjectMeta struct {
+	ContentType     string
+	CacheControl    string
+	ContentEncoding string
+	// Size is the size of the stored object, -1 if unknown. Ignored by Put.
+	Size int64
+	// Version is the opaque version of the stored object, for the conditional writes: the generation on Google Cloud
+	// Storage, the ETag on Azure Blob Storage. Ignored by Put.
+	Version string
+}
+
+// ObjectStore is a flat store of objects, typically the bucket of a cloud object storage, in which the
+// ObjectStoreBackend writes the chain pre-rendered as served to the indexers: one object per block, and the signed
+// head. The implementations retry nothing, which is done by the backend.
+type ObjectStore interface {
+	// Put writes an object, replacing the existing one if any.
+	Put(ctx context.Context, key string, data []byte, meta ObjectMeta) error
+
+	// PutIfVersion writes an object only if the stored one is at version, or if there is none when version is empty,
+	// and returns the new version. Returns ErrObjectModified otherwise.
+	PutIfVersion(ctx context.Context, key string, data []byte, meta ObjectMeta, version string) (string, error)
+
+	// Get returns the content of an object and its metadata. The caller must close the content.
+	// Returns ErrObjectNotFound if not found.
+	Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error)
+
+	// Head returns the metadata of an object.
+	// Returns ErrObjectNotFound if not found.
+	Head(ctx context.Context, key string) (ObjectMeta, error)
+}
+
+// ObjectLister is an optional interface of an ObjectStore, to list and delete its objects. It is required by the
+// BlockManager operations of the ObjectStoreBackend, such as the garbage collection.
+type ObjectLister interface {
+	// List calls fn for the key of every object starting with prefix, in no particular order.
+	// Iteration stops at the first error returned by fn.
+	List(ctx context.Context, prefix string, fn func(key string) error) error
+
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// blockContentType returns the Content-Type of a block of the chain.
+func blockContentType(c cid.Cid) (string, error) {
+	switch c.Prefix().Codec {
+	case cid.DagJSON:
+		return "application/json", nil
+	case cid.DagCBOR:
+		return "application/cbor", nil
+	default:
+		return "", fmt.Errorf("unknown block codec, cid %s, coded %v", c.String(), c.Prefix().Codec)
+	}
+}
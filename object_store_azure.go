@@ -0,0 +1,282 @@
+package herald
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// azureBlobAPIVersion is the version of the Blob service REST API used by the AzureBlobObjectStore.
+const azureBlobAPIVersion = "2021-08-06"
+
+var _ ObjectStore = &AzureBlobObjectStore{}
+var _ ObjectLister = &AzureBlobObjectStore{}
+
+// AzureBlobObjectStore is an ObjectStore of an Azure Blob Storage container, for the ObjectStoreBackend. The objects
+// are stored as block blobs. The requests are authenticated with a shared key, a SAS token or a Microsoft Entra ID
+// access token.
+type AzureBlobObjectStore struct {
+	httpObjectStore
+	containerURL *url.URL
+
+	account   string
+	sharedKey string // base64, as given by the portal
+	key       []byte
+	sasToken  url.Values
+	token     func(ctx context.Context) (string, error)
+}
+
+// AzureBlobOption is an optional configuration for the AzureBlobObjectStore.
+type AzureBlobOption func(*AzureBlobObjectStore)
+
+// WithAzureSharedKey authenticates the requests with an access key of the storage account, base64 encoded.
+func WithAzureSharedKey(account, key string) AzureBlobOption {
+	return func(s *AzureBlobObjectStore) {
+		s.account, s.sharedKey = account, key
+	}
+}
+
+// WithAzureSASToken authenticates the requests with a shared access signature, the query string granting access to
+// the container, with or without the leading "?".
+func WithAzureSASToken(token string) AzureBlobOption {
+	return func(s *AzureBlobObjectStore) {
+		s.sasToken, _ = url.ParseQuery(strings.TrimPrefix(token, "?"))
+	}
+}
+
+// WithAzureTokenSource authenticates the requests with the Microsoft Entra ID access tokens returned by token, for
+// the https://storage.azure.com/ resource.
+func WithAzureTokenSource(token func(ctx context.Context) (string, error)) AzureBlobOption {
+	return func(s *AzureBlobObjectStore) {
+		s.token = token
+	}
+}
+
+// WithAzureHTTPClient sets the HTTP client of the requests. Defaults to http.DefaultClient.
+func WithAzureHTTPClient(client *http.Client) AzureBlobOption {
+	return func(s *AzureBlobObjectStore) {
+		s.client = client
+	}
+}
+
+// NewAzureBlobObjectStore creates an AzureBlobObjectStore of the container at containerURL, for example
+// https://myaccount.blob.core.windows.net/mycontainer. Without credentials, the requests are anonymous.
+func NewAzureBlobObjectStore(containerURL string, opts ...AzureBlobOption) (*AzureBlobObjectStore, error) {
+	u, err := url.Parse(strings.TrimSuffix(containerURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid container URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" || u.Path == "" {
+		return nil, fmt.Errorf("invalid container URL %q: the scheme, host and container are required", containerURL)
+	}
+	s := &AzureBlobObjectStore{containerURL: u}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.sharedKey != "" {
+		if s.key, err = base64.StdEncoding.DecodeString(s.sharedKey); err != nil {
+			return nil, fmt.Errorf("invalid Azure shared key: %w", err)
+		}
+	}
+	s.authorize = s.authorizeRequest
+	return s, nil
+}
+
+func (s *AzureBlobObjectStore) blobURL(key string) string {
+	return s.containerURL.String() + "/" + escapeKey(key)
+}
+
+func (s *AzureBlobObjectStore) authorizeRequest(req *http.Request) error {
+	req.Header.Set("x-ms-version", azureBlobAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	switch {
+	case s.key != nil:
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+azureSignature(s.key, azureStringToSign(req, s.account)))
+	case s.sasToken != nil:
+		query := req.URL.Query()
+		for k, v := range s.sasToken {
+			query[k] = v
+		}
+		req.URL.RawQuery = query.Encode()
+	case s.token != nil:
+		token, err := s.token(req.Context())
+		if err != nil {
+			return fmt.Errorf("failed to get an Azure access token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// azureSignature returns the Shared Key signature of the string to sign, with the decoded account key.
+func azureSignature(key []byte, stringToSign string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// azureStringToSign returns the string signed by the Shared Key authorization of the Blob service.
+func azureStringToSign(req *http.Request, account string) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = fmt.Sprint(req.ContentLength)
+	}
+	var b strings.Builder
+	for _, v := range []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+
+	// canonicalized headers
+	var names []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := make([]string, 0, len(req.Header.Values(name)))
+		for _, v := range req.Header.Values(name) {
+			values = append(values, strings.TrimSpace(v))
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	// canonicalized resource
+	b.WriteString("/" + account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+	return b.String()
+}
+
+func (s *AzureBlobObjectStore) Put(ctx context.Context, key string, data []byte, meta ObjectMeta) error {
+	_, err := s.put(ctx, key, data, meta, http.Header{})
+	return err
+}
+
+// PutIfVersion writes the blob if its ETag is still version with the If-Match condition, or if it doesn't exist with
+// If-None-Match.
+func (s *AzureBlobObjectStore) PutIfVersion(ctx context.Context, key string, data []byte, meta ObjectMeta, version string) (string, error) {
+	header := http.Header{}
+	if version == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", version)
+	}
+	etag, err := s.put(ctx, key, data, meta, header)
+	var statusErr *objectStoreStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+		// BlobAlreadyExists, for If-None-Match
+		return "", ErrObjectModified
+	}
+	return etag, err
+}
+
+func (s *AzureBlobObjectStore) put(ctx context.Context, key string, data []byte, meta ObjectMeta, header http.Header) (string, error) {
+	header.Set("x-ms-blob-type", "BlockBlob")
+	setHeaderIf(header, "x-ms-blob-content-type", meta.ContentType)
+	setHeaderIf(header, "x-ms-blob-cache-control", meta.CacheControl)
+	setHeaderIf(header, "x-ms-blob-content-encoding", meta.ContentEncoding)
+	resp, err := s.do(ctx, http.MethodPut, s.blobURL(key), header, data, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), resp.Body.Close()
+}
+
+func (s *AzureBlobObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.blobURL(key), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, ObjectMeta{}, err
+	}
+	return resp.Body, objectMetaFromHeader(resp.Header, "ETag"), nil
+}
+
+func (s *AzureBlobObjectStore) Head(ctx context.Context, key string) (ObjectMeta, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.blobURL(key), nil, nil, http.StatusOK)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	_ = resp.Body.Close()
+	return objectMetaFromHeader(resp.Header, "ETag"), nil
+}
+
+// azureEnumerationResults is the response of the Blob service listing the blobs of a container.
+type azureEnumerationResults struct {
+	Blobs struct {
+		Blob []struct {
+			Name string
+		}
+	}
+	NextMarker string
+}
+
+func (s *AzureBlobObjectStore) List(ctx context.Context, prefix string, fn func(key string) error) error {
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.containerURL.String()+"?"+query.Encode(), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var result azureEnumerationResults
+		if err := decodeXML(resp, &result); err != nil {
+			return err
+		}
+		for _, blob := range result.Blobs.Blob {
+			if err := fn(blob.Name); err != nil {
+				return err
+			}
+		}
+		if result.NextMarker == "" {
+			return nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (s *AzureBlobObjectStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.blobURL(key), nil, nil, http.StatusAccepted, http.StatusOK)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
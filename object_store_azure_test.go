@@ -0,0 +1,244 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAzureBlob is a minimal in-memory Blob service, serving the container "container" of the account "account". The
+// requests are authorized with the shared key, or the SAS token "sig=secret".
+type fakeAzureBlob struct {
+	t     *testing.T
+	mu    sync.Mutex
+	blobs map[string][]byte
+	meta  map[string]http.Header
+	etags int // last ETag written
+	key   []byte
+	url   string
+}
+
+func newFakeAzureBlob(t *testing.T, key []byte) *fakeAzureBlob {
+	f := &fakeAzureBlob{t: t, blobs: make(map[string][]byte), meta: make(map[string]http.Header), key: key}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	return f
+}
+
+func (f *fakeAzureBlob) authorized(r *http.Request) bool {
+	if r.Header.Get("x-ms-version") != azureBlobAPIVersion || r.Header.Get("x-ms-date") == "" {
+		return false
+	}
+	if r.URL.Query().Get("sig") == "secret" {
+		return true
+	}
+	return r.Header.Get("Authorization") == "SharedKey account:"+azureSignature(f.key, azureStringToSign(r, "account"))
+}
+
+func (f *fakeAzureBlob) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.authorized(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == "/container" {
+		require.Equal(f.t, "container", r.URL.Query().Get("restype"))
+		require.Equal(f.t, "list", r.URL.Query().Get("comp"))
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("marker"))
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/container/")
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, exists := f.blobs[key]
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != f.meta[key].Get("ETag")) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.etags++
+		etag := fmt.Sprintf("\"0x%d\"", f.etags)
+		f.blobs[key] = data
+		f.meta[key] = http.Header{
+			"Content-Type":     {r.Header.Get("x-ms-blob-content-type")},
+			"Cache-Control":    {r.Header.Get("x-ms-blob-cache-control")},
+			"Content-Encoding": {r.Header.Get("x-ms-blob-content-encoding")},
+			"Etag":             {etag},
+		}
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.blobs[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for k, v := range f.meta[key] {
+			if v[0] != "" {
+				w.Header()[k] = v
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	case http.MethodDelete:
+		if _, ok := f.blobs[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, key)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// list serves pages of 2 blobs, with the last name as marker.
+func (f *fakeAzureBlob) list(w http.ResponseWriter, prefix, marker string) {
+	var names []string
+	for name := range f.blobs {
+		if strings.HasPrefix(name, prefix) && name > marker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var result azureEnumerationResults
+	if len(names) > 2 {
+		names = names[:2]
+		result.NextMarker = names[1]
+	}
+	for _, name := range names {
+		result.Blobs.Blob = append(result.Blobs.Blob, struct{ Name string }{name})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"EnumerationResults"`
+		azureEnumerationResults
+	}{azureEnumerationResults: result})
+}
+
+func TestAzureBlobObjectStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	fake := newFakeAzureBlob(t, key)
+	store, err := NewAzureBlobObjectStore(fake.url+"/container/", WithAzureSharedKey("account", base64.StdEncoding.EncodeToString(key)))
+	require.NoError(t, err)
+
+	backend := NewObjectStoreBackend(store, "/indexer/ingest/mainnet", cfg.PublisherKey, WithObjectStorePrefix("chain/"))
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	second, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(8)[5:]...))
+	require.NoError(t, err)
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, second, head)
+
+	meta, err := store.Head(ctx, "chain/"+first.String())
+	require.NoError(t, err)
+	require.Equal(t, "application/json", meta.ContentType)
+	require.Equal(t, DefaultS3BlockCacheControl, meta.CacheControl)
+
+	// the head is replaced only at the ETag it was read at
+	_, err = store.PutIfVersion(ctx, "chain/head", []byte("stale"), ObjectMeta{}, meta.Version)
+	require.ErrorIs(t, err, ErrObjectModified)
+	_, err = store.PutIfVersion(ctx, "chain/head", []byte("exists"), ObjectMeta{}, "")
+	require.ErrorIs(t, err, ErrObjectModified)
+
+	// listed in pages with the SAS token: 7 blocks and the head
+	sas, err := NewAzureBlobObjectStore(fake.url+"/container", WithAzureSASToken("?sv=2021-08-06&sig=secret"))
+	require.NoError(t, err)
+	var keys []string
+	require.NoError(t, sas.List(ctx, "chain/", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	require.Len(t, keys, 8)
+	require.Contains(t, keys, "chain/head")
+
+	require.NoError(t, backend.DeleteBlock(ctx, first))
+	require.NoError(t, sas.Delete(ctx, "chain/"+first.String()))
+	_, _, err = sas.Get(ctx, "chain/"+first.String())
+	require.ErrorIs(t, err, ErrObjectNotFound)
+
+	// the SAS token is not leaked by the errors
+	anonymous, err := NewAzureBlobObjectStore(fake.url + "/container")
+	require.NoError(t, err)
+	err = anonymous.Put(ctx, "foo", []byte("bar"), ObjectMeta{})
+	var statusErr *objectStoreStatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+	invalid, err := NewAzureBlobObjectStore(fake.url+"/container", WithAzureSASToken("sig=invalid"))
+	require.NoError(t, err)
+	_, err = invalid.Head(ctx, "foo")
+	require.ErrorAs(t, err, &statusErr)
+	require.NotContains(t, err.Error(), "invalid")
+}
+
+func TestAzureStringToSign(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "https://account.blob.core.windows.net/container/chain/head?comp=list&restype=container", strings.NewReader("foo"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-ms-version", azureBlobAPIVersion)
+	req.Header.Set("x-ms-date", "Sat, 17 Oct 2026 10:00:00 GMT")
+	req.Header.Set("X-Ms-Blob-Type", " BlockBlob ")
+
+	require.Equal(t, "PUT\n\n\n3\n\napplication/json\n\n\n\n\n\n\n"+
+		"x-ms-blob-type:BlockBlob\n"+
+		"x-ms-date:Sat, 17 Oct 2026 10:00:00 GMT\n"+
+		"x-ms-version:2021-08-06\n"+
+		"/account/container/chain/head\ncomp:list\nrestype:container", azureStringToSign(req, "account"))
+}
+
+// TestAzureSharedKeyVectors checks the signer against the examples of the Shared Key documentation of Azure Storage.
+func TestAzureSharedKeyVectors(t *testing.T) {
+	// the example string to sign, of a Get Container Metadata request
+	req, err := http.NewRequest(http.MethodGet, "https://myaccount.blob.core.windows.net/myaccount/mycontainer?restype=container&comp=metadata&timeout=20", nil)
+	require.NoError(t, err)
+	req.Header.Set("x-ms-date", "Sun, 11 Oct 2009 21:49:13 GMT")
+	req.Header.Set("x-ms-version", "2009-09-19")
+	stringToSign := azureStringToSign(req, "myaccount")
+	require.Equal(t, "GET\n\n\n\n\n\n\n\n\n\n\n\n"+
+		"x-ms-date:Sun, 11 Oct 2009 21:49:13 GMT\nx-ms-version:2009-09-19\n"+
+		"/myaccount/myaccount/mycontainer\ncomp:metadata\nrestype:container\ntimeout:20", stringToSign)
+
+	// its signature with the well-known key of the Azurite emulator, computed with the hmac module of Python
+	key, err := base64.StdEncoding.DecodeString("Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==")
+	require.NoError(t, err)
+	require.Equal(t, "Su5rvibNeMxB7A4I87rOtEgCDtdICsL8v5H+yJs0W+M=", azureSignature(key, stringToSign))
+
+	// the example canonicalized resource with a repeated parameter, whose values are sorted and joined
+	req, err = http.NewRequest(http.MethodGet, "https://myaccount.blob.core.windows.net/mycontainer?restype=container&comp=list&include=snapshots&include=metadata&include=uncommittedblobs", nil)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(azureStringToSign(req, "myaccount"),
+		"\n/myaccount/mycontainer\ncomp:list\ninclude:metadata,snapshots,uncommittedblobs\nrestype:container"))
+}
+
+func TestNewAzureBlobObjectStoreErrors(t *testing.T) {
+	_, err := NewAzureBlobObjectStore("account.blob.core.windows.net/container")
+	require.Error(t, err)
+	_, err = NewAzureBlobObjectStore("https://account.blob.core.windows.net")
+	require.Error(t, err)
+	_, err = NewAzureBlobObjectStore("https://account.blob.core.windows.net/container", WithAzureSharedKey("account", "not base64!"))
+	require.Error(t, err)
+}
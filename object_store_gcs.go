@@ -0,0 +1,216 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultGCSEndpoint is the endpoint of the XML API of Google Cloud Storage.
+const DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+var _ ObjectStore = &GCSObjectStore{}
+var _ ObjectLister = &GCSObjectStore{}
+
+// GCSObjectStore is an ObjectStore of a Google Cloud Storage bucket, for the ObjectStoreBackend. It uses the XML API
+// with an OAuth2 access token, see WithGCSTokenSource.
+type GCSObjectStore struct {
+	httpObjectStore
+	endpoint string
+	bucket   string
+	token    func(ctx context.Context) (string, error)
+}
+
+// GCSOption is an optional configuration for the GCSObjectStore.
+type GCSOption func(*GCSObjectStore)
+
+// WithGCSEndpoint sets the endpoint of the XML API, for example of an emulator. Defaults to DefaultGCSEndpoint.
+func WithGCSEndpoint(endpoint string) GCSOption {
+	return func(s *GCSObjectStore) {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	}
+}
+
+// WithGCSHTTPClient sets the HTTP client of the requests. Defaults to http.DefaultClient.
+func WithGCSHTTPClient(client *http.Client) GCSOption {
+	return func(s *GCSObjectStore) {
+		s.client = client
+	}
+}
+
+// WithGCSTokenSource authenticates the requests with the OAuth2 access tokens returned by token, for example
+// GCEMetadataTokenSource or the token source of golang.org/x/oauth2/google. Without it, the requests are anonymous.
+func WithGCSTokenSource(token func(ctx context.Context) (string, error)) GCSOption {
+	return func(s *GCSObjectStore) {
+		s.token = token
+	}
+}
+
+// NewGCSObjectStore creates a GCSObjectStore of the bucket.
+func NewGCSObjectStore(bucket string, opts ...GCSOption) *GCSObjectStore {
+	s := &GCSObjectStore{endpoint: DefaultGCSEndpoint, bucket: bucket}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.authorize = s.authorizeRequest
+	return s
+}
+
+func (s *GCSObjectStore) authorizeRequest(req *http.Request) error {
+	if s.token == nil {
+		return nil
+	}
+	token, err := s.token(req.Context())
+	if err != nil {
+		return fmt.Errorf("failed to get a GCS access token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (s *GCSObjectStore) objectURL(key string) string {
+	return s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + escapeKey(key)
+}
+
+// gcsGenerationHeader is the header of the generation of an object, its version.
+const gcsGenerationHeader = "x-goog-generation"
+
+func (s *GCSObjectStore) Put(ctx context.Context, key string, data []byte, meta ObjectMeta) error {
+	_, err := s.put(ctx, key, data, meta, http.Header{})
+	return err
+}
+
+// PutIfVersion writes the object if its generation is still version, with the x-goog-if-generation-match
+// precondition.
+func (s *GCSObjectStore) PutIfVersion(ctx context.Context, key string, data []byte, meta ObjectMeta, version string) (string, error) {
+	if version == "" {
+		// the generation 0 matches only a missing object
+		version = "0"
+	}
+	return s.put(ctx, key, data, meta, http.Header{"X-Goog-If-Generation-Match": {version}})
+}
+
+func (s *GCSObjectStore) put(ctx context.Context, key string, data []byte, meta ObjectMeta, header http.Header) (string, error) {
+	setHeaderIf(header, "Content-Type", meta.ContentType)
+	setHeaderIf(header, "Cache-Control", meta.CacheControl)
+	setHeaderIf(header, "Content-Encoding", meta.ContentEncoding)
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), header, data, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return "", err
+	}
+	return resp.Header.Get(gcsGenerationHeader), resp.Body.Close()
+}
+
+func (s *GCSObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, ObjectMeta, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, ObjectMeta{}, err
+	}
+	return resp.Body, objectMetaFromHeader(resp.Header, gcsGenerationHeader), nil
+}
+
+func (s *GCSObjectStore) Head(ctx context.Context, key string) (ObjectMeta, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key), nil, nil, http.StatusOK)
+	if err != nil {
+		return ObjectMeta{}, err
+	}
+	_ = resp.Body.Close()
+	return objectMetaFromHeader(resp.Header, gcsGenerationHeader), nil
+}
+
+// gcsListBucketResult is the response of the XML API listing the objects of a bucket.
+type gcsListBucketResult struct {
+	IsTruncated bool
+	NextMarker  string
+	Contents    []struct {
+		Key string
+	}
+}
+
+func (s *GCSObjectStore) List(ctx context.Context, prefix string, fn func(key string) error) error {
+	marker := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.endpoint+"/"+url.PathEscape(s.bucket)+"?"+query.Encode(), nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		var result gcsListBucketResult
+		if err := decodeXML(resp, &result); err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			if err := fn(obj.Key); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return nil
+		}
+		// NextMarker is only returned with a delimiter: the listing resumes after the last key otherwise
+		marker = result.NextMarker
+		if marker == "" {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+	}
+}
+
+func (s *GCSObjectStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil, nil, http.StatusOK, http.StatusNoContent)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// gceMetadataTokenURL is the endpoint of the metadata server returning the token of the default service account.
+const gceMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCEMetadataTokenSource returns a token source for WithGCSTokenSource, fetching the access tokens of the default
+// service account from the metadata server of Google Compute Engine, GKE or Cloud Run. The tokens are cached until
+// they expire.
+func GCEMetadataTokenSource() func(ctx context.Context) (string, error) {
+	return gceMetadataTokenSource(http.DefaultClient, gceMetadataTokenURL)
+}
+
+func gceMetadataTokenSource(client *http.Client, tokenURL string) func(ctx context.Context) (string, error) {
+	cache := &cachedToken{fetch: func(ctx context.Context) (string, time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", 0, fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+		}
+		var token struct {
+			AccessToken string `json:"access_token"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+			return "", 0, err
+		}
+		if token.AccessToken == "" {
+			return "", 0, errors.New("metadata server returned no access token")
+		}
+		return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+	}}
+	return cache.get
+}
@@ -0,0 +1,202 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGCS is a minimal in-memory Google Cloud Storage XML API, serving a single bucket. The reads are public, the
+// writes and the listing require the token.
+type fakeGCS struct {
+	mu          sync.Mutex
+	objects     map[string][]byte
+	headers     map[string]http.Header
+	generations map[string]int64
+	generation  int64 // last generation written
+	token       string
+	url         string
+}
+
+func newFakeGCS(t *testing.T, token string) *fakeGCS {
+	f := &fakeGCS{objects: make(map[string][]byte), headers: make(map[string]http.Header), generations: make(map[string]int64), token: token}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	f.url = server.URL
+	return f
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.URL.Path, "/bucket") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	if r.Method != http.MethodGet && r.Method != http.MethodHead || r.URL.Path == "/bucket" {
+		if r.Header.Get("Authorization") != "Bearer "+f.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+	switch {
+	case r.URL.Path == "/bucket":
+		f.list(w, r.URL.Query().Get("prefix"), r.URL.Query().Get("marker"))
+	case r.Method == http.MethodPut:
+		if match := r.Header.Get("x-goog-if-generation-match"); match != "" && match != strconv.FormatInt(f.generations[key], 10) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.generation++
+		f.objects[key] = data
+		f.headers[key] = r.Header.Clone()
+		f.generations[key] = f.generation
+		w.Header().Set("x-goog-generation", strconv.FormatInt(f.generation, 10))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for _, h := range []string{"Content-Encoding", "Content-Type", "Cache-Control"} {
+			if v := f.headers[key].Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		w.Header().Set("x-goog-generation", strconv.FormatInt(f.generations[key], 10))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		if _, ok := f.objects[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.objects, key)
+		delete(f.generations, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// list serves pages of 2 keys. As GCS without a delimiter, the truncated pages have no NextMarker.
+func (f *fakeGCS) list(w http.ResponseWriter, prefix, marker string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var result gcsListBucketResult
+	if len(keys) > 2 {
+		keys = keys[:2]
+		result.IsTruncated = true
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct{ Key string }{key})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		gcsListBucketResult
+	}{gcsListBucketResult: result})
+}
+
+func (f *fakeGCS) object(key string) ([]byte, http.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[key], f.headers[key]
+}
+
+func TestGCSObjectStore(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	fake := newFakeGCS(t, "secret")
+	token := func(context.Context) (string, error) { return "secret", nil }
+	store := NewGCSObjectStore("bucket", WithGCSEndpoint(fake.url+"/"), WithGCSTokenSource(token))
+
+	backend := NewObjectStoreBackend(store, "/indexer/ingest/mainnet", cfg.PublisherKey)
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	second, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(8)[5:], id: []byte("foo")})
+	require.NoError(t, err)
+
+	data, headers := fake.object(DefaultS3Prefix + first.String())
+	require.NotEmpty(t, data)
+	require.Equal(t, "application/json", headers.Get("Content-Type"))
+	require.Equal(t, DefaultS3BlockCacheControl, headers.Get("Cache-Control"))
+
+	// the public bucket serves the chain to the indexers
+	reader := NewHttpChainReader(fake.url+"/bucket/"+strings.TrimSuffix(DefaultS3Prefix, "/"), cfg.PublisherID)
+	remote, err := reader.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, second, remote)
+
+	// listed in pages: 3 + 2 entry chunks and 2 advertisements, without the head
+	var blocks []cid.Cid
+	require.NoError(t, backend.ForEachBlock(ctx, func(c cid.Cid) error {
+		blocks = append(blocks, c)
+		return nil
+	}))
+	require.Len(t, blocks, 7)
+	require.Contains(t, blocks, first)
+
+	require.NoError(t, backend.DeleteBlock(ctx, first))
+	require.NoError(t, backend.DeleteBlock(ctx, first))
+	_, err = store.Head(ctx, DefaultS3Prefix+first.String())
+	require.ErrorIs(t, err, ErrObjectNotFound)
+
+	// the head is replaced only at the generation it was read at
+	_, err = store.PutIfVersion(ctx, DefaultS3Prefix+"head", []byte("stale"), ObjectMeta{}, "1")
+	require.ErrorIs(t, err, ErrObjectModified)
+	_, err = store.PutIfVersion(ctx, DefaultS3Prefix+"head", []byte("exists"), ObjectMeta{}, "")
+	require.ErrorIs(t, err, ErrObjectModified)
+	version, err := store.PutIfVersion(ctx, "new", []byte("foo"), ObjectMeta{}, "")
+	require.NoError(t, err)
+	meta, err := store.Head(ctx, "new")
+	require.NoError(t, err)
+	require.Equal(t, version, meta.Version)
+
+	// the anonymous writes are rejected
+	anonymous := NewGCSObjectStore("bucket", WithGCSEndpoint(fake.url))
+	err = anonymous.Put(ctx, "foo", []byte("bar"), ObjectMeta{})
+	var statusErr *objectStoreStatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+}
+
+func TestGCEMetadataTokenSource(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		requests++
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3599,"token_type":"Bearer"}`, requests)
+	}))
+	defer server.Close()
+
+	token := gceMetadataTokenSource(server.Client(), server.URL)
+	for i := 0; i < 3; i++ {
+		got, err := token(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, "token-1", got)
+	}
+	require.Equal(t, 1, requests)
+}
@@ -0,0 +1,148 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpObjectStore is the REST client shared by the ObjectStore implementations of the cloud storages without SDK.
+type httpObjectStore struct {
+	client *http.Client
+	// authorize adds the credentials to a request, or does nothing if nil
+	authorize func(req *http.Request) error
+}
+
+// objectStoreStatusError is the unexpected response of an object storage.
+type objectStoreStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *objectStoreStatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// do sends a request, with body if not nil, and returns the response if its status is expected. A 404 Not Found is
+// returned as ErrObjectNotFound, and a 412 Precondition Failed as ErrObjectModified.
+func (s *httpObjectStore) do(ctx context.Context, method, rawURL string, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// asking explicitly for the stored encoding keeps the client from decompressing the gzipped objects
+	req.Header.Set("Accept-Encoding", "gzip")
+	if s.authorize != nil {
+		if err := s.authorize(req); err != nil {
+			return nil, err
+		}
+	}
+
+	client := s.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrObjectNotFound
+	case http.StatusPreconditionFailed:
+		return nil, ErrObjectModified
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, &objectStoreStatusError{Method: method, URL: redactQuery(req.URL), StatusCode: resp.StatusCode, Body: string(msg)}
+}
+
+// redactQuery returns the URL without its query, which may hold a SAS token.
+func redactQuery(u *url.URL) string {
+	redacted := *u
+	redacted.RawQuery = ""
+	return redacted.String()
+}
+
+// objectMetaFromHeader returns the ObjectMeta of the headers of a response, the version being in versionHeader.
+func objectMetaFromHeader(header http.Header, versionHeader string) ObjectMeta {
+	meta := ObjectMeta{
+		ContentType:     header.Get("Content-Type"),
+		CacheControl:    header.Get("Cache-Control"),
+		ContentEncoding: header.Get("Content-Encoding"),
+		Size:            -1,
+		Version:         header.Get(versionHeader),
+	}
+	if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+		meta.Size = size
+	}
+	return meta
+}
+
+// setHeaderIf sets a header if the value is not empty.
+func setHeaderIf(header http.Header, key, value string) {
+	if value != "" {
+		header.Set(key, value)
+	}
+}
+
+// decodeXML decodes the XML body of a response, and closes it.
+func decodeXML(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// escapeKey escapes an object key for a URL path, keeping the slashes.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// cachedToken caches an OAuth2 access token until shortly before it expires.
+type cachedToken struct {
+	fetch func(ctx context.Context) (token string, expiresIn time.Duration, err error)
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (c *cachedToken) get(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	token, expiresIn, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	// renewed one minute ahead, to not send an expiring token
+	c.token, c.expires = token, time.Now().Add(expiresIn-time.Minute)
+	return token, nil
+}
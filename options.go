@@ -1,15 +1,25 @@
 package herald
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/announce"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multicodec"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 type (
@@ -22,8 +32,33 @@ type (
 		providerAddrs           []string
 		localPublisherDir       string
 		adEntriesChunkSize      int
+		entriesChunkBytes       int
+		entriesFormat           EntriesFormat
 		ds                      datastore.Datastore
 		metadata                []byte
+		retryPolicy             RetryPolicy
+		resolveProviderAddrs    bool
+		duplicateContextIDs     DuplicateContextIDPolicy
+		deduplicateAds          bool
+		codec                   multicodec.Code
+		progress                func(ProgressEvent)
+
+		// assembly of the components
+		backend              func(o *options) (ChainWriter, error)
+		httpPublisher        bool
+		httpPublisherOpts    []HttpPublisherOption
+		publisherStatus      bool
+		libp2pHost           host.Host
+		readCacheBytes       int
+		publisherHttpAddrs   []multiaddr.Multiaddr
+		publisherLibp2pAddrs []multiaddr.Multiaddr
+		announcer            announce.Sender
+		heartbeatInterval    time.Duration
+		batchConfig          BatchConfig
+		metrics              *Metrics
+		metricsGatherer      prometheus.Gatherer
+		tracerProvider       trace.TracerProvider
+		logger               *zap.SugaredLogger
 	}
 )
 
@@ -33,6 +68,12 @@ func newOptions(o ...Option) (*options, error) {
 		topic:                   "/indexer/ingest/mainnet",
 		providerAddrs:           nil,
 		adEntriesChunkSize:      16 << 10,
+		retryPolicy:             DefaultRetryPolicy,
+		batchConfig: BatchConfig{
+			CountThreshold:         1000,
+			MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+			MaxDelay:               DefaultMaxDelay,
+		},
 	}
 	for _, apply := range o {
 		if err := apply(&opts); err != nil {
@@ -42,11 +83,18 @@ func newOptions(o ...Option) (*options, error) {
 	if opts.metadata == nil {
 		return nil, errors.New("Metadata must be set")
 	}
-	if opts.providerAddrs == nil {
-		return nil, errors.New("at least one provider address must be set")
+	if err := ValidateProviderAddrs(opts.providerAddrs); err != nil {
+		return nil, err
+	}
+	if opts.resolveProviderAddrs {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := ResolveProviderAddrs(ctx, opts.providerAddrs, nil, WithOperationLogger(opts.logger)); err != nil {
+			return nil, err
+		}
 	}
 	if opts.identity == nil {
-		logger.Warnw("no identity is specified; generating one at random...")
+		orDefaultLogger(opts.logger).Warnw("no identity is specified; generating one at random...")
 		var err error
 		opts.identity, _, err = crypto.GenerateEd25519Key(rand.Reader)
 		if err != nil {
@@ -56,12 +104,17 @@ func newOptions(o ...Option) (*options, error) {
 		if err != nil {
 			return nil, err
 		}
-		logger.Infow("using randomly generated identity", "peerID", opts.id)
+		orDefaultLogger(opts.logger).Infow("using randomly generated identity", "peerID", opts.id)
 	}
-	if opts.ds == nil {
-		logger.Warnw("using in-memory datastore")
+	if opts.ds == nil && opts.backend == nil {
+		orDefaultLogger(opts.logger).Warnw("using in-memory datastore")
 		opts.ds = sync.MutexWrap(datastore.NewMapDatastore())
 	}
+	if opts.backend == nil {
+		opts.backend = func(o *options) (ChainWriter, error) {
+			return NewDatastoreBackend(o.ds, WithDsMetrics(o.metrics), WithDsLogger(o.logger)), nil
+		}
+	}
 	return &opts, nil
 }
 
@@ -90,6 +143,28 @@ func WithIdentity(v crypto.PrivKey) Option {
 	}
 }
 
+// WithProgress reports the progress of the publications and retractions of the catalogs to fn, after every entry
+// chunk stored. fn must not block.
+func WithProgress(fn func(ProgressEvent)) Option {
+	return func(o *options) error {
+		o.progress = fn
+		return nil
+	}
+}
+
+// WithSigner sets the identity of the publisher from a Signer, for example backed by a KMS, in place of WithIdentity.
+// The advertisements and the heads are then signed by signer.
+func WithSigner(signer Signer) Option {
+	return func(o *options) error {
+		var err error
+		if o.id, err = peer.IDFromPublicKey(signer.PublicKey()); err != nil {
+			return err
+		}
+		o.identity = signerPrivKey(signer)
+		return nil
+	}
+}
+
 func WithProviderAddress(a ...multiaddr.Multiaddr) Option {
 	return func(o *options) error {
 		o.providerAddrs = make([]string, 0, len(a))
@@ -100,6 +175,14 @@ func WithProviderAddress(a ...multiaddr.Multiaddr) Option {
 	}
 }
 
+// WithProviderAddressResolution makes the construction verify that the DNS based provider addresses resolve.
+func WithProviderAddressResolution() Option {
+	return func(o *options) error {
+		o.resolveProviderAddrs = true
+		return nil
+	}
+}
+
 func WithLocalPublisherDir(v string) Option {
 	return func(o *options) error {
 		o.localPublisherDir = v
@@ -109,11 +192,61 @@ func WithLocalPublisherDir(v string) Option {
 
 func WithAdEntriesChunkSize(v int) Option {
 	return func(o *options) error {
+		if v < 0 {
+			return errors.New("AdEntriesChunkSize must not be negative")
+		}
 		o.adEntriesChunkSize = v
 		return nil
 	}
 }
 
+// WithEntriesFormat sets the data structure holding the multihashes of the advertisements, see
+// ChainConfig.EntriesFormat.
+func WithEntriesFormat(v EntriesFormat) Option {
+	return func(o *options) error {
+		o.entriesFormat = v
+		return nil
+	}
+}
+
+// WithEntriesChunkBytes sets the maximum encoded size of an entry chunk, see ChainConfig.EntriesChunkBytes.
+func WithEntriesChunkBytes(v int) Option {
+	return func(o *options) error {
+		if v < 0 || v > MaxEntriesChunkBytes {
+			return fmt.Errorf("EntriesChunkBytes must be between 0 and %d", MaxEntriesChunkBytes)
+		}
+		o.entriesChunkBytes = v
+		return nil
+	}
+}
+
+// WithDuplicateContextIDs sets what to do when publishing a ContextID already live with the same metadata.
+// It requires a backend maintaining a ContextIDIndex, for example WithDatastoreBackend(ds, WithDsContextIDIndex()).
+func WithDuplicateContextIDs(v DuplicateContextIDPolicy) Option {
+	return func(o *options) error {
+		o.duplicateContextIDs = v
+		return nil
+	}
+}
+
+// WithCodec sets the IPLD codec of the blocks of the chain, multicodec.DagJson or multicodec.DagCbor.
+// Defaults to multicodec.DagJson, the only codec the libp2p publisher can serve.
+func WithCodec(v multicodec.Code) Option {
+	return func(o *options) error {
+		o.codec = v
+		return nil
+	}
+}
+
+// WithAdDeduplication returns the advertisements already on the chain when publishing identical ones, for example
+// on the replay of a message. It requires a backend maintaining a ContextIDIndex.
+func WithAdDeduplication() Option {
+	return func(o *options) error {
+		o.deduplicateAds = true
+		return nil
+	}
+}
+
 func WithDatastore(v datastore.Datastore) Option {
 	return func(o *options) error {
 		o.ds = v
@@ -128,3 +261,204 @@ func WithMetadata(v metadata.Metadata) Option {
 		return err
 	}
 }
+
+// WithRetryPolicy sets the RetryPolicy used by all the components: backend, batcher and announcers.
+func WithRetryPolicy(v RetryPolicy) Option {
+	return func(o *options) error {
+		o.retryPolicy = v
+		return nil
+	}
+}
+
+// WithDatastoreBackend stores the IPNI chain in the given datastore.
+func WithDatastoreBackend(ds datastore.Datastore, opts ...DsBackendOption) Option {
+	return func(o *options) error {
+		o.ds = ds
+		o.backend = func(o *options) (ChainWriter, error) {
+			dsOpts := append([]DsBackendOption{WithDsMetrics(o.metrics), WithDsLogger(o.logger)}, opts...)
+			return NewDatastoreBackend(o.ds, dsOpts...), nil
+		}
+		return nil
+	}
+}
+
+// WithMemoryBackend keeps the IPNI chain in memory, for tests and ephemeral use.
+func WithMemoryBackend() Option {
+	return func(o *options) error {
+		o.backend = func(o *options) (ChainWriter, error) {
+			return NewMemoryBackend(), nil
+		}
+		return nil
+	}
+}
+
+// WithS3Backend stores the IPNI chain in an S3 bucket, from which it can be served directly to the indexers.
+func WithS3Backend(awsConfig aws.Config, bucket string, opts ...S3BackendOption) Option {
+	return func(o *options) error {
+		if bucket == "" {
+			return errors.New("S3 bucket must be set")
+		}
+		o.backend = func(o *options) (ChainWriter, error) {
+			s3Opts := append([]S3BackendOption{WithS3RetryPolicy(o.retryPolicy), WithS3Metrics(o.metrics), WithS3Logger(o.logger)}, opts...)
+			return NewS3Backend(awsConfig, bucket, o.topic, o.identity, s3Opts...), nil
+		}
+		return nil
+	}
+}
+
+// WithObjectStoreBackend stores the IPNI chain in an ObjectStore, such as a Google Cloud Storage bucket or an Azure
+// Blob container, from which it can be served directly to the indexers.
+func WithObjectStoreBackend(store ObjectStore, opts ...ObjectStoreBackendOption) Option {
+	return func(o *options) error {
+		if store == nil {
+			return errors.New("object store must be set")
+		}
+		o.backend = func(o *options) (ChainWriter, error) {
+			storeOpts := append([]ObjectStoreBackendOption{
+				WithObjectStoreRetryPolicy(o.retryPolicy), WithObjectStoreMetrics(o.metrics), WithObjectStoreLogger(o.logger),
+			}, opts...)
+			return NewObjectStoreBackend(store, o.topic, o.identity, storeOpts...), nil
+		}
+		return nil
+	}
+}
+
+// WithHTTPPublisher serves the IPNI chain over HTTP, listening on listenAddr.
+// The backend must also implement ChainReader.
+func WithHTTPPublisher(listenAddr string, opts ...HttpPublisherOption) Option {
+	return func(o *options) error {
+		o.httpPublisher = true
+		o.httpPublisherListenAddr = listenAddr
+		o.httpPublisherOpts = opts
+		return nil
+	}
+}
+
+// WithPublisherStatus serves the PublisherStatus on /status of the HTTP publisher, with the time of the last
+// successful announcement. It's off by default, as it exposes the state of the publisher and counts the chain.
+func WithPublisherStatus() Option {
+	return func(o *options) error {
+		o.publisherStatus = true
+		return nil
+	}
+}
+
+// WithReadCache caches up to maxBytes of blocks in memory, in front of the backend, for the publishers serving the
+// chain. See CachingReader.
+func WithReadCache(maxBytes int) Option {
+	return func(o *options) error {
+		o.readCacheBytes = maxBytes
+		return nil
+	}
+}
+
+// WithLibp2pPublisher serves the IPNI chain over libp2p streams on the given host, for the indexers syncing over
+// libp2p. It can be used alongside WithHTTPPublisher. The host identity must match the publisher identity.
+// The backend must also implement ChainReader.
+func WithLibp2pPublisher(h host.Host) Option {
+	return func(o *options) error {
+		if h == nil {
+			return errors.New("libp2p host must be set")
+		}
+		o.libp2pHost = h
+		return nil
+	}
+}
+
+// WithPublisherAddress sets the addresses from which the indexers can fetch the IPNI chain, as announced: HTTP
+// multiaddrs, with http or https, and libp2p multiaddrs of the publisher host. If unset, they are derived from the
+// HTTP publisher listener and the libp2p publisher host, if any, when the Herald starts.
+func WithPublisherAddress(a ...multiaddr.Multiaddr) Option {
+	return func(o *options) error {
+		o.publisherHttpAddrs, o.publisherLibp2pAddrs = nil, nil
+		for _, addr := range a {
+			if addr == nil {
+				return errors.New("nil publisher address")
+			}
+			if isHttpAddr(addr) {
+				o.publisherHttpAddrs = append(o.publisherHttpAddrs, addr)
+			} else {
+				o.publisherLibp2pAddrs = append(o.publisherLibp2pAddrs, addr)
+			}
+		}
+		return nil
+	}
+}
+
+// WithAnnouncer sets the sender of the announcements of new heads, for example a httpsender.Sender.
+// Without announcer, the indexers only discover the new advertisements when polling the publisher.
+func WithAnnouncer(v announce.Sender) Option {
+	return func(o *options) error {
+		o.announcer = v
+		return nil
+	}
+}
+
+// WithHeartbeat re-announces the current head when no announcement was sent for the interval, in case the indexers
+// missed the previous ones. The interval is randomized by 10% to spread the re-announcements of many publishers. It
+// requires an announcer and a backend that can be read.
+func WithHeartbeat(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return errors.New("heartbeat interval must be positive")
+		}
+		o.heartbeatInterval = interval
+		return nil
+	}
+}
+
+// WithBatching configures the batching of publishes and retracts.
+func WithBatching(v BatchConfig) Option {
+	return func(o *options) error {
+		if v.MaxMHsPerAdvertisement <= 0 {
+			return errors.New("MaxMHsPerAdvertisement must be positive")
+		}
+		if v.MaxDelay <= 0 {
+			return errors.New("MaxDelay must be positive")
+		}
+		if v.QueueCapacity < 0 || v.OverflowTimeout < 0 {
+			return errors.New("QueueCapacity and OverflowTimeout must not be negative")
+		}
+		if v.OverflowPolicy == OverflowSpill && v.Queue == nil {
+			return errors.New("OverflowSpill requires a Queue")
+		}
+		o.batchConfig = v
+		return nil
+	}
+}
+
+// WithMetrics records the metrics of all the components: chain, backend, batcher and HTTP publisher.
+// The Metrics are created with NewMetrics, on the caller's registry.
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) error {
+		o.metrics = m
+		return nil
+	}
+}
+
+// WithMetricsEndpoint exposes the metrics of the gatherer, typically the registry given to NewMetrics, on the
+// /metrics path of the HTTP publisher.
+func WithMetricsEndpoint(g prometheus.Gatherer) Option {
+	return func(o *options) error {
+		o.metricsGatherer = g
+		return nil
+	}
+}
+
+// WithLogger logs with the given logger instead of the default one, for example to set its level or output, or to
+// add per-tenant fields. It is used by all the components: chain, backend, batcher and HTTP publisher.
+func WithLogger(l *zap.SugaredLogger) Option {
+	return func(o *options) error {
+		o.logger = l
+		return nil
+	}
+}
+
+// WithTracerProvider records the spans of the publishing and retracting pipelines with the given provider, for
+// example the SDK TracerProvider exporting to an OpenTelemetry collector.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) error {
+		o.tracerProvider = tp
+		return nil
+	}
+}
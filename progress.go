@@ -0,0 +1,91 @@
+package herald
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressEvent reports the progress of the publication or retraction of the multihashes of a catalog, after every
+// entry chunk stored, and once done.
+type ProgressEvent struct {
+	// Operation is "publish" or "retract"
+	Operation string
+	// ContextID is the ContextID of the catalog, empty without ContextID
+	ContextID CatalogID
+
+	// Multihashes is the number of multihashes stored in the entries so far, out of Total, or -1 if unknown
+	Multihashes int
+	Total       int
+	// Chunks is the number of entry blocks stored so far
+	Chunks int
+	// Bytes is the estimated encoded size of the entry chunks stored so far, not counted with EntriesHAMT
+	Bytes int64
+
+	// Elapsed is the time since the start of the operation, and Remaining its estimated completion time, from the
+	// rate so far; zero if unknown
+	Elapsed   time.Duration
+	Remaining time.Duration
+
+	// Done is true for the last event, with Err the outcome of the operation
+	Done bool
+	Err  error
+}
+
+// progressTracker accumulates the progress of an operation, and reports it to the hook of the ChainConfig. A nil
+// *progressTracker is valid and reports nothing.
+type progressTracker struct {
+	hook  func(ProgressEvent)
+	start time.Time
+
+	mu    sync.Mutex
+	event ProgressEvent
+}
+
+// startProgress starts tracking the progress of an operation on catalog, if cfg.Progress is set.
+func (cfg ChainConfig) startProgress(operation string, id CatalogID, catalog Catalog) *progressTracker {
+	if cfg.Progress == nil {
+		return nil
+	}
+	return &progressTracker{
+		hook:  cfg.Progress,
+		start: time.Now(),
+		event: ProgressEvent{Operation: operation, ContextID: id, Total: catalog.Count()},
+	}
+}
+
+// stored records entry blocks stored, holding mhCount multihashes.
+func (p *progressTracker) stored(mhCount int, blocks int, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.event.Multihashes += mhCount
+	p.event.Chunks += blocks
+	p.event.Bytes += bytes
+	event := p.snapshot()
+	p.mu.Unlock()
+	p.hook(event)
+}
+
+// finish reports the end of the operation.
+func (p *progressTracker) finish(err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.event.Done, p.event.Err = true, err
+	event := p.snapshot()
+	event.Remaining = 0
+	p.mu.Unlock()
+	p.hook(event)
+}
+
+func (p *progressTracker) snapshot() ProgressEvent {
+	event := p.event
+	event.Elapsed = time.Since(p.start)
+	if event.Total > 0 && event.Multihashes > 0 && event.Multihashes < event.Total {
+		rate := float64(event.Elapsed) / float64(event.Multihashes)
+		event.Remaining = time.Duration(rate * float64(event.Total-event.Multihashes))
+	}
+	return event
+}
@@ -0,0 +1,47 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishProgress(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.AdEntriesChunkSize = 10
+	var events []ProgressEvent
+	cfg.Progress = func(e ProgressEvent) {
+		events = append(events, e)
+	}
+
+	_, err := PublishWithContextID(ctx, cfg, NewMemoryBackend(), testCatalog{MhCatalog: testMultihashes(95), id: []byte("foo")})
+	require.NoError(t, err)
+	require.Len(t, events, 11)
+	for i, e := range events[:10] {
+		require.Equal(t, "publish", e.Operation)
+		require.Equal(t, CatalogID("foo"), e.ContextID)
+		require.Equal(t, 95, e.Total)
+		require.Equal(t, i+1, e.Chunks)
+		require.Equal(t, min(10*(i+1), 95), e.Multihashes)
+		require.False(t, e.Done)
+		if i > 0 {
+			require.Greater(t, e.Bytes, events[i-1].Bytes)
+		}
+	}
+	last := events[10]
+	require.True(t, last.Done)
+	require.NoError(t, last.Err)
+	require.Equal(t, 95, last.Multihashes)
+	require.Zero(t, last.Remaining)
+
+	// the failures are reported too
+	events = nil
+	_, err = RetractRawMHs(ctx, cfg, &overlapBackend{MemoryBackend: NewMemoryBackend(), failing: true}, CatalogFromMultihashes(testMultihashes(5)...))
+	require.Error(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, "retract", events[0].Operation)
+	require.True(t, events[0].Done)
+	require.ErrorIs(t, events[0].Err, ErrBackendUnavailable)
+}
@@ -0,0 +1,54 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ValidateProviderAddrs checks that the provider addresses are valid multiaddrs that can be published.
+func ValidateProviderAddrs(addrs []string) error {
+	if len(addrs) == 0 {
+		return errors.New("at least one provider address must be set")
+	}
+	for _, a := range addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return fmt.Errorf("invalid provider address %q: %w", a, err)
+		}
+		if manet.IsIPUnspecified(ma) {
+			return fmt.Errorf("invalid provider address %q: unspecified IP address is not reachable by clients", a)
+		}
+	}
+	return nil
+}
+
+// ResolveProviderAddrs verifies that the DNS based provider addresses (/dns, /dns4, /dns6, /dnsaddr) resolve to at
+// least one address. If resolver is nil, madns.DefaultResolver is used.
+func ResolveProviderAddrs(ctx context.Context, addrs []string, resolver *madns.Resolver, opts ...OperationOption) error {
+	if resolver == nil {
+		resolver = madns.DefaultResolver
+	}
+	for _, a := range addrs {
+		ma, err := multiaddr.NewMultiaddr(a)
+		if err != nil {
+			return fmt.Errorf("invalid provider address %q: %w", a, err)
+		}
+		if !madns.Matches(ma) {
+			continue
+		}
+		resolved, err := resolver.Resolve(ctx, ma)
+		if err != nil {
+			return fmt.Errorf("failed to resolve provider address %q: %w", a, err)
+		}
+		if len(resolved) == 0 {
+			return fmt.Errorf("provider address %q doesn't resolve to any address", a)
+		}
+		operationLogger(opts).Debugw("resolved provider address", "addr", a, "resolved", resolved)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package herald
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateProviderAddrs(t *testing.T) {
+	require.NoError(t, ValidateProviderAddrs([]string{"/ip4/1.2.3.4/tcp/80/http", "/dns4/example.com/tcp/443/https"}))
+	require.Error(t, ValidateProviderAddrs(nil))
+	require.Error(t, ValidateProviderAddrs([]string{"1.2.3.4:80"}))
+	require.Error(t, ValidateProviderAddrs([]string{"/ip4/0.0.0.0/tcp/80/http"}))
+
+	// only the publications are checked, the retractions go through
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+	catalog := testCatalog{MhCatalog: testMultihashes(2), id: []byte("foo")}
+	_, err := PublishWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+	cfg.ProviderAddrs = []string{"/ip4/0.0.0.0/tcp/80/http"}
+	_, err = PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("bar")})
+	require.Error(t, err)
+	_, err = RetractWithContextID(ctx, cfg, backend, catalog)
+	require.NoError(t, err)
+}
+
+func TestResolveProviderAddrs(t *testing.T) {
+	ctx := context.Background()
+	resolver, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		IP: map[string][]net.IPAddr{
+			"example.com": {{IP: net.ParseIP("1.2.3.4")}},
+		},
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, ResolveProviderAddrs(ctx, []string{"/ip4/1.2.3.4/tcp/80", "/dns4/example.com/tcp/443/https"}, resolver))
+	require.Error(t, ResolveProviderAddrs(ctx, []string{"/dns4/unknown.example.com/tcp/443/https"}, resolver))
+}
@@ -0,0 +1,150 @@
+package herald
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/announce"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Publisher publishes and retracts catalogs right away, without batching, and announces each new head to the
+// indexers. It's the non-batched counterpart of CatalogBatcher.
+type Publisher struct {
+	mu        sync.RWMutex // protects cfg, held while generating advertisements
+	cfg       ChainConfig
+	backend   ChainWriter
+	announcer announce.Sender
+	retry     RetryPolicy
+}
+
+type PublisherOption func(*Publisher)
+
+// WithAnnounceRetryPolicy sets the policy applied when an announcement fails. Defaults to no retry.
+func WithAnnounceRetryPolicy(policy RetryPolicy) PublisherOption {
+	return func(p *Publisher) {
+		p.retry = policy
+	}
+}
+
+// NewPublisher creates a Publisher writing the chain to backend, and announcing the new heads with announcer.
+// A nil announcer disables the announcements.
+func NewPublisher(cfg ChainConfig, backend ChainWriter, announcer announce.Sender, opts ...PublisherOption) *Publisher {
+	p := &Publisher{cfg: cfg, backend: backend, announcer: announcer}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// PublishWithContextID is PublishWithContextID, followed by an announcement.
+func (p *Publisher) PublishWithContextID(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return PublishWithContextID(ctx, cfg, p.backend, catalog)
+	})
+}
+
+// RetractWithContextID is RetractWithContextID, followed by an announcement.
+func (p *Publisher) RetractWithContextID(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return RetractWithContextID(ctx, cfg, p.backend, catalog)
+	})
+}
+
+// UpdateMetadataWithContextID is UpdateMetadataWithContextID, followed by an announcement.
+func (p *Publisher) UpdateMetadataWithContextID(ctx context.Context, contextID CatalogID, metadata []byte) (cid.Cid, error) {
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return UpdateMetadataWithContextID(ctx, cfg, p.backend, contextID, metadata)
+	})
+}
+
+// RetractContextID is RetractContextID, followed by an announcement.
+func (p *Publisher) RetractContextID(ctx context.Context, id CatalogID) (cid.Cid, error) {
+	return p.RetractContextIDs(ctx, []CatalogID{id})
+}
+
+// RetractContextIDs is RetractContextIDs, followed by an announcement.
+func (p *Publisher) RetractContextIDs(ctx context.Context, ids []CatalogID) (cid.Cid, error) {
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return RetractContextIDs(ctx, cfg, p.backend, ids)
+	})
+}
+
+// RetractNamespace is RetractNamespace, followed by an announcement if anything was retracted. The backend must be a
+// ChainReader.
+func (p *Publisher) RetractNamespace(ctx context.Context, namespace []byte) (cid.Cid, error) {
+	reader, ok := p.backend.(ChainReader)
+	if !ok {
+		return cid.Undef, fmt.Errorf("backend %T can't be read to find the ContextIDs of the namespace", p.backend)
+	}
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return RetractNamespace(ctx, cfg, p.backend, reader, namespace)
+	})
+}
+
+// PublishRawMHs is PublishRawMHs, followed by an announcement.
+func (p *Publisher) PublishRawMHs(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return PublishRawMHs(ctx, cfg, p.backend, catalog)
+	})
+}
+
+// RetractRawMHs is RetractRawMHs, followed by an announcement.
+func (p *Publisher) RetractRawMHs(ctx context.Context, catalog Catalog) (cid.Cid, error) {
+	return p.announced(ctx, func(ctx context.Context, cfg ChainConfig) (cid.Cid, error) {
+		return RetractRawMHs(ctx, cfg, p.backend, catalog)
+	})
+}
+
+// UpdateProviderAddrs is UpdateProviderAddrs, followed by an announcement. The new addresses are used for all the
+// subsequent advertisements.
+func (p *Publisher) UpdateProviderAddrs(ctx context.Context, addrs []string) (cid.Cid, error) {
+	// the lock waits for the advertisements being generated, and makes sure that none is generated with the old
+	// addresses after the update
+	p.mu.Lock()
+	newHead, err := UpdateProviderAddrs(ctx, p.cfg, p.backend, addrs)
+	if err == nil {
+		p.cfg.ProviderAddrs = addrs
+	}
+	cfg := p.cfg
+	p.mu.Unlock()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := announceHead(ctx, cfg, p.announcer, p.retry, newHead); err != nil {
+		return newHead, fmt.Errorf("failed to announce new head %s: %w", newHead, err)
+	}
+	return newHead, nil
+}
+
+// announced runs fn and announces the new head. If only the announcement fails, the new head is returned along with
+// the error: the chain is updated, and the indexers will catch up with the next announcement or when polling.
+func (p *Publisher) announced(ctx context.Context, fn func(ctx context.Context, cfg ChainConfig) (cid.Cid, error)) (cid.Cid, error) {
+	p.mu.RLock()
+	cfg := p.cfg
+	newHead, err := fn(ctx, cfg)
+	p.mu.RUnlock()
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := announceHead(ctx, cfg, p.announcer, p.retry, newHead); err != nil {
+		return newHead, fmt.Errorf("failed to announce new head %s: %w", newHead, err)
+	}
+	return newHead, nil
+}
+
+// announceHead sends an announcement of the new head, retrying according to policy. A nil announcer or an undefined
+// head, when nothing was published, is a no-op.
+func announceHead(ctx context.Context, cfg ChainConfig, announcer announce.Sender, policy RetryPolicy, newHead cid.Cid) (err error) {
+	if announcer == nil || !newHead.Defined() {
+		return nil
+	}
+	ctx, span := cfg.tracer().Start(ctx, "announce", trace.WithAttributes(attribute.Stringer("herald.head", newHead)))
+	defer func() { endSpan(span, err) }()
+	return policy.withLogger(cfg.logger()).Do(ctx, func(ctx context.Context) error {
+		return announce.Send(ctx, newHead, cfg.PublisherAddrs(), announcer)
+	})
+}
@@ -0,0 +1,88 @@
+package herald
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ValidatePublisherAddrs checks that the publisher addresses can be announced to the indexers. Each one must be
+// either an HTTP address, with a host and an http or https component, or a libp2p address of the publisher host,
+// with a host and a TCP or UDP transport. A /p2p component must be the publisher.
+func ValidatePublisherAddrs(addrs []multiaddr.Multiaddr, publisher peer.ID) error {
+	for _, a := range addrs {
+		if err := validatePublisherAddr(a, publisher); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePublisherAddr(a multiaddr.Multiaddr, publisher peer.ID) error {
+	if a == nil {
+		return errors.New("nil publisher address")
+	}
+	var hasHost, hasTransport, dnsaddr bool
+	for _, p := range a.Protocols() {
+		switch p.Code {
+		case multiaddr.P_IP4, multiaddr.P_IP6, multiaddr.P_DNS, multiaddr.P_DNS4, multiaddr.P_DNS6:
+			hasHost = true
+		case multiaddr.P_DNSADDR:
+			// resolves to complete addresses
+			hasHost, dnsaddr = true, true
+		case multiaddr.P_TCP, multiaddr.P_UDP:
+			hasTransport = true
+		}
+	}
+	switch {
+	case !hasHost:
+		return fmt.Errorf("invalid publisher address %s: no IP or DNS host", a)
+	case manet.IsIPUnspecified(a):
+		return fmt.Errorf("invalid publisher address %s: unspecified IP address is not reachable by the indexers", a)
+	case !isHttpAddr(a) && !hasTransport && !dnsaddr:
+		return fmt.Errorf("invalid publisher address %s: neither HTTP nor a libp2p transport", a)
+	}
+	if _, id := peer.SplitAddr(a); id != "" && id != publisher {
+		return fmt.Errorf("invalid publisher address %s: peer %s is not the publisher %s", a, id, publisher)
+	}
+	return nil
+}
+
+// listenerHttpAddrs returns the HTTP multiaddrs of a listener, with scheme http or https. An unspecified IP is
+// expanded into the addresses of the interfaces, the loopback ones being dropped if others are available.
+func listenerHttpAddrs(addr net.Addr, scheme string) ([]multiaddr.Multiaddr, error) {
+	listen, err := manet.FromNetAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs := []multiaddr.Multiaddr{listen}
+	if manet.IsIPUnspecified(listen) {
+		ifaces, err := manet.InterfaceMultiaddrs()
+		if err != nil {
+			return nil, err
+		}
+		if addrs, err = manet.ResolveUnspecifiedAddress(listen, ifaces); err != nil {
+			return nil, err
+		}
+	}
+
+	var routable, loopback []multiaddr.Multiaddr
+	suffix := multiaddr.StringCast("/" + scheme)
+	for _, a := range addrs {
+		switch {
+		case manet.IsIP6LinkLocal(a):
+		case manet.IsIPLoopback(a):
+			loopback = append(loopback, a.Encapsulate(suffix))
+		default:
+			routable = append(routable, a.Encapsulate(suffix))
+		}
+	}
+	if len(routable) > 0 {
+		return routable, nil
+	}
+	return loopback, nil
+}
@@ -1,16 +1,29 @@
 package herald
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
 	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	libp2phttp "github.com/libp2p/go-libp2p/p2p/http"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 // HttpPublisher is an IPNI HTTP publisher that exposes the IPNI chain for retrieval.
@@ -18,14 +31,113 @@ import (
 type HttpPublisher struct {
 	backend ChainReader
 	server  http.Server
+	// listenAddr is the address listened on, once started
+	listenAddr net.Addr
 
 	// topic is the IPNI topic name on which the advertisement is published
 	topic string
 	// providerKey is the keypair of the IPNI publisher
 	providerKey crypto.PrivKey
+
+	// metrics records the requests served, if not nil
+	metrics *Metrics
+	logger  *zap.SugaredLogger
+	// gatherer, if not nil, is exposed on /metrics
+	gatherer prometheus.Gatherer
+
+	// pathPrefix is the portion of the path before ipnisync.IPNIPath
+	pathPrefix string
+	// legacyPaths also serves the chain at the root: /head and /<cid>
+	legacyPaths bool
+
+	// tlsCertFile and tlsKeyFile, if set, serve HTTPS
+	tlsCertFile string
+	tlsKeyFile  string
+	// tls is set when serving HTTPS. It is recorded once configured, as serving sets server.TLSConfig even for HTTP.
+	tls bool
+
+	// status, if not nil, serves /status
+	status       *chainLengthCounter
+	lastAnnounce func() time.Time
+
+	// the limits and middlewares wrapping the handlers, see wrap
+	maxConcurrent int
+	rateLimit     rate.Limit
+	rateBurst     int
+	accessLog     bool
+	corsOrigins   []string
+	middlewares   []func(http.Handler) http.Handler
+	compress      bool
+}
+
+// HttpPublisherOption is an optional configuration for the HttpPublisher.
+type HttpPublisherOption func(*HttpPublisher)
+
+// WithHttpPublisherMetrics records the requests served (counts by status code, latency).
+func WithHttpPublisherMetrics(metrics *Metrics) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.metrics = metrics
+	}
+}
+
+// WithHttpPublisherLogger sets the logger of the publisher and of its access log, instead of the default one.
+func WithHttpPublisherLogger(l *zap.SugaredLogger) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.logger = l
+	}
+}
+
+// WithHttpPublisherMetricsEndpoint exposes the metrics of the gatherer, typically a *prometheus.Registry, on /metrics.
+func WithHttpPublisherMetricsEndpoint(gatherer prometheus.Gatherer) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.gatherer = gatherer
+	}
 }
 
-func NewHttpPublisher(backend ChainReader, listenAddr string, topic string, providerKey crypto.PrivKey) (*HttpPublisher, error) {
+// WithHttpPublisherPathPrefix serves the chain under prefix + "/ipni/v1/ad/" instead of "/ipni/v1/ad/", for example
+// to share the server with other services. The indexers then need to be given the HTTP address including the prefix.
+func WithHttpPublisherPathPrefix(prefix string) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.pathPrefix = prefix
+	}
+}
+
+// WithHttpPublisherLegacyPaths sets whether the chain is also served at the root, at /head and /<cid>, as done by
+// older publishers. Enabled by default.
+func WithHttpPublisherLegacyPaths(enabled bool) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.legacyPaths = enabled
+	}
+}
+
+// WithHttpPublisherTLS serves HTTPS, with the certificate and matching key read from the given PEM files.
+func WithHttpPublisherTLS(certFile, keyFile string) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.tlsCertFile = certFile
+		p.tlsKeyFile = keyFile
+	}
+}
+
+// WithHttpPublisherTLSConfig serves HTTPS with the given configuration, which must provide the certificates, either
+// in Certificates or with GetCertificate. For example, to use Let's Encrypt: (&autocert.Manager{...}).TLSConfig().
+func WithHttpPublisherTLSConfig(config *tls.Config) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.server.TLSConfig = config
+	}
+}
+
+// WithHttpPublisherSigner signs the head with signer instead of the private key given to NewHttpPublisher, which can
+// then be nil.
+func WithHttpPublisherSigner(signer Signer) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.providerKey = signerPrivKey(signer)
+	}
+}
+
+// NewHttpPublisher creates an HttpPublisher serving the chain following the IPNI HTTP layout: the signed head at
+// /ipni/v1/ad/head and the blocks at /ipni/v1/ad/<cid>. The liveness and readiness probes are served at /healthz and
+// /readyz.
+func NewHttpPublisher(backend ChainReader, listenAddr string, topic string, providerKey crypto.PrivKey, opts ...HttpPublisherOption) (*HttpPublisher, error) {
 	pub := &HttpPublisher{
 		backend: backend,
 		server: http.Server{
@@ -36,8 +148,14 @@ func NewHttpPublisher(backend ChainReader, listenAddr string, topic string, prov
 		},
 		topic:       topic,
 		providerKey: providerKey,
+		legacyPaths: true,
 	}
-	pub.server.Handler = pub.serveMux()
+	for _, opt := range opts {
+		opt(pub)
+	}
+	pub.logger = orDefaultLogger(pub.logger)
+	pub.tls = pub.tlsCertFile != "" || pub.server.TLSConfig != nil
+	pub.server.Handler = pub.wrap(pub.serveMux())
 	return pub, nil
 }
 
@@ -46,34 +164,136 @@ func (p *HttpPublisher) Start() error {
 	if err != nil {
 		return err
 	}
+	p.listenAddr = listener.Addr()
 	go func() {
-		if err := p.server.Serve(listener); errors.Is(err, http.ErrServerClosed) {
-			logger.Info("HTTP publisher stopped successfully.")
+		var err error
+		if p.tls {
+			err = p.server.ServeTLS(listener, p.tlsCertFile, p.tlsKeyFile)
+		} else {
+			err = p.server.Serve(listener)
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			p.logger.Info("HTTP publisher stopped successfully.")
 		} else {
-			logger.Errorw("HTTP publisher stopped erroneously.", "err", err)
+			p.logger.Errorw("HTTP publisher stopped erroneously.", "err", err)
 		}
 	}()
-	logger.Infow("HTTP publisher started successfully.", "address", listener.Addr())
+	p.logger.Infow("HTTP publisher started successfully.", "address", listener.Addr())
 	return nil
 }
 
+// Addrs returns the HTTP multiaddrs of the running publisher, derived from its listener, to be announced. An
+// unspecified listen IP is expanded into the addresses of the interfaces. It fails if the publisher is not started, or
+// serves under a path prefix.
+func (p *HttpPublisher) Addrs() ([]multiaddr.Multiaddr, error) {
+	if p.listenAddr == nil {
+		return nil, errors.New("the HTTP publisher is not started")
+	}
+	if p.pathPrefix != "" {
+		return nil, fmt.Errorf("the path prefix %s can't be derived as a multiaddr", p.pathPrefix)
+	}
+	scheme := "http"
+	if p.tls {
+		scheme = "https"
+	}
+	return listenerHttpAddrs(p.listenAddr, scheme)
+}
+
+// Handler returns the HTTP handler serving the chain, the same as served by Start, to mount it on an existing server
+// or mux instead.
+func (p *HttpPublisher) Handler() http.Handler {
+	return p.server.Handler
+}
+
+// MountLibp2pHttp serves the chain on a libp2p HTTP host as well, under the ipnisync protocol, for the indexers
+// speaking libp2p-HTTP. It can be used alongside Start, or instead of it. The chain is served with the same
+// middlewares and access log, and limits of their own. Like Handler, it must be called after Use.
+func (p *HttpPublisher) MountLibp2pHttp(h *libp2phttp.Host) {
+	// the libp2p host strips the protocol path before calling the handler
+	h.SetHTTPHandler(ipnisync.ProtocolID, p.wrap(p.handleChain("/")))
+}
+
 func (p *HttpPublisher) serveMux() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/head", p.handleGetHead)
-	mux.HandleFunc("/*", p.handleGetContent)
+	ipniPath := path.Join("/", p.pathPrefix, ipnisync.IPNIPath) + "/"
+	mux.HandleFunc(ipniPath, p.handleChain(ipniPath))
+	if p.legacyPaths {
+		mux.HandleFunc("/", p.handleChain("/"))
+	}
+	if p.gatherer != nil {
+		mux.Handle("/metrics", promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{}))
+	}
+	mux.HandleFunc("/healthz", p.handleHealthz)
+	mux.HandleFunc("/readyz", p.handleReadyz)
+	if p.status != nil {
+		mux.HandleFunc("/status", p.handleStatus)
+	}
 	return mux
 }
 
+// handleChain serves the head at prefix + "head", and the blocks at prefix + "<cid>".
+func (p *HttpPublisher) handleChain(prefix string) http.HandlerFunc {
+	getHead := p.instrument("head", p.handleGetHead)
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch name := strings.TrimPrefix(r.URL.Path, prefix); {
+		case name == "head":
+			getHead(w, r)
+		case name == "" || strings.Contains(name, "/"):
+			http.NotFound(w, r)
+		default:
+			p.instrument("content", func(w http.ResponseWriter, r *http.Request) {
+				p.handleGetContent(w, r, name)
+			})(w, r)
+		}
+	}
+}
+
+// instrument wraps a handler to record its requests, if metrics are enabled.
+func (p *HttpPublisher) instrument(handler string, fn http.HandlerFunc) http.HandlerFunc {
+	if p.metrics == nil {
+		return fn
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		fn(rec, r)
+		p.metrics.recordHttpRequest(handler, rec.status, time.Since(start))
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+const (
+	// httpBlockCacheControl is the Cache-Control of the blocks, which are immutable as addressed by their CID
+	httpBlockCacheControl = "public, max-age=31536000, immutable"
+	// httpHeadCacheControl is the Cache-Control of the head, which must be revalidated, cheaply thanks to its ETag
+	httpHeadCacheControl = "no-cache"
+)
+
+// etag returns the ETag of a resource identified by a CID.
+func etag(c cid.Cid) string {
+	return `"` + c.String() + `"`
+}
+
 func (p *HttpPublisher) handleGetHead(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	h, err := p.backend.GetHead(r.Context())
 	if err != nil {
-		logger.Errorw("failed to get head CID", "err", err)
+		p.logger.Errorw("failed to get head CID", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
@@ -83,48 +303,50 @@ func (p *HttpPublisher) handleGetHead(w http.ResponseWriter, r *http.Request) {
 	}
 	signedHead, err := head.NewSignedHead(h, p.topic, p.providerKey)
 	if err != nil {
-		logger.Errorw("failed to generate signed head message", "err", err)
+		p.logger.Errorw("failed to generate signed head message", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 	resp, err := signedHead.Encode()
 	if err != nil {
-		logger.Errorw("failed to encode signed head message", "err", err)
+		p.logger.Errorw("failed to encode signed head message", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	if written, err := w.Write(resp); err != nil {
-		logger.Errorw("failed to write encoded head response", "written", written, "err", err)
-	} else {
-		logger.Debugw("successfully responded with head message", "head", h, "written", written)
-	}
+	w.Header().Set("Cache-Control", httpHeadCacheControl)
+	w.Header().Set("ETag", etag(h))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(resp))
+	p.logger.Debugw("successfully responded with head message", "head", h)
 }
 
-func (p *HttpPublisher) handleGetContent(w http.ResponseWriter, r *http.Request) {
+// handleGetContent serves a block, streamed from the backend if it implements ContentStreamer. As the blocks are
+// immutable, the conditional (If-None-Match) requests are supported, as well as HEAD, and the partial (Range) requests
+// if the stream can seek.
+func (p *HttpPublisher) handleGetContent(w http.ResponseWriter, r *http.Request, pathParam string) {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 	default:
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	pathParam := strings.TrimPrefix("/", r.URL.RawPath)
 	id, err := cid.Decode(pathParam)
 	if err != nil {
-		logger.Debugw("invalid CID as path parameter while getting content", "pathParam", pathParam, "err", err)
+		p.logger.Debugw("invalid CID as path parameter while getting content", "pathParam", pathParam, "err", err)
 		http.Error(w, "invalid CID", http.StatusBadRequest)
 		return
 	}
-	content, err := p.backend.GetContent(r.Context(), id)
+	content, size, err := GetContentStream(r.Context(), p.backend, id)
 	if errors.Is(err, ErrContentNotFound) {
 		http.Error(w, "", http.StatusNotFound)
 		return
 	}
 	if err != nil {
-		logger.Errorw("failed to get content from store", "id", id, "err", err)
+		p.logger.Errorw("failed to get content from store", "id", id, "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
+	defer content.Close()
 
 	switch id.Prefix().Codec {
 	case cid.DagJSON:
@@ -132,19 +354,48 @@ func (p *HttpPublisher) handleGetContent(w http.ResponseWriter, r *http.Request)
 	case cid.DagCBOR:
 		w.Header().Set("Content-Type", "application/cbor")
 	default:
-		logger.Errorw("unknown block codec", "cid", id.String(), "codec", id.Prefix().Codec)
+		p.logger.Errorw("unknown block codec", "cid", id.String(), "codec", id.Prefix().Codec)
 		http.Error(w, "invalid block", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = w.Write(content)
-	if err != nil {
-		logger.Errorw("failed to write content response", "err", err)
+	w.Header().Set("Cache-Control", httpBlockCacheControl)
+	w.Header().Set("ETag", etag(id))
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, seeker)
+		return
 	}
+	p.serveStream(w, r, etag(id), content, size)
+}
+
+// serveStream serves a stream which can't seek, ignoring the Range requests.
+func (p *HttpPublisher) serveStream(w http.ResponseWriter, r *http.Request, etag string, content io.Reader, size int64) {
+	if match := r.Header.Get("If-None-Match"); match != "" && (match == "*" || strings.Contains(match, etag)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, content); err != nil {
+		p.logger.Warnw("failed to stream content", "err", err)
+	}
+}
+
+// Shutdown gracefully stops the HTTP server, waiting for the in-flight requests until ctx is done.
+func (p *HttpPublisher) Shutdown(ctx context.Context) error {
+	if p.status != nil {
+		p.status.close()
+	}
+	return p.server.Shutdown(ctx)
 }
 
 func (p *HttpPublisher) Close() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return p.server.Shutdown(ctx)
+	return p.Shutdown(ctx)
 }
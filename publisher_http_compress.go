@@ -0,0 +1,156 @@
+package herald
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+// compressMinSize is the size under which a response is not worth compressing, when known.
+const compressMinSize = 512
+
+// WithHttpPublisherCompression compresses the responses with zstd or gzip, following the Accept-Encoding of the
+// clients. The entry chunks compress well, which saves bandwidth to the indexers. The compressed responses don't
+// support the partial (Range) requests.
+func WithHttpPublisherCompression() HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.compress = true
+	}
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// compressResponses compresses the responses of h with the encoding preferred by the client, if any.
+func compressResponses(h http.Handler, logger *zap.SugaredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// the ranges would apply to the compressed representation
+		r.Header.Del("Range")
+		cw := &compressWriter{ResponseWriter: w, logger: logger, encoding: encoding, head: r.Method == http.MethodHead}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the supported encoding preferred in the Accept-Encoding header, zstd then gzip, or "".
+func negotiateEncoding(accept string) string {
+	var gzipOk, zstdOk bool
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "zstd":
+			zstdOk = true
+		case "gzip":
+			gzipOk = true
+		}
+	}
+	switch {
+	case zstdOk:
+		return "zstd"
+	case gzipOk:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressWriter compresses the body of the successful responses of a compressible type.
+type compressWriter struct {
+	http.ResponseWriter
+	logger      *zap.SugaredLogger
+	encoding    string
+	head        bool
+	wroteHeader bool
+	enc         io.WriteCloser
+	release     func()
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	header := c.Header()
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && compressible(header) {
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+		// the compressed representation differs byte-wise
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		if !c.head {
+			c.startEncoder()
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// compressible returns true for the blocks and the head, and the responses large enough if their size is known.
+func compressible(header http.Header) bool {
+	switch header.Get("Content-Type") {
+	case "application/json", "application/cbor":
+	default:
+		return false
+	}
+	if size, err := strconv.Atoi(header.Get("Content-Length")); err == nil && size < compressMinSize {
+		return false
+	}
+	return true
+}
+
+func (c *compressWriter) startEncoder() {
+	switch c.encoding {
+	case "zstd":
+		enc := zstdWriters.Get().(*zstd.Encoder)
+		enc.Reset(c.ResponseWriter)
+		c.enc, c.release = enc, func() { zstdWriters.Put(enc) }
+	case "gzip":
+		enc := gzipWriters.Get().(*gzip.Writer)
+		enc.Reset(c.ResponseWriter)
+		c.enc, c.release = enc, func() { gzipWriters.Put(enc) }
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.enc != nil {
+		return c.enc.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// close flushes the compressed body, if any.
+func (c *compressWriter) close() {
+	if c.enc == nil {
+		return
+	}
+	if err := c.enc.Close(); err != nil {
+		c.logger.Debugw("failed to write compressed response", "err", err)
+	}
+	c.release()
+	c.enc = nil
+}
@@ -0,0 +1,169 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"go.uber.org/zap"
+)
+
+// httpReadyTimeout bounds the head read of the readiness probe.
+const httpReadyTimeout = 5 * time.Second
+
+// PublisherStatus is the state of the publisher, served as JSON on /status.
+type PublisherStatus struct {
+	// Head is the CID of the head, empty if the chain is empty
+	Head string `json:"head,omitempty"`
+	// ChainLength is the number of advertisements of the chain, as of the last head notification. It's missing until
+	// the chain is counted once.
+	ChainLength *int `json:"chainLength,omitempty"`
+	// LastAnnounce is the time of the last successful announcement, if known
+	LastAnnounce *time.Time `json:"lastAnnounce,omitempty"`
+}
+
+// WithHttpPublisherStatus serves the PublisherStatus as JSON on /status. lastAnnounce, if not nil, returns the time of
+// the last successful announcement, or the zero time if none. The length of the chain is counted in the background
+// from the first request, then updated incrementally from the head notifications of the backend.
+func WithHttpPublisherStatus(lastAnnounce func() time.Time) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.status = newChainLengthCounter()
+		p.lastAnnounce = lastAnnounce
+	}
+}
+
+// handleHealthz is the liveness probe: the publisher is up. It doesn't depend on the backend, so that an outage of the
+// backend doesn't restart the publisher.
+func (p *HttpPublisher) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz is the readiness probe: the head can be read from the backend.
+func (p *HttpPublisher) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), httpReadyTimeout)
+	defer cancel()
+	if _, err := p.backend.GetHead(ctx); err != nil {
+		p.logger.Warnw("readiness check failed to read the head", "err", err)
+		http.Error(w, "backend unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (p *HttpPublisher) handleStatus(w http.ResponseWriter, r *http.Request) {
+	h, err := p.backend.GetHead(r.Context())
+	if err != nil {
+		p.logger.Errorw("failed to get head CID", "err", err)
+		http.Error(w, "", http.StatusServiceUnavailable)
+		return
+	}
+	var status PublisherStatus
+	if h.Defined() {
+		status.Head = h.String()
+	}
+	p.status.watch(p.backend, p.logger)
+	if count, ok := p.status.length(); ok {
+		status.ChainLength = &count
+	}
+	if p.lastAnnounce != nil {
+		if t := p.lastAnnounce(); !t.IsZero() {
+			status.LastAnnounce = &t
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+// chainLengthCounter counts the advertisements of a chain, walking only the new ones on each head notification.
+type chainLengthCounter struct {
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	counted bool
+	head    cid.Cid
+	count   int
+}
+
+func newChainLengthCounter() *chainLengthCounter {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &chainLengthCounter{ctx: ctx, cancel: cancel}
+}
+
+// length returns the length of the chain, or false if not counted yet.
+func (c *chainLengthCounter) length() (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count, c.counted
+}
+
+// watch starts counting the chain, then following its head, once.
+func (c *chainLengthCounter) watch(backend ChainReader, logger *zap.SugaredLogger) {
+	c.once.Do(func() {
+		heads, err := backend.SubscribeHead(c.ctx)
+		if err != nil {
+			logger.Errorw("failed to subscribe to the head, the chain length is not reported", "err", err)
+			return
+		}
+		go func() {
+			head, err := backend.GetHead(c.ctx)
+			if err != nil {
+				logger.Warnw("failed to get the head to count the advertisements", "err", err)
+			} else {
+				c.advance(backend, head, logger)
+			}
+			for head := range heads {
+				c.advance(backend, head, logger)
+			}
+		}()
+	})
+}
+
+// advance counts the advertisements added up to head. Only the watch goroutine writes the count, so that the walk
+// doesn't hold the lock.
+func (c *chainLengthCounter) advance(backend ChainReader, head cid.Cid, logger *zap.SugaredLogger) {
+	c.mu.Lock()
+	prev, count, counted := c.head, c.count, c.counted
+	c.mu.Unlock()
+	if counted && head == prev {
+		return
+	}
+
+	var added int
+	found := false
+	if head.Defined() {
+		err := WalkAdsFrom(c.ctx, backend, head, func(adCid cid.Cid, _ schema.Advertisement) error {
+			if counted && prev.Defined() && adCid == prev {
+				found = true
+				return ErrStopWalk
+			}
+			added++
+			return nil
+		})
+		if err != nil {
+			// counted again from the previous head on the next notification
+			logger.Warnw("failed to count the advertisements", "head", head, "err", err)
+			return
+		}
+	}
+	if !found {
+		// the chain was rewritten, or counted for the first time
+		count = 0
+	}
+	c.mu.Lock()
+	c.head, c.count, c.counted = head, count+added, true
+	c.mu.Unlock()
+}
+
+// close stops following the head.
+func (c *chainLengthCounter) close() {
+	c.cancel()
+}
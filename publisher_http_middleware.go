@@ -0,0 +1,189 @@
+package herald
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitClients is the number of client IPs whose rate limiter is remembered, the least recent being forgotten.
+const rateLimitClients = 10_000
+
+// WithHttpPublisherTimeouts sets the timeouts of the server: reading a request (headers included), writing a
+// response, and keeping an idle connection open. Zero means no timeout, except for the idle timeout falling back to
+// the read one. Defaults to 10s for reading and writing.
+func WithHttpPublisherTimeouts(read, write, idle time.Duration) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.server.ReadTimeout = read
+		p.server.ReadHeaderTimeout = read
+		p.server.WriteTimeout = write
+		p.server.IdleTimeout = idle
+	}
+}
+
+// WithHttpPublisherMaxConcurrentRequests limits the number of requests served concurrently. The requests above the
+// limit are answered 503 Service Unavailable right away, for the clients to retry later.
+func WithHttpPublisherMaxConcurrentRequests(n int) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.maxConcurrent = n
+	}
+}
+
+// WithHttpPublisherRateLimit limits the requests of each client IP to rps per second, with bursts of up to burst
+// requests. The requests above the limit are answered 429 Too Many Requests.
+func WithHttpPublisherRateLimit(rps float64, burst int) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.rateLimit, p.rateBurst = rate.Limit(rps), burst
+	}
+}
+
+// WithHttpPublisherAccessLog logs every request served: method, path, status, size, duration and client address.
+func WithHttpPublisherAccessLog() HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.accessLog = true
+	}
+}
+
+// WithHttpPublisherCORS allows the given origins to read the chain from a browser, "*" allowing any origin.
+func WithHttpPublisherCORS(origins ...string) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.corsOrigins = origins
+	}
+}
+
+// WithHttpPublisherMiddleware wraps the handlers of the publisher, see HttpPublisher.Use.
+func WithHttpPublisherMiddleware(middleware ...func(http.Handler) http.Handler) HttpPublisherOption {
+	return func(p *HttpPublisher) {
+		p.middlewares = append(p.middlewares, middleware...)
+	}
+}
+
+// Use wraps the handlers of the publisher with the middlewares, for example to authenticate the clients. The first
+// middleware is the outermost, and they all run after the access log and CORS, and before the limits. It must be
+// called before Start, Handler or MountLibp2pHttp.
+func (p *HttpPublisher) Use(middleware ...func(http.Handler) http.Handler) {
+	p.middlewares = append(p.middlewares, middleware...)
+	p.server.Handler = p.wrap(p.serveMux())
+}
+
+// wrap applies the compression, the limits, the middlewares, CORS and the access log around h, from the innermost.
+func (p *HttpPublisher) wrap(h http.Handler) http.Handler {
+	if p.compress {
+		h = compressResponses(h, p.logger)
+	}
+	if p.maxConcurrent > 0 {
+		h = limitConcurrency(h, p.maxConcurrent)
+	}
+	if p.rateLimit > 0 {
+		h = limitRate(h, p.rateLimit, p.rateBurst)
+	}
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		h = p.middlewares[i](h)
+	}
+	if len(p.corsOrigins) > 0 {
+		h = allowCORS(h, p.corsOrigins)
+	}
+	if p.accessLog {
+		h = logAccess(h, p.logger)
+	}
+	return h
+}
+
+func limitConcurrency(h http.Handler, n int) http.Handler {
+	slots := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			h.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func limitRate(h http.Handler, limit rate.Limit, burst int) http.Handler {
+	limiters, _ := lru.New[string, *rate.Limiter](rateLimitClients)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		limiter, ok := limiters.Get(ip)
+		if !ok {
+			limiter = rate.NewLimiter(limit, burst)
+			if prev, ok, _ := limiters.PeekOrAdd(ip, limiter); ok {
+				limiter = prev
+			}
+		}
+		if !limiter.Allow() {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(1/float64(limit)))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP of the client of r, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func allowCORS(h http.Handler, origins []string) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+			}
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Content-Length")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				// preflight
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", "If-None-Match")
+				w.Header().Set("Access-Control-Max-Age", "86400")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func logAccess(h http.Handler, logger *zap.SugaredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &sizeRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+		h.ServeHTTP(rec, r)
+		logger.Infow("http request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+			"bytes", rec.size, "duration", time.Since(start), "remote", r.RemoteAddr)
+	})
+}
+
+// sizeRecorder captures the status code and the size of the body written by a handler.
+type sizeRecorder struct {
+	statusRecorder
+	size int
+}
+
+func (r *sizeRecorder) Write(b []byte) (int, error) {
+	n, err := r.statusRecorder.Write(b)
+	r.size += n
+	return n, err
+}
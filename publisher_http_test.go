@@ -0,0 +1,615 @@
+package herald
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/klauspost/compress/zstd"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2phttp "github.com/libp2p/go-libp2p/p2p/http"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpPublisherIpniSync(t *testing.T) {
+	for _, codec := range []multicodec.Code{multicodec.DagJson, multicodec.DagCbor} {
+		t.Run(codec.String(), func(t *testing.T) {
+			ctx := context.Background()
+			cfg := testChainConfig(t)
+			cfg.Codec = codec
+			backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+			head, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+			require.NoError(t, err)
+			require.Equal(t, uint64(codec), head.Prefix().Codec)
+
+			pub, err := NewHttpPublisher(backend, "", "/indexer/ingest/mainnet", cfg.PublisherKey)
+			require.NoError(t, err)
+			server := httptest.NewServer(pub.serveMux())
+			defer server.Close()
+
+			addr, err := manet.FromNetAddr(server.Listener.Addr())
+			require.NoError(t, err)
+
+			store := &memstore.Store{}
+			lsys := cidlink.DefaultLinkSystem()
+			lsys.SetReadStorage(store)
+			lsys.SetWriteStorage(store)
+			client := ipnisync.NewSync(lsys, nil)
+			defer client.Close()
+			syncer, err := client.NewSyncer(peer.AddrInfo{
+				ID:    cfg.PublisherID,
+				Addrs: []multiaddr.Multiaddr{addr.Encapsulate(multiaddr.StringCast("/http"))},
+			})
+			require.NoError(t, err)
+
+			remoteHead, err := syncer.GetHead(ctx)
+			require.NoError(t, err)
+			require.Equal(t, head, remoteHead)
+
+			// the whole chain, advertisement and entries
+			require.NoError(t, syncer.Sync(ctx, head, selectorparse.CommonSelector_ExploreAllRecursively))
+			has, err := store.Has(ctx, head.KeyString())
+			require.NoError(t, err)
+			require.True(t, has)
+			ad, err := LoadAdvertisement(ctx, backend, head)
+			require.NoError(t, err)
+			require.Equal(t, uint64(codec), linkCid(ad.Entries).Prefix().Codec)
+			has, err = store.Has(ctx, linkCid(ad.Entries).KeyString())
+			require.NoError(t, err)
+			require.True(t, has)
+			_, err = ad.VerifySignature()
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestHttpPublisherPaths(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+
+	status := func(handler http.Handler, path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	pub, err := NewHttpPublisher(backend, "", "topic", cfg.PublisherKey, WithHttpPublisherPathPrefix("/foo"))
+	require.NoError(t, err)
+	mux := pub.serveMux()
+	require.Equal(t, http.StatusOK, status(mux, "/foo/ipni/v1/ad/head"))
+	require.Equal(t, http.StatusOK, status(mux, "/foo/ipni/v1/ad/"+head.String()))
+	require.Equal(t, http.StatusOK, status(mux, "/head"))
+	require.Equal(t, http.StatusOK, status(mux, "/"+head.String()))
+	require.Equal(t, http.StatusNotFound, status(mux, "/ipni/v1/ad/head"))
+	require.Equal(t, http.StatusBadRequest, status(mux, "/foo/ipni/v1/ad/notacid"))
+	require.Equal(t, http.StatusNotFound, status(mux, "/foo/ipni/v1/ad/"+head.String()+"/more"))
+
+	pub, err = NewHttpPublisher(backend, "", "topic", cfg.PublisherKey, WithHttpPublisherLegacyPaths(false))
+	require.NoError(t, err)
+	mux = pub.serveMux()
+	require.Equal(t, http.StatusOK, status(mux, "/ipni/v1/ad/head"))
+	require.Equal(t, http.StatusNotFound, status(mux, "/head"))
+	require.Equal(t, http.StatusNotFound, status(mux, "/"+head.String()))
+}
+
+func TestHttpPublisherCaching(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	block, err := backend.GetContent(ctx, head)
+	require.NoError(t, err)
+
+	pub, err := NewHttpPublisher(backend, "", "topic", cfg.PublisherKey)
+	require.NoError(t, err)
+	mux := pub.serveMux()
+	serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	blockPath := "/ipni/v1/ad/" + head.String()
+
+	rec := serve(http.MethodGet, blockPath, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `"`+head.String()+`"`, rec.Header().Get("ETag"))
+	require.Contains(t, rec.Header().Get("Cache-Control"), "immutable")
+	require.Equal(t, block, rec.Body.Bytes())
+
+	rec = serve(http.MethodGet, blockPath, http.Header{"If-None-Match": {`"` + head.String() + `"`}})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	require.Empty(t, rec.Body.Bytes())
+
+	rec = serve(http.MethodHead, blockPath, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, strconv.Itoa(len(block)), rec.Header().Get("Content-Length"))
+	require.Empty(t, rec.Body.Bytes())
+
+	rec = serve(http.MethodGet, blockPath, http.Header{"Range": {"bytes=2-9"}})
+	require.Equal(t, http.StatusPartialContent, rec.Code)
+	require.Equal(t, block[2:10], rec.Body.Bytes())
+
+	rec = serve(http.MethodGet, "/ipni/v1/ad/head", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+	require.Equal(t, `"`+head.String()+`"`, rec.Header().Get("ETag"))
+
+	// the head is only revalidated until it changes
+	rec = serve(http.MethodGet, "/ipni/v1/ad/head", http.Header{"If-None-Match": {`"` + head.String() + `"`}})
+	require.Equal(t, http.StatusNotModified, rec.Code)
+	_, err = PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(1)...))
+	require.NoError(t, err)
+	rec = serve(http.MethodGet, "/ipni/v1/ad/head", http.Header{"If-None-Match": {`"` + head.String() + `"`}})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(http.MethodPost, blockPath, nil)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestHttpPublisherStreamsS3(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.AdEntriesChunkSize = 1000
+	fake, client := newFakeS3(t)
+	backend := NewS3Backend(aws.Config{}, "bucket", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithS3Client(client), WithS3Prefix("chain/"))
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(1000)...))
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	chunk := linkCid(ad.Entries)
+	block, _ := fake.object("chain/" + chunk.String())
+	require.NotEmpty(t, block)
+
+	pub, err := NewHttpPublisher(backend, "", "/indexer/ingest/mainnet", cfg.PublisherKey)
+	require.NoError(t, err)
+	server := httptest.NewServer(pub.serveMux())
+	defer server.Close()
+	get := func(method string, c cid.Cid, header http.Header) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, server.URL+"/ipni/v1/ad/"+c.String(), nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, body
+	}
+
+	// the S3 object is streamed through
+	resp, body := get(http.MethodGet, chunk, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, block, body)
+	require.Equal(t, strconv.Itoa(len(block)), resp.Header.Get("Content-Length"))
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	resp, body = get(http.MethodGet, chunk, http.Header{"If-None-Match": {`"` + chunk.String() + `"`}})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	require.Empty(t, body)
+
+	resp, body = get(http.MethodHead, chunk, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, int64(len(block)), resp.ContentLength)
+	require.Empty(t, body)
+
+	missing := testMultihashes(1)[0]
+	resp, _ = get(http.MethodGet, cid.NewCidV1(cid.DagJSON, missing), nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// the S3 backend can be read from, and so can the chain it serves, streamed and verified
+	localHead, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, localHead)
+	reader := NewHttpChainReader(server.URL+"/ipni/v1/ad", cfg.PublisherID)
+	stream, size, err := reader.GetContentStream(ctx, chunk)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(block)), size)
+	streamed, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	require.Equal(t, block, streamed)
+	report, err := VerifyChain(ctx, reader, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+}
+
+func TestHttpChainReaderStreamVerification(t *testing.T) {
+	ctx := context.Background()
+	data := []byte(`{"Entries":[]}`)
+	c, err := cid.Prefix{Version: 1, Codec: cid.DagJSON, MhType: multihash.SHA2_256, MhLength: -1}.Sum(data)
+	require.NoError(t, err)
+	served := data
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(served)
+	}))
+	defer server.Close()
+	reader := NewHttpChainReader(server.URL, "")
+
+	stream, _, err := reader.GetContentStream(ctx, c)
+	require.NoError(t, err)
+	read, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	require.NoError(t, stream.Close())
+
+	served = []byte(`{"Entries":[1]}`)
+	stream, _, err = reader.GetContentStream(ctx, c)
+	require.NoError(t, err)
+	_, err = io.ReadAll(stream)
+	require.ErrorContains(t, err, "doesn't match its CID")
+	require.NoError(t, stream.Close())
+}
+
+func TestHttpPublisherTLS(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+
+	// a self-signed certificate
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listenAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	pub, err := NewHttpPublisher(backend, listenAddr, "topic", cfg.PublisherKey, WithHttpPublisherTLS(certFile, keyFile))
+	require.NoError(t, err)
+	require.NoError(t, pub.Start())
+	defer pub.Close()
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	reader := NewHttpChainReader("https://"+listenAddr+"/ipni/v1/ad", cfg.PublisherID)
+	reader.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+	remoteHead, err := reader.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, remoteHead)
+}
+
+func TestHttpPublisherAddrs(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	_, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+
+	pub, err := NewHttpPublisher(backend, "127.0.0.1:0", "topic", cfg.PublisherKey)
+	require.NoError(t, err)
+	require.NoError(t, pub.Start())
+	defer pub.Close()
+
+	// once serving, the addresses stay plain HTTP
+	addrs, err := pub.Addrs()
+	require.NoError(t, err)
+	require.Len(t, addrs, 1)
+	_, err = NewHttpChainReader("http://"+pub.listenAddr.String()+"/ipni/v1/ad", cfg.PublisherID).GetHead(ctx)
+	require.NoError(t, err)
+	after, err := pub.Addrs()
+	require.NoError(t, err)
+	require.Equal(t, addrs, after)
+	require.Contains(t, after[0].String(), "/http")
+	require.NotContains(t, after[0].String(), "/https")
+}
+
+func TestHttpPublisherLibp2pHttp(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+
+	var served atomic.Int32
+	pub, err := NewHttpPublisher(backend, "", "topic", cfg.PublisherKey)
+	require.NoError(t, err)
+	pub.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served.Add(1)
+			next.ServeHTTP(w, r)
+		})
+	})
+	host := &libp2phttp.Host{
+		InsecureAllowHTTP: true,
+		ListenAddrs:       []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0/http")},
+	}
+	pub.MountLibp2pHttp(host)
+	go func() { _ = host.Serve() }()
+	defer host.Close()
+	require.Eventually(t, func() bool { return len(host.Addrs()) > 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// the ipnisync client discovers the protocol path through the libp2p well-known resource
+	store := &memstore.Store{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(store)
+	lsys.SetWriteStorage(store)
+	client := ipnisync.NewSync(lsys, nil)
+	defer client.Close()
+	syncer, err := client.NewSyncer(peer.AddrInfo{ID: cfg.PublisherID, Addrs: host.Addrs()})
+	require.NoError(t, err)
+	remoteHead, err := syncer.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, remoteHead)
+	require.NoError(t, syncer.Sync(ctx, head, selectorparse.CommonSelector_ExploreAllRecursively))
+	// through the middlewares
+	require.NotZero(t, served.Load())
+}
+
+// unreachableReader is a ChainReader failing to read the head when down.
+type unreachableReader struct {
+	ChainReader
+	down bool
+}
+
+func (u *unreachableReader) GetHead(ctx context.Context) (cid.Cid, error) {
+	if u.down {
+		return cid.Undef, errors.New("connection refused")
+	}
+	return u.ChainReader.GetHead(ctx)
+}
+
+func (s PublisherStatus) withoutLength() PublisherStatus {
+	s.ChainLength = nil
+	return s
+}
+
+func TestHttpPublisherHealth(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	reader := &unreachableReader{ChainReader: backend}
+	announced := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	pub, err := NewHttpPublisher(reader, "", "/indexer/ingest/mainnet", cfg.PublisherKey,
+		WithHttpPublisherStatus(func() time.Time { return announced }))
+	require.NoError(t, err)
+	server := httptest.NewServer(pub.serveMux())
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	status := func() PublisherStatus {
+		code, body := get("/status")
+		require.Equal(t, http.StatusOK, code)
+		var s PublisherStatus
+		require.NoError(t, json.Unmarshal([]byte(body), &s))
+		return s
+	}
+
+	code, _ := get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = get("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, PublisherStatus{LastAnnounce: &announced}, status().withoutLength())
+	// counted in the background, from the first request
+	require.Eventually(t, func() bool {
+		s := status()
+		return s.ChainLength != nil && *s.ChainLength == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(i)...))
+		require.NoError(t, err)
+		require.Equal(t, head.String(), status().Head)
+		require.Eventually(t, func() bool {
+			s := status()
+			return s.ChainLength != nil && *s.ChainLength == i
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	require.NoError(t, pub.Shutdown(ctx))
+
+	// the backend is down: not ready, but alive
+	reader.down = true
+	code, _ = get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	code, _ = get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestHttpPublisherMiddleware(t *testing.T) {
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+
+	serve := func(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	get := func(header http.Header) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return req
+	}
+
+	pub, err := NewHttpPublisher(backend, "", "topic", cfg.PublisherKey,
+		WithHttpPublisherRateLimit(1, 2),
+		WithHttpPublisherCORS("https://example.com"),
+		WithHttpPublisherAccessLog())
+	require.NoError(t, err)
+	// an authentication in front of the publisher
+	pub.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "secret" {
+				http.Error(w, "", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := pub.Handler()
+	authorized := http.Header{"Authorization": {"secret"}, "Origin": {"https://example.com"}}
+
+	require.Equal(t, http.StatusUnauthorized, serve(handler, get(nil)).Code)
+	rec := serve(handler, get(authorized))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "https://example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// the burst is exhausted
+	require.Equal(t, http.StatusOK, serve(handler, get(authorized)).Code)
+	require.Equal(t, http.StatusTooManyRequests, serve(handler, get(authorized)).Code)
+
+	// the preflights are answered before the authentication
+	preflight := httptest.NewRequest(http.MethodOptions, "/ipni/v1/ad/head", nil)
+	preflight.Header.Set("Origin", "https://example.com")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rec = serve(handler, preflight)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Contains(t, rec.Header().Get("Access-Control-Allow-Methods"), http.MethodGet)
+
+	// an unknown origin gets no CORS header
+	rec = serve(handler, get(http.Header{"Authorization": {"secret"}, "Origin": {"https://evil.com"}}))
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// the requests above the concurrency limit are refused
+	release := make(chan struct{})
+	started := make(chan struct{})
+	limited := limitConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}), 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(limited, get(nil))
+	}()
+	<-started
+	require.Equal(t, http.StatusServiceUnavailable, serve(limited, get(nil)).Code)
+	close(release)
+	<-done
+
+	pub, err = NewHttpPublisher(backend, "", "topic", cfg.PublisherKey, WithHttpPublisherTimeouts(time.Second, 2*time.Second, time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, time.Second, pub.server.ReadTimeout)
+	require.Equal(t, 2*time.Second, pub.server.WriteTimeout)
+	require.Equal(t, time.Minute, pub.server.IdleTimeout)
+}
+
+func TestHttpPublisherCompression(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	cfg.AdEntriesChunkSize = 100
+	backend := NewMemoryBackend()
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(100)...))
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	chunk := linkCid(ad.Entries)
+	block, err := backend.GetContent(ctx, chunk)
+	require.NoError(t, err)
+
+	pub, err := NewHttpPublisher(backend, "", "topic", cfg.PublisherKey, WithHttpPublisherCompression())
+	require.NoError(t, err)
+	handler := pub.Handler()
+	get := func(encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ipni/v1/ad/"+chunk.String(), nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		return rec
+	}
+
+	rec := get("gzip, deflate")
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	require.Equal(t, `W/"`+chunk.String()+`"`, rec.Header().Get("ETag"))
+	require.Less(t, rec.Body.Len(), len(block))
+	gz, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, block, data)
+
+	rec = get("gzip;q=0.5, zstd")
+	require.Equal(t, "zstd", rec.Header().Get("Content-Encoding"))
+	zr, err := zstd.NewReader(rec.Body)
+	require.NoError(t, err)
+	defer zr.Close()
+	data, err = io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, block, data)
+
+	for _, encoding := range []string{"", "br", "gzip;q=0"} {
+		rec = get(encoding)
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Equal(t, block, rec.Body.Bytes())
+	}
+
+	// the clients decompress transparently
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/ipni/v1/ad/" + chunk.String())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.True(t, resp.Uncompressed)
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, block, data)
+}
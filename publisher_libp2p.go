@@ -0,0 +1,139 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+// Libp2pPublisher is an IPNI publisher that exposes the IPNI chain over libp2p streams, with the ipnisync protocol.
+// It allows the indexers syncing over libp2p, rather than plain HTTP, to pull the chain.
+// The head served follows the head of the backend.
+// The blocks are served re-encoded as DAG-JSON, so the chain must be DAG-JSON, see ChainConfig.Codec.
+type Libp2pPublisher struct {
+	backend ChainReader
+	host    host.Host
+
+	// topic is the IPNI topic name on which the advertisement is published
+	topic string
+	// providerKey is the keypair of the IPNI publisher
+	providerKey crypto.PrivKey
+
+	logger *zap.SugaredLogger
+
+	pub    *ipnisync.Publisher
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Libp2pPublisherOption is an optional configuration for the Libp2pPublisher.
+type Libp2pPublisherOption func(*Libp2pPublisher)
+
+// WithLibp2pPublisherLogger sets the logger of the publisher, instead of the default one.
+func WithLibp2pPublisherLogger(l *zap.SugaredLogger) Libp2pPublisherOption {
+	return func(p *Libp2pPublisher) {
+		p.logger = l
+	}
+}
+
+// NewLibp2pPublisher creates a publisher serving the chain of backend on the libp2p host h.
+// The identity of h must be providerKey, as the indexers authenticate the publisher with it.
+func NewLibp2pPublisher(backend ChainReader, h host.Host, topic string, providerKey crypto.PrivKey, opts ...Libp2pPublisherOption) (*Libp2pPublisher, error) {
+	if h == nil {
+		return nil, errors.New("libp2p host must be set")
+	}
+	p := &Libp2pPublisher{
+		backend:     backend,
+		host:        h,
+		topic:       topic,
+		providerKey: providerKey,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.logger = orDefaultLogger(p.logger)
+	return p, nil
+}
+
+func (p *Libp2pPublisher) Start() error {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = p.storageReadOpener
+
+	var err error
+	p.pub, err = ipnisync.NewPublisher(lsys, p.providerKey,
+		ipnisync.WithStreamHost(p.host),
+		ipnisync.WithHeadTopic(p.topic),
+	)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+
+	heads, err := p.backend.SubscribeHead(ctx)
+	if err != nil {
+		cancel()
+		_ = p.pub.Close()
+		return err
+	}
+	head, err := p.backend.GetHead(ctx)
+	if err != nil {
+		cancel()
+		_ = p.pub.Close()
+		return err
+	}
+	p.pub.SetRoot(head)
+
+	go func() {
+		defer close(p.done)
+		for head := range heads {
+			p.pub.SetRoot(head)
+		}
+	}()
+
+	p.logger.Infow("libp2p publisher started successfully.", "peerID", p.host.ID(), "addresses", p.pub.Addrs())
+	return nil
+}
+
+// Addrs returns the libp2p addresses the publisher is reachable at.
+func (p *Libp2pPublisher) Addrs() []multiaddr.Multiaddr {
+	if p.pub == nil {
+		return nil
+	}
+	return p.pub.Addrs()
+}
+
+func (p *Libp2pPublisher) storageReadOpener(lnkCtx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+	ctx := lnkCtx.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	content, err := p.backend.GetContent(ctx, linkCid(lnk))
+	if errors.Is(err, ErrContentNotFound) {
+		return nil, ipld.ErrNotExists{}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(content), nil
+}
+
+func (p *Libp2pPublisher) Close() error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+	<-p.done
+	return p.pub.Close()
+}
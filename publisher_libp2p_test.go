@@ -0,0 +1,70 @@
+package herald
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibp2pPublisher(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	first, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")})
+	require.NoError(t, err)
+
+	h, err := libp2p.New(libp2p.Identity(cfg.PublisherKey), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h.Close()
+
+	pub, err := NewLibp2pPublisher(backend, h, "/indexer/ingest/mainnet", cfg.PublisherKey)
+	require.NoError(t, err)
+	require.NoError(t, pub.Start())
+	defer pub.Close()
+
+	clientHost, err := libp2p.New(libp2p.NoListenAddrs)
+	require.NoError(t, err)
+	defer clientHost.Close()
+
+	clientLsys := cidlink.DefaultLinkSystem()
+	clientStore := &memstore.Store{}
+	clientLsys.SetReadStorage(clientStore)
+	clientLsys.SetWriteStorage(clientStore)
+	syncer, err := ipnisync.NewSync(clientLsys, nil, ipnisync.ClientStreamHost(clientHost)).
+		NewSyncer(peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	require.NoError(t, err)
+
+	head, err := syncer.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first, head)
+
+	err = syncer.Sync(ctx, head, selectorparse.CommonSelector_MatchPoint)
+	require.NoError(t, err)
+	_, err = clientLsys.LoadRaw(ipld.LinkContext{Ctx: ctx}, cidlink.Link{Cid: head})
+	require.NoError(t, err)
+
+	// the served head follows the backend
+	second, err := RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		head, err := syncer.GetHead(ctx)
+		return err == nil && head.Equals(second)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// unknown content
+	err = syncer.Sync(ctx, cid.NewCidV1(cid.DagJSON, testMultihashes(1)[0]), selectorparse.CommonSelector_MatchPoint)
+	require.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/stretchr/testify/require"
+)
+
+// flakySender is an announce.Sender failing the first announcements.
+type flakySender struct {
+	failures int
+	sent     []cid.Cid
+}
+
+func (f *flakySender) Close() error { return nil }
+
+func (f *flakySender) Send(_ context.Context, msg message.Message) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("indexer unavailable")
+	}
+	f.sent = append(f.sent, msg.Cid)
+	return nil
+}
+
+func TestPublisher(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	sender := &flakySender{failures: 1}
+	publisher := NewPublisher(cfg, backend, sender, WithAnnounceRetryPolicy(RetryPolicy{MaxAttempts: 2}))
+
+	catalog := testCatalog{MhCatalog: testMultihashes(3), id: []byte("foo")}
+	published, err := publisher.PublishWithContextID(ctx, catalog)
+	require.NoError(t, err)
+	retracted, err := publisher.RetractWithContextID(ctx, catalog)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{published, retracted}, sender.sent)
+
+	// the retries are exhausted: the head is updated, but the error is reported
+	sender.failures = 2
+	head, err := publisher.PublishRawMHs(ctx, CatalogFromMultihashes(testMultihashes(2)...))
+	require.Error(t, err)
+	stored, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, stored, head)
+	require.Len(t, sender.sent, 2)
+}
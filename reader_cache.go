@@ -0,0 +1,228 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/ipfs/go-cid"
+	"go.uber.org/zap"
+)
+
+var _ ChainReader = &CachingReader{}
+var _ ContentStreamer = &CachingReader{}
+
+// CachingReader is a ChainReader keeping the blocks read from another one in memory, in an LRU cache bounded in
+// bytes. As the blocks are immutable, they are never invalidated. The head is cached too, and replaced on every head
+// notification of the inner reader, which keeps the repeated reads of the indexers syncing the chain off the backend.
+type CachingReader struct {
+	inner    ChainReader
+	maxBytes int
+	metrics  *Metrics
+	logger   *zap.SugaredLogger
+
+	mu     sync.Mutex
+	blocks *simplelru.LRU[cid.Cid, []byte]
+	size   int // total size of the blocks cached
+
+	headMu    sync.Mutex
+	head      cid.Cid
+	headValid bool
+	headGen   uint64 // incremented on every head notification
+	watching  bool   // the head notifications are received
+	watchOnce sync.Once
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// CachingReaderOption is an optional configuration for the CachingReader.
+type CachingReaderOption func(*CachingReader)
+
+// WithCachingReaderMetrics records the cache hits and misses.
+func WithCachingReaderMetrics(metrics *Metrics) CachingReaderOption {
+	return func(c *CachingReader) {
+		c.metrics = metrics
+	}
+}
+
+// WithCachingReaderLogger sets the logger of the CachingReader, instead of the default one.
+func WithCachingReaderLogger(l *zap.SugaredLogger) CachingReaderOption {
+	return func(c *CachingReader) {
+		c.logger = l
+	}
+}
+
+// NewCachingReader creates a CachingReader caching up to maxBytes of blocks of inner. It must be closed with Close.
+func NewCachingReader(inner ChainReader, maxBytes int, opts ...CachingReaderOption) *CachingReader {
+	c := &CachingReader{inner: inner, maxBytes: maxBytes, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.logger = orDefaultLogger(c.logger)
+	// the blocks are at least one byte, so maxBytes is also a bound of their number
+	c.blocks, _ = simplelru.NewLRU[cid.Cid, []byte](max(maxBytes, 1), func(_ cid.Cid, data []byte) {
+		c.size -= len(data)
+	})
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// GetHead return the cid of the IPNI chain head, from the cache if it's known to be current.
+// Returns cid.Undef if the chain hasn't started yet.
+func (c *CachingReader) GetHead(ctx context.Context) (cid.Cid, error) {
+	c.watchOnce.Do(c.watchHead)
+
+	c.headMu.Lock()
+	if c.headValid {
+		head := c.head
+		c.headMu.Unlock()
+		c.metrics.recordCacheRequest("head", true)
+		return head, nil
+	}
+	gen := c.headGen
+	c.headMu.Unlock()
+
+	c.metrics.recordCacheRequest("head", false)
+	head, err := c.inner.GetHead(ctx)
+	if err != nil {
+		return cid.Undef, err
+	}
+	c.headMu.Lock()
+	// unless a notification came in the meantime, with a newer head
+	if c.watching && gen == c.headGen {
+		c.head, c.headValid = head, true
+	}
+	c.headMu.Unlock()
+	return head, nil
+}
+
+// watchHead starts receiving the head notifications of the inner reader. Without them, the head is not cached.
+func (c *CachingReader) watchHead() {
+	heads, err := c.inner.SubscribeHead(c.ctx)
+	if err != nil {
+		c.logger.Warnw("failed to subscribe to the head, the head is not cached", "err", err)
+		close(c.done)
+		return
+	}
+	c.headMu.Lock()
+	c.watching = true
+	c.headMu.Unlock()
+	go func() {
+		defer close(c.done)
+		for head := range heads {
+			c.headMu.Lock()
+			c.head, c.headValid = head, true
+			c.headGen++
+			c.headMu.Unlock()
+		}
+		c.headMu.Lock()
+		c.watching, c.headValid = false, false
+		c.headMu.Unlock()
+	}()
+}
+
+// GetContent returns the raw content of an IPLD block of the IPNI chain, from the cache or the inner reader.
+// Returns ErrContentNotFound if not found.
+func (c *CachingReader) GetContent(ctx context.Context, id cid.Cid) ([]byte, error) {
+	if data, ok := c.get(id); ok {
+		return data, nil
+	}
+	data, err := c.inner.GetContent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	c.add(id, data)
+	return data, nil
+}
+
+// GetContentStream returns the raw content of an IPLD block of the IPNI chain as a stream, from the cache or the inner
+// reader. A block streamed from the inner reader is cached once completely read.
+// Returns ErrContentNotFound if not found.
+func (c *CachingReader) GetContentStream(ctx context.Context, id cid.Cid) (io.ReadCloser, int64, error) {
+	if data, ok := c.get(id); ok {
+		return newBytesStream(data)
+	}
+	stream, size, err := GetContentStream(ctx, c.inner, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, ok := stream.(bytesStream); ok {
+		// already in memory, cached as is to keep a stream that can seek
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			return nil, 0, err
+		}
+		c.add(id, data)
+		return newBytesStream(data)
+	}
+	if size > int64(c.maxBytes) {
+		return stream, size, nil
+	}
+	return &cachingStream{ReadCloser: stream, cache: c, id: id}, size, nil
+}
+
+// SubscribeHead returns a channel emitting every new head of the IPNI chain, until ctx is done.
+func (c *CachingReader) SubscribeHead(ctx context.Context) (<-chan cid.Cid, error) {
+	return c.inner.SubscribeHead(ctx)
+}
+
+// Close stops receiving the head notifications of the inner reader.
+func (c *CachingReader) Close() error {
+	c.watchOnce.Do(func() { close(c.done) })
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *CachingReader) get(id cid.Cid) ([]byte, bool) {
+	c.mu.Lock()
+	data, ok := c.blocks.Get(id)
+	c.mu.Unlock()
+	c.metrics.recordCacheRequest("block", ok)
+	return data, ok
+}
+
+func (c *CachingReader) add(id cid.Cid, data []byte) {
+	if len(data) == 0 || len(data) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blocks.Contains(id) {
+		return
+	}
+	c.blocks.Add(id, data)
+	c.size += len(data)
+	for c.size > c.maxBytes {
+		c.blocks.RemoveOldest()
+	}
+}
+
+// cachingStream is a stream of a block, caching it once completely read.
+type cachingStream struct {
+	io.ReadCloser
+	cache    *CachingReader
+	id       cid.Cid
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (s *cachingStream) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if !s.overflow {
+		if s.buf.Len()+n > s.cache.maxBytes {
+			s.overflow = true
+			s.buf = bytes.Buffer{}
+		} else {
+			s.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !s.overflow {
+		s.cache.add(s.id, bytes.Clone(s.buf.Bytes()))
+	}
+	return n, err
+}
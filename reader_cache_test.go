@@ -0,0 +1,91 @@
+package herald
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// countingReader counts the reads of a ChainReader.
+type countingReader struct {
+	ChainReader
+	heads, contents atomic.Int32
+}
+
+func (c *countingReader) GetHead(ctx context.Context) (cid.Cid, error) {
+	c.heads.Add(1)
+	return c.ChainReader.GetHead(ctx)
+}
+
+func (c *countingReader) GetContent(ctx context.Context, id cid.Cid) ([]byte, error) {
+	c.contents.Add(1)
+	return c.ChainReader.GetContent(ctx, id)
+}
+
+func TestCachingReader(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+	head, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(10)...))
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(reg)
+	require.NoError(t, err)
+	inner := &countingReader{ChainReader: backend}
+	cache := NewCachingReader(inner, 1<<20, WithCachingReaderMetrics(metrics))
+	defer cache.Close()
+
+	// the chain is read once from the backend, whatever the number of syncs
+	var blocks int
+	for i := 0; i < 3; i++ {
+		got, err := cache.GetHead(ctx)
+		require.NoError(t, err)
+		require.Equal(t, head, got)
+		report, err := VerifyChain(ctx, cache, VerifyOptions{PublisherID: cfg.PublisherID})
+		require.NoError(t, err)
+		require.True(t, report.OK(), report.Issues)
+		if i == 0 {
+			blocks = int(inner.contents.Load())
+		}
+	}
+	require.Equal(t, int32(blocks), inner.contents.Load())
+	require.Equal(t, int32(1), inner.heads.Load())
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.cacheRequests.WithLabelValues("head", "miss")))
+	require.Greater(t, testutil.ToFloat64(metrics.cacheRequests.WithLabelValues("head", "hit")), float64(1))
+	require.Equal(t, float64(blocks), testutil.ToFloat64(metrics.cacheRequests.WithLabelValues("block", "miss")))
+
+	// the streams come from the cache as well
+	stream, size, err := cache.GetContentStream(ctx, head)
+	require.NoError(t, err)
+	data, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), size)
+	require.Equal(t, int32(blocks), inner.contents.Load())
+
+	// a new head replaces the cached one
+	newHead, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(1)...))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		got, err := cache.GetHead(ctx)
+		return err == nil && got == newHead
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(1), inner.heads.Load())
+
+	// the cache is bounded in bytes
+	small := NewCachingReader(backend, len(data))
+	defer small.Close()
+	_, err = small.GetContent(ctx, head)
+	require.NoError(t, err)
+	_, err = small.GetContent(ctx, newHead)
+	require.NoError(t, err)
+	require.LessOrEqual(t, small.size, len(data))
+	require.Equal(t, 1, small.blocks.Len())
+}
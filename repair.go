@@ -0,0 +1,197 @@
+package herald
+
+import (
+	"context"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// OrphanReport lists the blocks of a backend that are not reachable from the head of the chain.
+type OrphanReport struct {
+	// Ads are the orphan advertisements, ordered such that an advertisement comes after its previous one.
+	Ads []cid.Cid
+
+	// Blocks are the other unreachable blocks: entry chunks of orphan advertisements, or unknown content.
+	Blocks []cid.Cid
+}
+
+// FindOrphans scans the backend for blocks not reachable from the current head, which can happen for example after
+// a crashed or conflicting head update.
+//
+// The blocks of a publication in flight, stored but not linked from the head yet, can't be told apart from the
+// orphans. FindOrphans and DeleteOrphans must only be used while nothing is published to the backend.
+func FindOrphans(ctx context.Context, reader ChainReader, blocks BlockManager, opts ...OperationOption) (*OrphanReport, error) {
+	reachable, err := reachableBlocks(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var unreachable []cid.Cid
+	err = blocks.ForEachBlock(ctx, func(c cid.Cid) error {
+		if _, ok := reachable[c]; !ok {
+			unreachable = append(unreachable, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &OrphanReport{}
+	ads := make(map[cid.Cid]schema.Advertisement)
+	for _, c := range unreachable {
+		data, err := reader.GetContent(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if ad, err := schema.BytesToAdvertisement(c, data); err == nil {
+			ads[c] = ad
+		} else {
+			report.Blocks = append(report.Blocks, c)
+		}
+	}
+	report.Ads = orderAds(ads)
+
+	operationLogger(opts).Infow("Scanned backend for orphans", "reachable", len(reachable), "orphanAds", len(report.Ads), "orphanBlocks", len(report.Blocks))
+	return report, nil
+}
+
+// RelinkOrphans republishes the given orphan advertisements on top of the current head, under a single head update.
+// Advertisements being immutable, new advertisements are signed with cfg, preserving the ContextID, metadata,
+// addresses, entries and retraction semantic of the orphans. Their entry chunks are reused as-is.
+func RelinkOrphans(ctx context.Context, cfg ChainConfig, backend ChainWriter, reader ChainReader, ads []cid.Cid) (cid.Cid, error) {
+	var newHead cid.Cid
+	err := backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
+		for _, adCid := range ads {
+			ad, err := LoadAdvertisement(ctx, reader, adCid)
+			if err != nil {
+				return cid.Undef, err
+			}
+			adCfg := cfg
+			adCfg.Metadata = ad.Metadata
+			adCfg.ProviderAddrs = ad.Addresses
+			head, err = storeAdvertisement(ctx, adCfg, backend, head, ad.ContextID, ad.Entries, ad.IsRm)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		newHead = head
+		return newHead, nil
+	})
+	if err != nil {
+		return cid.Undef, err
+	}
+	cfg.logger().Infow("Relinked orphan advertisements", "count", len(ads), "head", newHead)
+	return newHead, nil
+}
+
+// DeleteOrphans removes from the backend the blocks listed in the report, as found by FindOrphans, which must not
+// run concurrently with a publication.
+// If blocks is also a ChainReader, the blocks that became reachable since the report are kept: right before each
+// deletion, the advertisements added to the chain since the last check are walked. In particular, DeleteOrphans can
+// be used after RelinkOrphans, which makes the relinked advertisements and their entry chunks reachable again.
+func DeleteOrphans(ctx context.Context, blocks BlockManager, report *OrphanReport, opts ...OperationOption) error {
+	var reach *reachability
+	if reader, ok := blocks.(ChainReader); ok {
+		reach = newReachability(reader)
+	}
+	return deleteOrphans(ctx, blocks, report, reach, operationLogger(opts))
+}
+
+// deleteOrphans is DeleteOrphans, checking the reachability of each block with reach, if not nil.
+func deleteOrphans(ctx context.Context, blocks BlockManager, report *OrphanReport, reach *reachability, logger *zap.SugaredLogger) error {
+	var deleted, kept int
+	for _, list := range [][]cid.Cid{report.Ads, report.Blocks} {
+		for _, c := range list {
+			if reach != nil {
+				if err := reach.update(ctx); err != nil {
+					return err
+				}
+				if _, ok := reach.reachable[c]; ok {
+					kept++
+					continue
+				}
+			}
+			if err := blocks.DeleteBlock(ctx, c); err != nil {
+				return err
+			}
+			deleted++
+		}
+	}
+	logger.Infow("Deleted orphan blocks", "count", deleted, "reachableAgain", kept)
+	return nil
+}
+
+// reachability tracks the advertisements and entries blocks reachable from the head, as the head moves.
+type reachability struct {
+	reader    ChainReader
+	head      cid.Cid
+	reachable map[cid.Cid]struct{}
+}
+
+func newReachability(reader ChainReader) *reachability {
+	return &reachability{reader: reader, reachable: make(map[cid.Cid]struct{})}
+}
+
+// update adds the blocks of the advertisements added since the last update, walking from the current head until an
+// advertisement already known to be reachable.
+func (r *reachability) update(ctx context.Context) error {
+	head, err := r.reader.GetHead(ctx)
+	if err != nil {
+		return err
+	}
+	if head.Equals(r.head) {
+		return nil
+	}
+	err = WalkAdsFrom(ctx, r.reader, head, func(adCid cid.Cid, ad schema.Advertisement) error {
+		if _, ok := r.reachable[adCid]; ok {
+			return ErrStopWalk
+		}
+		r.reachable[adCid] = struct{}{}
+		if _, ok := r.reachable[linkCid(ad.Entries)]; ok {
+			// entries shared between advertisements have already been visited
+			return nil
+		}
+		return WalkEntryBlocks(ctx, r.reader, ad.Entries, func(blockCid cid.Cid, _ []byte, _ []multihash.Multihash) error {
+			r.reachable[blockCid] = struct{}{}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	r.head = head
+	return nil
+}
+
+// reachableBlocks returns the set of advertisements and entries blocks reachable from the head.
+func reachableBlocks(ctx context.Context, reader ChainReader) (map[cid.Cid]struct{}, error) {
+	r := newReachability(reader)
+	err := r.update(ctx)
+	return r.reachable, err
+}
+
+// orderAds orders the advertisements such that an advertisement comes after its previous one, if also present.
+func orderAds(ads map[cid.Cid]schema.Advertisement) []cid.Cid {
+	children := make(map[cid.Cid][]cid.Cid)
+	var queue []cid.Cid
+	for c, ad := range ads {
+		prev := ad.PreviousCid()
+		if _, ok := ads[prev]; ok {
+			children[prev] = append(children[prev], c)
+		} else {
+			queue = append(queue, c)
+		}
+	}
+	res := make([]cid.Cid, 0, len(ads))
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		res = append(res, c)
+		queue = append(queue, children[c]...)
+	}
+	return res
+}
@@ -0,0 +1,81 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrphans(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	orphan, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(8)[5:], id: []byte("foo")})
+	require.NoError(t, err)
+
+	// simulate a lost head update: the last advertisement becomes unreachable
+	err = backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		return first, nil
+	})
+	require.NoError(t, err)
+
+	report, err := FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{orphan}, report.Ads)
+	// the entry chunks of the orphan
+	require.Len(t, report.Blocks, 2)
+
+	newHead, err := RelinkOrphans(ctx, cfg, backend, backend, report.Ads)
+	require.NoError(t, err)
+	ad, err := LoadAdvertisement(ctx, backend, newHead)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), ad.ContextID)
+	require.Equal(t, first, ad.PreviousCid())
+
+	// the relinked advertisement has the same content and previous advertisement, so it is actually the same block
+	require.Equal(t, orphan, newHead)
+	report, err = FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+	require.Empty(t, report.Ads)
+	require.Empty(t, report.Blocks)
+
+	// lose the head update again, and delete the orphans this time
+	err = backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		return first, nil
+	})
+	require.NoError(t, err)
+	report, err = FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+
+	// the in-flight publication lands before the deletion: its blocks are reachable again, and kept
+	_, err = RelinkOrphans(ctx, cfg, backend, backend, report.Ads)
+	require.NoError(t, err)
+	require.NoError(t, DeleteOrphans(ctx, backend, report))
+	_, err = backend.GetContent(ctx, orphan)
+	require.NoError(t, err)
+	verify, err := VerifyChain(ctx, backend, VerifyOptions{})
+	require.NoError(t, err)
+	require.Empty(t, verify.Issues)
+
+	err = backend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		return first, nil
+	})
+	require.NoError(t, err)
+	report, err = FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+	require.NoError(t, DeleteOrphans(ctx, backend, report))
+	_, err = backend.GetContent(ctx, orphan)
+	require.ErrorIs(t, err, ErrContentNotFound)
+
+	report, err = FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+	require.Empty(t, report.Ads)
+	require.Empty(t, report.Blocks)
+}
@@ -0,0 +1,185 @@
+package herald
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ipni/go-libipni/find/client"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultRetractionSampleSize   = 20
+	DefaultRetractionPollInterval = 30 * time.Second
+	DefaultRetractionTimeout      = time.Hour
+)
+
+// RetractionVerifier checks that a retraction has propagated to an indexer, by polling its find API with a sample
+// of the retracted multihashes until none of them resolve to our provider anymore.
+type RetractionVerifier struct {
+	// Finder is the client of the indexer find API, typically created with client.New(indexerUrl).
+	Finder client.Finder
+
+	// ProviderID is the provider whose records must have disappeared.
+	ProviderID peer.ID
+
+	// SampleSize is the number of multihashes sampled from the retracted catalog. Defaults to DefaultRetractionSampleSize.
+	SampleSize int
+
+	// PollInterval is the interval between two rounds of queries. Defaults to DefaultRetractionPollInterval.
+	PollInterval time.Duration
+
+	// Timeout is the maximum time to wait for the propagation. Defaults to DefaultRetractionTimeout.
+	Timeout time.Duration
+
+	// OnReport, if set, is called with the outcome of each verification run in the background by a CatalogBatcher.
+	OnReport func(RetractionReport)
+
+	// Logger, if set, is used instead of the default logger, or of the logger of the CatalogBatcher running the
+	// verification.
+	Logger *zap.SugaredLogger
+}
+
+// RetractionReport is the outcome of a retraction verification.
+type RetractionReport struct {
+	// ContextID is the retracted ContextID, as published on the chain. Empty for a batched retraction.
+	ContextID []byte
+
+	// Sampled is the multihashes queried
+	Sampled []multihash.Multihash
+
+	// Remaining is the sampled multihashes still resolving to our provider when the verification ended
+	Remaining []multihash.Multihash
+
+	// Started and Ended delimit the verification
+	Started time.Time
+	Ended   time.Time
+
+	// Err is the last error returned by the indexer, if any
+	Err error
+}
+
+// Verified returns true if none of the sampled multihashes resolve to our provider anymore.
+func (r RetractionReport) Verified() bool {
+	return len(r.Remaining) == 0
+}
+
+// Sample picks uniformly at random up to SampleSize multihashes from the catalog.
+// The catalog is read entirely, so sampling should happen before the catalog's data is discarded.
+func (v *RetractionVerifier) Sample(ctx context.Context, catalog Catalog) ([]multihash.Multihash, error) {
+	size := v.SampleSize
+	if size <= 0 {
+		size = DefaultRetractionSampleSize
+	}
+	it, err := catalog.Iterator(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// reservoir sampling, as the catalog size is not always known
+	sample := make([]multihash.Multihash, 0, size)
+	for i := 0; !it.Done(); i++ {
+		mh := it.Next()
+		if i < size {
+			sample = append(sample, mh)
+		} else if j := rand.Intn(i + 1); j < size {
+			sample[j] = mh
+		}
+	}
+	if err := iteratorErr(it); err != nil {
+		return nil, err
+	}
+	return sample, ctx.Err()
+}
+
+// Verify polls the indexer until none of the given multihashes resolve to our provider, or the timeout expires.
+// If contextID is not empty, only the records with that ContextID are considered, as the same multihashes can
+// legitimately remain published under another ContextID.
+// A timeout is not an error: the report tells which multihashes still resolve.
+func (v *RetractionVerifier) Verify(ctx context.Context, contextID []byte, mhs []multihash.Multihash) RetractionReport {
+	return v.verify(ctx, contextID, mhs, orDefaultLogger(v.Logger))
+}
+
+func (v *RetractionVerifier) verify(ctx context.Context, contextID []byte, mhs []multihash.Multihash, logger *zap.SugaredLogger) RetractionReport {
+	interval := v.PollInterval
+	if interval <= 0 {
+		interval = DefaultRetractionPollInterval
+	}
+	timeout := v.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRetractionTimeout
+	}
+
+	report := RetractionReport{
+		ContextID: contextID,
+		Sampled:   mhs,
+		Remaining: mhs,
+		Started:   time.Now(),
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var remaining []multihash.Multihash
+		for _, mh := range report.Remaining {
+			resp, err := v.Finder.Find(ctx, mh)
+			if err != nil {
+				// keep it for the next round, the indexer might be temporarily unavailable
+				report.Err = err
+				remaining = append(remaining, mh)
+				continue
+			}
+			if v.resolves(resp, contextID) {
+				remaining = append(remaining, mh)
+			}
+		}
+		report.Remaining = remaining
+
+		if len(remaining) == 0 {
+			report.Err = nil
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			report.Err = ctx.Err()
+		case <-deadline.C:
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	report.Ended = time.Now()
+	if report.Verified() {
+		logger.Infow("Retraction verified", "contextID", contextID, "sampled", len(report.Sampled), "elapsed", report.Ended.Sub(report.Started))
+	} else {
+		logger.Warnw("Retraction not propagated", "contextID", contextID, "sampled", len(report.Sampled), "remaining", len(report.Remaining), "err", report.Err)
+	}
+	return report
+}
+
+// resolves returns true if the response holds a record of our provider.
+func (v *RetractionVerifier) resolves(resp *model.FindResponse, contextID []byte) bool {
+	if resp == nil {
+		return false
+	}
+	for _, mhr := range resp.MultihashResults {
+		for _, pr := range mhr.ProviderResults {
+			if pr.Provider == nil || pr.Provider.ID != v.ProviderID {
+				continue
+			}
+			if len(contextID) == 0 || bytes.Equal(pr.ContextID, contextID) {
+				return true
+			}
+		}
+	}
+	return false
+}
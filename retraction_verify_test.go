@@ -0,0 +1,161 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFinder resolves the multihashes to a provider, until they are removed.
+type fakeFinder struct {
+	provider  peer.ID
+	contextID []byte
+	records   map[string]struct{}
+	removed   atomic.Bool
+}
+
+func (f *fakeFinder) Find(_ context.Context, mh multihash.Multihash) (*model.FindResponse, error) {
+	if _, ok := f.records[string(mh)]; !ok || f.removed.Load() {
+		return &model.FindResponse{}, nil
+	}
+	return &model.FindResponse{MultihashResults: []model.MultihashResult{{
+		Multihash: mh,
+		ProviderResults: []model.ProviderResult{{
+			ContextID: f.contextID,
+			Provider:  &peer.AddrInfo{ID: f.provider},
+		}},
+	}}}, nil
+}
+
+func (f *fakeFinder) removeAll() {
+	f.removed.Store(true)
+}
+
+func TestRetractionVerifier(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	mhs := testMultihashes(50)
+
+	finder := &fakeFinder{provider: cfg.PublisherID, contextID: []byte("foo"), records: map[string]struct{}{}}
+	for _, mh := range mhs {
+		finder.records[string(mh)] = struct{}{}
+	}
+
+	verifier := &RetractionVerifier{
+		Finder:       finder,
+		ProviderID:   cfg.PublisherID,
+		SampleSize:   10,
+		PollInterval: 10 * time.Millisecond,
+		Timeout:      50 * time.Millisecond,
+	}
+
+	sample, err := verifier.Sample(ctx, CatalogFromMultihashes(mhs...))
+	require.NoError(t, err)
+	require.Len(t, sample, 10)
+
+	// not propagated: timeout
+	report := verifier.Verify(ctx, []byte("foo"), sample)
+	require.False(t, report.Verified())
+	require.Len(t, report.Remaining, 10)
+
+	// a record under another ContextID doesn't count
+	report = verifier.Verify(ctx, []byte("bar"), sample)
+	require.True(t, report.Verified())
+
+	// propagated while polling
+	verifier.Timeout = time.Second
+	time.AfterFunc(30*time.Millisecond, finder.removeAll)
+	report = verifier.Verify(ctx, []byte("foo"), sample)
+	require.True(t, report.Verified())
+	require.Len(t, report.Sampled, 10)
+	require.NoError(t, report.Err)
+
+	// a failing catalog fails the sample, rather than giving a short one
+	failing := CatalogFromIterator(nil, -1, func(context.Context) (multihash.Multihash, error) {
+		return nil, errors.New("broken")
+	})
+	_, err = verifier.Sample(ctx, failing)
+	require.ErrorContains(t, err, "broken")
+}
+
+func TestBatchingRetractionVerification(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	mhs := testMultihashes(20)
+
+	finder := &fakeFinder{provider: cfg.PublisherID, contextID: []byte("foo")}
+	reports := make(chan RetractionReport, 1)
+
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 100,
+		MaxDelay:               time.Second,
+		RetractionVerifier: &RetractionVerifier{
+			Finder:       finder,
+			ProviderID:   cfg.PublisherID,
+			PollInterval: 10 * time.Millisecond,
+			OnReport:     func(r RetractionReport) { reports <- r },
+		},
+	}, cfg, NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore())), nilAnnouncer{})
+
+	err := batcher.PublishCatalog(ctx, testCatalog{MhCatalog: mhs, id: []byte("foo")})
+	require.NoError(t, err)
+	err = batcher.RetractCatalog(ctx, testCatalog{MhCatalog: mhs, id: []byte("foo")})
+	require.NoError(t, err)
+
+	select {
+	case report := <-reports:
+		require.True(t, report.Verified())
+		require.Equal(t, []byte("foo"), report.ContextID)
+		require.Len(t, report.Sampled, DefaultRetractionSampleSize)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no retraction report")
+	}
+}
+
+func TestBatchingRetractionVerificationClose(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	mhs := testMultihashes(20)
+
+	// never propagated, the verification runs until the batcher is closed
+	finder := &fakeFinder{provider: cfg.PublisherID, contextID: []byte("foo"), records: map[string]struct{}{}}
+	for _, mh := range mhs {
+		finder.records[string(mh)] = struct{}{}
+	}
+	reports := make(chan RetractionReport, 1)
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: 100,
+		MaxDelay:               time.Second,
+		RetractionVerifier: &RetractionVerifier{
+			Finder:       finder,
+			ProviderID:   cfg.PublisherID,
+			PollInterval: 10 * time.Millisecond,
+			OnReport:     func(r RetractionReport) { reports <- r },
+		},
+	}, cfg, NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore())), nilAnnouncer{})
+
+	require.NoError(t, batcher.PublishCatalog(ctx, testCatalog{MhCatalog: mhs, id: []byte("foo")}))
+	require.NoError(t, batcher.RetractCatalog(ctx, testCatalog{MhCatalog: mhs, id: []byte("foo")}))
+
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, batcher.Close(closeCtx))
+	select {
+	case report := <-reports:
+		require.False(t, report.Verified())
+		require.ErrorIs(t, report.Err, context.Canceled)
+	default:
+		t.Fatal("Close returned before the verification")
+	}
+}
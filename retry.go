@@ -0,0 +1,117 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultRetryPolicy is a reasonable RetryPolicy for transient failures of remote services (S3, indexers ...).
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// RetryPolicy describes how an operation is retried on failure.
+// The zero value performs a single attempt, without any retry.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one.
+	// A value <= 1 means no retry.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the upper bound of the delay between two attempts. Zero means no bound.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor applied to the delay after each attempt. A value < 1 is treated as 1.
+	Multiplier float64
+
+	// Jitter is the fraction of the delay that is randomized, in [0, 1], to avoid synchronized retries.
+	Jitter float64
+
+	// Retryable classifies an error as retryable or not. If nil, every error is retryable except the
+	// cancellation or expiration of the context.
+	Retryable func(err error) bool
+
+	// Logger, if set, logs the retries instead of the default logger. The components given a policy without logger
+	// set their own.
+	Logger *zap.SugaredLogger
+}
+
+// Do executes fn, retrying according to the policy. It returns the error of the last attempt.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := p.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts || !p.isRetryable(err) {
+			return err
+		}
+
+		delay := p.jittered(backoff)
+		orDefaultLogger(p.Logger).Debugw("operation failed, retrying", "err", err, "attempt", attempt, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		backoff = p.next(backoff)
+	}
+}
+
+// withLogger returns the policy logging with l, unless it has its own logger.
+func (p RetryPolicy) withLogger(l *zap.SugaredLogger) RetryPolicy {
+	if p.Logger == nil {
+		p.Logger = l
+	}
+	return p
+}
+
+func (p RetryPolicy) isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return true
+}
+
+func (p RetryPolicy) next(backoff time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	backoff = time.Duration(float64(backoff) * multiplier)
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+func (p RetryPolicy) jittered(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	// spread uniformly in [backoff*(1-jitter), backoff*(1+jitter)]
+	delta := (rand.Float64()*2 - 1) * jitter * float64(backoff)
+	return backoff + time.Duration(delta)
+}
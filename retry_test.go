@@ -0,0 +1,61 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+
+	// succeed after transient failures
+	var attempts int
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+
+	// give up after MaxAttempts
+	attempts = 0
+	err = policy.Do(ctx, func(ctx context.Context) error {
+		attempts++
+		return errors.New("permanent")
+	})
+	require.Error(t, err)
+	require.Equal(t, 3, attempts)
+
+	// don't retry non-retryable errors
+	errFatal := errors.New("fatal")
+	policy.Retryable = func(err error) bool { return !errors.Is(err, errFatal) }
+	attempts = 0
+	err = policy.Do(ctx, func(ctx context.Context) error {
+		attempts++
+		return errFatal
+	})
+	require.ErrorIs(t, err, errFatal)
+	require.Equal(t, 1, attempts)
+
+	// zero value doesn't retry
+	attempts = 0
+	err = RetryPolicy{}.Do(ctx, func(ctx context.Context) error {
+		attempts++
+		return errors.New("fail")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
@@ -0,0 +1,120 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+// Rollback is the result of a head rollback.
+type Rollback struct {
+	// PreviousHead is the head before the rollback.
+	PreviousHead cid.Cid
+	// Head is the new head.
+	Head cid.Cid
+	// Dropped are the advertisements dropped from the chain, most recent first.
+	Dropped []cid.Cid
+}
+
+// RollbackHead drops the n most recent advertisements of the chain, by moving the head back to their previous
+// advertisement. See SetHead.
+func RollbackHead(ctx context.Context, backend ChainWriter, reader ChainReader, n int, opts ...OperationOption) (*Rollback, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of advertisements to roll back: %d", n)
+	}
+	return rollback(ctx, backend, reader, func(dropped []cid.Cid, _ cid.Cid) bool {
+		return len(dropped) == n
+	}, operationLogger(opts))
+}
+
+// SetHead moves the head of the chain back to target, which must be an advertisement of the current chain, dropping
+// the more recent advertisements. The head pointer is signed again by the backend.
+//
+// The dropped blocks are kept, see Rollback.DeleteDropped. Indexers that already synced the dropped advertisements
+// keep them: their effect must be undone with new advertisements, for example retractions, published on top of the
+// new head.
+func SetHead(ctx context.Context, backend ChainWriter, reader ChainReader, target cid.Cid, opts ...OperationOption) (*Rollback, error) {
+	if !target.Defined() {
+		return nil, errors.New("undefined target advertisement")
+	}
+	if _, err := LoadAdvertisement(ctx, reader, target); err != nil {
+		return nil, fmt.Errorf("invalid target advertisement %s: %w", target, err)
+	}
+	return rollback(ctx, backend, reader, func(_ []cid.Cid, adCid cid.Cid) bool {
+		return adCid == target
+	}, operationLogger(opts))
+}
+
+// rollback walks the chain from the head, dropping the advertisements until reached returns true for the new head.
+func rollback(ctx context.Context, backend ChainWriter, reader ChainReader, reached func(dropped []cid.Cid, adCid cid.Cid) bool, logger *zap.SugaredLogger) (*Rollback, error) {
+	res := &Rollback{}
+	err := backend.UpdateHead(ctx, func(head cid.Cid) (cid.Cid, error) {
+		res.PreviousHead, res.Head, res.Dropped = head, cid.Undef, nil
+		err := WalkAdsFrom(ctx, reader, head, func(adCid cid.Cid, ad schema.Advertisement) error {
+			if reached(res.Dropped, adCid) {
+				res.Head = adCid
+				return ErrStopWalk
+			}
+			res.Dropped = append(res.Dropped, adCid)
+			return nil
+		})
+		if err != nil {
+			return cid.Undef, err
+		}
+		if !res.Head.Defined() {
+			return cid.Undef, errors.New("rollback target not found in the chain")
+		}
+		return res.Head, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Infow("Rolled back the head", "previousHead", res.PreviousHead, "head", res.Head, "dropped", len(res.Dropped))
+	return res, nil
+}
+
+// DeleteDropped removes from the backend the dropped advertisements and their entry chunks, except the chunks still
+// reachable from the head of the chain.
+func (r *Rollback) DeleteDropped(ctx context.Context, reader ChainReader, blocks BlockManager, opts ...OperationOption) error {
+	reachable, err := reachableBlocks(ctx, reader)
+	if err != nil {
+		return err
+	}
+	var deleted int
+	for _, adCid := range r.Dropped {
+		if _, ok := reachable[adCid]; ok {
+			// published again since the rollback
+			continue
+		}
+		ad, err := LoadAdvertisement(ctx, reader, adCid)
+		if errors.Is(err, ErrContentNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var chunks []cid.Cid
+		err = WalkEntryBlocks(ctx, reader, ad.Entries, func(blockCid cid.Cid, _ []byte, _ []multihash.Multihash) error {
+			if _, ok := reachable[blockCid]; !ok {
+				chunks = append(chunks, blockCid)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrContentNotFound) {
+			return err
+		}
+		for _, c := range append(chunks, adCid) {
+			if err := blocks.DeleteBlock(ctx, c); err != nil {
+				return err
+			}
+			deleted++
+		}
+	}
+	operationLogger(opts).Infow("Deleted the dropped blocks", "count", deleted)
+	return nil
+}
@@ -0,0 +1,88 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollbackHead(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(5)...))
+	require.NoError(t, err)
+	second, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(8)[5:], id: []byte("foo")})
+	require.NoError(t, err)
+	third, err := RetractWithContextID(ctx, cfg, backend, testCatalog{id: []byte("foo")})
+	require.NoError(t, err)
+
+	_, err = RollbackHead(ctx, backend, backend, 3)
+	require.Error(t, err)
+	_, err = SetHead(ctx, backend, backend, cid.Undef)
+	require.Error(t, err)
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, third, head)
+
+	rollback, err := RollbackHead(ctx, backend, backend, 2)
+	require.NoError(t, err)
+	require.Equal(t, &Rollback{PreviousHead: third, Head: first, Dropped: []cid.Cid{third, second}}, rollback)
+	head, err = backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, first, head)
+
+	// the dropped advertisement is not part of the chain anymore
+	_, err = SetHead(ctx, backend, backend, second)
+	require.Error(t, err)
+
+	require.NoError(t, rollback.DeleteDropped(ctx, backend, backend))
+	_, err = backend.GetContent(ctx, second)
+	require.ErrorIs(t, err, ErrContentNotFound)
+	report, err := FindOrphans(ctx, backend, backend)
+	require.NoError(t, err)
+	require.Empty(t, report.Ads)
+	require.Empty(t, report.Blocks)
+
+	// the remaining chain is intact
+	count := 0
+	require.NoError(t, WalkAds(ctx, backend, func(_ cid.Cid, _ schema.Advertisement) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, 1, count)
+}
+
+func TestSetHead(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	first, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(2)...))
+	require.NoError(t, err)
+	second, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(4)[2:]...))
+	require.NoError(t, err)
+
+	// an entry chunk is not an advertisement
+	ad, err := LoadAdvertisement(ctx, backend, second)
+	require.NoError(t, err)
+	_, err = SetHead(ctx, backend, backend, linkCid(ad.Entries))
+	require.Error(t, err)
+
+	rollback, err := SetHead(ctx, backend, backend, first)
+	require.NoError(t, err)
+	require.Equal(t, []cid.Cid{second}, rollback.Dropped)
+
+	// publishing continues on top of the new head
+	third, err := PublishRawMHs(ctx, cfg, backend, CatalogFromMultihashes(testMultihashes(6)[4:]...))
+	require.NoError(t, err)
+	ad, err = LoadAdvertisement(ctx, backend, third)
+	require.NoError(t, err)
+	require.Equal(t, first, ad.PreviousCid())
+}
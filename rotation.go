@@ -0,0 +1,162 @@
+package herald
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipni/go-libipni/announce"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"go.uber.org/zap"
+)
+
+type RotationOptions struct {
+	// AllowPartial allows the rotation to proceed when the old chain can't be fully read, which is expected when
+	// rotating away from a corrupted chain. Only the contexts found before the first unreadable block are carried over.
+	AllowPartial bool
+
+	// Announcer, if not nil, is used to announce the head of the new chain.
+	Announcer announce.Sender
+}
+
+// Rotation is the result of a chain rotation.
+type Rotation struct {
+	// NewHead is the head of the new chain
+	NewHead cid.Cid
+
+	// Contexts is the number of ContextIDs carried over to the new chain
+	Contexts int
+
+	// RawAds is the number of advertisements without ContextID carried over to the new chain
+	RawAds int
+
+	// ReadErr is the error that interrupted the reading of the old chain, when AllowPartial is set
+	ReadErr error
+
+	oldHead cid.Cid
+	logger  *zap.SugaredLogger
+}
+
+// RotateChain starts a brand-new chain in newBackend, with a new genesis, republishing all the contexts still active
+// on the old chain. This is the recovery path from an irreparably corrupted chain.
+//
+// The active contexts are found by walking the old chain: each ContextID is carried over in its latest published
+// state, while the advertisements without ContextID are replayed in their original order. The entry chunks are copied
+// to the new backend.
+//
+// newBackend must not hold a chain already. Once the new chain is exposed by the publisher, the old chain can be
+// deleted with Rotation.CleanupOld or Rotation.ScheduleCleanup.
+func RotateChain(ctx context.Context, cfg ChainConfig, old ChainReader, newBackend ChainWriter, opts RotationOptions) (*Rotation, error) {
+	oldHead, err := old.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg = cfg.withLogFields(nil)
+	rotation := &Rotation{oldHead: oldHead, logger: cfg.logger()}
+
+	// collect the advertisements to carry over, newest first
+	var carried []schema.Advertisement
+	seen := make(map[string]struct{})
+	err = WalkAds(ctx, old, func(_ cid.Cid, ad schema.Advertisement) error {
+		if len(ad.ContextID) == 0 {
+			carried = append(carried, ad)
+			rotation.RawAds++
+			return nil
+		}
+		if _, ok := seen[string(ad.ContextID)]; ok {
+			return nil
+		}
+		seen[string(ad.ContextID)] = struct{}{}
+		if !ad.IsRm && linkCid(ad.Entries) != schema.NoEntries.Cid {
+			carried = append(carried, ad)
+			rotation.Contexts++
+		}
+		return nil
+	})
+	if err != nil {
+		if !opts.AllowPartial {
+			return nil, fmt.Errorf("failed to read the old chain: %w", err)
+		}
+		cfg.logger().Warnw("old chain is only partially readable, proceeding with a partial rotation", "err", err)
+		rotation.ReadErr = err
+	}
+
+	// copy the entries first, outside the head update
+	entries := make([]ipld.Link, len(carried))
+	for i, ad := range carried {
+		entries[i], err = copyEntries(ctx, cfg.linkPrototype(), old, newBackend, ad.Entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = newBackend.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		if prevHead.Defined() {
+			return cid.Undef, errors.New("the new backend already holds a chain")
+		}
+		head := cid.Undef
+		// oldest first
+		for i := len(carried) - 1; i >= 0; i-- {
+			ad := carried[i]
+			adCfg := cfg
+			adCfg.Metadata = ad.Metadata
+			head, err = storeAdvertisement(ctx, adCfg, newBackend, head, ad.ContextID, entries[i], ad.IsRm)
+			if err != nil {
+				return cid.Undef, err
+			}
+		}
+		if !head.Defined() {
+			return cid.Undef, errors.New("nothing to carry over to the new chain")
+		}
+		rotation.NewHead = head
+		return head, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.logger().Infow("Rotated chain", "oldHead", oldHead, "newHead", rotation.NewHead, "contexts", rotation.Contexts, "rawAds", rotation.RawAds)
+
+	if opts.Announcer != nil {
+		if err := announce.Send(ctx, rotation.NewHead, cfg.PublisherAddrs(), opts.Announcer); err != nil {
+			return rotation, fmt.Errorf("new chain created but failed to announce it: %w", err)
+		}
+	}
+	return rotation, nil
+}
+
+// CleanupOld deletes all the blocks of the old chain.
+// It must only be used once the publisher exposes the new chain, and the old backend is not used anymore.
+func (r *Rotation) CleanupOld(ctx context.Context, old BlockManager) error {
+	// list first, as not all backends support deleting while iterating
+	var blocks []cid.Cid
+	err := old.ForEachBlock(ctx, func(c cid.Cid) error {
+		blocks = append(blocks, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range blocks {
+		if err := old.DeleteBlock(ctx, c); err != nil {
+			return err
+		}
+	}
+	r.logger.Infow("Deleted the old chain", "oldHead", r.oldHead, "blocks", len(blocks))
+	return nil
+}
+
+// ScheduleCleanup runs CleanupOld after the given delay, giving time to the indexers to sync the new chain.
+// The returned timer can be used to cancel the cleanup.
+func (r *Rotation) ScheduleCleanup(old BlockManager, after time.Duration) *time.Timer {
+	return time.AfterFunc(after, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		if err := r.CleanupOld(ctx, old); err != nil {
+			r.logger.Errorw("failed to cleanup the old chain", "oldHead", r.oldHead, "err", err)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package herald
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateChain(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	old := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+
+	mhs := testMultihashes(10)
+	_, err := PublishWithContextID(ctx, cfg, old, testCatalog{MhCatalog: mhs[:3], id: []byte("a")})
+	require.NoError(t, err)
+	_, err = PublishWithContextID(ctx, cfg, old, testCatalog{MhCatalog: mhs[3:6], id: []byte("b")})
+	require.NoError(t, err)
+	_, err = PublishRawMHs(ctx, cfg, old, CatalogFromMultihashes(mhs[6:]...))
+	require.NoError(t, err)
+	_, err = RetractWithContextID(ctx, cfg, old, testCatalog{id: []byte("a")})
+	require.NoError(t, err)
+
+	newBackend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	rotation, err := RotateChain(ctx, cfg, old, newBackend, RotationOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 1, rotation.Contexts)
+	require.Equal(t, 1, rotation.RawAds)
+
+	var ads []schema.Advertisement
+	err = WalkAds(ctx, newBackend, func(_ cid.Cid, ad schema.Advertisement) error {
+		ads = append(ads, ad)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, ads, 2)
+	// newest first: the raw multihashes were published after "b"
+	require.Empty(t, ads[0].ContextID)
+	require.Equal(t, []byte("b"), ads[1].ContextID)
+
+	// the new backend already has a chain
+	_, err = RotateChain(ctx, cfg, old, newBackend, RotationOptions{})
+	require.Error(t, err)
+
+	require.NoError(t, rotation.CleanupOld(ctx, old))
+	var remaining int
+	require.NoError(t, old.ForEachBlock(ctx, func(c cid.Cid) error {
+		remaining++
+		return nil
+	}))
+	require.Zero(t, remaining)
+}
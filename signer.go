@@ -0,0 +1,74 @@
+package herald
+
+import (
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+)
+
+// Signer signs the advertisements and the heads on behalf of the publisher, without necessarily holding the private
+// key in memory, for example with a KMS or an HSM.
+type Signer interface {
+	// PublicKey returns the public key verifying the signatures.
+	PublicKey() crypto.PubKey
+	// Sign signs data, producing the same signature format as crypto.PrivKey.Sign for the type of PublicKey.
+	Sign(data []byte) ([]byte, error)
+}
+
+// KeySigner returns a Signer using the private key in memory.
+func KeySigner(key crypto.PrivKey) Signer {
+	return keySigner{key: key}
+}
+
+type keySigner struct {
+	key crypto.PrivKey
+}
+
+func (k keySigner) PublicKey() crypto.PubKey {
+	return k.key.GetPublic()
+}
+
+func (k keySigner) Sign(data []byte) ([]byte, error) {
+	return k.key.Sign(data)
+}
+
+// errNoRawKey is returned when the raw private key of a Signer is requested.
+var errNoRawKey = errors.New("the private key of a Signer is not available")
+
+var _ crypto.PrivKey = signerKey{}
+
+// signerKey exposes a Signer as a crypto.PrivKey, as expected by the signing functions of go-libipni, which only use
+// Sign and GetPublic.
+type signerKey struct {
+	signer Signer
+}
+
+// signerPrivKey returns a crypto.PrivKey signing with signer.
+func signerPrivKey(signer Signer) crypto.PrivKey {
+	if k, ok := signer.(keySigner); ok {
+		return k.key
+	}
+	return signerKey{signer: signer}
+}
+
+func (s signerKey) Sign(data []byte) ([]byte, error) {
+	return s.signer.Sign(data)
+}
+
+func (s signerKey) GetPublic() crypto.PubKey {
+	return s.signer.PublicKey()
+}
+
+func (s signerKey) Equals(other crypto.Key) bool {
+	o, ok := other.(crypto.PrivKey)
+	return ok && s.signer.PublicKey().Equals(o.GetPublic())
+}
+
+func (s signerKey) Raw() ([]byte, error) {
+	return nil, errNoRawKey
+}
+
+func (s signerKey) Type() pb.KeyType {
+	return s.signer.PublicKey().Type()
+}
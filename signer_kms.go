@@ -0,0 +1,83 @@
+package herald
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+var _ Signer = &KMSSigner{}
+
+// KMSAPI is the subset of the AWS KMS client used by KMSSigner.
+type KMSAPI interface {
+	GetPublicKey(ctx context.Context, params *kms.GetPublicKeyInput, optFns ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	Sign(ctx context.Context, params *kms.SignInput, optFns ...func(*kms.Options)) (*kms.SignOutput, error)
+}
+
+// kmsSignTimeout bounds a signing request, as Signer.Sign has no context.
+const kmsSignTimeout = 30 * time.Second
+
+// KMSSigner is a Signer whose private key stays in AWS KMS. The key must be an asymmetric SIGN_VERIFY key, either
+// ECC_NIST_P256 or RSA_2048 and above, whose signatures with SHA-256 are the ones of the libp2p keys of the same type.
+type KMSSigner struct {
+	client    KMSAPI
+	keyID     *string
+	algorithm types.SigningAlgorithmSpec
+	publicKey crypto.PubKey
+}
+
+// NewKMSSigner creates a KMSSigner with the key keyID, which can be a key ID, a key ARN or an alias. The public key is
+// fetched once, here.
+func NewKMSSigner(ctx context.Context, client KMSAPI, keyID string) (*KMSSigner, error) {
+	out, err := client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the public key of %s: %w", keyID, err)
+	}
+	if out.KeyUsage != types.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("key %s is not a signing key: %s", keyID, out.KeyUsage)
+	}
+
+	s := &KMSSigner{client: client, keyID: aws.String(keyID)}
+	switch out.KeySpec {
+	case types.KeySpecEccNistP256:
+		s.algorithm = types.SigningAlgorithmSpecEcdsaSha256
+		s.publicKey, err = crypto.UnmarshalECDSAPublicKey(out.PublicKey)
+	case types.KeySpecRsa2048, types.KeySpecRsa3072, types.KeySpecRsa4096:
+		s.algorithm = types.SigningAlgorithmSpecRsassaPkcs1V15Sha256
+		s.publicKey, err = crypto.UnmarshalRsaPublicKey(out.PublicKey)
+	default:
+		return nil, fmt.Errorf("unsupported key spec %s of key %s", out.KeySpec, keyID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key of %s: %w", keyID, err)
+	}
+	return s, nil
+}
+
+// PublicKey returns the public key of the KMS key.
+func (s *KMSSigner) PublicKey() crypto.PubKey {
+	return s.publicKey
+}
+
+// Sign signs the SHA-256 digest of data with KMS, which avoids the size limit of the messages signed by KMS.
+func (s *KMSSigner) Sign(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsSignTimeout)
+	defer cancel()
+	digest := sha256.Sum256(data)
+	out, err := s.client.Sign(ctx, &kms.SignInput{
+		KeyId:            s.keyID,
+		Message:          digest[:],
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: s.algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Signature, nil
+}
@@ -0,0 +1,113 @@
+package herald
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS is a KMS holding a single P-256 key, accepting only digests.
+type fakeKMS struct {
+	key   *ecdsa.PrivateKey
+	signs int
+}
+
+func (f *fakeKMS) GetPublicKey(_ context.Context, _ *kms.GetPublicKeyInput, _ ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GetPublicKeyOutput{
+		KeySpec:   types.KeySpecEccNistP256,
+		KeyUsage:  types.KeyUsageTypeSignVerify,
+		PublicKey: der,
+	}, nil
+}
+
+func (f *fakeKMS) Sign(_ context.Context, params *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+	if params.MessageType != types.MessageTypeDigest || params.SigningAlgorithm != types.SigningAlgorithmSpecEcdsaSha256 {
+		return nil, errors.New("unexpected signing request")
+	}
+	f.signs++
+	sig, err := ecdsa.SignASN1(rand.Reader, f.key, params.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.SignOutput{Signature: sig}, nil
+}
+
+func TestKMSSigner(t *testing.T) {
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	fake := &fakeKMS{key: key}
+	signer, err := NewKMSSigner(ctx, fake, "alias/herald")
+	require.NoError(t, err)
+
+	cfg := testChainConfig(t)
+	cfg.PublisherKey = nil
+	cfg.Signer = signer
+	cfg.PublisherID, err = peer.IDFromPublicKey(signer.PublicKey())
+	require.NoError(t, err)
+
+	backend := NewDatastoreBackend(sync.MutexWrap(datastore.NewMapDatastore()))
+	head, err := PublishWithContextID(ctx, cfg, backend, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.NoError(t, err)
+	require.Equal(t, 1, fake.signs)
+
+	report, err := VerifyChain(ctx, backend, VerifyOptions{PublisherID: cfg.PublisherID})
+	require.NoError(t, err)
+	require.True(t, report.OK(), report.Issues)
+	ad, err := LoadAdvertisement(ctx, backend, head)
+	require.NoError(t, err)
+	signerID, err := ad.VerifySignature()
+	require.NoError(t, err)
+	require.Equal(t, cfg.PublisherID, signerID)
+
+	// the head served over HTTP is signed by KMS too, and verified by the indexer client
+	pub, err := NewHttpPublisher(backend, "", "/indexer/ingest/mainnet", nil, WithHttpPublisherSigner(signer))
+	require.NoError(t, err)
+	server := httptest.NewServer(pub.serveMux())
+	defer server.Close()
+	addr, err := manet.FromNetAddr(server.Listener.Addr())
+	require.NoError(t, err)
+	client := ipnisync.NewSync(cidlink.DefaultLinkSystem(), nil)
+	defer client.Close()
+	syncer, err := client.NewSyncer(peer.AddrInfo{
+		ID:    cfg.PublisherID,
+		Addrs: []multiaddr.Multiaddr{addr.Encapsulate(multiaddr.StringCast("/http"))},
+	})
+	require.NoError(t, err)
+	remoteHead, err := syncer.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, head, remoteHead)
+}
+
+func TestKMSSignerUnsupportedKey(t *testing.T) {
+	_, err := NewKMSSigner(context.Background(), unsupportedKMS{}, "alias/herald")
+	require.ErrorContains(t, err, "unsupported key spec")
+}
+
+type unsupportedKMS struct {
+	KMSAPI
+}
+
+func (unsupportedKMS) GetPublicKey(context.Context, *kms.GetPublicKeyInput, ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	return &kms.GetPublicKeyOutput{KeySpec: types.KeySpecEccSecgP256k1, KeyUsage: types.KeyUsageTypeSignVerify}, nil
+}
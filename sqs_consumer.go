@@ -0,0 +1,345 @@
+package herald
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// DefaultSQSMaxMessages is the default number of messages received at once, which is also the SQS maximum.
+	DefaultSQSMaxMessages = 10
+	// DefaultSQSWaitTime is the default long polling duration, which is also the SQS maximum.
+	DefaultSQSWaitTime = 20 * time.Second
+)
+
+// SQSAPI is the subset of the SQS client used by SQSConsumer.
+type SQSAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// S3GetObjectAPI is the subset of the S3 client used to read the CARs stored on S3.
+type S3GetObjectAPI interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// SQSConsumerConfig is the configuration of an SQSConsumer.
+type SQSConsumerConfig struct {
+	// Client is the SQS client, typically sqs.NewFromConfig(awsCfg).
+	Client SQSAPI
+	// QueueURL is the URL of the queue to poll.
+	QueueURL string
+
+	// S3 reads the CARs referenced by the messages. Only required if the messages reference CARs.
+	S3 S3GetObjectAPI
+
+	// MaxMessages is the number of messages received at once, up to 10. Defaults to DefaultSQSMaxMessages.
+	MaxMessages int32
+	// WaitTime is the long polling duration, up to 20s. Defaults to DefaultSQSWaitTime.
+	WaitTime time.Duration
+	// VisibilityTimeout overrides the visibility timeout of the queue for the received messages, if not zero.
+	// It must be long enough to publish a catalog, batching delay included.
+	VisibilityTimeout time.Duration
+	// Concurrency is the number of messages processed concurrently, which lets the batcher merge them.
+	// Defaults to MaxMessages.
+	Concurrency int
+	// WaitForPublish deletes the messages only once their batch is published, rather than once persisted in the
+	// batch. Without a BatchConfig.Queue, the batch is only held in memory, and it avoids losing the batched catalogs
+	// on a crash. Each message then holds a
+	// slot of Concurrency for up to the batching delay, which Concurrency must account for.
+	WaitForPublish bool
+}
+
+// SQSMessage is the JSON message consumed by the SQSConsumer. It is either the body of the SQS message, or the
+// Message of an SNS notification delivered to the queue.
+//
+// A message publishes or retracts either the given multihashes or the blocks of a CAR stored on S3, with the given
+// ContextID. A publication with a ContextID is published on its own, whatever its size, so that the ContextID can be
+// retracted later; without, the multihashes are batched. Likewise, a retraction with a ContextID retracts the whole
+// ContextID, whether or not the multihashes or the CAR are given.
+//
+//	{"action": "publish", "context_id": "Zm9v", "multihashes": ["QmY7Yh4UquoXHLPFo2XbhXkhBvFoPwmQUSa92pxnxjQuPU"]}
+//	{"action": "publish", "context_id": "Zm9v", "car": "s3://bucket/path/to/file.car"}
+//	{"action": "retract", "context_id": "Zm9v"}
+type SQSMessage struct {
+	// Action is "publish" or "retract".
+	Action string `json:"action"`
+	// ContextID is the ContextID of the catalog, base64 encoded. Optional for multihashes, which are then
+	// published without ContextID.
+	ContextID []byte `json:"context_id,omitempty"`
+	// Multihashes are the base58 encoded multihashes to publish or retract.
+	Multihashes []string `json:"multihashes,omitempty"`
+	// Car is the location of a CAR on S3, as s3://bucket/key, whose blocks are published or retracted.
+	Car string `json:"car,omitempty"`
+}
+
+const (
+	SQSActionPublish = "publish"
+	SQSActionRetract = "retract"
+)
+
+// errInvalidSQSMessage marks the messages that will never succeed.
+var errInvalidSQSMessage = errors.New("invalid SQS message")
+
+// SQSConsumer polls an SQS queue, and publishes or retracts the catalogs described by the messages through a
+// CatalogBatcher.
+//
+// A message is deleted once its catalog is persisted in the batch, or published with SQSConsumerConfig.WaitForPublish.
+// Without a BatchConfig.Queue, the batch is only held in memory, and the batched messages are lost on a crash unless
+// WaitForPublish is set. A message failing to be processed is received again after the visibility timeout, and eventually moved to the dead-letter queue by the redrive policy of the queue, if any. The invalid
+// messages are made visible again immediately, to reach the dead-letter queue without waiting.
+type SQSConsumer struct {
+	cfg     SQSConsumerConfig
+	batcher *CatalogBatcher
+
+	// stop stops receiving new messages
+	stop context.CancelFunc
+	// cancel cancels the messages being processed
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartSQSConsumer starts polling the queue, until Shutdown or Close.
+func StartSQSConsumer(cfg SQSConsumerConfig, batcher *CatalogBatcher) (*SQSConsumer, error) {
+	if cfg.Client == nil || cfg.QueueURL == "" {
+		return nil, errors.New("SQS client and queue URL are required")
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = DefaultSQSMaxMessages
+	}
+	if cfg.WaitTime <= 0 {
+		cfg.WaitTime = DefaultSQSWaitTime
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = int(cfg.MaxMessages)
+	}
+
+	processCtx, cancel := context.WithCancel(context.Background())
+	receiveCtx, stop := context.WithCancel(processCtx)
+	c := &SQSConsumer{cfg: cfg, batcher: batcher, stop: stop, cancel: cancel, done: make(chan struct{})}
+	go c.run(receiveCtx, processCtx)
+	return c, nil
+}
+
+// Shutdown stops receiving messages, and waits for the messages being processed until ctx is done.
+// The messages not processed by then are received again later.
+func (c *SQSConsumer) Shutdown(ctx context.Context) error {
+	c.stop()
+	defer c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		c.cancel()
+		<-c.done
+		return ctx.Err()
+	}
+}
+
+func (c *SQSConsumer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+func (c *SQSConsumer) run(receiveCtx, processCtx context.Context) {
+	defer close(c.done)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, c.cfg.Concurrency)
+
+	for receiveCtx.Err() == nil {
+		input := &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(c.cfg.QueueURL),
+			MaxNumberOfMessages:         c.cfg.MaxMessages,
+			WaitTimeSeconds:             int32(c.cfg.WaitTime / time.Second),
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameApproximateReceiveCount},
+		}
+		if c.cfg.VisibilityTimeout > 0 {
+			input.VisibilityTimeout = int32(c.cfg.VisibilityTimeout / time.Second)
+		}
+		out, err := c.cfg.Client.ReceiveMessage(receiveCtx, input)
+		if err != nil {
+			if receiveCtx.Err() != nil {
+				return
+			}
+			c.batcher.logger().Errorw("failed to receive SQS messages", "queue", c.cfg.QueueURL, "err", err)
+			select {
+			case <-time.After(time.Second):
+			case <-receiveCtx.Done():
+			}
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			select {
+			case slots <- struct{}{}:
+			case <-receiveCtx.Done():
+				// not processed, received again after the visibility timeout
+				return
+			}
+			wg.Add(1)
+			go func(msg types.Message) {
+				defer wg.Done()
+				defer func() { <-slots }()
+				c.process(processCtx, msg)
+			}(msg)
+		}
+	}
+}
+
+// process handles a message, and deletes it on success.
+func (c *SQSConsumer) process(ctx context.Context, msg types.Message) {
+	log := c.batcher.logger().With("queue", c.cfg.QueueURL, "messageID", aws.ToString(msg.MessageId),
+		"receiveCount", msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+
+	err := c.handle(ctx, aws.ToString(msg.Body))
+	if errors.Is(err, errInvalidSQSMessage) {
+		log.Errorw("invalid SQS message, releasing it for the dead-letter queue", "err", err)
+		_, err = c.cfg.Client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(c.cfg.QueueURL),
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: 0,
+		})
+		if err != nil {
+			log.Warnw("failed to release the invalid SQS message", "err", err)
+		}
+		return
+	}
+	if err != nil {
+		log.Errorw("failed to process SQS message, it will be received again", "err", err)
+		return
+	}
+
+	_, err = c.cfg.Client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.cfg.QueueURL),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		// the message is received again, and published again, which the duplicate policy can take care of
+		log.Errorw("failed to delete processed SQS message", "err", err)
+	}
+}
+
+// handle publishes or retracts the catalog described by the message body.
+func (c *SQSConsumer) handle(ctx context.Context, body string) error {
+	msg, err := ParseSQSMessage(body)
+	if err != nil {
+		return err
+	}
+
+	if err := c.batcher.ValidateContextID(msg.ContextID); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidSQSMessage, err)
+	}
+	if msg.Action == SQSActionRetract && len(msg.Multihashes) == 0 && msg.Car == "" {
+		return c.batcher.RetractContextID(ctx, msg.ContextID)
+	}
+
+	var catalog Catalog
+	if msg.Car != "" {
+		if c.cfg.S3 == nil {
+			return fmt.Errorf("%w: no S3 client to read %s", errInvalidSQSMessage, msg.Car)
+		}
+		bucket, key, err := parseS3URL(msg.Car)
+		if err != nil {
+			return err
+		}
+		catalog, err = CatalogFromS3Car(ctx, c.cfg.S3, bucket, key, msg.ContextID)
+		if err != nil {
+			return err
+		}
+	} else {
+		mhs := make(MhCatalog, 0, len(msg.Multihashes))
+		for _, s := range msg.Multihashes {
+			mh, err := multihash.FromB58String(s)
+			if err != nil {
+				return fmt.Errorf("%w: invalid multihash %q: %v", errInvalidSQSMessage, s, err)
+			}
+			mhs = append(mhs, mh)
+		}
+		catalog = mhCatalogWithID{MhCatalog: mhs, id: msg.ContextID}
+	}
+
+	// with a ContextID, the catalog is published and retracted on its own, so that the retraction removes the
+	// ContextID that was published
+	var result <-chan PublishResult
+	if msg.Action == SQSActionPublish {
+		result, err = c.batcher.PublishCatalogWithID(ctx, catalog)
+	} else {
+		result, err = c.batcher.RetractCatalogWithID(ctx, catalog)
+	}
+	if err != nil || !c.cfg.WaitForPublish {
+		return err
+	}
+	select {
+	case r := <-result:
+		return r.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ParseSQSMessage parses and validates an SQSMessage, either raw or wrapped in an SNS notification.
+func ParseSQSMessage(body string) (SQSMessage, error) {
+	var envelope struct {
+		Type    string
+		Message string
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err == nil && envelope.Type == "Notification" {
+		body = envelope.Message
+	}
+
+	var msg SQSMessage
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return SQSMessage{}, fmt.Errorf("%w: %v", errInvalidSQSMessage, err)
+	}
+	switch msg.Action {
+	case SQSActionPublish, SQSActionRetract:
+	default:
+		return SQSMessage{}, fmt.Errorf("%w: unknown action %q", errInvalidSQSMessage, msg.Action)
+	}
+	if len(msg.Multihashes) != 0 && msg.Car != "" {
+		return SQSMessage{}, fmt.Errorf("%w: both multihashes and a CAR are given", errInvalidSQSMessage)
+	}
+	if len(msg.Multihashes) == 0 && msg.Car == "" && (msg.Action == SQSActionPublish || len(msg.ContextID) == 0) {
+		return SQSMessage{}, fmt.Errorf("%w: nothing to %s", errInvalidSQSMessage, msg.Action)
+	}
+	if msg.Car != "" {
+		if _, _, err := parseS3URL(msg.Car); err != nil {
+			return SQSMessage{}, err
+		}
+	}
+	return msg, nil
+}
+
+// parseS3URL parses a s3://bucket/key location.
+func parseS3URL(location string) (bucket, key string, err error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "s3" || u.Host == "" || strings.TrimPrefix(u.Path, "/") == "" {
+		return "", "", fmt.Errorf("%w: invalid S3 location %q, expected s3://bucket/key", errInvalidSQSMessage, location)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// mhCatalogWithID is a MhCatalog with a ContextID.
+type mhCatalogWithID struct {
+	MhCatalog
+	id []byte
+}
+
+func (c mhCatalogWithID) ID() []byte {
+	return c.id
+}
@@ -0,0 +1,193 @@
+package herald
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQS is a minimal in-memory SQS queue, delivering each message once unless released.
+type fakeSQS struct {
+	mu       sync.Mutex
+	pending  []types.Message
+	deleted  []string
+	released []string
+}
+
+func (f *fakeSQS) send(body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := strconv.Itoa(len(f.pending) + len(f.deleted) + len(f.released))
+	f.pending = append(f.pending, types.Message{MessageId: aws.String(id), ReceiptHandle: aws.String(id), Body: aws.String(body)})
+}
+
+func (f *fakeSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	f.mu.Lock()
+	if len(f.pending) > 0 {
+		n := min(len(f.pending), int(params.MaxNumberOfMessages))
+		msgs := f.pending[:n]
+		f.pending = f.pending[n:]
+		f.mu.Unlock()
+		return &sqs.ReceiveMessageOutput{Messages: msgs}, nil
+	}
+	f.mu.Unlock()
+	select {
+	case <-time.After(10 * time.Millisecond):
+		return &sqs.ReceiveMessageOutput{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *fakeSQS) DeleteMessage(_ context.Context, params *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, aws.ToString(params.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(_ context.Context, params *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.released = append(f.released, aws.ToString(params.ReceiptHandle))
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) handled() (deleted, released []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deleted...), append([]string(nil), f.released...)
+}
+
+func TestSQSConsumer(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewDatastoreBackend(dssync.MutexWrap(datastore.NewMapDatastore()), WithDsContextIDIndex())
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+		MaxDelay:               100 * time.Millisecond,
+	}, cfg, backend, nil)
+
+	fake, s3Client := newFakeS3(t)
+	// an indexed CARv2, to be published with its ContextID rather than batched
+	carPath := filepath.Join(t.TempDir(), "1.car")
+	require.NoError(t, carv2.WrapV1File("testdata/1.car", carPath))
+	car, err := os.ReadFile(carPath)
+	require.NoError(t, err)
+	fake.objects["cars/1.car"] = car
+
+	queue := &fakeSQS{}
+	mhs := testMultihashes(3)
+	queue.send(`{"action": "publish", "context_id": "Zm9v", "multihashes": ["` + mhs[0].B58String() + `", "` + mhs[1].B58String() + `"]}`)
+	queue.send(`{"action": "publish", "context_id": "YmFy", "car": "s3://bucket/cars/1.car"}`)
+	queue.send(`{"action": "publish", "multihashes": ["not a multihash"]}`)
+	queue.send(`not json`)
+	queue.send(`{"action": "publish", "context_id": "` + base64.StdEncoding.EncodeToString(make([]byte, 65)) + `", "multihashes": ["` + mhs[2].B58String() + `"]}`)
+	// above CountThreshold, but without ContextID: batched
+	var large []string
+	for _, mh := range testMultihashes(20) {
+		large = append(large, `"`+mh.B58String()+`"`)
+	}
+	queue.send(`{"action": "publish", "multihashes": [` + strings.Join(large, ",") + `]}`)
+	notification, err := json.Marshal(map[string]string{"Type": "Notification", "Message": `{"action": "retract", "context_id": "Zm9v"}`})
+	require.NoError(t, err)
+	queue.send(string(notification))
+	// a retraction with a ContextID and its multihashes retracts the ContextID, rather than being batched
+	queue.send(`{"action": "publish", "context_id": "YmF6", "multihashes": ["` + mhs[2].B58String() + `"]}`)
+	queue.send(`{"action": "retract", "context_id": "YmF6", "multihashes": ["` + mhs[2].B58String() + `"]}`)
+
+	// one message at a time, to process them in order
+	consumer, err := StartSQSConsumer(SQSConsumerConfig{
+		Client:      queue,
+		QueueURL:    "https://sqs.us-east-1.amazonaws.com/123456789012/herald",
+		S3:          s3Client,
+		Concurrency: 1,
+	}, batcher)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		deleted, released := queue.handled()
+		return len(deleted)+len(released) == 9
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, consumer.Close())
+
+	deleted, released := queue.handled()
+	require.Equal(t, []string{"0", "1", "5", "6", "7", "8"}, deleted)
+	require.Equal(t, []string{"2", "3", "4"}, released)
+
+	// the small catalog with a ContextID was published on its own, and retracted by its ContextID
+	info, err := backend.ContextIDState(ctx, []byte("foo"))
+	require.NoError(t, err)
+	require.Equal(t, ContextIDRetracted, info.State)
+	info, err = backend.ContextIDState(ctx, []byte("bar"))
+	require.NoError(t, err)
+	require.Equal(t, ContextIDLive, info.State)
+	info, err = backend.ContextIDState(ctx, []byte("baz"))
+	require.NoError(t, err)
+	require.Equal(t, ContextIDRetracted, info.State)
+}
+
+func TestSQSConsumerWaitForPublish(t *testing.T) {
+	ctx := context.Background()
+	cfg := testChainConfig(t)
+	backend := NewMemoryBackend()
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         10,
+		MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+		MaxDelay:               300 * time.Millisecond,
+	}, cfg, backend, nil)
+
+	queue := &fakeSQS{}
+	mhs := testMultihashes(2)
+	queue.send(`{"action": "publish", "multihashes": ["` + mhs[0].B58String() + `", "` + mhs[1].B58String() + `"]}`)
+	consumer, err := StartSQSConsumer(SQSConsumerConfig{
+		Client:         queue,
+		QueueURL:       "https://sqs.us-east-1.amazonaws.com/123456789012/herald",
+		WaitForPublish: true,
+	}, batcher)
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	// the message is only deleted once the batch is published
+	require.Eventually(t, func() bool {
+		deleted, _ := queue.handled()
+		return len(deleted) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	head, err := backend.GetHead(ctx)
+	require.NoError(t, err)
+	require.True(t, head.Defined())
+}
+
+func TestParseSQSMessage(t *testing.T) {
+	for _, body := range []string{
+		`{"action": "publish", "context_id": "Zm9v"}`,
+		`{"action": "retract"}`,
+		`{"action": "delete", "context_id": "Zm9v", "multihashes": ["foo"]}`,
+		`{"action": "publish", "multihashes": ["foo"], "car": "s3://bucket/key"}`,
+		`{"action": "publish", "car": "https://bucket/key"}`,
+		`{"action": "publish", "car": "s3://bucket"}`,
+	} {
+		_, err := ParseSQSMessage(body)
+		require.ErrorIs(t, err, errInvalidSQSMessage, body)
+	}
+
+	msg, err := ParseSQSMessage(`{"action": "publish", "car": "s3://bucket/key"}`)
+	require.NoError(t, err)
+	require.Equal(t, SQSMessage{Action: SQSActionPublish, Car: "s3://bucket/key"}, msg)
+}
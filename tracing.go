@@ -0,0 +1,134 @@
+package herald
+
+import (
+	"context"
+	"encoding/hex"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/linking"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the instrumentation name of the herald tracer.
+const TracerName = "github.com/ipni/herald"
+
+// tracer returns the configured tracer, or a no-op one.
+func (cfg ChainConfig) tracer() trace.Tracer {
+	if cfg.Tracer == nil {
+		return noop.NewTracerProvider().Tracer(TracerName)
+	}
+	return cfg.Tracer
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traced wraps the backend to trace the block writes and the head updates, if a tracer is configured.
+// The optional ChainReader, ContextIDIndex and BlockManager interfaces of the backend are forwarded.
+func (cfg ChainConfig) traced(backend ChainWriter) ChainWriter {
+	if cfg.Tracer == nil {
+		return backend
+	}
+	if _, ok := backend.(interface{ isTraced() }); ok {
+		return backend
+	}
+	t := tracedWriter{ChainWriter: backend, tracer: cfg.Tracer}
+	reader, isReader := backend.(ChainReader)
+	index, isIndex := backend.(ContextIDIndex)
+	manager, isManager := backend.(BlockManager)
+	switch {
+	case isReader && isIndex && isManager:
+		return struct {
+			tracedWriter
+			ChainReader
+			ContextIDIndex
+			BlockManager
+		}{t, reader, index, manager}
+	case isReader && isIndex:
+		return struct {
+			tracedWriter
+			ChainReader
+			ContextIDIndex
+		}{t, reader, index}
+	case isReader && isManager:
+		return struct {
+			tracedWriter
+			ChainReader
+			BlockManager
+		}{t, reader, manager}
+	case isIndex && isManager:
+		return struct {
+			tracedWriter
+			ContextIDIndex
+			BlockManager
+		}{t, index, manager}
+	case isReader:
+		return struct {
+			tracedWriter
+			ChainReader
+		}{t, reader}
+	case isIndex:
+		return struct {
+			tracedWriter
+			ContextIDIndex
+		}{t, index}
+	case isManager:
+		return struct {
+			tracedWriter
+			BlockManager
+		}{t, manager}
+	default:
+		return t
+	}
+}
+
+// tracedWriter is a ChainWriter recording a span for each operation.
+type tracedWriter struct {
+	ChainWriter
+	tracer trace.Tracer
+}
+
+func (tracedWriter) isTraced() {}
+
+func (t tracedWriter) UpdateHead(ctx context.Context, fn func(prevHead cid.Cid) (cid.Cid, error)) (err error) {
+	ctx, span := t.tracer.Start(ctx, "UpdateHead")
+	defer func() { endSpan(span, err) }()
+	return t.ChainWriter.UpdateHead(ctx, func(prevHead cid.Cid) (cid.Cid, error) {
+		newHead, err := fn(prevHead)
+		span.SetAttributes(attribute.Stringer("herald.head.previous", prevHead), attribute.Stringer("herald.head.new", newHead))
+		return newHead, err
+	})
+}
+
+func (t tracedWriter) Store(lnkCtx linking.LinkContext, lp datamodel.LinkPrototype, n datamodel.Node) (lnk datamodel.Link, err error) {
+	ctx := lnkCtx.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var span trace.Span
+	lnkCtx.Ctx, span = t.tracer.Start(ctx, "Store")
+	defer func() { endSpan(span, err) }()
+	lnk, err = t.ChainWriter.Store(lnkCtx, lp, n)
+	if err == nil {
+		span.SetAttributes(attribute.Stringer("herald.block", lnk))
+	}
+	return lnk, err
+}
+
+// catalogAttributes returns the span attributes describing a catalog.
+func catalogAttributes(catalog Catalog) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("herald.catalog.id", hex.EncodeToString(catalog.ID())),
+		attribute.Int("herald.catalog.count", catalog.Count()),
+	}
+}
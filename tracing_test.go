@@ -0,0 +1,89 @@
+package herald
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/announce/message"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// nopSender is an announce.Sender discarding the announcements.
+type nopSender struct{}
+
+func (nopSender) Close() error                                    { return nil }
+func (nopSender) Send(_ context.Context, _ message.Message) error { return nil }
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	cfg := testChainConfig(t)
+	cfg.Tracer = tp.Tracer(TracerName)
+	backend := NewDsPublisher(sync.MutexWrap(datastore.NewMapDatastore()))
+	batcher := StartCatalogBatcher(BatchConfig{
+		CountThreshold:         1,
+		MaxMHsPerAdvertisement: DefaultMaxMHsPerAdvertisement,
+		MaxDelay:               time.Second,
+	}, cfg, backend, nopSender{})
+
+	ctx, root := tp.Tracer("test").Start(ctx, "sqs message")
+	err := batcher.PublishCatalog(ctx, testCatalog{MhCatalog: testMultihashes(5), id: []byte("foo")})
+	require.NoError(t, err)
+	root.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		// all the spans belong to the same trace
+		require.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID())
+		// keep the first one ended, for the blocks: an entry chunk
+		if _, ok := spans[span.Name()]; !ok {
+			spans[span.Name()] = span
+		}
+	}
+	for parent, child := range map[string]string{
+		"sqs message":                   "CatalogBatcher.PublishCatalog",
+		"CatalogBatcher.PublishCatalog": "PublishWithContextID",
+		"PublishWithContextID":          "generateEntries",
+		"generateEntries":               "Store",
+	} {
+		require.Contains(t, spans, child)
+		require.Equal(t, spans[parent].SpanContext().SpanID(), spans[child].Parent().SpanID(), child)
+	}
+	require.Contains(t, spans, "UpdateHead")
+	require.Equal(t, spans["PublishWithContextID"].SpanContext().SpanID(), spans["UpdateHead"].Parent().SpanID())
+	require.Contains(t, spans, "announce")
+	require.Equal(t, spans["CatalogBatcher.PublishCatalog"].SpanContext().SpanID(), spans["announce"].Parent().SpanID())
+
+	head, err := backend.GetHead(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, cid.Undef, head)
+}
+
+func TestTracedForwardsInterfaces(t *testing.T) {
+	cfg := testChainConfig(t)
+	cfg.Tracer = sdktrace.NewTracerProvider().Tracer(TracerName)
+
+	traced := cfg.traced(NewMemoryBackend())
+	require.Implements(t, (*ChainReader)(nil), traced)
+	require.Implements(t, (*BlockManager)(nil), traced)
+	require.NotImplements(t, (*ContextIDIndex)(nil), traced)
+	// not wrapped twice
+	require.Equal(t, traced, cfg.traced(traced))
+
+	traced = cfg.traced(NewDatastoreBackend(datastore.NewMapDatastore(), WithDsContextIDIndex()))
+	require.Implements(t, (*ContextIDIndex)(nil), traced)
+
+	// the interfaces the backend lacks are not made up
+	traced = cfg.traced(struct{ ChainWriter }{NewMemoryBackend()})
+	require.NotImplements(t, (*ChainReader)(nil), traced)
+	require.NotImplements(t, (*ContextIDIndex)(nil), traced)
+	require.NotImplements(t, (*BlockManager)(nil), traced)
+}